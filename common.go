@@ -1,5 +1,3 @@
-//go:build windows
-
 package ndisapi
 
 import (
@@ -54,14 +52,72 @@ const (
 	AnySize = 1
 )
 
-// Packet actions
+const (
+	DEVICE_NDISWANIP               = `\DEVICE\NDISWANIP`
+	USER_NDISWANIP                 = `WAN Network Interface (IP)`
+	DEVICE_NDISWANBH               = `\DEVICE\NDISWANBH`
+	USER_NDISWANBH                 = `WAN Network Interface (BH)`
+	DEVICE_NDISWANIPV6             = `\DEVICE\NDISWANIPV6`
+	USER_NDISWANIPV6               = `WAN Network Interface (IPv6)`
+	REGSTR_COMPONENTID_NDISWANIP   = `ms_ndiswanip`
+	REGSTR_COMPONENTID_NDISWANIPV6 = `ms_ndiswanipv6`
+	REGSTR_COMPONENTID_NDISWANBH   = `ms_ndiswanbh`
+	REGSTR_VAL_CONNECTION          = `\Connection`
+	REGSTR_VAL_NAME                = `Name`
+	REGSTR_VAL_SERVICE_NAME        = `ServiceName`
+	REGSTR_VAL_DRIVER_DESC         = `DriverDesc`
+	REGSTR_VAL_TITLE               = `Title`
+
+	REGSTR_NETWORK_CONTROL_KEY   = `SYSTEM\CurrentControlSet\Control\Network\{4D36E972-E325-11CE-BFC1-08002BE10318}\`
+	REGSTR_NETWORK_CARDS         = `SOFTWARE\Microsoft\Windows NT\CurrentVersion\NetworkCards`
+	REGSTR_MSTCP_CLASS_NET       = `SYSTEM\CurrentControlSet\Services\Class\Net\`
+	REGSTR_NETWORK_CONTROL_CLASS = `SYSTEM\CurrentControlSet\Control\Class\{4D36E972-E325-11CE-BFC1-08002BE10318}`
+
+	OID_GEN_LINK_SPEED            = 0x00010107
+	OID_GEN_CURRENT_PACKET_FILTER = 0x0001010E
+	OID_GEN_MEDIA_CONNECT_STATUS  = 0x00010114
+	OID_GEN_XMIT_OK               = 0x00020101
+	OID_GEN_RCV_OK                = 0x00020102
+	OID_GEN_XMIT_ERROR            = 0x00020103
+	OID_GEN_RCV_ERROR             = 0x00020104
+	OID_GEN_RCV_NO_BUFFER         = 0x00020105
+	OID_802_3_PERMANENT_ADDRESS   = 0x01010101
+	OID_802_3_CURRENT_ADDRESS     = 0x01010102
+
+	// Hardware packet filter bits of OID_GEN_CURRENT_PACKET_FILTER
+	NDIS_PACKET_TYPE_DIRECTED       = 0x00000001
+	NDIS_PACKET_TYPE_MULTICAST      = 0x00000002
+	NDIS_PACKET_TYPE_ALL_MULTICAST  = 0x00000004
+	NDIS_PACKET_TYPE_BROADCAST      = 0x00000008
+	NDIS_PACKET_TYPE_SOURCE_ROUTING = 0x00000010
+	NDIS_PACKET_TYPE_PROMISCUOUS    = 0x00000020
+	NDIS_PACKET_TYPE_SMT            = 0x00000040
+	NDIS_PACKET_TYPE_ALL_LOCAL      = 0x00000080
+	NDIS_PACKET_TYPE_GROUP          = 0x00001000
+	NDIS_PACKET_TYPE_ALL_FUNCTIONAL = 0x00002000
+	NDIS_PACKET_TYPE_FUNCTIONAL     = 0x00004000
+	NDIS_PACKET_TYPE_MAC_FRAME      = 0x00008000
+	NDIS_PACKET_TYPE_NO_LOCAL       = 0x00010000
+
+	// Values of OID_GEN_MEDIA_CONNECT_STATUS
+	NdisMediaStateConnected    = 0
+	NdisMediaStateDisconnected = 1
+)
+
+// FilterAction is the verdict returned by packet filter callbacks.
 type FilterAction uint32
 
 const (
+	// FilterActionPass forwards the packet in its original direction.
 	FilterActionPass FilterAction = iota
+	// FilterActionDrop discards the packet.
 	FilterActionDrop
+	// FilterActionRedirect reflects the packet: packets received from the network are sent
+	// back to the network and packets sent by the protocol stack are indicated back to it.
 	FilterActionRedirect
+	// FilterActionPassRedirect forwards the packet like FilterActionPass and hands a copy to the listener.
 	FilterActionPassRedirect
+	// FilterActionDropRedirect discards the packet like FilterActionDrop and hands a copy to the listener.
 	FilterActionDropRedirect
 )
 
@@ -85,6 +141,7 @@ func (h *HAdapterQLinkUnion) GetAdapter() Handle {
 func (h *HAdapterQLinkUnion) GetQLink() QLink {
 	return *(*QLink)(unsafe.Pointer(&h.data[0]))
 }
+
 // SetAdapter sets the adapter handle in the HAdapterQLinkUnion.
 func (h *HAdapterQLinkUnion) SetAdapter(adapter Handle) {
 	copy(h.data[:8], adapter[:])
@@ -0,0 +1,62 @@
+package driver
+
+import (
+	"strings"
+
+	A "github.com/wiresock/ndisapi-go"
+	N "github.com/wiresock/ndisapi-go/netlib"
+)
+
+// adapterFollower follows the adapter of a single interface filter by its internal name across
+// the adapter list changes, so the filter survives the adapter being re-bound or reordered.
+type adapterFollower struct {
+	monitor     *N.AdapterMonitor
+	unsubscribe func()
+}
+
+// followAdapter subscribes to the adapter monitor of the filter api. The filter is suspended when the
+// adapter is removed and resumed when it arrives again, possibly with another handle and list position.
+func followAdapter(api A.NdisApiInterface, name string, suspend, resume func()) (*adapterFollower, error) {
+	monitor, err := N.NewAdapterMonitor(api)
+	if err != nil {
+		return nil, err
+	}
+
+	unsubscribe := monitor.Subscribe(func(event N.AdapterEvent) {
+		if !strings.EqualFold(event.ID, name) {
+			return
+		}
+
+		switch event.Type {
+		case N.AdapterRemoved:
+			suspend()
+		case N.AdapterChanged:
+			suspend()
+			resume()
+		case N.AdapterAdded:
+			resume()
+		}
+	})
+
+	return &adapterFollower{monitor: monitor, unsubscribe: unsubscribe}, nil
+}
+
+// Close stops following the adapter. It must not be called with the filter locked, since
+// the monitor may be waiting for the filter lock to deliver an event.
+func (a *adapterFollower) Close() {
+	if a == nil {
+		return
+	}
+	a.unsubscribe()
+	a.monitor.Close()
+}
+
+// indexOfAdapter returns the position of the adapter with the internal name, -1 if there is none.
+func indexOfAdapter(adapters []*N.NetworkAdapter, name string) int {
+	for i, adapter := range adapters {
+		if strings.EqualFold(adapter.InternalName, name) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,70 @@
+package driver_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	D "github.com/wiresock/ndisapi-go/driver"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+// tunneled reports whether the filter has put the adapter into the tunnel mode.
+func tunneled(api *sim.Driver, handle A.Handle) bool {
+	mode := A.AdapterMode{AdapterHandle: handle}
+	return api.GetAdapterMode(&mode) == nil && mode.Flags&A.MSTCP_FLAG_RECV_TUNNEL != 0
+}
+
+func TestPacketFilter_FollowsAdapter(t *testing.T) {
+	for _, pipeline := range verdictPipelines {
+		pipeline := pipeline
+		t.Run(pipeline.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			api := sim.NewDriver()
+			handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+			api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-1}`})
+
+			adapters, err := api.GetTcpipBoundAdaptersInfo()
+			require.NoError(t, err)
+
+			verdict := func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction {
+				return A.FilterAction(dstPort(buffer) % 1000)
+			}
+
+			filter, err := pipeline.create(ctx, api, adapters, verdict, verdict)
+			require.NoError(t, err)
+			require.NoError(t, startPipeline(pipeline, filter))
+			require.Eventually(t, func() bool { return tunneled(api, handle) }, 5*time.Second, time.Millisecond)
+
+			// The adapter is unplugged and plugged in again with another handle at the end of the adapter list.
+			require.NoError(t, api.RemoveAdapter(handle))
+			if _, multi := filter.(D.MultiInterfacePacketFilter); !multi {
+				require.Eventually(t, func() bool {
+					return filter.GetFilterState() == D.FilterStateSuspended
+				}, 5*time.Second, time.Millisecond)
+			}
+
+			handle = api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+			require.Eventually(t, func() bool { return tunneled(api, handle) }, 5*time.Second, time.Millisecond)
+			waitRunning(filter)
+
+			require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(inPortBase+uint16(A.FilterActionDrop))))
+			require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(inPortBase+uint16(A.FilterActionPass))))
+
+			waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+			defer waitCancel()
+			require.NoError(t, api.WaitDelivered(waitCtx, 1))
+
+			require.NoError(t, filter.Close())
+			assert.Equal(t, D.FilterStateStopped, filter.GetFilterState())
+			assert.Equal(t, []int{inPortBase + int(A.FilterActionPass)}, ports(api.StackPackets(handle)))
+			assert.False(t, tunneled(api, handle))
+		})
+	}
+}
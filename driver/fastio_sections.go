@@ -0,0 +1,354 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// FastIOPollStrategy is how a fast I/O packet filter waits for packets while its sections are empty.
+type FastIOPollStrategy int
+
+const (
+	// FastIOPollEvent waits for the packet event the driver signals, it costs no CPU while idle.
+	FastIOPollEvent FastIOPollStrategy = iota
+	// FastIOPollSpin polls the sections continuously, the lowest latency at the cost of a busy core.
+	FastIOPollSpin
+	// FastIOPollSpinYield polls the sections continuously for SpinCount empty polls,
+	// then yields the processor between the polls until packets arrive again.
+	FastIOPollSpinYield
+)
+
+func (s FastIOPollStrategy) String() string {
+	switch s {
+	case FastIOPollEvent:
+		return "event"
+	case FastIOPollSpin:
+		return "spin"
+	case FastIOPollSpinYield:
+		return "spin-yield"
+	default:
+		return fmt.Sprintf("FastIOPollStrategy(%d)", int(s))
+	}
+}
+
+// FastIOConfig configures the fast I/O sections of a packet filter and how they are polled.
+// The zero value of a field selects its default.
+type FastIOConfig struct {
+	SectionCount int    // number of sections, the first one primary and the others secondary, 4 by default
+	SectionSize  uint32 // size of each section in bytes, 3 MB by default
+	Poll         FastIOPollStrategy
+	SpinCount    int // empty polls before FastIOPollSpinYield starts yielding, 1000 by default
+	// DrainOverflow reads the packets the driver queues with ReadPacketsUnsorted when it
+	// finds the sections full. Without it they wait in the queue until the filter stops.
+	DrainOverflow bool
+}
+
+const (
+	defaultFastIOSectionCount = 4
+	defaultFastIOSectionSize  = 0x300000
+	defaultFastIOSpinCount    = 1000
+)
+
+// DefaultFastIOConfig returns the configuration the fast I/O packet filters use unless told otherwise.
+func DefaultFastIOConfig() FastIOConfig {
+	return FastIOConfig{
+		SectionCount: defaultFastIOSectionCount,
+		SectionSize:  defaultFastIOSectionSize,
+		Poll:         FastIOPollEvent,
+		SpinCount:    defaultFastIOSpinCount,
+	}
+}
+
+// withDefaults returns the configuration with the zero fields set to their defaults.
+func (c FastIOConfig) withDefaults() FastIOConfig {
+	if c.SectionCount == 0 {
+		c.SectionCount = defaultFastIOSectionCount
+	}
+	if c.SectionSize == 0 {
+		c.SectionSize = defaultFastIOSectionSize
+	}
+	if c.SpinCount == 0 {
+		c.SpinCount = defaultFastIOSpinCount
+	}
+	return c
+}
+
+// validate checks the configuration with the defaults applied.
+func (c FastIOConfig) validate() error {
+	if c.SectionCount < 1 {
+		return fmt.Errorf("invalid fast I/O section count %d", c.SectionCount)
+	}
+	if c.SectionSize < uint32(unsafe.Sizeof(A.FastIOSectionHeader{})+unsafe.Sizeof(A.IntermediateBuffer{})) {
+		return fmt.Errorf("fast I/O section size %d can't hold a packet", c.SectionSize)
+	}
+	if c.SectionSize > uint32(unsafe.Sizeof(A.FastIOSection{})) {
+		return fmt.Errorf("fast I/O section size %d exceeds the maximum of %d", c.SectionSize, unsafe.Sizeof(A.FastIOSection{}))
+	}
+	if c.SpinCount < 0 {
+		return fmt.Errorf("invalid fast I/O spin count %d", c.SpinCount)
+	}
+	switch c.Poll {
+	case FastIOPollEvent, FastIOPollSpin, FastIOPollSpinYield:
+	default:
+		return errors.New("invalid fast I/O poll strategy")
+	}
+	return nil
+}
+
+// capacity returns the number of packets a section holds.
+func (c FastIOConfig) capacity() uint32 {
+	return (c.SectionSize - uint32(unsafe.Sizeof(A.FastIOSectionHeader{}))) / uint32(unsafe.Sizeof(A.IntermediateBuffer{}))
+}
+
+// FastIOStats holds the section utilization counters of a fast I/O packet filter.
+type FastIOStats struct {
+	SectionCount    int
+	SectionCapacity uint32 // packets a section holds
+	Reads           uint64 // section reads finding packets
+	Packets         uint64 // packets read from the sections
+	PeakPackets     uint32 // most packets found in a section by a read
+	FullSections    uint64 // section reads finding the section full, the driver queues the packets it can't write
+	Drains          uint64 // reads of the driver queue
+	DrainedPackets  uint64 // packets read from the driver queue
+}
+
+// fastIOCounters are updated by the working thread and read by Stats. The 64-bit counters come
+// first in fastIOSections, so they are aligned for the atomic operations on 32-bit platforms.
+type fastIOCounters struct {
+	reads          uint64
+	packets        uint64
+	fullSections   uint64
+	drains         uint64
+	drainedPackets uint64
+	peakPackets    uint32
+}
+
+// fastIOSections holds the shared memory sections the driver writes the redirected packets to,
+// and the buffers the packets read from them are processed and sent back in. The driver keeps
+// a single set of sections per handle, so a handle serves one fast I/O filter at a time.
+type fastIOSections struct {
+	counters fastIOCounters
+
+	api    A.NdisApiInterface
+	config FastIOConfig
+
+	packetBuffer        []A.IntermediateBuffer
+	writeAdapterRequest []*A.IntermediateBuffer
+	writeMstcpRequest   []*A.IntermediateBuffer
+	drainRequest        []*A.IntermediateBuffer // the tail of the packet buffer reserved for the drained packets
+	fastIO              [][]byte                // shared fast i/o memory
+	sections            []fastIOSection         // views of the shared memory
+
+	overflowed bool // a section was full on the last read
+	flushing   bool // the filter is stopping, drain reads the driver queue on every poll
+	spins      int  // empty polls since the last packet
+}
+
+// fastIOSection is a view of a shared memory section, its header and as many packets as fit after it.
+type fastIOSection struct {
+	header  *A.FastIOSectionHeader
+	packets []A.IntermediateBuffer
+}
+
+// parkedFastIOSection is registered in place of the sections of a stopped filter. Its read in progress
+// flag is never cleared, so the driver never writes to it and queues the redirected packets instead.
+var parkedFastIOSection = func() *A.InitializeFastIOSection {
+	section := &A.InitializeFastIOSection{}
+	section.FastIOHeader.ReadInProgressFlag = 1
+	return section
+}()
+
+// newFastIOSections allocates the sections and registers them with the driver.
+func newFastIOSections(api A.NdisApiInterface, config FastIOConfig) (*fastIOSections, error) {
+	config = config.withDefaults()
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	sectionPackets := int(config.capacity()) * config.SectionCount
+	s := &fastIOSections{
+		api:                 api,
+		config:              config,
+		packetBuffer:        make([]A.IntermediateBuffer, sectionPackets+A.UnsortedMaximumPacketBlock),
+		writeAdapterRequest: make([]*A.IntermediateBuffer, sectionPackets+A.UnsortedMaximumPacketBlock),
+		writeMstcpRequest:   make([]*A.IntermediateBuffer, sectionPackets+A.UnsortedMaximumPacketBlock),
+		drainRequest:        make([]*A.IntermediateBuffer, A.UnsortedMaximumPacketBlock),
+		fastIO:              make([][]byte, config.SectionCount),
+		sections:            make([]fastIOSection, config.SectionCount),
+	}
+	for i := range s.drainRequest {
+		s.drainRequest[i] = &s.packetBuffer[sectionPackets+i]
+	}
+	for i := range s.fastIO {
+		s.fastIO[i] = make([]byte, config.SectionSize)
+
+		// The section is shorter than A.FastIOSection, only the header and the packets it holds are addressed
+		s.sections[i] = fastIOSection{
+			header:  (*A.FastIOSectionHeader)(unsafe.Pointer(&s.fastIO[i][0])),
+			packets: unsafe.Slice((*A.IntermediateBuffer)(unsafe.Pointer(&s.fastIO[i][unsafe.Offsetof(A.InitializeFastIOSection{}.FastIOPackets)])), config.capacity()),
+		}
+	}
+
+	fastIOSection := (*A.InitializeFastIOSection)(unsafe.Pointer(&s.fastIO[0][0]))
+
+	if err := api.InitializeFastIoErr(fastIOSection, config.SectionSize); err != nil {
+		return nil, fmt.Errorf("failed to initialize fast IO: %w", err)
+	}
+
+	for i := 1; i < config.SectionCount; i++ {
+		fastIOSection = (*A.InitializeFastIOSection)(unsafe.Pointer(&s.fastIO[i][0]))
+
+		if err := api.AddSecondaryFastIoErr(fastIOSection, config.SectionSize); err != nil {
+			_ = s.release()
+			return nil, fmt.Errorf("failed to add secondary fast IO: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+// release unregisters the sections from the driver, which keeps writing to them otherwise. There is
+// no request to unregister them, so the parked section replaces them as the primary one.
+func (s *fastIOSections) release() error {
+	if err := s.api.InitializeFastIoErr(parkedFastIOSection, uint32(unsafe.Sizeof(*parkedFastIOSection))); err != nil {
+		return fmt.Errorf("failed to release fast IO: %w", err)
+	}
+	return nil
+}
+
+// requests returns the arrays of the packets to send to the adapters and to the protocol stack.
+func (s *fastIOSections) requests() (writeAdapterRequest, writeMstcpRequest []*A.IntermediateBuffer) {
+	return s.writeAdapterRequest, s.writeMstcpRequest
+}
+
+// read moves the packets written to the sections into the packet buffer and returns their number.
+func (s *fastIOSections) read() uint32 {
+	var fastIOPacketsSuccess uint32
+
+	s.overflowed = false
+	for n := range s.sections {
+		header, packets := s.sections[n].header, s.sections[n].packets
+
+		if join := atomic.LoadUint32((*uint32)(unsafe.Pointer(&header.FastIOWriteUnion))); join > 0 {
+			atomic.StoreUint32(&header.ReadInProgressFlag, 1)
+
+			writeUnion := atomic.LoadUint32((*uint32)(unsafe.Pointer(&header.FastIOWriteUnion)))
+
+			currentPacketsSuccess := (*A.FastIOWriteUnion)(unsafe.Pointer(&writeUnion)).GetNumberOfPackets()
+
+			// Copy packets and reset section
+			copy(s.packetBuffer[fastIOPacketsSuccess:], packets[:currentPacketsSuccess-1])
+
+			// For the last packet(s) wait for the write completion if in progress
+			writeUnion = atomic.LoadUint32((*uint32)(unsafe.Pointer(&header.FastIOWriteUnion)))
+
+			for (*A.FastIOWriteUnion)(unsafe.Pointer(&writeUnion)).GetWriteInProgressFlag() != 0 {
+				writeUnion = atomic.LoadUint32((*uint32)(unsafe.Pointer(&header.FastIOWriteUnion)))
+			}
+
+			// Copy the last packet(s)
+			copy(s.packetBuffer[fastIOPacketsSuccess+uint32(currentPacketsSuccess)-1:], packets[currentPacketsSuccess-1:currentPacketsSuccess])
+
+			if currentPacketsSuccess < header.FastIOWriteUnion.GetNumberOfPackets() {
+				currentPacketsSuccess = header.FastIOWriteUnion.GetNumberOfPackets()
+				copy(s.packetBuffer[fastIOPacketsSuccess+uint32(currentPacketsSuccess)-1:], packets[currentPacketsSuccess-1:currentPacketsSuccess])
+			}
+
+			atomic.StoreUint32((*uint32)(unsafe.Pointer(&header.FastIOWriteUnion)), 0)
+			atomic.StoreUint32(&header.ReadInProgressFlag, 0)
+
+			fastIOPacketsSuccess += uint32(currentPacketsSuccess)
+
+			s.count(uint32(currentPacketsSuccess))
+		}
+	}
+
+	return fastIOPacketsSuccess
+}
+
+// count updates the counters for a section read.
+func (s *fastIOSections) count(packets uint32) {
+	atomic.AddUint64(&s.counters.reads, 1)
+	atomic.AddUint64(&s.counters.packets, uint64(packets))
+	if packets > atomic.LoadUint32(&s.counters.peakPackets) {
+		atomic.StoreUint32(&s.counters.peakPackets, packets)
+	}
+	if packets >= s.config.capacity() {
+		atomic.AddUint64(&s.counters.fullSections, 1)
+		s.overflowed = true
+	}
+}
+
+// drain reads the packets the driver queued while the sections were full, when the configuration
+// asks for it or the filter is stopping, and moves them after the packets read from the sections.
+// It returns their number.
+func (s *fastIOSections) drain(packets uint32) (uint32, error) {
+	if !s.flushing && (!s.config.DrainOverflow || !s.overflowed) {
+		return 0, nil
+	}
+
+	var drained uint32
+	err := s.api.ReadPacketsUnsortedErr(s.drainRequest, uint32(len(s.drainRequest)), &drained)
+	if err != nil {
+		return 0, err
+	}
+	atomic.AddUint64(&s.counters.drains, 1)
+	atomic.AddUint64(&s.counters.drainedPackets, uint64(drained))
+
+	// A full read leaves more queued packets to drain after the next read of the sections
+	s.overflowed = drained == uint32(len(s.drainRequest))
+
+	tail := len(s.packetBuffer) - len(s.drainRequest)
+	copy(s.packetBuffer[packets:], s.packetBuffer[tail:tail+int(drained)])
+
+	return drained, nil
+}
+
+// flush makes the following polls drain the driver queue, so a stopping filter writes the packets
+// queued while the sections were full before the queue is flushed.
+func (s *fastIOSections) flush() {
+	s.flushing = true
+}
+
+// idle is called after each poll of the sections with the number of packets found. While the sections
+// are empty it waits for the packet event, spins or yields the processor as the configuration asks.
+func (s *fastIOSections) idle(ctx context.Context, packets uint32, waitEvent func() error) error {
+	if packets > 0 {
+		s.spins = 0
+		return nil
+	}
+
+	switch s.config.Poll {
+	case FastIOPollSpin:
+	case FastIOPollSpinYield:
+		if s.spins < s.config.SpinCount {
+			s.spins++
+		} else {
+			runtime.Gosched()
+		}
+	default:
+		return waitEvent()
+	}
+
+	return ctx.Err()
+}
+
+// stats returns a snapshot of the counters.
+func (s *fastIOSections) stats() FastIOStats {
+	return FastIOStats{
+		SectionCount:    s.config.SectionCount,
+		SectionCapacity: s.config.capacity(),
+		Reads:           atomic.LoadUint64(&s.counters.reads),
+		Packets:         atomic.LoadUint64(&s.counters.packets),
+		PeakPackets:     atomic.LoadUint32(&s.counters.peakPackets),
+		FullSections:    atomic.LoadUint64(&s.counters.fullSections),
+		Drains:          atomic.LoadUint64(&s.counters.drains),
+		DrainedPackets:  atomic.LoadUint64(&s.counters.drainedPackets),
+	}
+}
@@ -0,0 +1,102 @@
+package driver_test
+
+import (
+	"context"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	D "github.com/wiresock/ndisapi-go/driver"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+func TestFastIOConfig(t *testing.T) {
+	api := sim.NewDriver()
+	api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+	adapters, err := api.GetTcpipBoundAdaptersInfo()
+	require.NoError(t, err)
+
+	filter, err := D.NewFastIOPacketFilter(context.Background(), api, adapters, nil, nil, true)
+	require.NoError(t, err)
+
+	// The default sections hold 3 MB each
+	stats := filter.FastIOStats()
+	assert.Equal(t, 4, stats.SectionCount)
+	assert.Equal(t, uint32((0x300000-unsafe.Sizeof(A.FastIOSectionHeader{}))/unsafe.Sizeof(A.IntermediateBuffer{})), stats.SectionCapacity)
+
+	assert.Error(t, filter.SetFastIOConfig(D.FastIOConfig{SectionCount: -1}))
+	assert.Error(t, filter.SetFastIOConfig(D.FastIOConfig{SectionSize: 16}))
+	assert.Error(t, filter.SetFastIOConfig(D.FastIOConfig{SectionSize: 1 << 30}))
+	assert.Error(t, filter.SetFastIOConfig(D.FastIOConfig{Poll: D.FastIOPollStrategy(42)}))
+
+	require.NoError(t, filter.SetFastIOConfig(D.FastIOConfig{SectionCount: 2}))
+	assert.Equal(t, 2, filter.FastIOStats().SectionCount)
+	assert.Equal(t, "spin-yield", D.FastIOPollSpinYield.String())
+}
+
+func TestFastIOPacketFilter_DrainOverflow(t *testing.T) {
+	for _, poll := range []D.FastIOPollStrategy{D.FastIOPollEvent, D.FastIOPollSpin, D.FastIOPollSpinYield} {
+		poll := poll
+		t.Run(poll.String(), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			api := sim.NewDriver()
+			handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+			adapters, err := api.GetTcpipBoundAdaptersInfo()
+			require.NoError(t, err)
+
+			// The first packet holds the working thread while the others arrive
+			blocked := make(chan struct{})
+			release := make(chan struct{})
+			in := func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction {
+				if dstPort(buffer) == 1 {
+					close(blocked)
+					<-release
+				}
+				return A.FilterActionPass
+			}
+
+			filter, err := D.NewFastIOPacketFilter(ctx, api, adapters, in, nil, true)
+			require.NoError(t, err)
+			require.NoError(t, filter.SetFastIOConfig(D.FastIOConfig{
+				SectionCount:  1,
+				SectionSize:   uint32(unsafe.Sizeof(A.FastIOSectionHeader{}) + 2*unsafe.Sizeof(A.IntermediateBuffer{})),
+				Poll:          poll,
+				SpinCount:     10,
+				DrainOverflow: true,
+			}))
+			require.NoError(t, filter.StartFilter(0))
+			waitRunning(filter)
+
+			require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(1)))
+			<-blocked
+
+			// The section holds two packets, the driver queues the others
+			for port := uint16(2); port <= 6; port++ {
+				require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(port)))
+			}
+			close(release)
+
+			waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+			defer waitCancel()
+			require.NoError(t, api.WaitDelivered(waitCtx, 6))
+
+			stats := filter.FastIOStats()
+			assert.Equal(t, uint32(2), stats.SectionCapacity)
+			assert.Equal(t, uint64(3), stats.Packets)
+			assert.Equal(t, uint32(2), stats.PeakPackets)
+			assert.Equal(t, uint64(1), stats.FullSections)
+			assert.Equal(t, uint64(3), stats.DrainedPackets)
+
+			require.NoError(t, filter.Close())
+			assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, ports(api.StackPackets(handle)))
+		})
+	}
+}
@@ -1,27 +1,53 @@
-//go:build windows
-
 package driver
 
 import (
 	"bytes"
+	"fmt"
 	"net"
 
 	A "github.com/wiresock/ndisapi-go"
 )
 
+// Filter describes a static filter. Zero valued criteria do not take part in the match.
 type Filter struct {
 	*A.StaticFilter
 	AdapterHandle         A.Handle
 	SourceMacAddress      net.HardwareAddr
 	DestinationMacAddress net.HardwareAddr
 	EthernetType          uint16
-	SourceAddress         net.IPNet
-	DestinationAddress    net.IPNet
-	SourcePort            [2]uint16
-	DestinationPort       [2]uint16
-	Protocol              uint8
-	Direction             PacketDirection
-	Action                A.FilterAction
+	// IPVersion restricts the filter to IPv4 (4) or IPv6 (6) packets. It may be left zero when
+	// the addresses imply the version, a Protocol without addresses applies to IPv4 packets.
+	IPVersion               uint8
+	SourceAddress           net.IPNet
+	DestinationAddress      net.IPNet
+	SourceAddressRange      IPRange
+	DestinationAddressRange IPRange
+	SourcePort              [2]uint16
+	DestinationPort         [2]uint16
+	Protocol                uint8
+	// TCPFlags matches TCP packets having all of the flags set.
+	TCPFlags uint8
+	// ICMPType and ICMPCode match ICMP packets, they can't be combined with ports and TCP flags.
+	ICMPType  *A.ByteRange
+	ICMPCode  *A.ByteRange
+	Direction PacketDirection
+	Action    A.FilterAction
+}
+
+// IPRange is an inclusive range of IPv4 or IPv6 addresses.
+type IPRange struct {
+	Start net.IP
+	End   net.IP
+}
+
+// IsZero reports whether the range is not set.
+func (r IPRange) IsZero() bool {
+	return r.Start == nil && r.End == nil
+}
+
+// Equal checks if two ranges are equal
+func (r IPRange) Equal(other IPRange) bool {
+	return r.Start.Equal(other.Start) && r.End.Equal(other.End)
 }
 
 // Equal checks if two filters are equal
@@ -36,11 +62,104 @@ func (f *Filter) Equal(other *Filter) bool {
 		bytes.Equal(f.SourceMacAddress, other.SourceMacAddress) &&
 		bytes.Equal(f.DestinationMacAddress, other.DestinationMacAddress) &&
 		f.EthernetType == other.EthernetType &&
+		f.ipVersion() == other.ipVersion() &&
 		f.SourceAddress.String() == other.SourceAddress.String() &&
 		f.DestinationAddress.String() == other.DestinationAddress.String() &&
+		f.SourceAddressRange.Equal(other.SourceAddressRange) &&
+		f.DestinationAddressRange.Equal(other.DestinationAddressRange) &&
 		f.SourcePort == other.SourcePort &&
 		f.DestinationPort == other.DestinationPort &&
 		f.Protocol == other.Protocol &&
+		f.TCPFlags == other.TCPFlags &&
+		equalByteRange(f.ICMPType, other.ICMPType) &&
+		equalByteRange(f.ICMPCode, other.ICMPCode) &&
 		f.Direction == other.Direction &&
 		f.Action == other.Action
 }
+
+// Validate checks that the filter criteria can be expressed by a single static filter.
+func (f *Filter) Validate() error {
+	if f.SourceMacAddress != nil && len(f.SourceMacAddress) != A.ETHER_ADDR_LENGTH {
+		return fmt.Errorf("invalid source MAC address: %v", f.SourceMacAddress)
+	}
+	if f.DestinationMacAddress != nil && len(f.DestinationMacAddress) != A.ETHER_ADDR_LENGTH {
+		return fmt.Errorf("invalid destination MAC address: %v", f.DestinationMacAddress)
+	}
+
+	if f.IPVersion != 0 && f.IPVersion != 4 && f.IPVersion != 6 {
+		return fmt.Errorf("invalid IP version: %d", f.IPVersion)
+	}
+	if f.SourceAddress.IP != nil && !f.SourceAddressRange.IsZero() {
+		return fmt.Errorf("source address and source address range are mutually exclusive")
+	}
+	if f.DestinationAddress.IP != nil && !f.DestinationAddressRange.IsZero() {
+		return fmt.Errorf("destination address and destination address range are mutually exclusive")
+	}
+
+	version := f.ipVersion()
+	for _, ip := range []net.IP{
+		f.SourceAddress.IP, f.DestinationAddress.IP,
+		f.SourceAddressRange.Start, f.SourceAddressRange.End,
+		f.DestinationAddressRange.Start, f.DestinationAddressRange.End,
+	} {
+		if ip != nil && ipVersionOf(ip) != version {
+			return fmt.Errorf("address %v does not match IP version %d", ip, version)
+		}
+	}
+	for _, r := range []IPRange{f.SourceAddressRange, f.DestinationAddressRange} {
+		if !r.IsZero() && (r.Start == nil || r.End == nil) {
+			return fmt.Errorf("address range requires both bounds")
+		}
+	}
+	for _, ipNet := range []net.IPNet{f.SourceAddress, f.DestinationAddress} {
+		if ipNet.IP != nil && len(ipNet.Mask) != len(ipAddress(ipNet.IP, version)) {
+			return fmt.Errorf("invalid mask of %v", ipNet.IP)
+		}
+	}
+
+	if (f.ICMPType != nil || f.ICMPCode != nil) && (f.SourcePort != [2]uint16{} || f.DestinationPort != [2]uint16{} || f.TCPFlags != 0) {
+		return fmt.Errorf("ICMP criteria can't be combined with ports and TCP flags")
+	}
+
+	return nil
+}
+
+// ipVersion returns the IP version the network layer criteria apply to, zero if there are none.
+func (f *Filter) ipVersion() uint8 {
+	if f.IPVersion != 0 {
+		return f.IPVersion
+	}
+	for _, ip := range []net.IP{f.SourceAddress.IP, f.DestinationAddress.IP, f.SourceAddressRange.Start, f.DestinationAddressRange.Start} {
+		if ip != nil {
+			return ipVersionOf(ip)
+		}
+	}
+	if f.Protocol != 0 {
+		return 4
+	}
+	return 0
+}
+
+// ipVersionOf returns 4 for IPv4 addresses and 6 otherwise.
+func ipVersionOf(ip net.IP) uint8 {
+	if ip.To4() != nil {
+		return 4
+	}
+	return 6
+}
+
+// ipAddress returns the 4 or 16 byte form of the address for the IP version.
+func ipAddress(ip net.IP, version uint8) net.IP {
+	if version == 4 {
+		return ip.To4()
+	}
+	return ip.To16()
+}
+
+// equalByteRange checks if two optional byte ranges are equal.
+func equalByteRange(a, b *A.ByteRange) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,56 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// atomicFilterState holds the state of a packet filter. It is changed under the filter lock and read
+// by the working threads and GetFilterState without it.
+type atomicFilterState struct {
+	value uint32
+}
+
+func (s *atomicFilterState) load() FilterState {
+	return FilterState(atomic.LoadUint32(&s.value))
+}
+
+func (s *atomicFilterState) store(state FilterState) {
+	atomic.StoreUint32(&s.value, uint32(state))
+}
+
+// doneContext stops the working threads of a filter at once, as Close does.
+var doneContext = func() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}()
+
+// waitFlushed waits for the working threads to write the packets in flight and exit. When the context is
+// done first, the working threads are cancelled, discarding the packets left, and its error is returned.
+func waitFlushed(ctx context.Context, wg *sync.WaitGroup, cancel context.CancelFunc) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	if ctx.Err() == nil {
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+		}
+	}
+	cancel()
+	<-done
+
+	return ctx.Err()
+}
+
+// flushing tells whether a working thread whose wait for packets failed should read the packets left in
+// the driver queue and exit, rather than exit at once: the filter is being stopped gracefully.
+func flushing(ctx, readCtx context.Context) bool {
+	return ctx.Err() == nil && readCtx.Err() != nil
+}
@@ -0,0 +1,297 @@
+package driver_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	D "github.com/wiresock/ndisapi-go/driver"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+// blockingFilter holds the first packet in its callback until released and counts the packets it filters.
+type blockingFilter struct {
+	once     sync.Once
+	blocked  chan struct{}
+	release  chan struct{}
+	filtered int32
+}
+
+func newBlockingFilter() *blockingFilter {
+	return &blockingFilter{blocked: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (b *blockingFilter) filter(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction {
+	b.once.Do(func() {
+		close(b.blocked)
+		<-b.release
+	})
+	atomic.AddInt32(&b.filtered, 1)
+	return A.FilterActionPass
+}
+
+// startLifecyclePipeline starts the pipeline on a new simulated adapter with the callback for both directions.
+func startLifecyclePipeline(t *testing.T, ctx context.Context, pipeline verdictPipeline, filter filterFunc) (*sim.Driver, A.Handle, testFilter) {
+	api := sim.NewDriver()
+	handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+	adapters, err := api.GetTcpipBoundAdaptersInfo()
+	require.NoError(t, err)
+
+	packetFilter, err := pipeline.create(ctx, api, adapters, filter, filter)
+	require.NoError(t, err)
+	require.NoError(t, startPipeline(pipeline, packetFilter))
+
+	return api, handle, packetFilter
+}
+
+func TestPacketFilter_StopFlushes(t *testing.T) {
+	const packets = 16
+
+	for _, pipeline := range verdictPipelines {
+		pipeline := pipeline
+		t.Run(pipeline.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			blocking := newBlockingFilter()
+			api, handle, filter := startLifecyclePipeline(t, ctx, pipeline, blocking.filter)
+
+			for i := 0; i < packets; i++ {
+				require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(inPortBase+uint16(i))))
+			}
+
+			select {
+			case <-blocking.blocked:
+			case <-ctx.Done():
+				t.Fatal("the filter did not read the packets")
+			}
+
+			stopped := make(chan error, 1)
+			go func() {
+				stopped <- filter.Stop(ctx)
+			}()
+
+			// Stop waits for the packets in flight
+			require.Eventually(t, func() bool {
+				return filter.GetFilterState() == D.FilterStateStopping
+			}, 5*time.Second, time.Millisecond)
+			select {
+			case err := <-stopped:
+				t.Fatalf("Stop returned before the packets were written: %v", err)
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			close(blocking.release)
+			require.NoError(t, <-stopped)
+
+			assert.Equal(t, D.FilterStateStopped, filter.GetFilterState())
+			assert.Equal(t, int32(packets), atomic.LoadInt32(&blocking.filtered))
+			assert.Len(t, api.StackPackets(handle), packets)
+			assert.Error(t, filter.Stop(ctx))
+		})
+	}
+}
+
+func TestPacketFilter_StopTimeout(t *testing.T) {
+	for _, pipeline := range verdictPipelines {
+		pipeline := pipeline
+		t.Run(pipeline.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			blocking := newBlockingFilter()
+			api, handle, filter := startLifecyclePipeline(t, ctx, pipeline, blocking.filter)
+
+			require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(inPortBase)))
+			select {
+			case <-blocking.blocked:
+			case <-ctx.Done():
+				t.Fatal("the filter did not read the packet")
+			}
+
+			// The callback returns after the stop deadline, the filter is stopped anyway
+			time.AfterFunc(100*time.Millisecond, func() {
+				close(blocking.release)
+			})
+			stopCtx, stopCancel := context.WithTimeout(ctx, 20*time.Millisecond)
+			defer stopCancel()
+
+			assert.ErrorIs(t, filter.Stop(stopCtx), context.DeadlineExceeded)
+			assert.Equal(t, D.FilterStateStopped, filter.GetFilterState())
+		})
+	}
+}
+
+func TestPacketFilter_PauseResume(t *testing.T) {
+	const packets = 4
+
+	for _, pipeline := range verdictPipelines {
+		pipeline := pipeline
+		t.Run(pipeline.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			var filtered int32
+			count := func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction {
+				atomic.AddInt32(&filtered, 1)
+				return A.FilterActionPass
+			}
+			api, handle, filter := startLifecyclePipeline(t, ctx, pipeline, count)
+
+			assert.Error(t, filter.Resume())
+			require.NoError(t, filter.Pause())
+			assert.Equal(t, D.FilterStatePaused, filter.GetFilterState())
+			assert.Error(t, filter.Pause())
+
+			// The paused filter passes the traffic through
+			for i := 0; i < packets; i++ {
+				require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(inPortBase+uint16(i))))
+			}
+			require.NoError(t, api.WaitDelivered(ctx, packets))
+			assert.Equal(t, int32(0), atomic.LoadInt32(&filtered))
+
+			require.NoError(t, filter.Resume())
+			assert.Equal(t, D.FilterStateRunning, filter.GetFilterState())
+
+			for i := 0; i < packets; i++ {
+				require.NoError(t, api.SendFromStack(handle, udpFrame(outPortBase+uint16(i))))
+			}
+			require.NoError(t, api.WaitDelivered(ctx, 2*packets))
+			assert.Equal(t, int32(packets), atomic.LoadInt32(&filtered))
+
+			require.NoError(t, filter.Stop(ctx))
+			assert.Error(t, filter.Pause())
+			assert.Len(t, api.StackPackets(handle), packets)
+			assert.Len(t, api.WirePackets(handle), packets)
+		})
+	}
+}
+
+func TestPacketFilter_StoppedOnReadFailure(t *testing.T) {
+	for _, pipeline := range verdictPipelines {
+		pipeline := pipeline
+		t.Run(pipeline.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			pass := func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction {
+				return A.FilterActionPass
+			}
+			api, handle, filter := startLifecyclePipeline(t, ctx, pipeline, pass)
+
+			// The wait for packets fails, the working thread exits and the filter is stopped
+			require.NoError(t, api.ClosePacketEvent(handle))
+			for i := 0; i < 1000 && filter.GetFilterState() != D.FilterStateStopped; i++ {
+				time.Sleep(time.Millisecond)
+			}
+			require.Equal(t, D.FilterStateStopped, filter.GetFilterState())
+			assert.Error(t, filter.Pause())
+			assert.Error(t, filter.Stop(ctx))
+
+			// The adapter passes its traffic through again
+			require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(inPortBase)))
+			require.NoError(t, api.WaitDelivered(ctx, 1))
+			assert.Len(t, api.StackPackets(handle), 1)
+		})
+	}
+}
+
+func TestPacketFilter_ConcurrentLifecycle(t *testing.T) {
+	const packets = 200
+
+	for _, pipeline := range verdictPipelines {
+		pipeline := pipeline
+		t.Run(pipeline.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			pass := func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction {
+				return A.FilterActionPass
+			}
+			api, handle, filter := startLifecyclePipeline(t, ctx, pipeline, pass)
+
+			// The traffic and the observers run while the filter is paused and resumed
+			var wg sync.WaitGroup
+			done := make(chan struct{})
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < packets; i++ {
+					assert.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(inPortBase+uint16(i))))
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+						_ = filter.GetFilterState()
+						_ = filter.Stats()
+					}
+				}
+			}()
+
+			for i := 0; i < 10; i++ {
+				require.NoError(t, filter.Pause())
+				require.NoError(t, filter.Resume())
+			}
+			require.NoError(t, filter.Pause())
+
+			close(done)
+			wg.Wait()
+
+			// No packet is lost across the transitions
+			require.NoError(t, filter.Stop(ctx))
+			assert.Len(t, api.StackPackets(handle), packets)
+		})
+	}
+}
+
+func TestPacketFilter_ConcurrentReconfigure(t *testing.T) {
+	for _, pipeline := range verdictPipelines {
+		pipeline := pipeline
+		t.Run(pipeline.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			api := sim.NewDriver()
+			api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+			adapters, err := api.GetTcpipBoundAdaptersInfo()
+			require.NoError(t, err)
+
+			pass := func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction {
+				return A.FilterActionPass
+			}
+			filter, err := pipeline.create(ctx, api, adapters, pass, pass)
+			require.NoError(t, err)
+
+			// The concurrent calls rebuild the adapter list one after another
+			for i := 0; i < 10; i++ {
+				var wg sync.WaitGroup
+				wg.Add(2)
+				for j := 0; j < 2; j++ {
+					go func() {
+						defer wg.Done()
+						assert.NoError(t, filter.Reconfigure())
+					}()
+				}
+				wg.Wait()
+
+				require.NoError(t, startPipeline(pipeline, filter))
+
+				assert.Error(t, filter.Reconfigure())
+				require.NoError(t, filter.Stop(ctx))
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package driver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// TrafficCounters holds the packet and byte counters of a static filter.
+type TrafficCounters struct {
+	PacketsIn  uint64 `json:"packetsIn"`
+	BytesIn    uint64 `json:"bytesIn"`
+	PacketsOut uint64 `json:"packetsOut"`
+	BytesOut   uint64 `json:"bytesOut"`
+}
+
+// TrafficRates holds the packet and byte rates of a static filter, per second.
+type TrafficRates struct {
+	PacketsIn  float64 `json:"packetsIn"`
+	BytesIn    float64 `json:"bytesIn"`
+	PacketsOut float64 `json:"packetsOut"`
+	BytesOut   float64 `json:"bytesOut"`
+}
+
+// FilterStats holds the traffic statistics of a static filter.
+type FilterStats struct {
+	Position int    `json:"position"`
+	Rule     string `json:"rule"`
+	Label    string `json:"label,omitempty"`
+	Filter   Filter `json:"-"`
+	// Total accumulates the counters since the collector first saw the filter, across counter resets.
+	Total TrafficCounters `json:"total"`
+	// Delta holds the traffic since the previous collection.
+	Delta TrafficCounters `json:"delta"`
+	// Rate is the Delta divided by the time elapsed since the previous collection.
+	Rate      TrafficRates `json:"rate"`
+	LastReset uint32       `json:"lastReset"`
+}
+
+// StatsSnapshot holds the statistics of the static filter table at a point in time.
+type StatsSnapshot struct {
+	Time     time.Time     `json:"time"`
+	Interval time.Duration `json:"interval"`
+	Filters  []FilterStats `json:"filters"`
+}
+
+// StatsCollector polls the counters of the static filter table and maps them to the filters.
+type StatsCollector struct {
+	sync.Mutex
+	filters  *StaticFilters
+	names    *AdapterNames
+	labels   []statsLabel
+	previous map[statsKey]statsEntry
+	snapshot *StatsSnapshot
+}
+
+// statsLabel is a user label of the filters matching the static filter.
+type statsLabel struct {
+	filter A.StaticFilter
+	label  string
+}
+
+// statsKey identifies a static filter across collections by its criteria and its occurrence
+// among the equal filters of the table, since the positions change when the table is updated.
+type statsKey struct {
+	filter     A.StaticFilter
+	occurrence int
+}
+
+// statsEntry holds the state of a static filter at the previous collection.
+type statsEntry struct {
+	counters  TrafficCounters
+	total     TrafficCounters
+	lastReset uint32
+}
+
+// NewStatsCollector creates a collector of the static filter table statistics.
+// The adapter names are used to format the rules, names may be nil.
+func NewStatsCollector(filters *StaticFilters, names *AdapterNames) *StatsCollector {
+	return &StatsCollector{
+		filters:  filters,
+		names:    names,
+		previous: make(map[statsKey]statsEntry),
+	}
+}
+
+// SetLabel labels the statistics of the filters equal to filter.
+func (c *StatsCollector) SetLabel(filter *Filter, label string) error {
+	staticFilter, err := toStaticFilter(filter)
+	if err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	key := withoutCounters(staticFilter)
+	for i := range c.labels {
+		if c.labels[i].filter == key {
+			c.labels[i].label = label
+			return nil
+		}
+	}
+	c.labels = append(c.labels, statsLabel{filter: key, label: label})
+
+	return nil
+}
+
+// Collect reads the counters of the static filter table and returns the resulting snapshot.
+func (c *StatsCollector) Collect() (*StatsSnapshot, error) {
+	table, err := c.filters.readTable()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	c.Lock()
+	defer c.Unlock()
+
+	snapshot := &StatsSnapshot{
+		Time:    now,
+		Filters: make([]FilterStats, 0, len(table.StaticFilters)),
+	}
+	if c.snapshot != nil {
+		snapshot.Interval = now.Sub(c.snapshot.Time)
+	}
+
+	occurrences := make(map[A.StaticFilter]int)
+	current := make(map[statsKey]statsEntry, len(table.StaticFilters))
+
+	for i := range table.StaticFilters {
+		staticFilter := &table.StaticFilters[i]
+		key := statsKey{filter: withoutCounters(staticFilter)}
+		key.occurrence = occurrences[key.filter]
+		occurrences[key.filter]++
+
+		counters := TrafficCounters{
+			PacketsIn:  staticFilter.PacketsIn,
+			BytesIn:    staticFilter.BytesIn,
+			PacketsOut: staticFilter.PacketsOut,
+			BytesOut:   staticFilter.BytesOut,
+		}
+
+		// The counters restart from zero when the driver resets them
+		delta := counters
+		previous, seen := c.previous[key]
+		if seen && previous.lastReset == staticFilter.LastReset &&
+			counters.PacketsIn >= previous.counters.PacketsIn && counters.BytesIn >= previous.counters.BytesIn &&
+			counters.PacketsOut >= previous.counters.PacketsOut && counters.BytesOut >= previous.counters.BytesOut {
+			delta = TrafficCounters{
+				PacketsIn:  counters.PacketsIn - previous.counters.PacketsIn,
+				BytesIn:    counters.BytesIn - previous.counters.BytesIn,
+				PacketsOut: counters.PacketsOut - previous.counters.PacketsOut,
+				BytesOut:   counters.BytesOut - previous.counters.BytesOut,
+			}
+		}
+
+		total := TrafficCounters{
+			PacketsIn:  previous.total.PacketsIn + delta.PacketsIn,
+			BytesIn:    previous.total.BytesIn + delta.BytesIn,
+			PacketsOut: previous.total.PacketsOut + delta.PacketsOut,
+			BytesOut:   previous.total.BytesOut + delta.BytesOut,
+		}
+		current[key] = statsEntry{counters: counters, total: total, lastReset: staticFilter.LastReset}
+
+		var rate TrafficRates
+		if seconds := snapshot.Interval.Seconds(); seconds > 0 {
+			rate = TrafficRates{
+				PacketsIn:  float64(delta.PacketsIn) / seconds,
+				BytesIn:    float64(delta.BytesIn) / seconds,
+				PacketsOut: float64(delta.PacketsOut) / seconds,
+				BytesOut:   float64(delta.BytesOut) / seconds,
+			}
+		}
+
+		filter := fromStaticFilter(staticFilter)
+		filter.StaticFilter = nil
+		stats := FilterStats{
+			Position:  i,
+			Rule:      FormatRule(filter, c.names),
+			Filter:    *filter,
+			Total:     total,
+			Delta:     delta,
+			Rate:      rate,
+			LastReset: staticFilter.LastReset,
+		}
+		for _, label := range c.labels {
+			if label.filter == key.filter {
+				stats.Label = label.label
+			}
+		}
+
+		snapshot.Filters = append(snapshot.Filters, stats)
+	}
+
+	c.previous = current
+	c.snapshot = snapshot
+
+	return snapshot, nil
+}
+
+// Run collects the statistics every interval until the context is canceled.
+func (c *StatsCollector) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.Collect(); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Snapshot returns the latest snapshot, nil if the statistics were not collected yet.
+func (c *StatsCollector) Snapshot() *StatsSnapshot {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.snapshot
+}
+
+// ServeHTTP serves the latest snapshot in the Prometheus text format, or as JSON when
+// the format=json query parameter is set.
+func (c *StatsCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	snapshot := c.Snapshot()
+	if snapshot == nil {
+		snapshot = &StatsSnapshot{}
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(snapshot)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = snapshot.WritePrometheus(w)
+}
+
+// WritePrometheus writes the snapshot in the Prometheus text exposition format.
+func (s *StatsSnapshot) WritePrometheus(w io.Writer) error {
+	metrics := []struct {
+		name  string
+		kind  string
+		help  string
+		value func(stats *FilterStats) (in, out string)
+	}{
+		{"ndisapi_static_filter_packets_total", "counter", "Packets matched by the static filter.", func(stats *FilterStats) (string, string) {
+			return strconv.FormatUint(stats.Total.PacketsIn, 10), strconv.FormatUint(stats.Total.PacketsOut, 10)
+		}},
+		{"ndisapi_static_filter_bytes_total", "counter", "Bytes matched by the static filter.", func(stats *FilterStats) (string, string) {
+			return strconv.FormatUint(stats.Total.BytesIn, 10), strconv.FormatUint(stats.Total.BytesOut, 10)
+		}},
+		{"ndisapi_static_filter_packets_per_second", "gauge", "Packet rate of the static filter over the last collection interval.", func(stats *FilterStats) (string, string) {
+			return formatRate(stats.Rate.PacketsIn), formatRate(stats.Rate.PacketsOut)
+		}},
+		{"ndisapi_static_filter_bytes_per_second", "gauge", "Byte rate of the static filter over the last collection interval.", func(stats *FilterStats) (string, string) {
+			return formatRate(stats.Rate.BytesIn), formatRate(stats.Rate.BytesOut)
+		}},
+	}
+
+	out := bufio.NewWriter(w)
+	for _, metric := range metrics {
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		for i := range s.Filters {
+			stats := &s.Filters[i]
+			in, outbound := metric.value(stats)
+			for _, sample := range []struct{ direction, value string }{{"in", in}, {"out", outbound}} {
+				fmt.Fprintf(out, "%s{position=\"%d\",rule=\"%s\",label=\"%s\",direction=\"%s\"} %s\n",
+					metric.name, stats.Position, escapeLabel(stats.Rule), escapeLabel(stats.Label), sample.direction, sample.value)
+			}
+		}
+	}
+
+	return out.Flush()
+}
+
+// withoutCounters returns the static filter with its statistics cleared.
+func withoutCounters(filter *A.StaticFilter) A.StaticFilter {
+	result := *filter
+	result.LastReset, result.PacketsIn, result.BytesIn, result.PacketsOut, result.BytesOut = 0, 0, 0, 0, 0
+	return result
+}
+
+// escapeLabel escapes a Prometheus label value.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatRate formats a rate as a Prometheus sample value.
+func formatRate(rate float64) string {
+	return strconv.FormatFloat(rate, 'f', -1, 64)
+}
@@ -0,0 +1,81 @@
+package driver_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	D "github.com/wiresock/ndisapi-go/driver"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+func TestStatsCollector(t *testing.T) {
+	api := sim.NewDriver()
+	handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+	require.NoError(t, api.SetAdapterMode(&A.AdapterMode{AdapterHandle: handle, Flags: A.MSTCP_FLAG_RECV_TUNNEL}))
+
+	filters, err := D.NewStaticFilters(api, true, false)
+	require.NoError(t, err)
+	defer filters.Close()
+
+	rules, err := D.ParseRules("drop in udp to any port 1000-1999\npass both\n", nil)
+	require.NoError(t, err)
+	require.NoError(t, filters.Apply(rules))
+
+	collector := D.NewStatsCollector(filters, nil)
+	require.NoError(t, collector.SetLabel(&rules[0], "blocked"))
+	assert.Nil(t, collector.Snapshot())
+
+	frameLength := uint64(len(udpFrame(1500)))
+	for _, port := range []uint16{500, 1500, 1600} {
+		require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(port)))
+	}
+
+	snapshot, err := collector.Collect()
+	require.NoError(t, err)
+	require.Len(t, snapshot.Filters, 2)
+	assert.Equal(t, "drop in udp to any port 1000-1999", snapshot.Filters[0].Rule)
+	assert.Equal(t, "blocked", snapshot.Filters[0].Label)
+	assert.True(t, snapshot.Filters[0].Filter.Equal(&rules[0]))
+	assert.Equal(t, D.TrafficCounters{PacketsIn: 2, BytesIn: 2 * frameLength}, snapshot.Filters[0].Total)
+	assert.Equal(t, D.TrafficCounters{PacketsIn: 1, BytesIn: frameLength}, snapshot.Filters[1].Total)
+	assert.Zero(t, snapshot.Filters[0].Rate)
+
+	// The counters are reset by the driver between the collections and a filter is inserted in front.
+	_, err = api.GetPacketFilterTableResetStats()
+	require.NoError(t, err)
+	require.True(t, filters.AddFilterFront(&D.Filter{Direction: D.PacketDirectionOut, Action: A.FilterActionPass}))
+	require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(1700)))
+	time.Sleep(20 * time.Millisecond)
+
+	snapshot, err = collector.Collect()
+	require.NoError(t, err)
+	require.Len(t, snapshot.Filters, 3)
+	assert.Equal(t, "blocked", snapshot.Filters[1].Label)
+	assert.Equal(t, D.TrafficCounters{PacketsIn: 1, BytesIn: frameLength}, snapshot.Filters[1].Delta)
+	assert.Equal(t, D.TrafficCounters{PacketsIn: 3, BytesIn: 3 * frameLength}, snapshot.Filters[1].Total)
+	assert.Equal(t, D.TrafficCounters{PacketsIn: 1, BytesIn: frameLength}, snapshot.Filters[2].Total)
+	assert.Positive(t, snapshot.Interval)
+	assert.Positive(t, snapshot.Filters[1].Rate.PacketsIn)
+	assert.Same(t, snapshot, collector.Snapshot())
+
+	recorder := httptest.NewRecorder()
+	collector.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	assert.Contains(t, body, "# TYPE ndisapi_static_filter_packets_total counter\n")
+	assert.Contains(t, body, `ndisapi_static_filter_packets_total{position="1",rule="drop in udp to any port 1000-1999",label="blocked",direction="in"} 3`+"\n")
+	assert.Contains(t, body, `ndisapi_static_filter_bytes_total{position="2",rule="pass both",label="",direction="out"} 0`+"\n")
+
+	recorder = httptest.NewRecorder()
+	collector.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics?format=json", nil))
+	var decoded D.StatsSnapshot
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &decoded))
+	require.Len(t, decoded.Filters, 3)
+	assert.Equal(t, snapshot.Filters[1].Total, decoded.Filters[1].Total)
+	assert.Equal(t, "blocked", decoded.Filters[1].Label)
+}
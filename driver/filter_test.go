@@ -0,0 +1,326 @@
+package driver_test
+
+import (
+	"math/rand"
+	"net"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	D "github.com/wiresock/ndisapi-go/driver"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+// randomFilter generates valid filters using every criterion supported by the static filters.
+type randomFilter struct {
+	D.Filter
+}
+
+func (randomFilter) Generate(r *rand.Rand, size int) reflect.Value {
+	var filter D.Filter
+	filter.AdapterHandle = A.Handle{byte(r.Intn(3))}
+	filter.Direction = D.PacketDirection(r.Intn(3))
+	filter.Action = verdicts[r.Intn(len(verdicts))]
+
+	if r.Intn(2) == 0 {
+		filter.SourceMacAddress = randomBytes(r, A.ETHER_ADDR_LENGTH)
+	}
+	if r.Intn(2) == 0 {
+		filter.DestinationMacAddress = randomBytes(r, A.ETHER_ADDR_LENGTH)
+	}
+	if r.Intn(2) == 0 {
+		filter.EthernetType = uint16(1 + r.Intn(0xFFFF))
+	}
+
+	if version := []uint8{0, 4, 6}[r.Intn(3)]; version != 0 {
+		if r.Intn(2) == 0 {
+			filter.IPVersion = version
+		}
+		switch r.Intn(3) {
+		case 1:
+			filter.SourceAddress = randomIPNet(r, version)
+		case 2:
+			filter.SourceAddressRange = randomIPRange(r, version)
+		}
+		switch r.Intn(3) {
+		case 1:
+			filter.DestinationAddress = randomIPNet(r, version)
+		case 2:
+			filter.DestinationAddressRange = randomIPRange(r, version)
+		}
+		if r.Intn(2) == 0 {
+			filter.Protocol = uint8(1 + r.Intn(0xFF))
+		}
+	}
+
+	switch r.Intn(3) {
+	case 1:
+		if r.Intn(2) == 0 {
+			filter.SourcePort = [2]uint16{uint16(1 + r.Intn(0xFFFF)), uint16(r.Intn(0x10000))}
+		}
+		if r.Intn(2) == 0 {
+			filter.DestinationPort = [2]uint16{uint16(r.Intn(0x10000)), uint16(1 + r.Intn(0xFFFF))}
+		}
+		if r.Intn(2) == 0 {
+			filter.TCPFlags = uint8(1 + r.Intn(0xFF))
+		}
+	case 2:
+		if r.Intn(2) == 0 {
+			filter.ICMPType = &A.ByteRange{StartRange: uint8(r.Intn(0x100)), EndRange: uint8(r.Intn(0x100))}
+		}
+		if r.Intn(2) == 0 {
+			filter.ICMPCode = &A.ByteRange{StartRange: uint8(r.Intn(0x100)), EndRange: uint8(r.Intn(0x100))}
+		}
+	}
+
+	return reflect.ValueOf(randomFilter{filter})
+}
+
+// randomStaticFilter generates static filters whose criteria are all representable by a Filter.
+type randomStaticFilter struct {
+	A.StaticFilter
+}
+
+func (randomStaticFilter) Generate(r *rand.Rand, size int) reflect.Value {
+	var filter A.StaticFilter
+	filter.Adapter = A.Handle{byte(r.Intn(3))}
+	filter.DirectionFlags = []uint32{A.PACKET_FLAG_ON_RECEIVE, A.PACKET_FLAG_ON_SEND, A.PACKET_FLAG_ON_RECEIVE | A.PACKET_FLAG_ON_SEND}[r.Intn(3)]
+	filter.FilterAction = uint32(A.FILTER_PACKET_PASS + r.Intn(5))
+
+	if r.Intn(2) == 0 {
+		filter.ValidFields |= A.DATA_LINK_LAYER_VALID
+		filter.DataLinkFilter.Selector = A.ETH_802_3
+		eth := &filter.DataLinkFilter.Eth8023Filter
+		eth.ValidFields = uint32(1 + r.Intn(7))
+		if eth.ValidFields&A.ETH_802_3_SRC_ADDRESS != 0 {
+			copy(eth.SourceAddress[:], randomBytes(r, A.ETHER_ADDR_LENGTH))
+		}
+		if eth.ValidFields&A.ETH_802_3_DEST_ADDRESS != 0 {
+			copy(eth.DestinationAddress[:], randomBytes(r, A.ETHER_ADDR_LENGTH))
+		}
+		if eth.ValidFields&A.ETH_802_3_PROTOCOL != 0 {
+			eth.Protocol = uint16(1 + r.Intn(0xFFFF))
+		}
+	}
+
+	switch r.Intn(3) {
+	case 1:
+		filter.ValidFields |= A.NETWORK_LAYER_VALID
+		var ipv4 A.IPv4Filter
+		ipv4.ValidFields = uint32(r.Intn(8))
+		if ipv4.ValidFields&A.IP_V4_FILTER_SRC_ADDRESS != 0 {
+			ipv4.SourceAddress = randomIPv4Address(r)
+		}
+		if ipv4.ValidFields&A.IP_V4_FILTER_DEST_ADDRESS != 0 {
+			ipv4.DestinationAddress = randomIPv4Address(r)
+		}
+		if ipv4.ValidFields&A.IP_V4_FILTER_PROTOCOL != 0 {
+			ipv4.Protocol = uint8(1 + r.Intn(0xFF))
+		}
+		filter.NetworkFilter.SetIPv4(ipv4)
+	case 2:
+		filter.ValidFields |= A.NETWORK_LAYER_VALID
+		var ipv6 A.IPv6Filter
+		ipv6.ValidFields = uint32(r.Intn(8))
+		if ipv6.ValidFields&A.IP_V6_FILTER_SRC_ADDRESS != 0 {
+			ipv6.SourceAddress = randomIPv6Address(r)
+		}
+		if ipv6.ValidFields&A.IP_V6_FILTER_DEST_ADDRESS != 0 {
+			ipv6.DestinationAddress = randomIPv6Address(r)
+		}
+		if ipv6.ValidFields&A.IP_V6_FILTER_PROTOCOL != 0 {
+			ipv6.Protocol = uint8(1 + r.Intn(0xFF))
+		}
+		filter.NetworkFilter.SetIPv6(ipv6)
+	}
+
+	switch r.Intn(3) {
+	case 1:
+		filter.ValidFields |= A.TRANSPORT_LAYER_VALID
+		var tcpudp A.TCPUDPFilter
+		tcpudp.ValidFields = uint32(1 + r.Intn(7))
+		if tcpudp.ValidFields&A.TCPUDP_SRC_PORT != 0 {
+			tcpudp.SourcePort = A.PortRange{StartRange: uint16(1 + r.Intn(0xFFFF)), EndRange: uint16(r.Intn(0x10000))}
+		}
+		if tcpudp.ValidFields&A.TCPUDP_DEST_PORT != 0 {
+			tcpudp.DestinationPort = A.PortRange{StartRange: uint16(1 + r.Intn(0xFFFF)), EndRange: uint16(r.Intn(0x10000))}
+		}
+		if tcpudp.ValidFields&A.TCPUDP_TCP_FLAGS != 0 {
+			tcpudp.TCPFlags = uint8(1 + r.Intn(0xFF))
+		}
+		filter.TransportFilter.SetTCPUDP(tcpudp)
+	case 2:
+		filter.ValidFields |= A.TRANSPORT_LAYER_VALID
+		var icmp A.ICMPFilter
+		icmp.ValidFields = uint32(1 + r.Intn(3))
+		if icmp.ValidFields&A.ICMP_TYPE != 0 {
+			icmp.TypeRange = A.ByteRange{StartRange: uint8(r.Intn(0x100)), EndRange: uint8(r.Intn(0x100))}
+		}
+		if icmp.ValidFields&A.ICMP_CODE != 0 {
+			icmp.CodeRange = A.ByteRange{StartRange: uint8(r.Intn(0x100)), EndRange: uint8(r.Intn(0x100))}
+		}
+		filter.TransportFilter.SetICMP(icmp)
+	}
+
+	return reflect.ValueOf(randomStaticFilter{filter})
+}
+
+func randomBytes(r *rand.Rand, n int) []byte {
+	data := make([]byte, n)
+	r.Read(data)
+	return data
+}
+
+func randomIPNet(r *rand.Rand, version uint8) net.IPNet {
+	bits := 32
+	if version == 6 {
+		bits = 128
+	}
+	return net.IPNet{IP: randomBytes(r, bits/8), Mask: net.CIDRMask(r.Intn(bits+1), bits)}
+}
+
+func randomIPRange(r *rand.Rand, version uint8) D.IPRange {
+	length := net.IPv4len
+	if version == 6 {
+		length = net.IPv6len
+	}
+	return D.IPRange{Start: randomBytes(r, length), End: randomBytes(r, length)}
+}
+
+func randomIPv4Address(r *rand.Rand) A.IPv4Address {
+	var address A.IPv4Address
+	if r.Intn(2) == 0 {
+		address.SetSubnet(A.IPv4Subnet{IP: r.Uint32(), IPMask: r.Uint32()})
+	} else {
+		address.SetRange(A.IPv4Range{StartIP: r.Uint32(), EndIP: r.Uint32()})
+	}
+	return address
+}
+
+func randomIPv6Address(r *rand.Rand) A.IPv6Address {
+	var start, end A.IPv6
+	copy(start[:], randomBytes(r, net.IPv6len))
+	copy(end[:], randomBytes(r, net.IPv6len))
+
+	var address A.IPv6Address
+	if r.Intn(2) == 0 {
+		address.SetSubnet(A.IPv6Subnet{IP: start, IPMask: end})
+	} else {
+		address.SetRange(A.IPv6Range{StartIP: start, EndIP: end})
+	}
+	return address
+}
+
+var quickConfig = &quick.Config{MaxCount: 500, Rand: rand.New(rand.NewSource(1))}
+
+func TestFilter_RoundTrip(t *testing.T) {
+	api := sim.NewDriver()
+	filters, err := D.NewStaticFilters(api, true, false)
+	require.NoError(t, err)
+
+	roundTrip := func(generated randomFilter) bool {
+		filter := generated.Filter
+		if !assert.NoError(t, filter.Validate()) {
+			return false
+		}
+
+		filters.Filters = nil
+		require.NoError(t, api.ResetPacketFilterTable())
+		if !assert.True(t, filters.AddFilterBack(&filter)) {
+			return false
+		}
+
+		_, err := filters.LoadTable()
+		require.NoError(t, err)
+		require.Len(t, filters.Filters, 1)
+
+		return assert.True(t, filters.Filters[0].Equal(&filter), "%+v\n%+v", filter, filters.Filters[0])
+	}
+	require.NoError(t, quick.Check(roundTrip, quickConfig))
+}
+
+func TestFilter_StaticFilterRoundTrip(t *testing.T) {
+	api := sim.NewDriver()
+	filters, err := D.NewStaticFilters(api, true, false)
+	require.NoError(t, err)
+
+	roundTrip := func(generated randomStaticFilter) bool {
+		require.NoError(t, api.ResetPacketFilterTable())
+		require.NoError(t, api.AddStaticFilterBack(&generated.StaticFilter))
+
+		_, err := filters.LoadTable()
+		require.NoError(t, err)
+		require.Len(t, filters.Filters, 1)
+		filter := filters.Filters[0]
+
+		require.NoError(t, api.ResetPacketFilterTable())
+		filters.Filters = nil
+		if !assert.True(t, filters.AddFilterBack(&filter)) {
+			return false
+		}
+
+		table, err := api.GetPacketFilterTable(1)
+		require.NoError(t, err)
+		stored := table.StaticFilters[0]
+		stored.LastReset = 0
+		return assert.Equal(t, generated.StaticFilter, stored)
+	}
+	require.NoError(t, quick.Check(roundTrip, quickConfig))
+}
+
+func TestFilter_CombinedCriteria(t *testing.T) {
+	api := sim.NewDriver()
+	handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+	filters, err := D.NewStaticFilters(api, true, false)
+	require.NoError(t, err)
+
+	// Source and destination criteria of the same layer used to overwrite each other.
+	require.True(t, filters.AddFilterBack(&D.Filter{
+		SourceAddress:           net.IPNet{IP: net.IPv4(192, 168, 1, 2).To4(), Mask: net.CIDRMask(32, 32)},
+		DestinationAddressRange: D.IPRange{Start: net.IPv4(192, 168, 1, 0), End: net.IPv4(192, 168, 1, 1)},
+		Protocol:                17,
+		SourcePort:              [2]uint16{40000, 40000},
+		DestinationPort:         [2]uint16{1000, 1999},
+		Direction:               D.PacketDirectionIn,
+		Action:                  A.FilterActionDrop,
+	}))
+
+	require.True(t, filters.AddFilterBack(&D.Filter{Direction: D.PacketDirectionBoth, Action: A.FilterActionPass}))
+
+	require.NoError(t, api.SetAdapterMode(&A.AdapterMode{AdapterHandle: handle, Flags: A.MSTCP_FLAG_RECV_TUNNEL}))
+	for _, port := range []uint16{500, 1500, 2500} {
+		require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(port)))
+	}
+	assert.Equal(t, []int{500, 2500}, ports(api.StackPackets(handle)))
+}
+
+func TestFilter_Validate(t *testing.T) {
+	ipv4 := net.IPNet{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)}
+	ipv6 := net.IPNet{IP: net.ParseIP("fd00::"), Mask: net.CIDRMask(8, 128)}
+
+	invalid := map[string]D.Filter{
+		"MAC address length":     {SourceMacAddress: net.HardwareAddr{1, 2, 3}},
+		"IP version":             {IPVersion: 5},
+		"mixed address families": {SourceAddress: ipv4, DestinationAddress: ipv6},
+		"IP version mismatch":    {IPVersion: 6, SourceAddress: ipv4},
+		"subnet and range":       {SourceAddress: ipv4, SourceAddressRange: D.IPRange{Start: ipv4.IP, End: ipv4.IP}},
+		"open range":             {DestinationAddressRange: D.IPRange{Start: ipv4.IP}},
+		"mask length":            {SourceAddress: net.IPNet{IP: ipv4.IP, Mask: ipv6.Mask}},
+		"ICMP and ports":         {ICMPType: &A.ByteRange{}, DestinationPort: [2]uint16{80, 80}},
+	}
+	for name, filter := range invalid {
+		filter := filter
+		assert.Error(t, filter.Validate(), name)
+	}
+
+	api := sim.NewDriver()
+	filters, err := D.NewStaticFilters(api, true, false)
+	require.NoError(t, err)
+	filter := invalid["ICMP and ports"]
+	assert.False(t, filters.AddFilterBack(&filter))
+}
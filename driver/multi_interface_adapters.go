@@ -0,0 +1,180 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	A "github.com/wiresock/ndisapi-go"
+	N "github.com/wiresock/ndisapi-go/netlib"
+)
+
+// multiInterfaceAdapters holds the network interfaces of a multi-interface packet filter, all sharing the
+// packet event of the filter, and the names of the filtered ones. It is guarded by the filter lock, except
+// the snapshot of the network interfaces the working threads look the packet adapters up in.
+type multiInterfaceAdapters struct {
+	networkInterfaces []*N.NetworkAdapter
+	filterAdapterList []string
+	packetEvent       A.Event
+
+	snapshot       atomic.Value // map[A.Handle]*N.NetworkAdapter
+	releaseMonitor func()
+}
+
+// addNetworkInterfaces adds a network interface for each adapter of the list and takes a new snapshot.
+func (a *multiInterfaceAdapters) addNetworkInterfaces(api A.NdisApiInterface, adapters *A.TcpAdapterList, diagnostics *diagnostics) {
+	for i := 0; i < int(adapters.AdapterCount); i++ {
+		name := adapters.AdapterName(i)
+		adapterHandle := adapters.AdapterHandle[i]
+		currentAddress := adapters.CurrentAddress[i]
+		medium := adapters.AdapterMediumList[i]
+		mtu := adapters.MTU[i]
+
+		friendlyName := api.ConvertWindows2000AdapterName(name)
+
+		networkAdapter, err := N.NewNetworkAdapter(api, adapterHandle, currentAddress, name, friendlyName, medium, mtu, a.packetEvent)
+		if err != nil {
+			diagnostics.log().Error("error creating network adapter", "adapter", name, "error", err)
+			continue
+		}
+		networkAdapter.Logger = diagnostics.logger
+		a.networkInterfaces = append(a.networkInterfaces, networkAdapter)
+	}
+
+	a.takeSnapshot()
+}
+
+// applyAdapterChange updates the network interfaces for an adapter list change and takes a new snapshot.
+// The filtered adapters are followed by their names, so an adapter removed and arriving again is filtered again.
+func (a *multiInterfaceAdapters) applyAdapterChange(api A.NdisApiInterface, event N.AdapterEvent, diagnostics *diagnostics) {
+	defer a.takeSnapshot()
+
+	index := indexOfAdapter(a.networkInterfaces, event.ID)
+	if event.Type == N.AdapterRemoved {
+		if index >= 0 {
+			a.networkInterfaces = append(a.networkInterfaces[:index], a.networkInterfaces[index+1:]...)
+		}
+		return
+	}
+
+	networkAdapter, err := N.NewNetworkAdapterFromInfo(api, &event.Adapter, a.packetEvent)
+	if err != nil {
+		diagnostics.log().Error("error creating network adapter", "adapter", event.ID, "error", err)
+		return
+	}
+	networkAdapter.Logger = diagnostics.logger
+	if index >= 0 {
+		a.networkInterfaces[index] = networkAdapter
+	} else {
+		a.networkInterfaces = append(a.networkInterfaces, networkAdapter)
+	}
+}
+
+// takeSnapshot publishes the network interfaces to the working threads.
+func (a *multiInterfaceAdapters) takeSnapshot() {
+	snapshot := make(map[A.Handle]*N.NetworkAdapter, len(a.networkInterfaces))
+	for _, adapter := range a.networkInterfaces {
+		snapshot[adapter.GetAdapter()] = adapter
+	}
+	a.snapshot.Store(snapshot)
+}
+
+// lookupNetworkInterface returns the network interface with the specified handle, nil if there is none.
+// It is called by the working threads without the filter lock.
+func (a *multiInterfaceAdapters) lookupNetworkInterface(handle A.Handle) *N.NetworkAdapter {
+	snapshot, _ := a.snapshot.Load().(map[A.Handle]*N.NetworkAdapter)
+	return snapshot[handle]
+}
+
+// setAdaptersMode tunnels the packets of the filtered adapters in the directions the filter has callbacks
+// for and restores the other adapters. The filtered adapters of a paused filter keep the packet event but
+// pass their traffic through.
+func (a *multiInterfaceAdapters) setAdaptersMode(state FilterState, filterIn, filterOut bool, telemetry *telemetry) {
+	mode := uint32(0)
+	if state == FilterStateRunning {
+		if filterOut {
+			mode |= A.MSTCP_FLAG_SENT_TUNNEL
+		}
+		if filterIn {
+			mode |= A.MSTCP_FLAG_RECV_TUNNEL
+		}
+	}
+
+	for _, adapter := range a.networkInterfaces {
+		matched := false
+		if state == FilterStateRunning || state == FilterStatePaused {
+			for _, element := range a.filterAdapterList {
+				if element == adapter.InternalName {
+					adapter.SetMode(mode)
+					adapter.SetPacketEvent()
+					telemetry.adapter(adapter.GetAdapter())
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			adapter.ResetPacketEvent()
+			adapter.SetMode(0)
+		}
+	}
+}
+
+// selectFilteredAdapters fills an empty filter list with the adapters at the indexes, all of them if none is given.
+func (a *multiInterfaceAdapters) selectFilteredAdapters(filterAdapterIdx []uint32) {
+	if len(a.filterAdapterList) != 0 {
+		return
+	}
+
+	for i, adapter := range a.networkInterfaces {
+		if len(filterAdapterIdx) > 0 {
+			for _, idx := range filterAdapterIdx {
+				if i == int(idx) {
+					a.filterAdapterList = append(a.filterAdapterList, adapter.InternalName)
+				}
+			}
+		} else {
+			a.filterAdapterList = append(a.filterAdapterList, adapter.InternalName)
+		}
+	}
+}
+
+// removeFilteredAdapter removes the adapter from the filter list.
+func (a *multiInterfaceAdapters) removeFilteredAdapter(name string) {
+	for i, adapter := range a.filterAdapterList {
+		if adapter == name {
+			a.filterAdapterList = append(a.filterAdapterList[:i], a.filterAdapterList[i+1:]...)
+			break
+		}
+	}
+}
+
+// followAdapters calls onChange for the adapter list changes until releaseMonitor is called or the context is done.
+func (a *multiInterfaceAdapters) followAdapters(ctx context.Context, api A.NdisApiInterface, onChange func(event N.AdapterEvent)) error {
+	monitor, err := N.NewAdapterMonitor(api)
+	if err != nil {
+		return err
+	}
+	unsubscribe := monitor.Subscribe(onChange)
+	released := make(chan struct{})
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			close(released)
+			unsubscribe()
+			monitor.Close()
+		})
+	}
+	a.releaseMonitor = release
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			release()
+		case <-released:
+		}
+	}()
+
+	return nil
+}
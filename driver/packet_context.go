@@ -0,0 +1,157 @@
+package driver
+
+import (
+	"time"
+
+	A "github.com/wiresock/ndisapi-go"
+	N "github.com/wiresock/ndisapi-go/netlib"
+)
+
+// ContextFilterFunc is a packet filter callback receiving the PacketContext of the packet.
+type ContextFilterFunc func(packet *PacketContext) A.FilterAction
+
+// PacketContext describes a packet passed to a ContextFilterFunc.
+//
+// The context and the views it returns are only valid for the duration of the callback,
+// as the filters reuse the context and the packet buffers for the following packets.
+type PacketContext struct {
+	// Buffer is the packet as read from the driver.
+	Buffer *A.IntermediateBuffer
+	// Adapter is the network adapter the packet was intercepted on, nil if it is unknown.
+	Adapter *N.NetworkAdapter
+	// Timestamp is the time the packet was read from the driver.
+	Timestamp time.Time
+
+	target  *A.Handle
+	decoded bool
+	packet  A.DecodedPacket
+}
+
+// reset prepares the context for the next packet.
+func (p *PacketContext) reset(adapter *N.NetworkAdapter, buffer *A.IntermediateBuffer) {
+	*p = PacketContext{
+		Buffer:    buffer,
+		Adapter:   adapter,
+		Timestamp: time.Now(),
+	}
+}
+
+// Handle returns the handle of the adapter the packet was intercepted on.
+func (p *PacketContext) Handle() A.Handle {
+	if p.Adapter != nil {
+		return p.Adapter.GetAdapter()
+	}
+	return p.Buffer.HAdapterQLinkUnion.GetAdapter()
+}
+
+// Direction returns PacketDirectionOut for packets sent by the protocol stack and PacketDirectionIn otherwise.
+func (p *PacketContext) Direction() PacketDirection {
+	if p.Buffer.DeviceFlags == A.PACKET_FLAG_ON_SEND {
+		return PacketDirectionOut
+	}
+	return PacketDirectionIn
+}
+
+// FilterID returns the position of the static filter which redirected the packet.
+func (p *PacketContext) FilterID() uint32 {
+	return p.Buffer.FilterID
+}
+
+// HasVLAN reports whether the NIC stripped an 802.1Q tag from the packet.
+func (p *PacketContext) HasVLAN() bool {
+	return p.Buffer.M8021q != 0
+}
+
+// VLANID returns the VLAN identifier of the stripped 802.1Q tag.
+func (p *PacketContext) VLANID() uint16 {
+	return uint16(p.Buffer.M8021q>>4) & 0x0FFF
+}
+
+// VLANPriority returns the user priority of the stripped 802.1Q tag.
+func (p *PacketContext) VLANPriority() uint8 {
+	return uint8(p.Buffer.M8021q & 0x07)
+}
+
+// SetTargetAdapter forwards the packet through another adapter.
+// It is honored by the filters sending packets through the unsorted API, i.e.
+// QueuedMultiInterfacePacketFilter, FastIOPacketFilter and FastIOMultiInterfacePacketFilter.
+func (p *PacketContext) SetTargetAdapter(handle A.Handle) {
+	p.target = &handle
+}
+
+// Data returns the packet data.
+func (p *PacketContext) Data() []byte {
+	length := p.Buffer.Length
+	if length > A.MAX_ETHER_FRAME {
+		length = A.MAX_ETHER_FRAME
+	}
+	return p.Buffer.Buffer[:length]
+}
+
+// Packet returns the decoded headers of the packet.
+func (p *PacketContext) Packet() *A.DecodedPacket {
+	if !p.decoded {
+		p.decoded = true
+		_ = p.Buffer.Decode(&p.packet)
+	}
+	return &p.packet
+}
+
+// L2 returns the Ethernet header including the 802.1Q tags present in the frame, nil if the frame is truncated.
+func (p *PacketContext) L2() []byte {
+	return p.Packet().LinkHeader()
+}
+
+// L3 returns the IPv4 or IPv6 header including the IPv6 extension headers, nil for other protocols.
+func (p *PacketContext) L3() []byte {
+	return p.Packet().NetworkHeader()
+}
+
+// L4 returns the TCP, UDP, ICMP or ICMPv6 header, nil for other protocols and non-first fragments.
+func (p *PacketContext) L4() []byte {
+	return p.Packet().TransportHeader()
+}
+
+// Payload returns the data following the transport header, nil if the transport header was not decoded.
+func (p *PacketContext) Payload() []byte {
+	return p.Packet().Payload()
+}
+
+// EtherType returns the EtherType following the 802.1Q tags of the frame.
+func (p *PacketContext) EtherType() uint16 {
+	return p.Packet().EtherType()
+}
+
+// IPProtocol returns the transport protocol number of IPv4 and IPv6 packets.
+func (p *PacketContext) IPProtocol() uint8 {
+	return p.Packet().Protocol()
+}
+
+// contextFilters holds the PacketContext based callbacks of a packet filter.
+type contextFilters struct {
+	in, out ContextFilterFunc
+	packet  PacketContext
+}
+
+// has reports whether a callback is set for the direction given by the device flags.
+func (c *contextFilters) has(deviceFlags uint32) bool {
+	if deviceFlags == A.PACKET_FLAG_ON_SEND {
+		return c.out != nil
+	}
+	return c.in != nil
+}
+
+// filter runs the callback for the packet and returns its verdict together with the
+// adapter the packet should be forwarded through, nil to keep the original one.
+func (c *contextFilters) filter(adapter *N.NetworkAdapter, buffer *A.IntermediateBuffer) (A.FilterAction, *A.Handle) {
+	c.packet.reset(adapter, buffer)
+
+	var action A.FilterAction
+	if buffer.DeviceFlags == A.PACKET_FLAG_ON_SEND {
+		action = c.out(&c.packet)
+	} else {
+		action = c.in(&c.packet)
+	}
+
+	return action, c.packet.target
+}
@@ -0,0 +1,184 @@
+package driver_test
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	D "github.com/wiresock/ndisapi-go/driver"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+// newPacketContext wraps the frame into a PacketContext.
+func newPacketContext(frame []byte) *D.PacketContext {
+	buffer := &A.IntermediateBuffer{Length: uint32(len(frame))}
+	copy(buffer.Buffer[:], frame)
+	return &D.PacketContext{Buffer: buffer}
+}
+
+func TestPacketContext_DecodeIPv4(t *testing.T) {
+	packet := newPacketContext(udpFrame(53))
+
+	assert.Equal(t, uint16(0x0800), packet.EtherType())
+	assert.Equal(t, uint8(17), packet.IPProtocol())
+	assert.Len(t, packet.L2(), 14)
+	assert.Len(t, packet.L3(), 20)
+	assert.Len(t, packet.L4(), 8)
+	assert.Equal(t, uint16(53), binary.BigEndian.Uint16(packet.L4()[2:]))
+	assert.Empty(t, packet.Payload())
+}
+
+func TestPacketContext_DecodeVLANTaggedTCP(t *testing.T) {
+	frame := make([]byte, 18+20+20+4)
+	binary.BigEndian.PutUint16(frame[12:], 0x8100)
+	binary.BigEndian.PutUint16(frame[14:], 100)
+	binary.BigEndian.PutUint16(frame[16:], 0x0800)
+	ip := frame[18:]
+	ip[0] = 0x45
+	ip[9] = 6
+	tcp := ip[20:]
+	binary.BigEndian.PutUint16(tcp[2:], 443)
+	tcp[12] = 5 << 4
+
+	packet := newPacketContext(frame)
+
+	assert.Equal(t, uint16(0x0800), packet.EtherType())
+	assert.Len(t, packet.L2(), 18)
+	assert.Equal(t, uint8(6), packet.IPProtocol())
+	assert.Equal(t, uint16(443), binary.BigEndian.Uint16(packet.L4()[2:]))
+	assert.Len(t, packet.Payload(), 4)
+}
+
+func TestPacketContext_DecodeIPv6ExtensionHeaders(t *testing.T) {
+	frame := make([]byte, 14+40+8+8+8)
+	binary.BigEndian.PutUint16(frame[12:], 0x86DD)
+	ip := frame[14:]
+	ip[0] = 0x60
+	ip[6] = 0   // hop-by-hop options
+	ip[40] = 44 // fragment header follows
+	ip[48] = 58 // ICMPv6 follows, first fragment
+	ip[56] = 128
+
+	packet := newPacketContext(frame)
+
+	assert.Equal(t, uint8(58), packet.IPProtocol())
+	assert.Len(t, packet.L3(), 56)
+	require.Len(t, packet.L4(), 8)
+	assert.Equal(t, uint8(128), packet.L4()[0])
+
+	// Non-first fragments carry no transport header.
+	binary.BigEndian.PutUint16(ip[50:], 8<<3)
+	packet = newPacketContext(frame)
+	assert.Equal(t, uint8(58), packet.IPProtocol())
+	assert.Nil(t, packet.L4())
+}
+
+func TestPacketContext_DecodeTruncated(t *testing.T) {
+	packet := newPacketContext(udpFrame(53)[:30])
+
+	assert.Equal(t, uint16(0x0800), packet.EtherType())
+	assert.Len(t, packet.L2(), 14)
+	assert.Nil(t, packet.L3())
+	assert.Nil(t, packet.L4())
+
+	packet = newPacketContext(nil)
+	assert.Nil(t, packet.L2())
+	assert.Zero(t, packet.EtherType())
+}
+
+func TestPacketContext_Pipelines(t *testing.T) {
+	for _, pipeline := range verdictPipelines {
+		pipeline := pipeline
+		t.Run(pipeline.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			api := sim.NewDriver()
+			handle := api.AddAdapter(sim.AdapterConfig{
+				Name:         `\DEVICE\{SIM-0}`,
+				FriendlyName: "Ethernet",
+				HardwareAddr: [6]byte{0x02, 0, 0, 0, 0, 0x01},
+				MTU:          1400,
+			})
+
+			adapters, err := api.GetTcpipBoundAdaptersInfo()
+			require.NoError(t, err)
+
+			var skipped A.StaticFilter
+			skipped.DirectionFlags = A.PACKET_FLAG_ON_SEND
+			skipped.FilterAction = A.FILTER_PACKET_PASS
+			require.NoError(t, api.AddStaticFilterBack(&skipped))
+
+			var matched A.StaticFilter
+			matched.DirectionFlags = A.PACKET_FLAG_ON_RECEIVE
+			matched.FilterAction = A.FILTER_PACKET_REDIRECT
+			require.NoError(t, api.AddStaticFilterBack(&matched))
+
+			type observed struct {
+				handle       A.Handle
+				direction    D.PacketDirection
+				friendlyName string
+				mtu          uint16
+				vlanID       uint16
+				vlanPriority uint8
+				filterID     uint32
+				port         uint16
+				timestamp    time.Time
+			}
+			packets := make(chan observed, 1)
+
+			filter, err := pipeline.create(ctx, api, adapters, nil, nil)
+			require.NoError(t, err)
+			filter.SetContextFilter(func(packet *D.PacketContext) A.FilterAction {
+				packets <- observed{
+					handle:       packet.Handle(),
+					direction:    packet.Direction(),
+					friendlyName: packet.Adapter.FriendlyName,
+					mtu:          packet.Adapter.MTU,
+					vlanID:       packet.VLANID(),
+					vlanPriority: packet.VLANPriority(),
+					filterID:     packet.FilterID(),
+					port:         binary.BigEndian.Uint16(packet.L4()[2:]),
+					timestamp:    packet.Timestamp,
+				}
+				return A.FilterActionPass
+			}, nil)
+			require.NoError(t, startPipeline(pipeline, filter))
+
+			start := time.Now()
+			require.NoError(t, api.Inject(sim.Packet{
+				Adapter:     handle,
+				DeviceFlags: A.PACKET_FLAG_ON_RECEIVE,
+				M8021q:      5 | 42<<4,
+				Data:        udpFrame(53),
+			}))
+
+			select {
+			case packet := <-packets:
+				assert.Equal(t, handle, packet.handle)
+				assert.Equal(t, D.PacketDirectionIn, packet.direction)
+				assert.Equal(t, "Ethernet", packet.friendlyName)
+				assert.Equal(t, uint16(1400), packet.mtu)
+				assert.Equal(t, uint16(42), packet.vlanID)
+				assert.Equal(t, uint8(5), packet.vlanPriority)
+				assert.Equal(t, uint32(1), packet.filterID)
+				assert.Equal(t, uint16(53), packet.port)
+				assert.False(t, packet.timestamp.Before(start))
+			case <-time.After(5 * time.Second):
+				t.Fatal("packet was not filtered")
+			}
+
+			waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+			defer waitCancel()
+			require.NoError(t, api.WaitDelivered(waitCtx, 1))
+			require.NoError(t, filter.Close())
+
+			assert.Len(t, api.StackPackets(handle), 1)
+		})
+	}
+}
@@ -1,5 +1,11 @@
 package driver
 
+import (
+	"context"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
 const MaximumBlockNum = 10
 
 type FilterState uint32
@@ -9,6 +15,11 @@ const (
 	FilterStateStarting
 	FilterStateRunning
 	FilterStateStopping
+	// FilterStateSuspended means the filtered adapter was removed, the filter resumes when the adapter arrives again.
+	FilterStateSuspended
+	// FilterStatePaused means the adapters pass their traffic unfiltered while the filter keeps its buffers
+	// and working threads, the filter resumes filtering on Resume.
+	FilterStatePaused
 )
 
 type PacketDirection int
@@ -20,9 +31,17 @@ const (
 )
 
 type PacketFilter interface {
+	// Close stops filtering at once, discarding the packets read but not written yet.
 	Close() error
+	// Stop stops filtering after the packets in flight and those left in the driver queue are filtered
+	// and written, unless the context is done first. Then the rest is discarded and its error returned.
+	Stop(ctx context.Context) error
+	// Pause passes the traffic through unfiltered until Resume, keeping the buffers and the working threads.
+	Pause() error
+	Resume() error
 	Reconfigure() error
 	GetFilterState() FilterState
+	Stats() PipelineStats
 }
 
 type SingleInterfacePacketFilter interface {
@@ -33,4 +52,37 @@ type SingleInterfacePacketFilter interface {
 type MultiInterfacePacketFilter interface {
 	PacketFilter
 	StartFilter(filterAdapterIdx ...uint32) error
-}
\ No newline at end of file
+}
+
+// ListenFunc receives a copy of every packet given the FilterActionPassRedirect or
+// FilterActionDropRedirect verdict. The copy is owned by the listener.
+type ListenFunc func(handle A.Handle, buffer *A.IntermediateBuffer)
+
+// routePacket resolves the verdict for a packet intercepted in the direction given by
+// the device flags into its destinations. Unknown verdicts are treated as FilterActionDrop.
+func routePacket(action A.FilterAction, deviceFlags uint32) (toAdapter, toMstcp, listen bool) {
+	outgoing := deviceFlags == A.PACKET_FLAG_ON_SEND
+
+	switch action {
+	case A.FilterActionPass:
+		return outgoing, !outgoing, false
+	case A.FilterActionRedirect:
+		return !outgoing, outgoing, false
+	case A.FilterActionPassRedirect:
+		return outgoing, !outgoing, true
+	case A.FilterActionDropRedirect:
+		return false, false, true
+	}
+
+	return false, false, false
+}
+
+// listenCopy hands a copy of the packet to the listener, if any.
+func listenCopy(listen ListenFunc, handle A.Handle, buffer *A.IntermediateBuffer) {
+	if listen == nil {
+		return
+	}
+
+	packet := *buffer
+	listen(handle, &packet)
+}
@@ -0,0 +1,88 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// ErrorFunc receives the errors of the packet filter working threads, which have no caller to return them to.
+// It is called from the working threads, so it should return quickly.
+type ErrorFunc func(err error)
+
+// PacketSendError reports packets the filter failed to pass to the protocol stack or to the network.
+type PacketSendError struct {
+	Adapter A.Handle // zero for the unsorted sends, whose packets carry their own adapters
+	ToMstcp bool     // the packets were sent to the protocol stack rather than to the network
+	Packets uint32   // packets the filter tried to send
+	Sent    uint32   // packets accepted by the driver, when it reports them
+	Err     error
+}
+
+func (e *PacketSendError) Error() string {
+	destination := "adapter"
+	if e.ToMstcp {
+		destination = "MSTCP"
+	}
+	if e.Err == nil {
+		return fmt.Sprintf("sent %d of %d packets to %s", e.Sent, e.Packets, destination)
+	}
+	return fmt.Sprintf("failed to send %d packets to %s: %v", e.Packets, destination, e.Err)
+}
+
+func (e *PacketSendError) Unwrap() error {
+	return e.Err
+}
+
+// PacketReadError reports a failure waiting for or reading the packets of an adapter.
+// A failed wait stops the working thread reading the packets, a failed read is retried on the next event.
+type PacketReadError struct {
+	Adapter A.Handle // zero for the filters sharing a packet event between the adapters
+	Err     error
+}
+
+func (e *PacketReadError) Error() string {
+	return fmt.Sprintf("failed to read packets: %v", e.Err)
+}
+
+func (e *PacketReadError) Unwrap() error {
+	return e.Err
+}
+
+// diagnostics holds the logger and the error handler of a packet filter.
+type diagnostics struct {
+	logger A.Logger
+	errors ErrorFunc
+}
+
+// log returns the logger of the filter, a NopLogger if none is set.
+func (d *diagnostics) log() A.Logger {
+	return A.LoggerOrNop(d.logger)
+}
+
+// report logs the error and passes it to the error handler, if any.
+func (d *diagnostics) report(err error) {
+	d.log().Error("packet filter error", "error", err)
+	if d.errors != nil {
+		d.errors(err)
+	}
+}
+
+// sendFailed reports a failed send unless all the packets were sent.
+func (d *diagnostics) sendFailed(adapter A.Handle, toMstcp bool, packets, sent uint32, err error) {
+	if err == nil && sent >= packets {
+		return
+	}
+	d.report(&PacketSendError{Adapter: adapter, ToMstcp: toMstcp, Packets: packets, Sent: sent, Err: err})
+}
+
+// readFailed reports a failed wait for or read of the packets, unless the filter is being stopped
+// or no packets were queued.
+func (d *diagnostics) readFailed(ctx context.Context, adapter A.Handle, err error) {
+	if err == nil || ctx.Err() != nil || errors.Is(err, A.ErrQueueEmpty) {
+		return
+	}
+	d.report(&PacketReadError{Adapter: adapter, Err: err})
+}
@@ -0,0 +1,230 @@
+package driver_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	D "github.com/wiresock/ndisapi-go/driver"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+var errStackGone = errors.New("stack gone")
+
+// failingMstcpDriver fails to indicate any packet to the protocol stack.
+type failingMstcpDriver struct {
+	*sim.Driver
+}
+
+func (d *failingMstcpDriver) SendPacketsToMstcp(packet *A.EtherMultiRequest) error {
+	return errStackGone
+}
+
+func (d *failingMstcpDriver) SendPacketsToMstcpUnsortedErr(packets []*A.IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) error {
+	*packetSuccess = 0
+	return errStackGone
+}
+
+// errGenFailure is the Win32 ERROR_GEN_FAILURE errno.
+const errGenFailure = syscall.Errno(31)
+
+// failingReadDriver fails the first packet read like the driver does, with an errno the read
+// errors are not classified by.
+type failingReadDriver struct {
+	*sim.Driver
+	failed int32
+}
+
+func (d *failingReadDriver) readFailure(service uint32) error {
+	if atomic.CompareAndSwapInt32(&d.failed, 0, 1) {
+		return &A.DriverError{Op: A.IoctlName(service), Err: errGenFailure}
+	}
+	return nil
+}
+
+func (d *failingReadDriver) ReadPacketsErr(packet *A.EtherMultiRequest) error {
+	if err := d.readFailure(A.IOCTL_NDISRD_READ_PACKETS); err != nil {
+		return err
+	}
+	return d.Driver.ReadPacketsErr(packet)
+}
+
+func (d *failingReadDriver) ReadPacketsUnsortedErr(packets []*A.IntermediateBuffer, packetsNum uint32, packetsSuccess *uint32) error {
+	if err := d.readFailure(A.IOCTL_NDISRD_READ_PACKETS_UNSORTED); err != nil {
+		*packetsSuccess = 0
+		return err
+	}
+	return d.Driver.ReadPacketsUnsortedErr(packets, packetsNum, packetsSuccess)
+}
+
+// recordingLogger records the messages logged at the Error level.
+type recordingLogger struct {
+	A.NopLogger
+	sync.Mutex
+	errors []string
+}
+
+func (l *recordingLogger) Error(msg string, args ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.errors = append(l.errors, msg)
+}
+
+func (l *recordingLogger) messages() []string {
+	l.Lock()
+	defer l.Unlock()
+	return append([]string(nil), l.errors...)
+}
+
+func TestPacketFilter_ReportsSendErrors(t *testing.T) {
+	for _, pipeline := range verdictPipelines {
+		pipeline := pipeline
+		t.Run(pipeline.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			api := sim.NewDriver()
+			handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+			adapters, err := api.GetTcpipBoundAdaptersInfo()
+			require.NoError(t, err)
+
+			pass := func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction {
+				return A.FilterActionPass
+			}
+
+			filter, err := pipeline.create(ctx, &failingMstcpDriver{api}, adapters, pass, pass)
+			require.NoError(t, err)
+
+			logger := &recordingLogger{}
+			errs := make(chan error, 16)
+			filter.SetLogger(logger)
+			filter.SetErrorHandler(func(err error) {
+				select {
+				case errs <- err:
+				default:
+				}
+			})
+
+			require.NoError(t, startPipeline(pipeline, filter))
+			require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(inPortBase)))
+
+			select {
+			case err := <-errs:
+				var sendErr *D.PacketSendError
+				require.True(t, errors.As(err, &sendErr))
+				assert.True(t, sendErr.ToMstcp)
+				assert.Equal(t, uint32(1), sendErr.Packets)
+				assert.Zero(t, sendErr.Sent)
+				assert.True(t, errors.Is(err, errStackGone))
+			case <-time.After(5 * time.Second):
+				t.Fatal("no send error reported")
+			}
+
+			require.NoError(t, filter.Close())
+			assert.Contains(t, logger.messages(), "packet filter error")
+			assert.Empty(t, api.StackPackets(handle))
+			assert.Equal(t, uint64(1), filter.Stats().MstcpSendFailures)
+		})
+	}
+}
+
+func TestPacketFilter_ReportsReadErrors(t *testing.T) {
+	for _, pipeline := range verdictPipelines {
+		pipeline := pipeline
+		// The fast I/O filters read the driver queue only to drain it
+		if strings.HasPrefix(pipeline.name, "FastIO") {
+			continue
+		}
+		t.Run(pipeline.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			api := sim.NewDriver()
+			handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+			adapters, err := api.GetTcpipBoundAdaptersInfo()
+			require.NoError(t, err)
+
+			pass := func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction {
+				return A.FilterActionPass
+			}
+
+			filter, err := pipeline.create(ctx, &failingReadDriver{Driver: api}, adapters, pass, pass)
+			require.NoError(t, err)
+
+			errs := make(chan error, 16)
+			filter.SetErrorHandler(func(err error) {
+				select {
+				case errs <- err:
+				default:
+				}
+			})
+
+			require.NoError(t, startPipeline(pipeline, filter))
+			require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(inPortBase)))
+
+			select {
+			case err := <-errs:
+				var readErr *D.PacketReadError
+				require.True(t, errors.As(err, &readErr))
+				assert.True(t, errors.Is(err, errGenFailure))
+				assert.False(t, errors.Is(err, A.ErrQueueEmpty))
+			case <-time.After(5 * time.Second):
+				t.Fatal("no read error reported")
+			}
+
+			require.NoError(t, filter.Close())
+		})
+	}
+}
+
+func TestPacketFilter_RequiresDriverVersion(t *testing.T) {
+	for _, pipeline := range verdictPipelines {
+		pipeline := pipeline
+		t.Run(pipeline.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			api := sim.NewDriver()
+			api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+			// The driver predates fast I/O and the unsorted reads and sends
+			api.SetVersion(uint32(A.NewDriverVersion(3, 2, 0)))
+
+			adapters, err := api.GetTcpipBoundAdaptersInfo()
+			require.NoError(t, err)
+
+			filter, err := pipeline.create(ctx, api, adapters, nil, nil)
+			if pipeline.requires == 0 {
+				require.NoError(t, err)
+				_ = filter.Close()
+				return
+			}
+
+			var versionErr *A.VersionError
+			require.True(t, errors.As(err, &versionErr))
+			assert.True(t, errors.Is(err, A.ErrVersionMismatch))
+			assert.Equal(t, pipeline.requires, versionErr.Required)
+			assert.Equal(t, "3.2.0", versionErr.Version.String())
+		})
+	}
+}
+
+func TestPacketSendError(t *testing.T) {
+	err := &D.PacketSendError{Packets: 3, Sent: 1}
+	assert.Equal(t, "sent 1 of 3 packets to adapter", err.Error())
+	assert.Nil(t, errors.Unwrap(err))
+
+	err = &D.PacketSendError{ToMstcp: true, Packets: 2, Err: errStackGone}
+	assert.Equal(t, "failed to send 2 packets to MSTCP: stack gone", err.Error())
+	assert.True(t, errors.Is(err, errStackGone))
+}
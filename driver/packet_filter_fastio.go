@@ -1,5 +1,3 @@
-//go:build windows
-
 package driver
 
 import (
@@ -7,57 +5,62 @@ import (
 	"errors"
 	"fmt"
 	"sync"
-	"sync/atomic"
-	"unsafe"
+	"time"
 
 	A "github.com/wiresock/ndisapi-go"
 	N "github.com/wiresock/ndisapi-go/netlib"
-
-	"golang.org/x/sys/windows"
 )
 
 var _ PacketFilter = (*FastIOPacketFilter)(nil)
 var _ SingleInterfacePacketFilter = (*FastIOPacketFilter)(nil)
 
-const fastIOSize = 0x300000
-
-type requestStorageType [unsafe.Sizeof(A.IntermediateBuffer{}) * A.FastIOMaximumPacketBlock]byte
-type fastIOStorageType [fastIOSize]byte
-
 type FastIOPacketFilter struct {
-	*A.NdisApi
+	A.NdisApiInterface
+	sync.Mutex
 	ctx context.Context
 
 	adapters *A.TcpAdapterList
 
 	filterIncomingPacket func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction
 	filterOutgoingPacket func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction
-	filterState          FilterState
+	listen               ListenFunc
+	contexts             contextFilters
+	diagnostics          diagnostics
+	telemetry            *telemetry
+	filterState          atomicFilterState
+	paused               bool
 	networkInterfaces    []*N.NetworkAdapter
 	adapter              int
+	adapterName          string
+	follower             *adapterFollower
 
-	packetBuffer []A.IntermediateBuffer
-
-	waitOnPoll          bool
-	writeAdapterRequest *requestStorageType
-	writeMstcpRequest   *requestStorageType
-	fastIO              []fastIOStorageType // shared fast i/o memory
+	config   FastIOConfig
+	sections *fastIOSections
 
 	wg     sync.WaitGroup
 	cancel context.CancelFunc
+	flush  context.CancelFunc
 }
 
-func NewFastIOPacketFilter(ctx context.Context, api *A.NdisApi, adapters *A.TcpAdapterList, in, out func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction, waitOnPool bool) (*FastIOPacketFilter, error) {
+func NewFastIOPacketFilter(ctx context.Context, api A.NdisApiInterface, adapters *A.TcpAdapterList, in, out func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction, waitOnPool bool) (*FastIOPacketFilter, error) {
+	// The secondary fast I/O sections and the unsorted sends the filter relies on arrived in the same driver version
+	if err := A.RequireDriverVersion(api, A.SecondaryFastIODriverVersion, "fast I/O packet filter"); err != nil {
+		return nil, err
+	}
+
 	filter := &FastIOPacketFilter{
-		NdisApi: api,
-		ctx:     ctx,
+		NdisApiInterface: api,
+		ctx:              ctx,
 
 		adapters: adapters,
 
-		waitOnPoll:           waitOnPool,
+		config:               DefaultFastIOConfig(),
 		filterIncomingPacket: in,
 		filterOutgoingPacket: out,
-		filterState:          FilterStateStopped,
+		telemetry:            newTelemetry(),
+	}
+	if !waitOnPool {
+		filter.config.Poll = FastIOPollSpin
 	}
 
 	err := filter.initializeNetworkInterfaces()
@@ -68,55 +71,102 @@ func NewFastIOPacketFilter(ctx context.Context, api *A.NdisApi, adapters *A.TcpA
 	return filter, nil
 }
 
-func (f *FastIOPacketFilter) initFilter() error {
-	f.packetBuffer = make([]A.IntermediateBuffer, A.FastIOMaximumPacketBlock)
+// SetListener sets the consumer of the packets given the FilterActionPassRedirect or
+// FilterActionDropRedirect verdict. It should be called before the filter is started.
+func (f *FastIOPacketFilter) SetListener(listen ListenFunc) {
+	f.listen = listen
+}
 
-	f.writeAdapterRequest = &requestStorageType{}
-	f.writeMstcpRequest = &requestStorageType{}
-	f.fastIO = make([]fastIOStorageType, 4)
+// SetContextFilter sets PacketContext based callbacks for the incoming and outgoing packets.
+// A callback set for a direction takes precedence over the one passed to the constructor.
+// It should be called before the filter is started.
+func (f *FastIOPacketFilter) SetContextFilter(in, out ContextFilterFunc) {
+	f.contexts.in = in
+	f.contexts.out = out
+}
 
-	if f.waitOnPoll {
-		if err := f.networkInterfaces[f.adapter].SetPacketEvent(); err != nil {
-			f.packetBuffer = nil
-			f.writeAdapterRequest = nil
-			f.writeMstcpRequest = nil
-			f.fastIO = nil
-			return err
-		}
+// SetLogger sets the logger receiving the diagnostics of the filter, nil discards them.
+// It should be called before the filter is started.
+func (f *FastIOPacketFilter) SetLogger(logger A.Logger) {
+	f.diagnostics.logger = logger
+	for _, adapter := range f.networkInterfaces {
+		adapter.Logger = logger
+	}
+}
+
+// SetErrorHandler sets the consumer of the errors of the working thread, e.g. the
+// PacketSendError and PacketReadError. It should be called before the filter is started.
+func (f *FastIOPacketFilter) SetErrorHandler(handler ErrorFunc) {
+	f.diagnostics.errors = handler
+}
+
+// SetFastIOConfig sets the fast I/O sections and the poll strategy of the filter, overriding the
+// strategy selected by the waitOnPool constructor argument. It should be called before the filter is started.
+func (f *FastIOPacketFilter) SetFastIOConfig(config FastIOConfig) error {
+	config = config.withDefaults()
+	if err := config.validate(); err != nil {
+		return err
 	}
 
-	fastIOSection := (*A.InitializeFastIOSection)(unsafe.Pointer(&f.fastIO[0]))
+	f.Lock()
+	defer f.Unlock()
+
+	f.config = config
+	return nil
+}
+
+// FastIOStats returns the section utilization counters since the filter was last started.
+func (f *FastIOPacketFilter) FastIOStats() FastIOStats {
+	f.Lock()
+	defer f.Unlock()
 
-	if !f.InitializeFastIo(fastIOSection, fastIOSize) {
-		f.packetBuffer = nil
-		f.writeAdapterRequest = nil
-		f.writeMstcpRequest = nil
-		f.fastIO = nil
-		return errors.New("failed to initialize fast IO")
+	if f.sections == nil {
+		config := f.config.withDefaults()
+		return FastIOStats{SectionCount: config.SectionCount, SectionCapacity: config.capacity()}
 	}
+	return f.sections.stats()
+}
 
-	for i := 1; i < 4; i++ {
-		fastIOSection = (*A.InitializeFastIOSection)(unsafe.Pointer(&f.fastIO[i]))
+// Stats returns a snapshot of the packet counters of the filter. FastIOStats reports the use of its sections.
+func (f *FastIOPacketFilter) Stats() PipelineStats {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.telemetry.snapshot(f.NdisApiInterface, f.networkInterfaces)
+}
 
-		if !f.AddSecondaryFastIo(fastIOSection, fastIOSize) {
-			f.packetBuffer = nil
-			f.writeAdapterRequest = nil
-			f.writeMstcpRequest = nil
-			f.fastIO = nil
-			return errors.New("failed to add secondary fast IO")
+func (f *FastIOPacketFilter) initFilter() error {
+	if f.config.Poll == FastIOPollEvent {
+		if err := f.networkInterfaces[f.adapter].SetPacketEvent(); err != nil {
+			return err
 		}
 	}
 
-	if err := f.networkInterfaces[f.adapter].SetMode(A.MSTCP_FLAG_SENT_TUNNEL | A.MSTCP_FLAG_RECV_TUNNEL); err != nil {
+	sections, err := newFastIOSections(f.NdisApiInterface, f.config)
+	if err != nil {
+		return err
+	}
+	f.sections = sections
+	f.telemetry.adapter(f.networkInterfaces[f.adapter].GetAdapter())
+
+	if err := f.networkInterfaces[f.adapter].SetMode(f.mode()); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// mode returns the adapter mode of the filter, which intercepts no packets while paused.
+func (f *FastIOPacketFilter) mode() uint32 {
+	if f.paused {
+		return 0
+	}
+	return A.MSTCP_FLAG_SENT_TUNNEL | A.MSTCP_FLAG_RECV_TUNNEL
+}
+
 func (f *FastIOPacketFilter) initializeNetworkInterfaces() error {
 	for i := 0; i < int(f.adapters.AdapterCount); i++ {
-		name := string(f.adapters.AdapterNameList[i][:])
+		name := f.adapters.AdapterName(i)
 		adapterHandle := f.adapters.AdapterHandle[i]
 		currentAddress := f.adapters.CurrentAddress[i]
 		medium := f.adapters.AdapterMediumList[i]
@@ -124,11 +174,12 @@ func (f *FastIOPacketFilter) initializeNetworkInterfaces() error {
 
 		friendlyName := f.ConvertWindows2000AdapterName(name)
 
-		networkAdapter, err := N.NewNetworkAdapter(f.NdisApi, adapterHandle, currentAddress, name, friendlyName, medium, mtu, nil)
+		networkAdapter, err := N.NewNetworkAdapter(f.NdisApiInterface, adapterHandle, currentAddress, name, friendlyName, medium, mtu, nil)
 		if err != nil {
-			fmt.Println("error creating network adapter", err.Error())
+			f.diagnostics.log().Error("error creating network adapter", "adapter", name, "error", err)
 			continue
 		}
+		networkAdapter.Logger = f.diagnostics.logger
 		f.networkInterfaces = append(f.networkInterfaces, networkAdapter)
 	}
 
@@ -136,7 +187,10 @@ func (f *FastIOPacketFilter) initializeNetworkInterfaces() error {
 }
 
 func (f *FastIOPacketFilter) Reconfigure() error {
-	if f.filterState != FilterStateStopped {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateStopped {
 		return errors.New("filter is not stopped")
 	}
 
@@ -148,159 +202,318 @@ func (f *FastIOPacketFilter) Reconfigure() error {
 	return nil
 }
 
+// StartFilter starts filtering the adapter. The filter follows the adapter by its name: it is
+// suspended while the adapter is removed and resumes when the adapter arrives again.
 func (f *FastIOPacketFilter) StartFilter(adapterIdx int) error {
-	if f.filterState != FilterStateStopped {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateStopped {
 		return errors.New("filter is not stopped")
 	}
+	if adapterIdx < 0 || adapterIdx >= len(f.networkInterfaces) {
+		return fmt.Errorf("adapter index %d is out of range", adapterIdx)
+	}
 
-	f.filterState = FilterStateStarting
 	f.adapter = adapterIdx
+	f.adapterName = f.networkInterfaces[adapterIdx].InternalName
+
+	if err := f.start(); err != nil {
+		f.filterState.store(FilterStateStopped)
+		return err
+	}
+
+	follower, err := followAdapter(f.NdisApiInterface, f.adapterName, f.suspend, f.resume)
+	if err != nil {
+		_ = f.stop(doneContext)
+		f.filterState.store(FilterStateStopped)
+		return err
+	}
+	f.follower = follower
+
+	return nil
+}
+
+// Close stops filtering at once, discarding the packets read but not written yet.
+func (f *FastIOPacketFilter) Close() error {
+	if err := f.Stop(doneContext); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// Stop stops filtering. The adapter stops tunneling packets and the working thread filters and writes
+// the packets left in the sections and in the driver queue, unless the context is done first.
+func (f *FastIOPacketFilter) Stop(ctx context.Context) error {
+	var err error
+
+	f.Lock()
+	switch f.filterState.load() {
+	case FilterStateRunning, FilterStatePaused:
+		err = f.stop(ctx)
+	case FilterStateSuspended:
+	default:
+		f.Unlock()
+		return errors.New("filter is not running")
+	}
+	follower := f.stopped()
+	f.Unlock()
+
+	follower.Close()
+	return err
+}
+
+// Pause passes the traffic of the adapter through unfiltered until Resume. The packets read before
+// pass unfiltered too, while the sections and the working thread are kept.
+func (f *FastIOPacketFilter) Pause() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateRunning {
+		return errors.New("filter is not running")
+	}
+	f.paused = true
+	if err := f.networkInterfaces[f.adapter].SetMode(f.mode()); err != nil {
+		f.paused = false
+		return err
+	}
+	f.filterState.store(FilterStatePaused)
+
+	return nil
+}
+
+// Resume resumes filtering the traffic of the adapter after Pause.
+func (f *FastIOPacketFilter) Resume() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStatePaused {
+		return errors.New("filter is not paused")
+	}
+	f.paused = false
+	if err := f.networkInterfaces[f.adapter].SetMode(f.mode()); err != nil {
+		f.paused = true
+		return err
+	}
+	f.filterState.store(FilterStateRunning)
+
+	return nil
+}
+
+// start initializes the filter for the current adapter and starts the working thread. Must be called locked.
+func (f *FastIOPacketFilter) start() error {
+	f.filterState.store(FilterStateStarting)
 
 	if err := f.initFilter(); err != nil {
 		return err
 	}
 
 	ctx, cancel := context.WithCancel(f.ctx)
+	readCtx, flush := context.WithCancel(ctx)
 	f.cancel = cancel
+	f.flush = flush
 
-	f.filterState = FilterStateStarting
+	if f.paused {
+		f.filterState.store(FilterStatePaused)
+	} else {
+		f.filterState.store(FilterStateRunning)
+	}
 
 	// Start the working thread
 	f.wg.Add(1)
-	go f.filterWorkingThread(ctx)
+	go f.filterWorkingThread(ctx, readCtx)
 
 	return nil
 }
 
-func (f *FastIOPacketFilter) Close() error {
-	if f.filterState != FilterStateRunning {
-		return errors.New("filter is not running")
+// stop stops the working thread, restores the adapter mode and releases the sections. The packets left in
+// the sections and in the driver queue are filtered before, unless the context is done first. Must be called locked.
+func (f *FastIOPacketFilter) stop(ctx context.Context) error {
+	f.filterState.store(FilterStateStopping)
+	_ = f.networkInterfaces[f.adapter].SetMode(0)
+	f.flush()
+	err := waitFlushed(ctx, &f.wg, f.cancel)
+	f.cancel()
+	if releaseErr := f.sections.release(); releaseErr != nil {
+		f.diagnostics.log().Warn("failed to release the fast I/O sections", "adapter", f.adapterName, "error", releaseErr)
 	}
-
-	f.filterState = FilterStateStopping
 	f.networkInterfaces[f.adapter].Close()
-	f.cancel()
-	f.wg.Wait()
-	f.filterState = FilterStateStopped
-	return nil
+	return err
 }
 
-func (f *FastIOPacketFilter) filterWorkingThread(ctx context.Context) {
-	defer f.wg.Done()
-
-	f.filterState = FilterStateRunning
-	for {
-		select {
-		case <-f.ctx.Done():
-			return
-		default:
-			var sentSuccess uint32
-			var fastIOPacketsSuccess uint32
-
-			writeAdapterRequest := (*[A.FastIOMaximumPacketBlock]*A.IntermediateBuffer)(unsafe.Pointer(&f.writeAdapterRequest[0]))[:]
-			writeMstcpRequest := (*[A.FastIOMaximumPacketBlock]*A.IntermediateBuffer)(unsafe.Pointer(&f.writeMstcpRequest[0]))[:]
-
-			fastIOSection := []*A.FastIOSection{
-				(*A.FastIOSection)(unsafe.Pointer(&f.fastIO[0])),
-				(*A.FastIOSection)(unsafe.Pointer(&f.fastIO[1])),
-				(*A.FastIOSection)(unsafe.Pointer(&f.fastIO[2])),
-				(*A.FastIOSection)(unsafe.Pointer(&f.fastIO[3])),
-			}
-
-			for {
-				if f.filterState != FilterStateRunning {
-					return
-				}
+// suspend stops filtering the removed adapter until it arrives again.
+func (f *FastIOPacketFilter) suspend() {
+	f.Lock()
+	defer f.Unlock()
 
-				for _, i := range fastIOSection {
-					if join := atomic.LoadUint32((*uint32)(unsafe.Pointer(&i.FastIOHeader.FastIOWriteUnion))); join > 0 {
-						atomic.StoreUint32(&i.FastIOHeader.ReadInProgressFlag, 1)
+	switch f.filterState.load() {
+	case FilterStateRunning, FilterStatePaused, FilterStateStarting:
+	default:
+		return
+	}
+	_ = f.stop(doneContext)
+	f.filterState.store(FilterStateSuspended)
+	f.diagnostics.log().Info("adapter removed, filter suspended", "adapter", f.adapterName)
+}
 
-						writeUnion := atomic.LoadUint32((*uint32)(unsafe.Pointer(&i.FastIOHeader.FastIOWriteUnion)))
+// stopped marks the filter stopped and detaches the adapter follower, to be closed unlocked. Must be called locked.
+func (f *FastIOPacketFilter) stopped() *adapterFollower {
+	f.filterState.store(FilterStateStopped)
+	f.paused = false
+	follower := f.follower
+	f.follower = nil
+	return follower
+}
 
-						currentPacketsSuccess := (*A.FastIOWriteUnion)(unsafe.Pointer(&writeUnion)).GetNumberOfPackets()
+// fail stops the filter after its working thread exited on an error, unless the run was stopped or
+// suspended meanwhile. It is called from a goroutine of its own, as stop waits for the working thread.
+func (f *FastIOPacketFilter) fail(run context.Context) {
+	f.Lock()
+	if run.Err() != nil {
+		f.Unlock()
+		return
+	}
+	_ = f.stop(doneContext)
+	follower := f.stopped()
+	f.Unlock()
 
-						// Copy packets and reset section
-						copy(f.packetBuffer[fastIOPacketsSuccess:], i.FastIOPackets[:currentPacketsSuccess-1])
+	follower.Close()
+	f.diagnostics.log().Warn("working thread failed, filter stopped", "adapter", f.adapterName)
+}
 
-						// For the last packet(s) wait for the write completion if in progress
-						writeUnion = atomic.LoadUint32((*uint32)(unsafe.Pointer(&i.FastIOHeader.FastIOWriteUnion)))
+// resume restarts filtering the adapter arrived again, wherever it is in the adapter list.
+func (f *FastIOPacketFilter) resume() {
+	f.Lock()
+	defer f.Unlock()
 
-						for (*A.FastIOWriteUnion)(unsafe.Pointer(&writeUnion)).GetWriteInProgressFlag() != 0 {
-							writeUnion = atomic.LoadUint32((*uint32)(unsafe.Pointer(&i.FastIOHeader.FastIOWriteUnion)))
-						}
+	if f.filterState.load() != FilterStateSuspended {
+		return
+	}
 
-						// Copy the last packet(s)
-						copy(f.packetBuffer[fastIOPacketsSuccess+uint32(currentPacketsSuccess)-1:], i.FastIOPackets[currentPacketsSuccess-1:currentPacketsSuccess])
+	adapters, err := f.GetTcpipBoundAdaptersInfo()
+	if err != nil {
+		f.diagnostics.log().Warn("failed to query the adapters to resume the filter", "adapter", f.adapterName, "error", err)
+		return
+	}
+	f.adapters = adapters
+	f.networkInterfaces = nil
+	if err := f.initializeNetworkInterfaces(); err != nil {
+		return
+	}
 
-						if currentPacketsSuccess < i.FastIOHeader.FastIOWriteUnion.GetNumberOfPackets() {
-							currentPacketsSuccess = i.FastIOHeader.FastIOWriteUnion.GetNumberOfPackets()
-							copy(f.packetBuffer[fastIOPacketsSuccess+uint32(currentPacketsSuccess)-1:], i.FastIOPackets[currentPacketsSuccess-1:currentPacketsSuccess])
-						}
+	if f.adapter = indexOfAdapter(f.networkInterfaces, f.adapterName); f.adapter < 0 {
+		f.adapter = 0
+		return
+	}
+	if err := f.start(); err != nil {
+		f.filterState.store(FilterStateSuspended)
+		f.diagnostics.log().Warn("failed to resume the filter", "adapter", f.adapterName, "error", err)
+		return
+	}
+	f.diagnostics.log().Info("adapter arrived, filter resumed", "adapter", f.adapterName)
+}
 
-						atomic.StoreUint32((*uint32)(unsafe.Pointer(&i.FastIOHeader.FastIOWriteUnion)), 0)
-						atomic.StoreUint32(&i.FastIOHeader.ReadInProgressFlag, 0)
+// filterWorkingThread polls the fast I/O sections until the filter is stopped. Once the read context
+// is done, it polls the sections and drains the driver queue until no packets are left, then exits.
+func (f *FastIOPacketFilter) filterWorkingThread(ctx, readCtx context.Context) {
+	defer f.wg.Done()
 
-						fastIOPacketsSuccess += uint32(currentPacketsSuccess)
-					}
-				}
-				fmt.Println(fastIOPacketsSuccess)
-
-				var sendToAdapterNum uint32
-				var sendToMstcpNum uint32
-
-				for i := uint32(0); i < fastIOPacketsSuccess; i++ {
-					packetAction := A.FilterActionPass
-
-					if f.packetBuffer[i].DeviceFlags == A.PACKET_FLAG_ON_SEND {
-						if f.filterOutgoingPacket != nil {
-							packetAction = f.filterOutgoingPacket(f.packetBuffer[i].HAdapterQLinkUnion.GetAdapter(), &f.packetBuffer[i])
-						}
-					} else {
-						if f.filterIncomingPacket != nil {
-							packetAction = f.filterIncomingPacket(f.packetBuffer[i].HAdapterQLinkUnion.GetAdapter(), &f.packetBuffer[i])
-						}
+	var sentSuccess uint32
+
+	adapter := f.networkInterfaces[f.adapter]
+	sections := f.sections
+	packetBuffer := sections.packetBuffer
+	writeAdapterRequest, writeMstcpRequest := sections.requests()
+
+	for ctx.Err() == nil {
+		fastIOPacketsSuccess := sections.read()
+		drained, err := sections.drain(fastIOPacketsSuccess)
+		f.diagnostics.readFailed(ctx, A.Handle{}, err)
+		fastIOPacketsSuccess += drained
+		f.telemetry.batch(fastIOPacketsSuccess)
+		paused := f.filterState.load() == FilterStatePaused
+
+		var sendToAdapterNum uint32
+		var sendToMstcpNum uint32
+
+		for i := uint32(0); i < fastIOPacketsSuccess; i++ {
+			var adapterHandle *A.Handle
+			packetAction := A.FilterActionPass
+			handle := packetBuffer[i].HAdapterQLinkUnion.GetAdapter()
+
+			// The packets read before the filter was paused pass unfiltered
+			if !paused {
+				start := time.Now()
+				if f.contexts.has(packetBuffer[i].DeviceFlags) {
+					packetAction, adapterHandle = f.contexts.filter(adapter, &packetBuffer[i])
+				} else if packetBuffer[i].DeviceFlags == A.PACKET_FLAG_ON_SEND {
+					if f.filterOutgoingPacket != nil {
+						packetAction = f.filterOutgoingPacket(packetBuffer[i].HAdapterQLinkUnion.GetAdapter(), &packetBuffer[i])
 					}
-
-					// Place packet back into the flow if was allowed to
-					if packetAction == A.FilterActionPass {
-						if f.packetBuffer[i].DeviceFlags == A.PACKET_FLAG_ON_SEND {
-							writeAdapterRequest[sendToAdapterNum] = &f.packetBuffer[i]
-							sendToAdapterNum++
-						} else {
-							writeMstcpRequest[sendToMstcpNum] = &f.packetBuffer[i]
-							sendToMstcpNum++
-						}
-					} else if packetAction == A.FilterActionRedirect {
-						if f.packetBuffer[i].DeviceFlags == A.PACKET_FLAG_ON_RECEIVE {
-							writeAdapterRequest[sendToAdapterNum] = &f.packetBuffer[i]
-							sendToAdapterNum++
-						} else {
-							writeMstcpRequest[sendToMstcpNum] = &f.packetBuffer[i]
-							sendToMstcpNum++
-						}
+				} else {
+					if f.filterIncomingPacket != nil {
+						packetAction = f.filterIncomingPacket(packetBuffer[i].HAdapterQLinkUnion.GetAdapter(), &packetBuffer[i])
 					}
 				}
+				f.telemetry.callback(start)
+			}
 
-				if sendToAdapterNum > 0 {
-					f.SendPacketsToAdaptersUnsorted(writeAdapterRequest, sendToAdapterNum, &sentSuccess)
-				}
+			if adapterHandle != nil {
+				packetBuffer[i].HAdapterQLinkUnion.SetAdapter(*adapterHandle)
+			}
 
-				if sendToMstcpNum > 0 {
-					f.SendPacketsToMstcpUnsorted(writeMstcpRequest, sendToMstcpNum, &sentSuccess)
-				}
+			// Place packet back into the flow if was allowed to
+			toAdapter, toMstcp, listen := routePacket(packetAction, packetBuffer[i].DeviceFlags)
+			f.telemetry.packet(handle, &packetBuffer[i], toAdapter, toMstcp)
+			if listen {
+				listenCopy(f.listen, packetBuffer[i].HAdapterQLinkUnion.GetAdapter(), &packetBuffer[i])
+			}
+			if toAdapter {
+				writeAdapterRequest[sendToAdapterNum] = &packetBuffer[i]
+				sendToAdapterNum++
+			} else if toMstcp {
+				writeMstcpRequest[sendToMstcpNum] = &packetBuffer[i]
+				sendToMstcpNum++
+			}
+		}
 
-				if fastIOPacketsSuccess == 0 {
-					f.networkInterfaces[f.adapter].WaitEvent(windows.INFINITE)
-					f.networkInterfaces[f.adapter].ResetEvent()
-				}
+		if sendToAdapterNum > 0 {
+			err := f.SendPacketsToAdaptersUnsortedErr(writeAdapterRequest, sendToAdapterNum, &sentSuccess)
+			f.diagnostics.sendFailed(A.Handle{}, false, sendToAdapterNum, sentSuccess, err)
+			f.telemetry.sent(false, sendToAdapterNum, sentSuccess, err)
+		}
 
-				fastIOPacketsSuccess = 0
+		if sendToMstcpNum > 0 {
+			err := f.SendPacketsToMstcpUnsortedErr(writeMstcpRequest, sendToMstcpNum, &sentSuccess)
+			f.diagnostics.sendFailed(A.Handle{}, true, sendToMstcpNum, sentSuccess, err)
+			f.telemetry.sent(true, sendToMstcpNum, sentSuccess, err)
+		}
+
+		if sections.flushing {
+			if fastIOPacketsSuccess == 0 {
+				return
+			}
+			continue
+		}
+
+		err = sections.idle(readCtx, fastIOPacketsSuccess, func() error {
+			if err := adapter.WaitEvent(readCtx, A.WaitInfinite); err != nil {
+				return err
+			}
+			adapter.ResetEvent()
+			return nil
+		})
+		if err != nil {
+			if !flushing(ctx, readCtx) {
+				f.diagnostics.readFailed(ctx, adapter.GetAdapter(), err)
+				go f.fail(ctx)
+				return
 			}
+			sections.flush()
 		}
 	}
 }
 
 func (f *FastIOPacketFilter) GetFilterState() FilterState {
-	return f.filterState
+	return f.filterState.load()
 }
@@ -0,0 +1,441 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	A "github.com/wiresock/ndisapi-go"
+	N "github.com/wiresock/ndisapi-go/netlib"
+)
+
+var _ PacketFilter = (*FastIOMultiInterfacePacketFilter)(nil)
+var _ MultiInterfacePacketFilter = (*FastIOMultiInterfacePacketFilter)(nil)
+
+// FastIOMultiInterfacePacketFilter filters the packets of several network adapters through the fast I/O
+// sections shared by all of them. Each packet carries the handle of its adapter, so the adapters can be
+// added and removed while the filter runs.
+type FastIOMultiInterfacePacketFilter struct {
+	A.NdisApiInterface
+	sync.Mutex
+	ctx context.Context
+
+	adapters *A.TcpAdapterList
+
+	filterIncomingPacket PacketFilterFunc
+	filterOutgoingPacket PacketFilterFunc
+	listen               ListenFunc
+	contexts             contextFilters
+	diagnostics          diagnostics
+	telemetry            *telemetry
+	filterState          atomicFilterState
+
+	config   FastIOConfig
+	sections *fastIOSections
+
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+	flush  context.CancelFunc
+
+	multiInterfaceAdapters
+}
+
+// NewFastIOMultiInterfacePacketFilter constructs a FastIOMultiInterfacePacketFilter.
+func NewFastIOMultiInterfacePacketFilter(ctx context.Context, api A.NdisApiInterface, adapters *A.TcpAdapterList, in, out PacketFilterFunc) (*FastIOMultiInterfacePacketFilter, error) {
+	if err := A.RequireDriverVersion(api, A.SecondaryFastIODriverVersion, "fast I/O multi-interface packet filter"); err != nil {
+		return nil, err
+	}
+
+	filter := &FastIOMultiInterfacePacketFilter{
+		ctx:              ctx,
+		NdisApiInterface: api,
+		adapters:         adapters,
+
+		filterIncomingPacket: in,
+		filterOutgoingPacket: out,
+		telemetry:            newTelemetry(),
+		config:               DefaultFastIOConfig(),
+	}
+
+	packetEvent, err := A.NewEvent()
+	if err != nil {
+		return nil, fmt.Errorf("error creating event for adapter: %s", err.Error())
+	}
+	filter.packetEvent = packetEvent
+
+	filter.addNetworkInterfaces(api, adapters, &filter.diagnostics)
+
+	if err := filter.followAdapters(ctx, api, filter.onNetworkAdapterChange); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+// SetListener sets the consumer of the packets given the FilterActionPassRedirect or
+// FilterActionDropRedirect verdict. It should be called before the filter is started.
+func (f *FastIOMultiInterfacePacketFilter) SetListener(listen ListenFunc) {
+	f.listen = listen
+}
+
+// SetContextFilter sets PacketContext based callbacks for the incoming and outgoing packets.
+// A callback set for a direction takes precedence over the one passed to the constructor.
+// It should be called before the filter is started.
+func (f *FastIOMultiInterfacePacketFilter) SetContextFilter(in, out ContextFilterFunc) {
+	f.contexts.in = in
+	f.contexts.out = out
+}
+
+// SetLogger sets the logger receiving the diagnostics of the filter, nil discards them.
+// It should be called before the filter is started.
+func (f *FastIOMultiInterfacePacketFilter) SetLogger(logger A.Logger) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.diagnostics.logger = logger
+	for _, adapter := range f.networkInterfaces {
+		adapter.Logger = logger
+	}
+}
+
+// SetErrorHandler sets the consumer of the errors of the working thread, e.g. the
+// PacketSendError and PacketReadError. It should be called before the filter is started.
+func (f *FastIOMultiInterfacePacketFilter) SetErrorHandler(handler ErrorFunc) {
+	f.diagnostics.errors = handler
+}
+
+// SetFastIOConfig sets the fast I/O sections and the poll strategy of the filter.
+// It should be called before the filter is started.
+func (f *FastIOMultiInterfacePacketFilter) SetFastIOConfig(config FastIOConfig) error {
+	config = config.withDefaults()
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	f.config = config
+	return nil
+}
+
+// FastIOStats returns the section utilization counters since the filter was last started.
+func (f *FastIOMultiInterfacePacketFilter) FastIOStats() FastIOStats {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.sections == nil {
+		config := f.config.withDefaults()
+		return FastIOStats{SectionCount: config.SectionCount, SectionCapacity: config.capacity()}
+	}
+	return f.sections.stats()
+}
+
+// Stats returns a snapshot of the packet counters of the filter. FastIOStats reports the use of its sections.
+func (f *FastIOMultiInterfacePacketFilter) Stats() PipelineStats {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.telemetry.snapshot(f.NdisApiInterface, f.networkInterfaces)
+}
+
+// updateAdaptersFilterState tunnels the packets of the filtered adapters into the fast I/O sections
+// and restores the other adapters. The filtered adapters of a paused filter keep the packet event but
+// pass their traffic through. Must be called locked.
+func (f *FastIOMultiInterfacePacketFilter) updateAdaptersFilterState() {
+	f.setAdaptersMode(f.filterState.load(), f.filterIncomingPacket != nil || f.contexts.in != nil, f.filterOutgoingPacket != nil || f.contexts.out != nil, f.telemetry)
+}
+
+// onNetworkAdapterChange updates the network interfaces for an adapter list change.
+func (f *FastIOMultiInterfacePacketFilter) onNetworkAdapterChange(event N.AdapterEvent) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.applyAdapterChange(f.NdisApiInterface, event, &f.diagnostics)
+	f.updateAdaptersFilterState()
+}
+
+// FilterNetworkAdapter adds the specified network adapter to the filter list.
+func (f *FastIOMultiInterfacePacketFilter) FilterNetworkAdapter(name string) {
+	f.Lock()
+	defer f.Unlock()
+	f.filterAdapterList = append(f.filterAdapterList, name)
+	f.updateAdaptersFilterState()
+}
+
+// UnfilterNetworkAdapter removes the specified network adapter from the filter list.
+func (f *FastIOMultiInterfacePacketFilter) UnfilterNetworkAdapter(name string) {
+	f.Lock()
+	defer f.Unlock()
+	f.removeFilteredAdapter(name)
+	f.updateAdaptersFilterState()
+}
+
+// GetFilteredAdapters retrieves a list of currently filtered network adapters.
+func (f *FastIOMultiInterfacePacketFilter) GetFilteredAdapters() []string {
+	f.Lock()
+	defer f.Unlock()
+	return append([]string(nil), f.filterAdapterList...)
+}
+
+// Reconfigure updates available network interfaces. Should be called when the filter is inactive.
+func (f *FastIOMultiInterfacePacketFilter) Reconfigure() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateStopped {
+		return errors.New("filter is not stopped")
+	}
+
+	f.networkInterfaces = make([]*N.NetworkAdapter, 0)
+	f.addNetworkInterfaces(f.NdisApiInterface, f.adapters, &f.diagnostics)
+
+	return nil
+}
+
+// StartFilter registers the fast I/O sections and starts packet filtering.
+// If no adapter index is provided and no adapter was added with FilterNetworkAdapter, all available adapters will be used.
+func (f *FastIOMultiInterfacePacketFilter) StartFilter(filterAdapterIdx ...uint32) error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateStopped {
+		return errors.New("filter is not stopped")
+	}
+
+	f.filterState.store(FilterStateStarting)
+
+	sections, err := newFastIOSections(f.NdisApiInterface, f.config)
+	if err != nil {
+		f.filterState.store(FilterStateStopped)
+		return err
+	}
+	f.sections = sections
+
+	f.selectFilteredAdapters(filterAdapterIdx)
+
+	f.filterState.store(FilterStateRunning)
+
+	ctx, cancel := context.WithCancel(f.ctx)
+	readCtx, flush := context.WithCancel(ctx)
+	f.cancel = cancel
+	f.flush = flush
+
+	f.wg.Add(1)
+	go f.filterWorkingThread(ctx, readCtx)
+
+	f.updateAdaptersFilterState()
+
+	return nil
+}
+
+// Close stops packet filtering at once and restores the filtered adapters, discarding the packets
+// read but not written yet, and stops following the adapter list changes.
+func (f *FastIOMultiInterfacePacketFilter) Close() error {
+	err := f.Stop(doneContext)
+
+	// The monitor may be waiting for the filter lock to deliver an event, so it is released unlocked
+	f.releaseMonitor()
+
+	if !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// Stop stops packet filtering and restores the filtered adapters. The adapters stop tunneling packets
+// and the working thread filters and writes the packets left in the sections and in the driver queue,
+// unless the context is done first. The sections are then released from the driver.
+func (f *FastIOMultiInterfacePacketFilter) Stop(ctx context.Context) error {
+	f.Lock()
+	if state := f.filterState.load(); state != FilterStateRunning && state != FilterStatePaused {
+		f.Unlock()
+		return errors.New("filter is not running")
+	}
+	f.filterState.store(FilterStateStopping)
+	f.updateAdaptersFilterState()
+	f.flush()
+	f.Unlock()
+
+	// The adapter list changes are applied under the lock meanwhile, so the working thread is flushed unlocked
+	err := waitFlushed(ctx, &f.wg, f.cancel)
+	f.cancel()
+
+	f.Lock()
+	defer f.Unlock()
+
+	f.stopped()
+
+	return err
+}
+
+// stopped releases the sections, closes the network interfaces and marks the filter stopped once the
+// working thread has exited. Must be called locked.
+func (f *FastIOMultiInterfacePacketFilter) stopped() {
+	if err := f.sections.release(); err != nil {
+		f.diagnostics.log().Warn("failed to release the fast I/O sections", "error", err)
+	}
+	for _, adapter := range f.networkInterfaces {
+		adapter.Close()
+	}
+	f.filterState.store(FilterStateStopped)
+}
+
+// fail stops the filter after its working thread exited on an error, unless the run was stopped meanwhile.
+// It is called from a goroutine of its own, as the filter waits for the working thread to stop.
+func (f *FastIOMultiInterfacePacketFilter) fail(run context.Context) {
+	f.Lock()
+	defer f.Unlock()
+
+	if state := f.filterState.load(); run.Err() != nil || (state != FilterStateRunning && state != FilterStatePaused) {
+		return
+	}
+	f.filterState.store(FilterStateStopping)
+	f.updateAdaptersFilterState()
+	_ = waitFlushed(doneContext, &f.wg, f.cancel)
+	f.stopped()
+	f.diagnostics.log().Warn("working thread failed, filter stopped")
+}
+
+// Pause passes the traffic of the filtered adapters through unfiltered until Resume. The packets read
+// before pass unfiltered too, while the sections and the working thread are kept.
+func (f *FastIOMultiInterfacePacketFilter) Pause() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateRunning {
+		return errors.New("filter is not running")
+	}
+	f.filterState.store(FilterStatePaused)
+	f.updateAdaptersFilterState()
+
+	return nil
+}
+
+// Resume resumes filtering the traffic of the filtered adapters after Pause.
+func (f *FastIOMultiInterfacePacketFilter) Resume() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStatePaused {
+		return errors.New("filter is not paused")
+	}
+	f.filterState.store(FilterStateRunning)
+	f.updateAdaptersFilterState()
+
+	return nil
+}
+
+// filterWorkingThread reads the packets of all the filtered adapters from the fast I/O sections,
+// filters them and sends them back, waiting for the packet event when the sections are empty.
+// Once the read context is done, it drains the sections and the driver queue and exits.
+func (f *FastIOMultiInterfacePacketFilter) filterWorkingThread(ctx, readCtx context.Context) {
+	defer f.wg.Done()
+
+	var sentSuccess uint32
+
+	sections := f.sections
+	packetBuffer := sections.packetBuffer
+	writeAdapterRequest, writeMstcpRequest := sections.requests()
+
+	for ctx.Err() == nil {
+		fastIOPacketsSuccess := sections.read()
+		drained, err := sections.drain(fastIOPacketsSuccess)
+		f.diagnostics.readFailed(ctx, A.Handle{}, err)
+		fastIOPacketsSuccess += drained
+		f.telemetry.batch(fastIOPacketsSuccess)
+		paused := f.filterState.load() == FilterStatePaused
+
+		var sendToAdapterNum uint32
+		var sendToMstcpNum uint32
+
+		for i := uint32(0); i < fastIOPacketsSuccess; i++ {
+			var adapterHandle *A.Handle
+			packetAction := A.FilterActionPass
+			handle := packetBuffer[i].HAdapterQLinkUnion.GetAdapter()
+
+			// The packets read before the filter was paused pass unfiltered
+			if !paused {
+				start := time.Now()
+				if f.contexts.has(packetBuffer[i].DeviceFlags) {
+					packetAction, adapterHandle = f.contexts.filter(f.lookupNetworkInterface(packetBuffer[i].HAdapterQLinkUnion.GetAdapter()), &packetBuffer[i])
+				} else if packetBuffer[i].DeviceFlags == A.PACKET_FLAG_ON_SEND {
+					if f.filterOutgoingPacket != nil {
+						packetAction, adapterHandle = f.filterOutgoingPacket(packetBuffer[i].HAdapterQLinkUnion.GetAdapter(), &packetBuffer[i])
+					}
+				} else {
+					if f.filterIncomingPacket != nil {
+						packetAction, adapterHandle = f.filterIncomingPacket(packetBuffer[i].HAdapterQLinkUnion.GetAdapter(), &packetBuffer[i])
+					}
+				}
+				f.telemetry.callback(start)
+			}
+
+			if adapterHandle != nil {
+				packetBuffer[i].HAdapterQLinkUnion.SetAdapter(*adapterHandle)
+			}
+
+			toAdapter, toMstcp, listen := routePacket(packetAction, packetBuffer[i].DeviceFlags)
+			f.telemetry.packet(handle, &packetBuffer[i], toAdapter, toMstcp)
+			if listen {
+				listenCopy(f.listen, packetBuffer[i].HAdapterQLinkUnion.GetAdapter(), &packetBuffer[i])
+			}
+			if toAdapter {
+				writeAdapterRequest[sendToAdapterNum] = &packetBuffer[i]
+				sendToAdapterNum++
+			} else if toMstcp {
+				writeMstcpRequest[sendToMstcpNum] = &packetBuffer[i]
+				sendToMstcpNum++
+			}
+		}
+
+		if sendToAdapterNum > 0 {
+			err := f.SendPacketsToAdaptersUnsortedErr(writeAdapterRequest, sendToAdapterNum, &sentSuccess)
+			f.diagnostics.sendFailed(A.Handle{}, false, sendToAdapterNum, sentSuccess, err)
+			f.telemetry.sent(false, sendToAdapterNum, sentSuccess, err)
+		}
+
+		if sendToMstcpNum > 0 {
+			err := f.SendPacketsToMstcpUnsortedErr(writeMstcpRequest, sendToMstcpNum, &sentSuccess)
+			f.diagnostics.sendFailed(A.Handle{}, true, sendToMstcpNum, sentSuccess, err)
+			f.telemetry.sent(true, sendToMstcpNum, sentSuccess, err)
+		}
+
+		if sections.flushing {
+			if fastIOPacketsSuccess == 0 {
+				return
+			}
+			continue
+		}
+
+		err = sections.idle(readCtx, fastIOPacketsSuccess, func() error {
+			// The event is reset before the sections are read again, so a packet written meanwhile signals it anew
+			if err := f.packetEvent.WaitContext(readCtx, A.WaitInfinite); err != nil {
+				return err
+			}
+			return f.packetEvent.Reset()
+		})
+		if err != nil {
+			if !flushing(ctx, readCtx) {
+				f.diagnostics.readFailed(ctx, A.Handle{}, err)
+				go f.fail(ctx)
+				return
+			}
+			sections.flush()
+		}
+	}
+}
+
+// GetFilterState returns the current filter state.
+func (f *FastIOMultiInterfacePacketFilter) GetFilterState() FilterState {
+	return f.filterState.load()
+}
+
+// GetInterfaceList retrieves the list of all network interfaces available for packet filtering.
+func (f *FastIOMultiInterfacePacketFilter) GetInterfaceList() []*N.NetworkAdapter {
+	f.Lock()
+	defer f.Unlock()
+	return f.networkInterfaces
+}
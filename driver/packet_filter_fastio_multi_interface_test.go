@@ -0,0 +1,120 @@
+package driver_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	D "github.com/wiresock/ndisapi-go/driver"
+	N "github.com/wiresock/ndisapi-go/netlib"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+func TestFastIOMultiInterfacePacketFilter_FilterNetworkAdapter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	api := sim.NewDriver()
+	first := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+	second := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-1}`})
+
+	adapters, err := api.GetTcpipBoundAdaptersInfo()
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	filtered := map[A.Handle][]int{}
+	in := func(handle A.Handle, buffer *A.IntermediateBuffer) (A.FilterAction, *A.Handle) {
+		mu.Lock()
+		defer mu.Unlock()
+		filtered[handle] = append(filtered[handle], int(dstPort(buffer)))
+		return A.FilterActionPass, nil
+	}
+	seen := func(handle A.Handle) []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), filtered[handle]...)
+	}
+
+	filter, err := D.NewFastIOMultiInterfacePacketFilter(ctx, api, adapters, in, nil)
+	require.NoError(t, err)
+	require.NoError(t, filter.StartFilter(0))
+	assert.Equal(t, []string{`\DEVICE\{SIM-0}`}, filter.GetFilteredAdapters())
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer waitCancel()
+
+	// Both adapters share the sections, the packets of the unfiltered one bypass the filter
+	require.NoError(t, api.ReceiveFromNetwork(first, udpFrame(1)))
+	require.NoError(t, api.ReceiveFromNetwork(second, udpFrame(2)))
+	require.NoError(t, api.WaitDelivered(waitCtx, 2))
+	assert.Equal(t, []int{1}, seen(first))
+	assert.Empty(t, seen(second))
+
+	filter.FilterNetworkAdapter(`\DEVICE\{SIM-1}`)
+	require.NoError(t, api.ReceiveFromNetwork(second, udpFrame(3)))
+	require.NoError(t, api.WaitDelivered(waitCtx, 3))
+	assert.Equal(t, []int{3}, seen(second))
+
+	filter.UnfilterNetworkAdapter(`\DEVICE\{SIM-0}`)
+	require.NoError(t, api.ReceiveFromNetwork(first, udpFrame(4)))
+	require.NoError(t, api.WaitDelivered(waitCtx, 4))
+	assert.Equal(t, []int{1}, seen(first))
+	assert.Equal(t, []string{`\DEVICE\{SIM-1}`}, filter.GetFilteredAdapters())
+
+	require.NoError(t, filter.Close())
+	assert.Equal(t, D.FilterStateStopped, filter.GetFilterState())
+	assert.Len(t, api.StackPackets(first), 2)
+	assert.Len(t, api.StackPackets(second), 2)
+}
+
+func TestFastIOMultiInterfacePacketFilter_CloseReleasesMonitor(t *testing.T) {
+	api := sim.NewDriver()
+	api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+	adapters, err := api.GetTcpipBoundAdaptersInfo()
+	require.NoError(t, err)
+
+	// The context of the filter is never done, Close releases the monitor
+	filter, err := D.NewFastIOMultiInterfacePacketFilter(context.Background(), api, adapters, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, filter.StartFilter())
+
+	shared, err := N.NewAdapterMonitor(api)
+	require.NoError(t, err)
+	shared.Close()
+
+	assert.NoError(t, filter.Close())
+
+	monitor, err := N.NewAdapterMonitor(api)
+	require.NoError(t, err)
+	defer monitor.Close()
+	assert.NotSame(t, shared, monitor)
+}
+
+func TestFastIOMultiInterfacePacketFilter_StopReleasesSections(t *testing.T) {
+	api := sim.NewDriver()
+	handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+	adapters, err := api.GetTcpipBoundAdaptersInfo()
+	require.NoError(t, err)
+
+	filter, err := D.NewFastIOMultiInterfacePacketFilter(context.Background(), api, adapters, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, filter.StartFilter())
+	require.NoError(t, filter.Close())
+
+	// The driver no longer writes to the sections of the stopped filter, it queues the packets
+	require.NoError(t, api.SetAdapterMode(&A.AdapterMode{AdapterHandle: handle, Flags: A.MSTCP_FLAG_RECV_TUNNEL}))
+	require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(1)))
+
+	packets := []*A.IntermediateBuffer{new(A.IntermediateBuffer)}
+	var read uint32
+	require.NoError(t, api.ReadPacketsUnsortedErr(packets, 1, &read))
+	assert.Equal(t, uint32(1), read)
+	assert.Equal(t, uint16(1), dstPort(packets[0]))
+}
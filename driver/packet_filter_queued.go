@@ -1,5 +1,3 @@
-//go:build windows
-
 package driver
 
 import (
@@ -7,53 +5,61 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	A "github.com/wiresock/ndisapi-go"
 	N "github.com/wiresock/ndisapi-go/netlib"
-
-	"golang.org/x/sys/windows"
 )
 
 var _ PacketFilter = (*QueuedPacketFilter)(nil)
 var _ SingleInterfacePacketFilter = (*QueuedPacketFilter)(nil)
 
 type QueuedPacketFilter struct {
-	*A.NdisApi
+	A.NdisApiInterface
+	sync.Mutex
 	ctx context.Context
 
 	adapters *A.TcpAdapterList
 
 	filterIncomingPacket func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction
 	filterOutgoingPacket func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction
-	filterState          FilterState
+	listen               ListenFunc
+	contexts             contextFilters
+	diagnostics          diagnostics
+	telemetry            *telemetry
+	filterState          atomicFilterState
+	paused               bool
 	networkInterfaces    []*N.NetworkAdapter
 	adapter              int
+	adapterName          string
+	follower             *adapterFollower
+	config               QueuedConfig
 
 	wg     sync.WaitGroup
 	cancel context.CancelFunc
-
-	packetReadChan         chan *PacketBlock
-	packetProcessChan      chan *PacketBlock
-	packetWriteMstcpChan   chan *PacketBlock
-	packetWriteAdapterChan chan *PacketBlock
+	flush  context.CancelFunc
+
+	packetReadChan         chan *queuedBlock
+	packetProcessChan      chan *queuedBlock
+	packetWorkerChans      []chan *queuedBlock
+	packetCollectChan      chan *queuedBlock
+	packetWriteMstcpChan   chan *queuedBlock
+	packetWriteAdapterChan chan *queuedBlock
 }
 
 // NewQueuedPacketFilter constructs a QueuedPacketFilter.
-func NewQueuedPacketFilter(ctx context.Context, api *A.NdisApi, adapters *A.TcpAdapterList, in, out func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction) (*QueuedPacketFilter, error) {
+func NewQueuedPacketFilter(ctx context.Context, api A.NdisApiInterface, adapters *A.TcpAdapterList, in, out func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction) (*QueuedPacketFilter, error) {
 	filter := &QueuedPacketFilter{
-		ctx:      ctx,
-		NdisApi:  api,
-		adapters: adapters,
+		ctx:              ctx,
+		NdisApiInterface: api,
+		adapters:         adapters,
 
 		filterIncomingPacket: in,
 		filterOutgoingPacket: out,
-		filterState:          FilterStateStopped,
 		adapter:              0,
-
-		packetReadChan:         make(chan *PacketBlock, A.MaximumPacketBlock),
-		packetProcessChan:      make(chan *PacketBlock, A.MaximumPacketBlock),
-		packetWriteMstcpChan:   make(chan *PacketBlock, A.MaximumPacketBlock),
-		packetWriteAdapterChan: make(chan *PacketBlock, A.MaximumPacketBlock),
+		config:               DefaultQueuedConfig(),
+		telemetry:            newTelemetry(),
 	}
 
 	err := filter.initializeNetworkInterfaces()
@@ -64,12 +70,71 @@ func NewQueuedPacketFilter(ctx context.Context, api *A.NdisApi, adapters *A.TcpA
 	return filter, nil
 }
 
+// SetListener sets the consumer of the packets given the FilterActionPassRedirect or
+// FilterActionDropRedirect verdict. It should be called before the filter is started.
+func (f *QueuedPacketFilter) SetListener(listen ListenFunc) {
+	f.listen = listen
+}
+
+// SetContextFilter sets PacketContext based callbacks for the incoming and outgoing packets.
+// A callback set for a direction takes precedence over the one passed to the constructor.
+// It should be called before the filter is started.
+func (f *QueuedPacketFilter) SetContextFilter(in, out ContextFilterFunc) {
+	f.contexts.in = in
+	f.contexts.out = out
+}
+
+// SetLogger sets the logger receiving the diagnostics of the filter, nil discards them.
+// It should be called before the filter is started.
+func (f *QueuedPacketFilter) SetLogger(logger A.Logger) {
+	f.diagnostics.logger = logger
+	for _, adapter := range f.networkInterfaces {
+		adapter.Logger = logger
+	}
+}
+
+// SetErrorHandler sets the consumer of the errors of the working threads, e.g. the
+// PacketSendError and PacketReadError. It should be called before the filter is started.
+func (f *QueuedPacketFilter) SetErrorHandler(handler ErrorFunc) {
+	f.diagnostics.errors = handler
+}
+
+// SetQueuedConfig sets the number of the processing workers, the packet blocks and the queue depth
+// of the pipeline. It should be called before the filter is started.
+func (f *QueuedPacketFilter) SetQueuedConfig(config QueuedConfig) error {
+	config = config.withDefaults()
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	f.config = config
+	return nil
+}
+
 // initFilter initializes the filter and associated data structures required for packet filtering.
 func (f *QueuedPacketFilter) initFilter() error {
-	for i := 0; i < A.MaximumBlockNum; i++ {
-		packetBlock := NewPacketBlock(f.networkInterfaces[f.adapter].GetAdapter())
+	f.packetReadChan = make(chan *queuedBlock, f.config.BlockCount)
+	f.packetProcessChan = make(chan *queuedBlock, f.config.QueueDepth)
+	f.packetWriteMstcpChan = make(chan *queuedBlock, f.config.QueueDepth)
+	f.packetWriteAdapterChan = make(chan *queuedBlock, f.config.QueueDepth)
+	f.packetWorkerChans = nil
+	f.packetCollectChan = nil
+	if f.config.Workers > 1 {
+		f.packetWorkerChans = make([]chan *queuedBlock, f.config.Workers)
+		for i := range f.packetWorkerChans {
+			f.packetWorkerChans[i] = make(chan *queuedBlock, f.config.QueueDepth)
+		}
+		f.packetCollectChan = make(chan *queuedBlock, f.config.QueueDepth)
+	}
+
+	for i := 0; i < f.config.BlockCount; i++ {
+		packetBlock := newQueuedBlock(f.networkInterfaces[f.adapter].GetAdapter(), f.config.Workers)
 		f.packetReadChan <- packetBlock
 	}
+	f.telemetry.adapter(f.networkInterfaces[f.adapter].GetAdapter())
 
 	// Set events for helper driver
 	if err := f.networkInterfaces[f.adapter].SetPacketEvent(); err != nil {
@@ -79,25 +144,32 @@ func (f *QueuedPacketFilter) initFilter() error {
 		return err
 	}
 
-	f.networkInterfaces[f.adapter].SetMode(
-		func() uint32 {
-			mode := uint32(0)
-			if f.filterOutgoingPacket != nil {
-				mode |= A.MSTCP_FLAG_SENT_TUNNEL
-			}
-			if f.filterIncomingPacket != nil {
-				mode |= A.MSTCP_FLAG_RECV_TUNNEL
-			}
-			return mode
-		}(),
-	)
+	f.networkInterfaces[f.adapter].SetMode(f.mode())
 
 	return nil
 }
 
+// mode returns the adapter mode tunneling the directions with filter callbacks, none while paused.
+func (f *QueuedPacketFilter) mode() uint32 {
+	mode := uint32(0)
+	if f.paused {
+		return mode
+	}
+	if f.filterOutgoingPacket != nil || f.contexts.out != nil {
+		mode |= A.MSTCP_FLAG_SENT_TUNNEL
+	}
+	if f.filterIncomingPacket != nil || f.contexts.in != nil {
+		mode |= A.MSTCP_FLAG_RECV_TUNNEL
+	}
+	return mode
+}
+
 // Reconfigure updates available network interfaces. Should be called when the filter is inactive.
 func (f *QueuedPacketFilter) Reconfigure() error {
-	if f.filterState != FilterStateStopped {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateStopped {
 		return errors.New("filter is not stopped")
 	}
 
@@ -109,42 +181,151 @@ func (f *QueuedPacketFilter) Reconfigure() error {
 	return nil
 }
 
-// StartFilter starts packet filtering.
+// StartFilter starts filtering the adapter. The filter follows the adapter by its name: it is
+// suspended while the adapter is removed and resumes when the adapter arrives again.
 func (f *QueuedPacketFilter) StartFilter(adapter int) error {
-	if f.filterState != FilterStateStopped {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateStopped {
 		return errors.New("filter is not stopped")
 	}
+	if adapter < 0 || adapter >= len(f.networkInterfaces) {
+		return fmt.Errorf("adapter index %d is out of range", adapter)
+	}
 
-	f.filterState = FilterStateStarting
 	f.adapter = adapter
+	f.adapterName = f.networkInterfaces[adapter].InternalName
+
+	if err := f.start(); err != nil {
+		f.filterState.store(FilterStateStopped)
+		return err
+	}
+
+	follower, err := followAdapter(f.NdisApiInterface, f.adapterName, f.suspend, f.resume)
+	if err != nil {
+		_ = f.stop(doneContext)
+		f.filterState.store(FilterStateStopped)
+		return err
+	}
+	f.follower = follower
+
+	return nil
+}
+
+// Close stops packet filtering at once, discarding the packet blocks in the pipeline.
+func (f *QueuedPacketFilter) Close() error {
+	if err := f.Stop(doneContext); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// Stop stops packet filtering. The adapter stops queueing packets, the reader reads the packets left in
+// the driver queue and the pipeline filters and writes all the blocks in flight, unless the context is
+// done first.
+func (f *QueuedPacketFilter) Stop(ctx context.Context) error {
+	var err error
+
+	f.Lock()
+	switch f.filterState.load() {
+	case FilterStateRunning, FilterStatePaused:
+		err = f.stop(ctx)
+	case FilterStateSuspended:
+	default:
+		f.Unlock()
+		return errors.New("filter is not running")
+	}
+	follower := f.stopped()
+	f.Unlock()
+
+	follower.Close()
+	return err
+}
+
+// Pause passes the traffic of the adapter through unfiltered until Resume. The packet blocks in the
+// pipeline pass unfiltered too, while the blocks and the pipeline are kept.
+func (f *QueuedPacketFilter) Pause() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateRunning {
+		return errors.New("filter is not running")
+	}
+	f.paused = true
+	if err := f.networkInterfaces[f.adapter].SetMode(f.mode()); err != nil {
+		f.paused = false
+		return err
+	}
+	f.filterState.store(FilterStatePaused)
+
+	return nil
+}
+
+// Resume resumes filtering the traffic of the adapter after Pause.
+func (f *QueuedPacketFilter) Resume() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStatePaused {
+		return errors.New("filter is not paused")
+	}
+	f.paused = false
+	if err := f.networkInterfaces[f.adapter].SetMode(f.mode()); err != nil {
+		f.paused = true
+		return err
+	}
+	f.filterState.store(FilterStateRunning)
+
+	return nil
+}
+
+// start initializes the filter for the current adapter and starts the pipeline. Must be called locked.
+func (f *QueuedPacketFilter) start() error {
+	f.filterState.store(FilterStateStarting)
 
 	if err := f.initFilter(); err != nil {
-		f.filterState = FilterStateStopped
 		return err
 	}
-	f.filterState = FilterStateRunning
+	if f.paused {
+		f.filterState.store(FilterStatePaused)
+	} else {
+		f.filterState.store(FilterStateRunning)
+	}
 
 	ctx, cancel := context.WithCancel(f.ctx)
+	readCtx, flush := context.WithCancel(ctx)
 	f.cancel = cancel
+	f.flush = flush
 	f.wg.Add(4)
 
-	go f.packetRead(ctx)
-	go f.packetProcess(ctx)
+	go f.packetRead(ctx, readCtx)
+	if len(f.packetWorkerChans) == 0 {
+		go f.packetProcess(ctx)
+	} else {
+		f.wg.Add(len(f.packetWorkerChans) + 1)
+		go f.packetDispatch(ctx)
+		for i := range f.packetWorkerChans {
+			go f.packetProcessWorker(ctx, i)
+		}
+		go f.packetCollect(ctx)
+	}
 	go f.packetWriteMstcp(ctx)
 	go f.packetWriteAdapter(ctx)
 
 	return nil
 }
 
-// Close stops packet filtering.
-func (f *QueuedPacketFilter) Close() error {
-	if f.filterState != FilterStateRunning {
-		return errors.New("filter is not running")
-	}
-
-	f.filterState = FilterStateStopping
-	f.networkInterfaces[f.adapter].Close()
+// stop stops the pipeline and restores the adapter mode. The packets left in the driver queue and the blocks
+// in flight are filtered and written before, unless the context is done first and the blocks are discarded.
+// Must be called locked.
+func (f *QueuedPacketFilter) stop(ctx context.Context) error {
+	f.filterState.store(FilterStateStopping)
+	_ = f.networkInterfaces[f.adapter].SetMode(0)
+	f.flush()
+	err := waitFlushed(ctx, &f.wg, f.cancel)
 	f.cancel()
+	f.networkInterfaces[f.adapter].Close()
 	// clear queues
 	{
 		for len(f.packetReadChan) > 0 {
@@ -153,6 +334,14 @@ func (f *QueuedPacketFilter) Close() error {
 		for len(f.packetProcessChan) > 0 {
 			<-f.packetProcessChan
 		}
+		for _, packetWorkerChan := range f.packetWorkerChans {
+			for len(packetWorkerChan) > 0 {
+				<-packetWorkerChan
+			}
+		}
+		for len(f.packetCollectChan) > 0 {
+			<-f.packetCollectChan
+		}
 		for len(f.packetWriteMstcpChan) > 0 {
 			<-f.packetWriteMstcpChan
 		}
@@ -160,15 +349,83 @@ func (f *QueuedPacketFilter) Close() error {
 			<-f.packetWriteAdapterChan
 		}
 	}
-	f.wg.Wait()
-	f.filterState = FilterStateStopped
-	return nil
+	return err
+}
+
+// suspend stops filtering the removed adapter until it arrives again.
+func (f *QueuedPacketFilter) suspend() {
+	f.Lock()
+	defer f.Unlock()
+
+	if state := f.filterState.load(); state != FilterStateRunning && state != FilterStatePaused {
+		return
+	}
+	_ = f.stop(doneContext)
+	f.filterState.store(FilterStateSuspended)
+	f.diagnostics.log().Info("adapter removed, filter suspended", "adapter", f.adapterName)
+}
+
+// stopped marks the filter stopped and detaches the adapter follower, to be closed unlocked. Must be called locked.
+func (f *QueuedPacketFilter) stopped() *adapterFollower {
+	f.filterState.store(FilterStateStopped)
+	f.paused = false
+	follower := f.follower
+	f.follower = nil
+	return follower
+}
+
+// fail stops the filter after its working thread exited on an error, unless the run was stopped or
+// suspended meanwhile. It is called from a goroutine of its own, as stop waits for the working thread.
+func (f *QueuedPacketFilter) fail(run context.Context) {
+	f.Lock()
+	if run.Err() != nil {
+		f.Unlock()
+		return
+	}
+	_ = f.stop(doneContext)
+	follower := f.stopped()
+	f.Unlock()
+
+	follower.Close()
+	f.diagnostics.log().Warn("working thread failed, filter stopped", "adapter", f.adapterName)
+}
+
+// resume restarts filtering the adapter arrived again, wherever it is in the adapter list.
+func (f *QueuedPacketFilter) resume() {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateSuspended {
+		return
+	}
+
+	adapters, err := f.GetTcpipBoundAdaptersInfo()
+	if err != nil {
+		f.diagnostics.log().Warn("failed to query the adapters to resume the filter", "adapter", f.adapterName, "error", err)
+		return
+	}
+	f.adapters = adapters
+	f.networkInterfaces = nil
+	if err := f.initializeNetworkInterfaces(); err != nil {
+		return
+	}
+
+	if f.adapter = indexOfAdapter(f.networkInterfaces, f.adapterName); f.adapter < 0 {
+		f.adapter = 0
+		return
+	}
+	if err := f.start(); err != nil {
+		f.filterState.store(FilterStateSuspended)
+		f.diagnostics.log().Warn("failed to resume the filter", "adapter", f.adapterName, "error", err)
+		return
+	}
+	f.diagnostics.log().Info("adapter arrived, filter resumed", "adapter", f.adapterName)
 }
 
 // initializeNetworkInterfaces initializes available network interface list.
 func (f *QueuedPacketFilter) initializeNetworkInterfaces() error {
 	for i := 0; i < int(f.adapters.AdapterCount); i++ {
-		name := string(f.adapters.AdapterNameList[i][:])
+		name := f.adapters.AdapterName(i)
 		adapterHandle := f.adapters.AdapterHandle[i]
 		currentAddress := f.adapters.CurrentAddress[i]
 		medium := f.adapters.AdapterMediumList[i]
@@ -176,11 +433,12 @@ func (f *QueuedPacketFilter) initializeNetworkInterfaces() error {
 
 		friendlyName := f.ConvertWindows2000AdapterName(name)
 
-		networkAdapter, err := N.NewNetworkAdapter(f.NdisApi, adapterHandle, currentAddress, name, friendlyName, medium, mtu, nil)
+		networkAdapter, err := N.NewNetworkAdapter(f.NdisApiInterface, adapterHandle, currentAddress, name, friendlyName, medium, mtu, nil)
 		if err != nil {
-			fmt.Println("error creating network adapter", err.Error())
+			f.diagnostics.log().Error("error creating network adapter", "adapter", name, "error", err)
 			continue
 		}
+		networkAdapter.Logger = f.diagnostics.logger
 		f.networkInterfaces = append(f.networkInterfaces, networkAdapter)
 	}
 
@@ -211,41 +469,52 @@ func (f *QueuedPacketFilter) InsertPacketToAdapter(packetData *A.IntermediateBuf
 	return f.SendPacketToAdapter(request)
 }
 
-// packetRead reads packets from the network interface.
-func (q *QueuedPacketFilter) packetRead(ctx context.Context) {
+// packetRead reads packets from the network interface. Once the read context is done, it reads the packets
+// left in the driver queue and closes the process queue, so the following stages write them and exit.
+func (q *QueuedPacketFilter) packetRead(ctx, readCtx context.Context) {
 	defer q.wg.Done()
+	defer close(q.packetProcessChan)
+
+	adapter := q.networkInterfaces[q.adapter]
+	flush := false
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		default:
-			if q.filterState != FilterStateRunning {
-				return
-			}
-
-			packetBlock := <-q.packetReadChan
-
+		case packetBlock := <-q.packetReadChan:
 			readRequest := packetBlock.GetReadRequest()
 
-			for q.filterState == FilterStateRunning {
-				_, err := q.networkInterfaces[q.adapter].WaitEvent(windows.INFINITE)
-				if err != nil {
-					ctx.Done()
-					return
-				}
-
-				err = q.networkInterfaces[q.adapter].ResetEvent()
-				if err != nil {
-					ctx.Done()
-					return
+			for {
+				if !flush {
+					if err := adapter.WaitEvent(readCtx, A.WaitInfinite); err != nil {
+						if flush = flushing(ctx, readCtx); !flush {
+							q.diagnostics.readFailed(ctx, adapter.GetAdapter(), err)
+							go q.fail(ctx)
+							return
+						}
+					} else if err := adapter.ResetEvent(); err != nil {
+						q.diagnostics.readFailed(ctx, adapter.GetAdapter(), err)
+						go q.fail(ctx)
+						return
+					}
 				}
 
-				if !q.ReadPackets(readRequest) {
+				err := q.ReadPacketsErr(readRequest)
+				if err == nil {
 					break
 				}
+				q.diagnostics.readFailed(ctx, readRequest.AdapterHandle, err)
+				if flush || ctx.Err() != nil {
+					return
+				}
 			}
+			q.telemetry.batch(readRequest.PacketsSuccess)
 
-			q.packetProcessChan <- packetBlock
+			select {
+			case q.packetProcessChan <- packetBlock:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
 }
@@ -253,81 +522,231 @@ func (q *QueuedPacketFilter) packetRead(ctx context.Context) {
 // packetProcess processes the read packets.
 func (q *QueuedPacketFilter) packetProcess(ctx context.Context) {
 	defer q.wg.Done()
+	defer close(q.packetWriteMstcpChan)
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		default:
-			if q.filterState != FilterStateRunning {
+		case packetBlock, ok := <-q.packetProcessChan:
+			if !ok {
 				return
 			}
 
-			packetBlock := <-q.packetProcessChan
-
 			readRequest := packetBlock.GetReadRequest()
 			writeAdapterRequest := packetBlock.GetWriteAdapterRequest()
 			writeMstcpRequest := packetBlock.GetWriteMstcpRequest()
 
 			for i := 0; i < int(readRequest.PacketsSuccess); i++ {
-				packetAction := A.FilterActionPass
+				toAdapter, toMstcp := q.filterPacket(&q.contexts, readRequest.AdapterHandle, &packetBlock.packetBuffer[i])
+				if toAdapter {
+					writeAdapterRequest.EthernetPackets[writeAdapterRequest.PacketsNumber].Buffer = &packetBlock.packetBuffer[i]
+					writeAdapterRequest.PacketsNumber++
+				} else if toMstcp {
+					writeMstcpRequest.EthernetPackets[writeMstcpRequest.PacketsNumber].Buffer = &packetBlock.packetBuffer[i]
+					writeMstcpRequest.PacketsNumber++
+				}
+			}
 
-				if packetBlock.packetBuffer[i].DeviceFlags == A.PACKET_FLAG_ON_SEND {
-					if q.filterOutgoingPacket != nil {
-						packetAction = q.filterOutgoingPacket(readRequest.AdapterHandle, &packetBlock.packetBuffer[i])
-					}
-				} else {
-					if q.filterIncomingPacket != nil {
-						packetAction = q.filterIncomingPacket(readRequest.AdapterHandle, &packetBlock.packetBuffer[i])
-					}
+			readRequest.PacketsSuccess = 0
+
+			select {
+			case q.packetWriteMstcpChan <- packetBlock:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// packetDispatch distributes the packets of the read blocks among the workers by their flow and
+// queues the blocks to be collected in the order they were read.
+func (q *QueuedPacketFilter) packetDispatch(ctx context.Context) {
+	defer q.wg.Done()
+	defer func() {
+		close(q.packetCollectChan)
+		for _, packetWorkerChan := range q.packetWorkerChans {
+			close(packetWorkerChan)
+		}
+	}()
+
+	var packet A.DecodedPacket
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case packetBlock, ok := <-q.packetProcessChan:
+			if !ok {
+				return
+			}
+
+			readRequest := packetBlock.GetReadRequest()
+			for worker := range packetBlock.workers {
+				packetBlock.workers[worker] = packetBlock.workers[worker][:0]
+			}
+			for i := 0; i < int(readRequest.PacketsSuccess); i++ {
+				_ = packetBlock.packetBuffer[i].Decode(&packet)
+				worker := flowHash(&packet) % uint32(len(packetBlock.workers))
+				packetBlock.workers[worker] = append(packetBlock.workers[worker], uint16(i))
+			}
+
+			remaining := int32(0)
+			for _, packets := range packetBlock.workers {
+				if len(packets) > 0 {
+					remaining++
 				}
+			}
+			packetBlock.remaining = remaining
+			if remaining == 0 {
+				packetBlock.done <- struct{}{}
+			}
 
-				if packetAction == A.FilterActionPass {
-					if packetBlock.packetBuffer[i].DeviceFlags == A.PACKET_FLAG_ON_SEND {
-						writeAdapterRequest.EthernetPackets[writeAdapterRequest.PacketsNumber].Buffer = &packetBlock.packetBuffer[i]
-						writeAdapterRequest.PacketsNumber++
-					} else {
-						writeMstcpRequest.EthernetPackets[writeMstcpRequest.PacketsNumber].Buffer = &packetBlock.packetBuffer[i]
-						writeMstcpRequest.PacketsNumber++
-					}
-				} else if packetAction == A.FilterActionRedirect {
-					if packetBlock.packetBuffer[i].DeviceFlags == A.PACKET_FLAG_ON_RECEIVE {
-						writeAdapterRequest.EthernetPackets[writeAdapterRequest.PacketsNumber].Buffer = &packetBlock.packetBuffer[i]
-						writeAdapterRequest.PacketsNumber++
-					} else {
-						writeMstcpRequest.EthernetPackets[writeMstcpRequest.PacketsNumber].Buffer = &packetBlock.packetBuffer[i]
-						writeMstcpRequest.PacketsNumber++
-					}
+			select {
+			case q.packetCollectChan <- packetBlock:
+			case <-ctx.Done():
+				return
+			}
+
+			for worker, packets := range packetBlock.workers {
+				if len(packets) == 0 {
+					continue
+				}
+				select {
+				case q.packetWorkerChans[worker] <- packetBlock:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+// packetProcessWorker processes the packets of the blocks dispatched to the worker.
+func (q *QueuedPacketFilter) packetProcessWorker(ctx context.Context, worker int) {
+	defer q.wg.Done()
+	contexts := contextFilters{in: q.contexts.in, out: q.contexts.out}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case packetBlock, ok := <-q.packetWorkerChans[worker]:
+			if !ok {
+				return
+			}
+
+			handle := packetBlock.GetReadRequest().AdapterHandle
+			for _, i := range packetBlock.workers[worker] {
+				route := &packetBlock.routes[i]
+				route.toAdapter, route.toMstcp = q.filterPacket(&contexts, handle, &packetBlock.packetBuffer[i])
+			}
+
+			if atomic.AddInt32(&packetBlock.remaining, -1) == 0 {
+				packetBlock.done <- struct{}{}
+			}
+		}
+	}
+}
+
+// packetCollect waits for the workers to process the blocks and queues their packets for writing
+// in the order they were read.
+func (q *QueuedPacketFilter) packetCollect(ctx context.Context) {
+	defer q.wg.Done()
+	defer close(q.packetWriteMstcpChan)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case packetBlock, ok := <-q.packetCollectChan:
+			if !ok {
+				return
+			}
+			select {
+			case <-packetBlock.done:
+			case <-ctx.Done():
+				return
+			}
+
+			readRequest := packetBlock.GetReadRequest()
+			writeAdapterRequest := packetBlock.GetWriteAdapterRequest()
+			writeMstcpRequest := packetBlock.GetWriteMstcpRequest()
+
+			for i := 0; i < int(readRequest.PacketsSuccess); i++ {
+				if packetBlock.routes[i].toAdapter {
+					writeAdapterRequest.EthernetPackets[writeAdapterRequest.PacketsNumber].Buffer = &packetBlock.packetBuffer[i]
+					writeAdapterRequest.PacketsNumber++
+				} else if packetBlock.routes[i].toMstcp {
+					writeMstcpRequest.EthernetPackets[writeMstcpRequest.PacketsNumber].Buffer = &packetBlock.packetBuffer[i]
+					writeMstcpRequest.PacketsNumber++
 				}
 			}
 
 			readRequest.PacketsSuccess = 0
 
-			q.packetWriteMstcpChan <- packetBlock
+			select {
+			case q.packetWriteMstcpChan <- packetBlock:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// filterPacket runs the filter callback for the packet, hands it to the listener if the verdict
+// says so and returns its destinations.
+func (q *QueuedPacketFilter) filterPacket(contexts *contextFilters, handle A.Handle, buffer *A.IntermediateBuffer) (toAdapter, toMstcp bool) {
+	packetAction := A.FilterActionPass
+
+	// The packets read before the filter was paused pass unfiltered
+	if q.filterState.load() != FilterStatePaused {
+		start := time.Now()
+		if contexts.has(buffer.DeviceFlags) {
+			packetAction, _ = contexts.filter(q.networkInterfaces[q.adapter], buffer)
+		} else if buffer.DeviceFlags == A.PACKET_FLAG_ON_SEND {
+			if q.filterOutgoingPacket != nil {
+				packetAction = q.filterOutgoingPacket(handle, buffer)
+			}
+		} else {
+			if q.filterIncomingPacket != nil {
+				packetAction = q.filterIncomingPacket(handle, buffer)
+			}
 		}
+		q.telemetry.callback(start)
 	}
+
+	toAdapter, toMstcp, listen := routePacket(packetAction, buffer.DeviceFlags)
+	q.telemetry.packet(handle, buffer, toAdapter, toMstcp)
+	if listen {
+		listenCopy(q.listen, handle, buffer)
+	}
+	return toAdapter, toMstcp
 }
 
 // packetWriteMstcp writes packets to the MSTCP.
 func (q *QueuedPacketFilter) packetWriteMstcp(ctx context.Context) {
 	defer q.wg.Done()
+	defer close(q.packetWriteAdapterChan)
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		default:
-			if q.filterState != FilterStateRunning {
+		case packetBlock, ok := <-q.packetWriteMstcpChan:
+			if !ok {
 				return
 			}
 
-			packetBlock := <-q.packetWriteMstcpChan
-
 			writeMstcpRequest := packetBlock.GetWriteMstcpRequest()
 			if writeMstcpRequest.PacketsNumber > 0 {
-				q.SendPacketsToMstcp(writeMstcpRequest)
+				if err := q.SendPacketsToMstcp(writeMstcpRequest); err != nil {
+					q.diagnostics.sendFailed(writeMstcpRequest.AdapterHandle, true, writeMstcpRequest.PacketsNumber, 0, err)
+					q.telemetry.sent(true, writeMstcpRequest.PacketsNumber, 0, err)
+				}
 				writeMstcpRequest.PacketsNumber = 0
 			}
 
-			q.packetWriteAdapterChan <- packetBlock
+			select {
+			case q.packetWriteAdapterChan <- packetBlock:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
 }
@@ -339,25 +758,54 @@ func (q *QueuedPacketFilter) packetWriteAdapter(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			return
-		default:
-			if q.filterState != FilterStateRunning {
+		case packetBlock, ok := <-q.packetWriteAdapterChan:
+			if !ok {
 				return
 			}
 
-			packetBlock := <-q.packetWriteAdapterChan
-
 			writeAdapterRequest := packetBlock.GetWriteAdapterRequest()
 			if writeAdapterRequest.PacketsNumber > 0 {
-				q.SendPacketsToAdapter(writeAdapterRequest)
+				if err := q.SendPacketsToAdapter(writeAdapterRequest); err != nil {
+					q.diagnostics.sendFailed(writeAdapterRequest.AdapterHandle, false, writeAdapterRequest.PacketsNumber, 0, err)
+					q.telemetry.sent(false, writeAdapterRequest.PacketsNumber, 0, err)
+				}
 				writeAdapterRequest.PacketsNumber = 0
 			}
 
-			q.packetReadChan <- packetBlock
+			select {
+			case q.packetReadChan <- packetBlock:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
 }
 
 // GetFilterState returns the current filter state.
 func (f *QueuedPacketFilter) GetFilterState() FilterState {
-	return f.filterState
+	return f.filterState.load()
+}
+
+// Stats returns a snapshot of the packet counters of the filter and the packet blocks waiting in its queues.
+// The read queue holds the blocks free to read the packets into.
+func (f *QueuedPacketFilter) Stats() PipelineStats {
+	f.Lock()
+	defer f.Unlock()
+
+	stats := f.telemetry.snapshot(f.NdisApiInterface, f.networkInterfaces)
+	queue := func(name string, queue chan *queuedBlock) {
+		stats.Queues = append(stats.Queues, QueueStats{Name: name, Length: len(queue), Capacity: cap(queue)})
+	}
+	queue("read", f.packetReadChan)
+	queue("process", f.packetProcessChan)
+	for i, packetWorkerChan := range f.packetWorkerChans {
+		queue(fmt.Sprintf("worker%d", i), packetWorkerChan)
+	}
+	if f.packetCollectChan != nil {
+		queue("collect", f.packetCollectChan)
+	}
+	queue("write-mstcp", f.packetWriteMstcpChan)
+	queue("write-adapter", f.packetWriteAdapterChan)
+
+	return stats
 }
@@ -1,5 +1,3 @@
-//go:build windows
-
 package driver
 
 import (
@@ -7,11 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	A "github.com/wiresock/ndisapi-go"
 	N "github.com/wiresock/ndisapi-go/netlib"
-
-	"golang.org/x/sys/windows"
 )
 
 var _ PacketFilter = (*QueuedMultiInterfacePacketFilter)(nil)
@@ -21,7 +18,7 @@ var _ MultiInterfacePacketFilter = (*QueuedMultiInterfacePacketFilter)(nil)
 type PacketFilterFunc func(handle A.Handle, buffer *A.IntermediateBuffer) (A.FilterAction, *A.Handle)
 
 type QueuedMultiInterfacePacketFilter struct {
-	*A.NdisApi
+	A.NdisApiInterface
 	sync.Mutex
 	ctx context.Context
 
@@ -29,128 +26,112 @@ type QueuedMultiInterfacePacketFilter struct {
 
 	filterIncomingPacket PacketFilterFunc
 	filterOutgoingPacket PacketFilterFunc
-	filterState          FilterState
-	networkInterfaces    []*N.NetworkAdapter
-	filterAdapterList    []string
+	listen               ListenFunc
+	contexts             contextFilters
+	diagnostics          diagnostics
+	telemetry            *telemetry
+	filterState          atomicFilterState
 
 	wg     sync.WaitGroup
 	cancel context.CancelFunc
+	flush  context.CancelFunc
 
 	packetReadChan         chan *UnsortedPacketBlock
 	packetProcessChan      chan *UnsortedPacketBlock
 	packetWriteMstcpChan   chan *UnsortedPacketBlock
 	packetWriteAdapterChan chan *UnsortedPacketBlock
 
-	adapterEvent *A.SafeEvent
-	packetEvent  *A.SafeEvent
+	multiInterfaceAdapters
 }
 
 // NewQueuedMultiInterfacePacketFilter constructs a QueuedMultiInterfacePacketFilter.
-func NewQueuedMultiInterfacePacketFilter(ctx context.Context, api *A.NdisApi, adapters *A.TcpAdapterList, in, out PacketFilterFunc) (*QueuedMultiInterfacePacketFilter, error) {
+func NewQueuedMultiInterfacePacketFilter(ctx context.Context, api A.NdisApiInterface, adapters *A.TcpAdapterList, in, out PacketFilterFunc) (*QueuedMultiInterfacePacketFilter, error) {
+	if err := A.RequireDriverVersion(api, A.UnsortedIODriverVersion, "queued multi-interface packet filter"); err != nil {
+		return nil, err
+	}
+
 	filter := &QueuedMultiInterfacePacketFilter{
-		ctx:      ctx,
-		NdisApi:  api,
-		adapters: adapters,
+		ctx:              ctx,
+		NdisApiInterface: api,
+		adapters:         adapters,
 
 		filterIncomingPacket: in,
 		filterOutgoingPacket: out,
-		filterState:          FilterStateStopped,
-
-		packetReadChan:         make(chan *UnsortedPacketBlock, A.UnsortedMaximumPacketBlock),
-		packetProcessChan:      make(chan *UnsortedPacketBlock, A.UnsortedMaximumPacketBlock),
-		packetWriteMstcpChan:   make(chan *UnsortedPacketBlock, A.UnsortedMaximumPacketBlock),
-		packetWriteAdapterChan: make(chan *UnsortedPacketBlock, A.UnsortedMaximumPacketBlock),
-	}
-
-	adapterEvent, err := windows.CreateEvent(nil, 1, 0, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating event for adapter: %s", err.Error())
+		telemetry:            newTelemetry(),
 	}
-	filter.adapterEvent = A.NewSafeEvent(adapterEvent)
 
-	packetEvent, err := windows.CreateEvent(nil, 1, 0, nil)
+	packetEvent, err := A.NewEvent()
 	if err != nil {
 		return nil, fmt.Errorf("error creating event for adapter: %s", err.Error())
 	}
-	filter.packetEvent = A.NewSafeEvent(packetEvent)
+	filter.packetEvent = packetEvent
 
-	go filter.monitorAdapterChanges()
-	if err := filter.SetAdapterListChangeEvent(adapterEvent); err != nil {
-		return nil, err
-	}
+	filter.addNetworkInterfaces(api, adapters, &filter.diagnostics)
 
-	err = filter.initializeNetworkInterfaces()
-	if err != nil {
+	if err := filter.followAdapters(ctx, api, filter.onNetworkAdapterChange); err != nil {
 		return nil, err
 	}
 
 	return filter, nil
 }
 
-// UpdateAdaptersFilterState updates the filter state of network adapters.
-func (f *QueuedMultiInterfacePacketFilter) UpdateAdaptersFilterState() {
+// SetListener sets the consumer of the packets given the FilterActionPassRedirect or
+// FilterActionDropRedirect verdict. It should be called before the filter is started.
+func (f *QueuedMultiInterfacePacketFilter) SetListener(listen ListenFunc) {
+	f.listen = listen
+}
+
+// SetContextFilter sets PacketContext based callbacks for the incoming and outgoing packets.
+// A callback set for a direction takes precedence over the one passed to the constructor.
+// It should be called before the filter is started.
+func (f *QueuedMultiInterfacePacketFilter) SetContextFilter(in, out ContextFilterFunc) {
+	f.contexts.in = in
+	f.contexts.out = out
+}
+
+// SetLogger sets the logger receiving the diagnostics of the filter, nil discards them.
+// It should be called before the filter is started.
+func (f *QueuedMultiInterfacePacketFilter) SetLogger(logger A.Logger) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.diagnostics.logger = logger
 	for _, adapter := range f.networkInterfaces {
-		matched := false
-		if f.filterState == FilterStateRunning {
-			for _, element := range f.filterAdapterList {
-				if element == adapter.InternalName {
-					mode := uint32(0)
-					if f.filterOutgoingPacket != nil {
-						mode |= A.MSTCP_FLAG_SENT_TUNNEL
-					}
-					if f.filterIncomingPacket != nil {
-						mode |= A.MSTCP_FLAG_RECV_TUNNEL
-					}
-					adapter.SetMode(mode)
-					adapter.SetPacketEvent()
-					matched = true
-					break
-				}
-			}
-		}
-		if !matched {
-			adapter.ResetPacketEvent()
-			adapter.SetMode(0)
-		}
+		adapter.Logger = logger
 	}
 }
 
+// SetErrorHandler sets the consumer of the errors of the working threads, e.g. the
+// PacketSendError and PacketReadError. It should be called before the filter is started.
+func (f *QueuedMultiInterfacePacketFilter) SetErrorHandler(handler ErrorFunc) {
+	f.diagnostics.errors = handler
+}
+
+// UpdateAdaptersFilterState updates the filter state of network adapters. The filtered adapters of
+// a paused filter keep the packet event but pass their traffic through.
+func (f *QueuedMultiInterfacePacketFilter) UpdateAdaptersFilterState() {
+	f.setAdaptersMode(f.filterState.load(), f.filterIncomingPacket != nil || f.contexts.in != nil, f.filterOutgoingPacket != nil || f.contexts.out != nil, f.telemetry)
+}
+
 // initFilter initializes the filter and associated data structures required for packet filtering.
 func (f *QueuedMultiInterfacePacketFilter) initFilter() {
+	f.packetReadChan = make(chan *UnsortedPacketBlock, A.UnsortedMaximumPacketBlock)
+	f.packetProcessChan = make(chan *UnsortedPacketBlock, A.UnsortedMaximumPacketBlock)
+	f.packetWriteMstcpChan = make(chan *UnsortedPacketBlock, A.UnsortedMaximumPacketBlock)
+	f.packetWriteAdapterChan = make(chan *UnsortedPacketBlock, A.UnsortedMaximumPacketBlock)
+
 	for i := 0; i < A.UnsortedMaximumBlockNum; i++ {
 		packetBlock := NewUnsortedPacketBlock()
 		f.packetReadChan <- packetBlock
 	}
 }
 
-// monitorAdapterChanges monitors network adapter changes.
-func (f *QueuedMultiInterfacePacketFilter) monitorAdapterChanges() {
-	for {
-		select {
-		case <-f.ctx.Done():
-			return
-		default:
-			f.adapterEvent.Wait(windows.INFINITE)
-			f.adapterEvent.Reset()
-			if f.ctx.Err() == nil {
-				f.onNetworkAdapterChange()
-			}
-		}
-	}
-}
-
-// onNetworkAdapterChange handles network adapter changes.
-func (f *QueuedMultiInterfacePacketFilter) onNetworkAdapterChange() {
+// onNetworkAdapterChange updates the network interfaces for an adapter list change.
+func (f *QueuedMultiInterfacePacketFilter) onNetworkAdapterChange(event N.AdapterEvent) {
 	f.Lock()
 	defer f.Unlock()
 
-	f.networkInterfaces = nil
-
-	if err := f.initializeNetworkInterfaces(); err != nil {
-		fmt.Println("error initializing network interfaces:", err)
-		return
-	}
-
+	f.applyAdapterChange(f.NdisApiInterface, event, &f.diagnostics)
 	f.UpdateAdaptersFilterState()
 }
 
@@ -166,12 +147,7 @@ func (f *QueuedMultiInterfacePacketFilter) FilterNetworkAdapter(name string) {
 func (f *QueuedMultiInterfacePacketFilter) UnfilterNetworkAdapter(name string) {
 	f.Lock()
 	defer f.Unlock()
-	for i, adapter := range f.filterAdapterList {
-		if adapter == name {
-			f.filterAdapterList = append(f.filterAdapterList[:i], f.filterAdapterList[i+1:]...)
-			break
-		}
-	}
+	f.removeFilteredAdapter(name)
 	f.UpdateAdaptersFilterState()
 }
 
@@ -184,14 +160,15 @@ func (f *QueuedMultiInterfacePacketFilter) GetFilteredAdapters() []string {
 
 // Reconfigure updates available network interfaces. Should be called when the filter is inactive.
 func (f *QueuedMultiInterfacePacketFilter) Reconfigure() error {
-	if f.filterState != FilterStateStopped {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateStopped {
 		return errors.New("filter is not stopped")
 	}
 
 	f.networkInterfaces = make([]*N.NetworkAdapter, 0)
-	if err := f.initializeNetworkInterfaces(); err != nil {
-		return err
-	}
+	f.addNetworkInterfaces(f.NdisApiInterface, f.adapters, &f.diagnostics)
 
 	return nil
 }
@@ -199,36 +176,27 @@ func (f *QueuedMultiInterfacePacketFilter) Reconfigure() error {
 // StartFilter starts packet filtering.
 // If no adapter index is provided, all available adapters will be used.
 func (f *QueuedMultiInterfacePacketFilter) StartFilter(filterAdapterIdx ...uint32) error {
-	if f.filterState != FilterStateStopped {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateStopped {
 		return errors.New("filter is not stopped")
 	}
 
-	f.filterState = FilterStateStarting
+	f.filterState.store(FilterStateStarting)
 
-	f.Lock()
-	if len(f.filterAdapterList) == 0 {
-		for i, adapter := range f.networkInterfaces {
-			if len(filterAdapterIdx) > 0 {
-				for _, idx := range filterAdapterIdx {
-					if i == int(idx) {
-						f.filterAdapterList = append(f.filterAdapterList, adapter.InternalName)
-					}
-				}
-			} else {
-				f.filterAdapterList = append(f.filterAdapterList, adapter.InternalName)
-			}
-		}
-	}
-	f.Unlock()
+	f.selectFilteredAdapters(filterAdapterIdx)
 
-	f.filterState = FilterStateRunning
+	f.filterState.store(FilterStateRunning)
 	f.initFilter()
 
 	ctx, cancel := context.WithCancel(context.Background())
+	readCtx, flush := context.WithCancel(ctx)
 	f.cancel = cancel
+	f.flush = flush
 
 	f.wg.Add(4)
-	go f.packetRead(ctx)
+	go f.packetRead(ctx, readCtx)
 	go f.packetProcess(ctx)
 	go f.packetWriteMstcp(ctx)
 	go f.packetWriteAdapter(ctx)
@@ -238,20 +206,52 @@ func (f *QueuedMultiInterfacePacketFilter) StartFilter(filterAdapterIdx ...uint3
 	return nil
 }
 
-// StopFilter stops packet filtering.
+// Close stops packet filtering at once, discarding the packet blocks in the pipeline, and stops
+// following the adapter list changes.
 func (f *QueuedMultiInterfacePacketFilter) Close() error {
-	if f.filterState != FilterStateRunning {
+	err := f.Stop(doneContext)
+
+	// The monitor may be waiting for the filter lock to deliver an event, so it is released unlocked
+	f.releaseMonitor()
+
+	if !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// Stop stops packet filtering. The filtered adapters stop queueing packets, the reader reads the packets
+// left in the driver queue and the pipeline filters and writes all the blocks in flight, unless the context
+// is done first.
+func (f *QueuedMultiInterfacePacketFilter) Stop(ctx context.Context) error {
+	f.Lock()
+	if state := f.filterState.load(); state != FilterStateRunning && state != FilterStatePaused {
+		f.Unlock()
 		return errors.New("filter is not running")
 	}
+	f.filterState.store(FilterStateStopping)
+	f.UpdateAdaptersFilterState()
+	f.flush()
+	f.Unlock()
+
+	// The adapter list changes are applied under the lock meanwhile, so the pipeline is flushed unlocked
+	err := waitFlushed(ctx, &f.wg, f.cancel)
+	f.cancel()
 
-	f.filterState = FilterStateStopping
-	f.adapterEvent.Signal()
 	f.Lock()
+	defer f.Unlock()
+
+	f.stopped()
+
+	return err
+}
+
+// stopped closes the network interfaces, clears the queues and marks the filter stopped once the
+// pipeline has exited. Must be called locked.
+func (f *QueuedMultiInterfacePacketFilter) stopped() {
 	for _, adapter := range f.networkInterfaces {
 		adapter.Close()
 	}
-	f.Unlock()
-	f.cancel()
 	// clear queues
 	{
 		for len(f.packetReadChan) > 0 {
@@ -267,68 +267,99 @@ func (f *QueuedMultiInterfacePacketFilter) Close() error {
 			<-f.packetWriteAdapterChan
 		}
 	}
-	f.wg.Wait()
-	f.filterState = FilterStateStopped
+	f.filterState.store(FilterStateStopped)
+}
+
+// fail stops the filter after its pipeline exited on an error, unless the run was stopped meanwhile.
+// It is called from a goroutine of its own, as the filter waits for the pipeline to stop.
+func (f *QueuedMultiInterfacePacketFilter) fail(run context.Context) {
+	f.Lock()
+	defer f.Unlock()
+
+	if state := f.filterState.load(); run.Err() != nil || (state != FilterStateRunning && state != FilterStatePaused) {
+		return
+	}
+	f.filterState.store(FilterStateStopping)
+	f.UpdateAdaptersFilterState()
+	_ = waitFlushed(doneContext, &f.wg, f.cancel)
+	f.stopped()
+	f.diagnostics.log().Warn("packet pipeline failed, filter stopped")
+}
+
+// Pause passes the traffic of the filtered adapters through unfiltered until Resume. The packet blocks
+// in the pipeline pass unfiltered too, while the blocks and the pipeline are kept.
+func (f *QueuedMultiInterfacePacketFilter) Pause() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateRunning {
+		return errors.New("filter is not running")
+	}
+	f.filterState.store(FilterStatePaused)
+	f.UpdateAdaptersFilterState()
+
 	return nil
 }
 
-// initializeNetworkInterfaces initializes available network interface list.
-func (f *QueuedMultiInterfacePacketFilter) initializeNetworkInterfaces() error {
-	for i := 0; i < int(f.adapters.AdapterCount); i++ {
-		name := string(f.adapters.AdapterNameList[i][:])
-		adapterHandle := f.adapters.AdapterHandle[i]
-		currentAddress := f.adapters.CurrentAddress[i]
-		medium := f.adapters.AdapterMediumList[i]
-		mtu := f.adapters.MTU[i]
-
-		friendlyName := f.ConvertWindows2000AdapterName(name)
-
-		networkAdapter, err := N.NewNetworkAdapter(f.NdisApi, adapterHandle, currentAddress, name, friendlyName, medium, mtu, f.packetEvent.Get())
-		if err != nil {
-			fmt.Println("error creating network adapter", err.Error())
-			continue
-		}
-		f.networkInterfaces = append(f.networkInterfaces, networkAdapter)
+// Resume resumes filtering the traffic of the filtered adapters after Pause.
+func (f *QueuedMultiInterfacePacketFilter) Resume() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStatePaused {
+		return errors.New("filter is not paused")
 	}
+	f.filterState.store(FilterStateRunning)
+	f.UpdateAdaptersFilterState()
 
 	return nil
 }
 
-// packetRead reads packets from the network interface.
-func (q *QueuedMultiInterfacePacketFilter) packetRead(ctx context.Context) {
+// packetRead reads packets from the network interfaces. Once the read context is done, it reads the packets
+// left in the driver queue and closes the process queue, so the following stages write them and exit.
+func (q *QueuedMultiInterfacePacketFilter) packetRead(ctx, readCtx context.Context) {
 	defer q.wg.Done()
+	defer close(q.packetProcessChan)
+
+	flush := false
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		default:
-			if q.filterState != FilterStateRunning {
-				return
-			}
-
-			packetBlock := <-q.packetReadChan
-
+		case packetBlock := <-q.packetReadChan:
 			readRequest := packetBlock.ReadRequest
 
-			for q.filterState == FilterStateRunning {
-				_, err := q.packetEvent.Wait(windows.INFINITE)
-				if err != nil {
-					ctx.Done()
-					return
-				}
-
-				err = q.packetEvent.Reset()
-				if err != nil {
-					ctx.Done()
-					return
+			for {
+				if !flush {
+					if err := q.packetEvent.WaitContext(readCtx, A.WaitInfinite); err != nil {
+						if flush = flushing(ctx, readCtx); !flush {
+							q.diagnostics.readFailed(ctx, A.Handle{}, err)
+							go q.fail(ctx)
+							return
+						}
+					} else if err := q.packetEvent.Reset(); err != nil {
+						q.diagnostics.readFailed(ctx, A.Handle{}, err)
+						go q.fail(ctx)
+						return
+					}
 				}
 
-				if q.ReadPacketsUnsorted(readRequest, uint32(len(readRequest)), &packetBlock.PacketsSuccess) && packetBlock.PacketsSuccess > 0 {
+				err := q.ReadPacketsUnsortedErr(readRequest, uint32(len(readRequest)), &packetBlock.PacketsSuccess)
+				if err == nil && packetBlock.PacketsSuccess > 0 {
 					break
 				}
+				q.diagnostics.readFailed(ctx, A.Handle{}, err)
+				if flush || ctx.Err() != nil {
+					return
+				}
 			}
+			q.telemetry.batch(packetBlock.PacketsSuccess)
 
-			q.packetProcessChan <- packetBlock
+			select {
+			case q.packetProcessChan <- packetBlock:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
 }
@@ -336,51 +367,60 @@ func (q *QueuedMultiInterfacePacketFilter) packetRead(ctx context.Context) {
 // packetProcess processes the read packets.
 func (q *QueuedMultiInterfacePacketFilter) packetProcess(ctx context.Context) {
 	defer q.wg.Done()
+	defer close(q.packetWriteMstcpChan)
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		default:
-			if q.filterState != FilterStateRunning {
+		case packetBlock, ok := <-q.packetProcessChan:
+			if !ok {
 				return
 			}
-
-			packetBlock := <-q.packetProcessChan
+			paused := q.filterState.load() == FilterStatePaused
 
 			for i := 0; i < int(packetBlock.PacketsSuccess); i++ {
 				var adapterHandle *A.Handle
 				packetAction := A.FilterActionPass
-
-				if packetBlock.PacketBuffer[i].DeviceFlags == A.PACKET_FLAG_ON_SEND {
-					if q.filterOutgoingPacket != nil {
-						packetAction, adapterHandle = q.filterOutgoingPacket(packetBlock.PacketBuffer[i].HAdapterQLinkUnion.GetAdapter(), &packetBlock.PacketBuffer[i])
-					}
-				} else {
-					if q.filterIncomingPacket != nil {
-						packetAction, adapterHandle = q.filterIncomingPacket(packetBlock.PacketBuffer[i].HAdapterQLinkUnion.GetAdapter(), &packetBlock.PacketBuffer[i])
+				handle := packetBlock.PacketBuffer[i].HAdapterQLinkUnion.GetAdapter()
+
+				// The packets read before the filter was paused pass unfiltered
+				if !paused {
+					start := time.Now()
+					if q.contexts.has(packetBlock.PacketBuffer[i].DeviceFlags) {
+						packetAction, adapterHandle = q.contexts.filter(q.lookupNetworkInterface(packetBlock.PacketBuffer[i].HAdapterQLinkUnion.GetAdapter()), &packetBlock.PacketBuffer[i])
+					} else if packetBlock.PacketBuffer[i].DeviceFlags == A.PACKET_FLAG_ON_SEND {
+						if q.filterOutgoingPacket != nil {
+							packetAction, adapterHandle = q.filterOutgoingPacket(packetBlock.PacketBuffer[i].HAdapterQLinkUnion.GetAdapter(), &packetBlock.PacketBuffer[i])
+						}
+					} else {
+						if q.filterIncomingPacket != nil {
+							packetAction, adapterHandle = q.filterIncomingPacket(packetBlock.PacketBuffer[i].HAdapterQLinkUnion.GetAdapter(), &packetBlock.PacketBuffer[i])
+						}
 					}
+					q.telemetry.callback(start)
 				}
 
 				if adapterHandle != nil {
 					packetBlock.PacketBuffer[i].HAdapterQLinkUnion.SetAdapter(*adapterHandle)
 				}
 
-				if packetAction == A.FilterActionPass {
-					if packetBlock.PacketBuffer[i].DeviceFlags == A.PACKET_FLAG_ON_SEND {
-						packetBlock.WriteAdapterRequest = append(packetBlock.WriteAdapterRequest, &packetBlock.PacketBuffer[i])
-					} else {
-						packetBlock.WriteMstcpRequest = append(packetBlock.WriteMstcpRequest, &packetBlock.PacketBuffer[i])
-					}
-				} else if packetAction == A.FilterActionRedirect {
-					if packetBlock.PacketBuffer[i].DeviceFlags == A.PACKET_FLAG_ON_RECEIVE {
-						packetBlock.WriteAdapterRequest = append(packetBlock.WriteAdapterRequest, &packetBlock.PacketBuffer[i])
-					} else {
-						packetBlock.WriteMstcpRequest = append(packetBlock.WriteMstcpRequest, &packetBlock.PacketBuffer[i])
-					}
+				toAdapter, toMstcp, listen := routePacket(packetAction, packetBlock.PacketBuffer[i].DeviceFlags)
+				q.telemetry.packet(handle, &packetBlock.PacketBuffer[i], toAdapter, toMstcp)
+				if listen {
+					listenCopy(q.listen, packetBlock.PacketBuffer[i].HAdapterQLinkUnion.GetAdapter(), &packetBlock.PacketBuffer[i])
+				}
+				if toAdapter {
+					packetBlock.WriteAdapterRequest = append(packetBlock.WriteAdapterRequest, &packetBlock.PacketBuffer[i])
+				} else if toMstcp {
+					packetBlock.WriteMstcpRequest = append(packetBlock.WriteMstcpRequest, &packetBlock.PacketBuffer[i])
 				}
 			}
 
-			q.packetWriteMstcpChan <- packetBlock
+			select {
+			case q.packetWriteMstcpChan <- packetBlock:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
 }
@@ -388,24 +428,30 @@ func (q *QueuedMultiInterfacePacketFilter) packetProcess(ctx context.Context) {
 // packetWriteMstcp writes packets to the MSTCP.
 func (q *QueuedMultiInterfacePacketFilter) packetWriteMstcp(ctx context.Context) {
 	defer q.wg.Done()
+	defer close(q.packetWriteAdapterChan)
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		default:
-			if q.filterState != FilterStateRunning {
+		case packetBlock, ok := <-q.packetWriteMstcpChan:
+			if !ok {
 				return
 			}
 
-			packetBlock := <-q.packetWriteMstcpChan
-
 			if len(packetBlock.WriteMstcpRequest) > 0 {
 				var packetsSent uint32
-				q.SendPacketsToMstcpUnsorted(packetBlock.WriteMstcpRequest, uint32(len(packetBlock.WriteMstcpRequest)), &packetsSent)
+				packets := uint32(len(packetBlock.WriteMstcpRequest))
+				err := q.SendPacketsToMstcpUnsortedErr(packetBlock.WriteMstcpRequest, packets, &packetsSent)
+				q.diagnostics.sendFailed(A.Handle{}, true, packets, packetsSent, err)
+				q.telemetry.sent(true, packets, packetsSent, err)
 				packetBlock.WriteMstcpRequest = packetBlock.WriteMstcpRequest[:0]
 			}
 
-			q.packetWriteAdapterChan <- packetBlock
+			select {
+			case q.packetWriteAdapterChan <- packetBlock:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
 }
@@ -417,27 +463,50 @@ func (q *QueuedMultiInterfacePacketFilter) packetWriteAdapter(ctx context.Contex
 		select {
 		case <-ctx.Done():
 			return
-		default:
-			if q.filterState != FilterStateRunning {
+		case packetBlock, ok := <-q.packetWriteAdapterChan:
+			if !ok {
 				return
 			}
 
-			packetBlock := <-q.packetWriteAdapterChan
-
 			if len(packetBlock.WriteAdapterRequest) > 0 {
 				var packetsSent uint32
-				q.SendPacketsToAdaptersUnsorted(packetBlock.WriteAdapterRequest, uint32(len(packetBlock.WriteAdapterRequest)), &packetsSent)
+				packets := uint32(len(packetBlock.WriteAdapterRequest))
+				err := q.SendPacketsToAdaptersUnsortedErr(packetBlock.WriteAdapterRequest, packets, &packetsSent)
+				q.diagnostics.sendFailed(A.Handle{}, false, packets, packetsSent, err)
+				q.telemetry.sent(false, packets, packetsSent, err)
 				packetBlock.WriteAdapterRequest = packetBlock.WriteAdapterRequest[:0]
 			}
 
-			q.packetReadChan <- packetBlock
+			select {
+			case q.packetReadChan <- packetBlock:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
 }
 
 // GetFilterState returns the current filter state.
 func (f *QueuedMultiInterfacePacketFilter) GetFilterState() FilterState {
-	return f.filterState
+	return f.filterState.load()
+}
+
+// Stats returns a snapshot of the packet counters of the filter and the packet blocks waiting in its queues.
+// The read queue holds the blocks free to read the packets into.
+func (f *QueuedMultiInterfacePacketFilter) Stats() PipelineStats {
+	f.Lock()
+	defer f.Unlock()
+
+	stats := f.telemetry.snapshot(f.NdisApiInterface, f.networkInterfaces)
+	queue := func(name string, queue chan *UnsortedPacketBlock) {
+		stats.Queues = append(stats.Queues, QueueStats{Name: name, Length: len(queue), Capacity: cap(queue)})
+	}
+	queue("read", f.packetReadChan)
+	queue("process", f.packetProcessChan)
+	queue("write-mstcp", f.packetWriteMstcpChan)
+	queue("write-adapter", f.packetWriteAdapterChan)
+
+	return stats
 }
 
 // GetInterfaceList retrieves the list of all network interfaces available for packet filtering.
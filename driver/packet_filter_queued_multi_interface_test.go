@@ -0,0 +1,37 @@
+package driver_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	D "github.com/wiresock/ndisapi-go/driver"
+	N "github.com/wiresock/ndisapi-go/netlib"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+func TestQueuedMultiInterfacePacketFilter_CloseReleasesMonitor(t *testing.T) {
+	api := sim.NewDriver()
+	api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+	adapters, err := api.GetTcpipBoundAdaptersInfo()
+	require.NoError(t, err)
+
+	// The context of the filter is never done, Close releases the monitor
+	filter, err := D.NewQueuedMultiInterfacePacketFilter(context.Background(), api, adapters, nil, nil)
+	require.NoError(t, err)
+	require.NoError(t, filter.StartFilter())
+
+	shared, err := N.NewAdapterMonitor(api)
+	require.NoError(t, err)
+	shared.Close()
+
+	assert.NoError(t, filter.Close())
+
+	monitor, err := N.NewAdapterMonitor(api)
+	require.NoError(t, err)
+	defer monitor.Close()
+	assert.NotSame(t, shared, monitor)
+}
@@ -1,5 +1,3 @@
-//go:build windows
-
 package driver
 
 import (
@@ -7,10 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 	"unsafe"
 
-	"golang.org/x/sys/windows"
-
 	A "github.com/wiresock/ndisapi-go"
 	N "github.com/wiresock/ndisapi-go/netlib"
 )
@@ -21,19 +18,28 @@ var _ PacketFilter = (*SimplePacketFilter)(nil)
 var _ SingleInterfacePacketFilter = (*SimplePacketFilter)(nil)
 
 type SimplePacketFilter struct {
-	*A.NdisApi
+	A.NdisApiInterface
+	sync.Mutex
 	ctx context.Context
 
 	adapters *A.TcpAdapterList
 
 	filterIncomingPacket func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction
 	filterOutgoingPacket func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction
-	filterState          FilterState
+	listen               ListenFunc
+	contexts             contextFilters
+	diagnostics          diagnostics
+	telemetry            *telemetry
+	filterState          atomicFilterState
+	paused               bool
 	networkInterfaces    []*N.NetworkAdapter
 	adapter              int
+	adapterName          string
+	follower             *adapterFollower
 
 	wg     sync.WaitGroup
 	cancel context.CancelFunc
+	flush  context.CancelFunc
 
 	packetBuffer []A.IntermediateBuffer
 
@@ -42,15 +48,15 @@ type SimplePacketFilter struct {
 	writeMstcpRequest   *MultiRequestBuffer
 }
 
-func NewSimplePacketFilter(ctx context.Context, api *A.NdisApi, adapters *A.TcpAdapterList, in, out func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction) (*SimplePacketFilter, error) {
+func NewSimplePacketFilter(ctx context.Context, api A.NdisApiInterface, adapters *A.TcpAdapterList, in, out func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction) (*SimplePacketFilter, error) {
 	filter := &SimplePacketFilter{
-		ctx:      ctx,
-		NdisApi:  api,
-		adapters: adapters,
+		ctx:              ctx,
+		NdisApiInterface: api,
+		adapters:         adapters,
 
 		filterIncomingPacket: in,
 		filterOutgoingPacket: out,
-		filterState:          FilterStateStopped,
+		telemetry:            newTelemetry(),
 	}
 
 	err := filter.initializeNetworkInterfaces()
@@ -61,6 +67,35 @@ func NewSimplePacketFilter(ctx context.Context, api *A.NdisApi, adapters *A.TcpA
 	return filter, nil
 }
 
+// SetListener sets the consumer of the packets given the FilterActionPassRedirect or
+// FilterActionDropRedirect verdict. It should be called before the filter is started.
+func (f *SimplePacketFilter) SetListener(listen ListenFunc) {
+	f.listen = listen
+}
+
+// SetContextFilter sets PacketContext based callbacks for the incoming and outgoing packets.
+// A callback set for a direction takes precedence over the one passed to the constructor.
+// It should be called before the filter is started.
+func (f *SimplePacketFilter) SetContextFilter(in, out ContextFilterFunc) {
+	f.contexts.in = in
+	f.contexts.out = out
+}
+
+// SetLogger sets the logger receiving the diagnostics of the filter, nil discards them.
+// It should be called before the filter is started.
+func (f *SimplePacketFilter) SetLogger(logger A.Logger) {
+	f.diagnostics.logger = logger
+	for _, adapter := range f.networkInterfaces {
+		adapter.Logger = logger
+	}
+}
+
+// SetErrorHandler sets the consumer of the errors of the working threads, e.g. the
+// PacketSendError and PacketReadError. It should be called before the filter is started.
+func (f *SimplePacketFilter) SetErrorHandler(handler ErrorFunc) {
+	f.diagnostics.errors = handler
+}
+
 func (f *SimplePacketFilter) initFilter() error {
 	f.packetBuffer = make([]A.IntermediateBuffer, A.MaximumPacketBlock)
 
@@ -73,6 +108,7 @@ func (f *SimplePacketFilter) initFilter() error {
 	writeMstcpRequest := (*A.EtherMultiRequest)(unsafe.Pointer(f.writeMstcpRequest))
 
 	adapterHandle := f.networkInterfaces[f.adapter].GetAdapter()
+	f.telemetry.adapter(adapterHandle)
 	readRequest.AdapterHandle = adapterHandle
 	writeAdapterRequest.AdapterHandle = adapterHandle
 	writeMstcpRequest.AdapterHandle = adapterHandle
@@ -94,7 +130,7 @@ func (f *SimplePacketFilter) initFilter() error {
 	}
 
 	// Set adapter mode
-	err := f.networkInterfaces[f.adapter].SetMode(A.MSTCP_FLAG_SENT_TUNNEL | A.MSTCP_FLAG_RECV_TUNNEL)
+	err := f.networkInterfaces[f.adapter].SetMode(f.mode())
 	if err != nil {
 		return err
 	}
@@ -102,9 +138,17 @@ func (f *SimplePacketFilter) initFilter() error {
 	return nil
 }
 
+// mode returns the adapter mode of the filter, which intercepts no packets while paused.
+func (f *SimplePacketFilter) mode() uint32 {
+	if f.paused {
+		return 0
+	}
+	return A.MSTCP_FLAG_SENT_TUNNEL | A.MSTCP_FLAG_RECV_TUNNEL
+}
+
 func (f *SimplePacketFilter) initializeNetworkInterfaces() error {
 	for i := 0; i < int(f.adapters.AdapterCount); i++ {
-		name := string(f.adapters.AdapterNameList[i][:])
+		name := f.adapters.AdapterName(i)
 		adapterHandle := f.adapters.AdapterHandle[i]
 		currentAddress := f.adapters.CurrentAddress[i]
 		medium := f.adapters.AdapterMediumList[i]
@@ -112,11 +156,12 @@ func (f *SimplePacketFilter) initializeNetworkInterfaces() error {
 
 		friendlyName := f.ConvertWindows2000AdapterName(name)
 
-		networkAdapter, err := N.NewNetworkAdapter(f.NdisApi, adapterHandle, currentAddress, name, friendlyName, medium, mtu, nil)
+		networkAdapter, err := N.NewNetworkAdapter(f.NdisApiInterface, adapterHandle, currentAddress, name, friendlyName, medium, mtu, nil)
 		if err != nil {
-			fmt.Println("error creating network adapter", err.Error())
+			f.diagnostics.log().Error("error creating network adapter", "adapter", name, "error", err)
 			continue
 		}
+		networkAdapter.Logger = f.diagnostics.logger
 		f.networkInterfaces = append(f.networkInterfaces, networkAdapter)
 	}
 
@@ -124,7 +169,10 @@ func (f *SimplePacketFilter) initializeNetworkInterfaces() error {
 }
 
 func (f *SimplePacketFilter) Reconfigure() error {
-	if f.filterState != FilterStateStopped {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateStopped {
 		return errors.New("filter is not stopped")
 	}
 
@@ -136,122 +184,314 @@ func (f *SimplePacketFilter) Reconfigure() error {
 	return nil
 }
 
+// StartFilter starts filtering the adapter. The filter follows the adapter by its name: it is
+// suspended while the adapter is removed and resumes when the adapter arrives again.
 func (f *SimplePacketFilter) StartFilter(adapterIdx int) error {
-	if f.filterState != FilterStateStopped {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateStopped {
 		return errors.New("filter is not stopped")
 	}
+	if adapterIdx < 0 || adapterIdx >= len(f.networkInterfaces) {
+		return fmt.Errorf("adapter index %d is out of range", adapterIdx)
+	}
 
-	f.filterState = FilterStateStarting
 	f.adapter = adapterIdx
+	f.adapterName = f.networkInterfaces[adapterIdx].InternalName
+
+	if err := f.start(); err != nil {
+		f.filterState.store(FilterStateStopped)
+		return err
+	}
+
+	follower, err := followAdapter(f.NdisApiInterface, f.adapterName, f.suspend, f.resume)
+	if err != nil {
+		_ = f.stop(doneContext)
+		f.filterState.store(FilterStateStopped)
+		return err
+	}
+	f.follower = follower
+
+	return nil
+}
+
+// Close stops filtering at once, discarding the packets read but not written yet.
+func (f *SimplePacketFilter) Close() error {
+	if err := f.Stop(doneContext); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// Stop stops filtering. The adapter stops queueing packets and the working thread filters and writes
+// the packets it has read and those left in the driver queue, unless the context is done first.
+func (f *SimplePacketFilter) Stop(ctx context.Context) error {
+	var err error
+
+	f.Lock()
+	switch f.filterState.load() {
+	case FilterStateRunning, FilterStatePaused:
+		err = f.stop(ctx)
+	case FilterStateSuspended:
+	default:
+		f.Unlock()
+		return errors.New("filter is not running")
+	}
+	follower := f.stopped()
+	f.Unlock()
+
+	follower.Close()
+	return err
+}
+
+// Pause passes the traffic of the adapter through unfiltered until Resume. The packets read before
+// pass unfiltered too, while the buffers and the working thread are kept.
+func (f *SimplePacketFilter) Pause() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateRunning {
+		return errors.New("filter is not running")
+	}
+	f.paused = true
+	if err := f.networkInterfaces[f.adapter].SetMode(f.mode()); err != nil {
+		f.paused = false
+		return err
+	}
+	f.filterState.store(FilterStatePaused)
+
+	return nil
+}
+
+// Resume resumes filtering the traffic of the adapter after Pause.
+func (f *SimplePacketFilter) Resume() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStatePaused {
+		return errors.New("filter is not paused")
+	}
+	f.paused = false
+	if err := f.networkInterfaces[f.adapter].SetMode(f.mode()); err != nil {
+		f.paused = true
+		return err
+	}
+	f.filterState.store(FilterStateRunning)
+
+	return nil
+}
+
+// start initializes the filter for the current adapter and starts the working thread. Must be called locked.
+func (f *SimplePacketFilter) start() error {
+	f.filterState.store(FilterStateStarting)
 
 	if err := f.initFilter(); err != nil {
 		return err
 	}
 
 	ctx, cancel := context.WithCancel(f.ctx)
+	readCtx, flush := context.WithCancel(ctx)
 	f.cancel = cancel
+	f.flush = flush
+
+	if f.paused {
+		f.filterState.store(FilterStatePaused)
+	} else {
+		f.filterState.store(FilterStateRunning)
+	}
 
 	// Start the working thread
 	f.wg.Add(1)
-	go f.filterWorkingThread(ctx)
+	go f.filterWorkingThread(ctx, readCtx)
 
 	return nil
 }
 
-func (f *SimplePacketFilter) Close() error {
-	if f.filterState != FilterStateRunning {
-		return errors.New("filter is not running")
+// stop stops the working thread and restores the adapter mode. The packets left in the driver queue
+// are filtered before, unless the context is done first. Must be called locked.
+func (f *SimplePacketFilter) stop(ctx context.Context) error {
+	f.filterState.store(FilterStateStopping)
+	_ = f.networkInterfaces[f.adapter].SetMode(0)
+	f.flush()
+	err := waitFlushed(ctx, &f.wg, f.cancel)
+	f.cancel()
+	f.networkInterfaces[f.adapter].Close()
+	return err
+}
+
+// suspend stops filtering the removed adapter until it arrives again.
+func (f *SimplePacketFilter) suspend() {
+	f.Lock()
+	defer f.Unlock()
+
+	switch f.filterState.load() {
+	case FilterStateRunning, FilterStatePaused, FilterStateStarting:
+	default:
+		return
 	}
+	_ = f.stop(doneContext)
+	f.filterState.store(FilterStateSuspended)
+	f.diagnostics.log().Info("adapter removed, filter suspended", "adapter", f.adapterName)
+}
 
-	f.filterState = FilterStateStopping
-	f.networkInterfaces[f.adapter].Close()
-	f.cancel()
-	f.wg.Wait()
-	f.filterState = FilterStateStopped
-	return nil
+// stopped marks the filter stopped and detaches the adapter follower, to be closed unlocked. Must be called locked.
+func (f *SimplePacketFilter) stopped() *adapterFollower {
+	f.filterState.store(FilterStateStopped)
+	f.paused = false
+	follower := f.follower
+	f.follower = nil
+	return follower
+}
+
+// fail stops the filter after its working thread exited on an error, unless the run was stopped or
+// suspended meanwhile. It is called from a goroutine of its own, as stop waits for the working thread.
+func (f *SimplePacketFilter) fail(run context.Context) {
+	f.Lock()
+	if run.Err() != nil {
+		f.Unlock()
+		return
+	}
+	_ = f.stop(doneContext)
+	follower := f.stopped()
+	f.Unlock()
+
+	follower.Close()
+	f.diagnostics.log().Warn("working thread failed, filter stopped", "adapter", f.adapterName)
+}
+
+// resume restarts filtering the adapter arrived again, wherever it is in the adapter list.
+func (f *SimplePacketFilter) resume() {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.filterState.load() != FilterStateSuspended {
+		return
+	}
+
+	adapters, err := f.GetTcpipBoundAdaptersInfo()
+	if err != nil {
+		f.diagnostics.log().Warn("failed to query the adapters to resume the filter", "adapter", f.adapterName, "error", err)
+		return
+	}
+	f.adapters = adapters
+	f.networkInterfaces = nil
+	if err := f.initializeNetworkInterfaces(); err != nil {
+		return
+	}
+
+	if f.adapter = indexOfAdapter(f.networkInterfaces, f.adapterName); f.adapter < 0 {
+		f.adapter = 0
+		return
+	}
+	if err := f.start(); err != nil {
+		f.filterState.store(FilterStateSuspended)
+		f.diagnostics.log().Warn("failed to resume the filter", "adapter", f.adapterName, "error", err)
+		return
+	}
+	f.diagnostics.log().Info("adapter arrived, filter resumed", "adapter", f.adapterName)
 }
 
-func (f *SimplePacketFilter) filterWorkingThread(ctx context.Context) {
+// filterWorkingThread filters the packets of the adapter until the filter is stopped. Once the read
+// context is done, it filters the packets left in the driver queue and exits.
+func (f *SimplePacketFilter) filterWorkingThread(ctx, readCtx context.Context) {
 	defer f.wg.Done()
 
-	f.filterState = FilterStateRunning
+	adapter := f.networkInterfaces[f.adapter]
+	readRequest := (*A.EtherMultiRequest)(unsafe.Pointer(f.readRequest))
+	writeAdapterRequest := (*A.EtherMultiRequest)(unsafe.Pointer(f.writeAdapterRequest))
+	writeMstcpRequest := (*A.EtherMultiRequest)(unsafe.Pointer(f.writeMstcpRequest))
 
-	for {
-		select {
-		case <-ctx.Done():
+	for ctx.Err() == nil {
+		flush := false
+		if err := adapter.WaitEvent(readCtx, A.WaitInfinite); err != nil {
+			if flush = flushing(ctx, readCtx); !flush {
+				f.diagnostics.readFailed(ctx, adapter.GetAdapter(), err)
+				go f.fail(ctx)
+				return
+			}
+		} else if err := adapter.ResetEvent(); err != nil {
+			f.diagnostics.readFailed(ctx, adapter.GetAdapter(), err)
+			go f.fail(ctx)
 			return
-		default:
-			readRequest := (*A.EtherMultiRequest)(unsafe.Pointer(f.readRequest))
-			writeAdapterRequest := (*A.EtherMultiRequest)(unsafe.Pointer(f.writeAdapterRequest))
-			writeMstcpRequest := (*A.EtherMultiRequest)(unsafe.Pointer(f.writeMstcpRequest))
-
-			for f.filterState == FilterStateRunning {
-				_, err := f.networkInterfaces[f.adapter].WaitEvent(windows.INFINITE)
-				if err != nil {
-					ctx.Done()
-					return
-				}
+		}
 
-				err = f.networkInterfaces[f.adapter].ResetEvent()
-				if err != nil {
-					ctx.Done()
-					return
-				}
+		for ctx.Err() == nil {
+			if err := f.ReadPacketsErr(readRequest); err != nil {
+				f.diagnostics.readFailed(ctx, readRequest.AdapterHandle, err)
+				break
+			}
 
-				for f.filterState == FilterStateRunning {
-					if f.ReadPackets(readRequest) {
-						break
-					}
+			f.telemetry.batch(readRequest.PacketsSuccess)
+			paused := f.filterState.load() == FilterStatePaused
 
-					for i := uint32(0); i < readRequest.PacketsSuccess; i++ {
-						packetAction := A.FilterActionPass
-
-						if f.packetBuffer[i].DeviceFlags == A.PACKET_FLAG_ON_SEND {
-							if f.filterOutgoingPacket != nil {
-								packetAction = f.filterOutgoingPacket(readRequest.AdapterHandle, &f.packetBuffer[i])
-							}
-						} else {
-							if f.filterIncomingPacket != nil {
-								packetAction = f.filterIncomingPacket(readRequest.AdapterHandle, &f.packetBuffer[i])
-							}
-						}
+			for i := uint32(0); i < readRequest.PacketsSuccess; i++ {
+				packetAction := A.FilterActionPass
 
-						if packetAction == A.FilterActionPass {
-							if f.packetBuffer[i].DeviceFlags == A.PACKET_FLAG_ON_SEND {
-								writeAdapterRequest.EthernetPackets[writeAdapterRequest.PacketsNumber].Buffer = &f.packetBuffer[i]
-								writeAdapterRequest.PacketsNumber++
-							} else {
-								writeMstcpRequest.EthernetPackets[writeMstcpRequest.PacketsNumber].Buffer = &f.packetBuffer[i]
-								writeMstcpRequest.PacketsNumber++
-							}
-						} else if packetAction == A.FilterActionRedirect {
-							if f.packetBuffer[i].DeviceFlags == A.PACKET_FLAG_ON_RECEIVE {
-								writeAdapterRequest.EthernetPackets[writeAdapterRequest.PacketsNumber].Buffer = &f.packetBuffer[i]
-								writeAdapterRequest.PacketsNumber++
-							} else {
-								writeMstcpRequest.EthernetPackets[writeMstcpRequest.PacketsNumber].Buffer = &f.packetBuffer[i]
-								writeMstcpRequest.PacketsNumber++
-							}
+				// The packets read before the filter was paused pass unfiltered
+				if !paused {
+					start := time.Now()
+					if f.contexts.has(f.packetBuffer[i].DeviceFlags) {
+						packetAction, _ = f.contexts.filter(adapter, &f.packetBuffer[i])
+					} else if f.packetBuffer[i].DeviceFlags == A.PACKET_FLAG_ON_SEND {
+						if f.filterOutgoingPacket != nil {
+							packetAction = f.filterOutgoingPacket(readRequest.AdapterHandle, &f.packetBuffer[i])
+						}
+					} else {
+						if f.filterIncomingPacket != nil {
+							packetAction = f.filterIncomingPacket(readRequest.AdapterHandle, &f.packetBuffer[i])
 						}
 					}
+					f.telemetry.callback(start)
+				}
 
-					if writeAdapterRequest.PacketsNumber > 0 {
-						_ = f.SendPacketsToAdapter(writeAdapterRequest)
-						writeAdapterRequest.PacketsNumber = 0
-					}
+				toAdapter, toMstcp, listen := routePacket(packetAction, f.packetBuffer[i].DeviceFlags)
+				f.telemetry.packet(readRequest.AdapterHandle, &f.packetBuffer[i], toAdapter, toMstcp)
+				if listen {
+					listenCopy(f.listen, readRequest.AdapterHandle, &f.packetBuffer[i])
+				}
+				if toAdapter {
+					writeAdapterRequest.EthernetPackets[writeAdapterRequest.PacketsNumber].Buffer = &f.packetBuffer[i]
+					writeAdapterRequest.PacketsNumber++
+				} else if toMstcp {
+					writeMstcpRequest.EthernetPackets[writeMstcpRequest.PacketsNumber].Buffer = &f.packetBuffer[i]
+					writeMstcpRequest.PacketsNumber++
+				}
+			}
 
-					if writeMstcpRequest.PacketsNumber > 0 {
-						_ = f.SendPacketsToMstcp(writeMstcpRequest)
-						writeMstcpRequest.PacketsNumber = 0
-					}
+			if writeAdapterRequest.PacketsNumber > 0 {
+				if err := f.SendPacketsToAdapter(writeAdapterRequest); err != nil {
+					f.diagnostics.sendFailed(writeAdapterRequest.AdapterHandle, false, writeAdapterRequest.PacketsNumber, 0, err)
+					f.telemetry.sent(false, writeAdapterRequest.PacketsNumber, 0, err)
+				}
+				writeAdapterRequest.PacketsNumber = 0
+			}
 
-					readRequest.PacketsSuccess = 0
+			if writeMstcpRequest.PacketsNumber > 0 {
+				if err := f.SendPacketsToMstcp(writeMstcpRequest); err != nil {
+					f.diagnostics.sendFailed(writeMstcpRequest.AdapterHandle, true, writeMstcpRequest.PacketsNumber, 0, err)
+					f.telemetry.sent(true, writeMstcpRequest.PacketsNumber, 0, err)
 				}
+				writeMstcpRequest.PacketsNumber = 0
 			}
+
+			readRequest.PacketsSuccess = 0
+		}
+
+		if flush {
+			return
 		}
 	}
 }
 
 func (f *SimplePacketFilter) GetFilterState() FilterState {
-	return f.filterState
+	return f.filterState.load()
+}
+
+// Stats returns a snapshot of the packet counters of the filter.
+func (f *SimplePacketFilter) Stats() PipelineStats {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.telemetry.snapshot(f.NdisApiInterface, f.networkInterfaces)
 }
@@ -0,0 +1,82 @@
+package driver_test
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	D "github.com/wiresock/ndisapi-go/driver"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+// udpFrame builds an Ethernet/IPv4/UDP frame addressed to the destination port.
+func udpFrame(dstPort uint16) []byte {
+	frame := make([]byte, 14+20+8)
+	binary.BigEndian.PutUint16(frame[12:14], 0x0800)
+
+	ip := frame[14:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], 28)
+	ip[8] = 64
+	ip[9] = 17
+	copy(ip[12:16], []byte{192, 168, 1, 2})
+	copy(ip[16:20], []byte{192, 168, 1, 1})
+
+	udp := ip[20:]
+	binary.BigEndian.PutUint16(udp[0:2], 40000)
+	binary.BigEndian.PutUint16(udp[2:4], dstPort)
+	binary.BigEndian.PutUint16(udp[4:6], 8)
+
+	return frame
+}
+
+// dstPort extracts the UDP destination port of a frame built by udpFrame.
+func dstPort(buffer *A.IntermediateBuffer) uint16 {
+	return binary.BigEndian.Uint16(buffer.Buffer[14+20+2:])
+}
+
+func TestSimplePacketFilter_Simulated(t *testing.T) {
+	api := sim.NewDriver()
+	handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+	adapters, err := api.GetTcpipBoundAdaptersInfo()
+	require.NoError(t, err)
+
+	drop := func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction {
+		if dstPort(buffer) == 23 {
+			return A.FilterActionDrop
+		}
+		return A.FilterActionPass
+	}
+
+	filter, err := D.NewSimplePacketFilter(context.Background(), api, adapters, drop, drop)
+	require.NoError(t, err)
+	require.NoError(t, filter.StartFilter(0))
+	require.Eventually(t, func() bool {
+		return filter.GetFilterState() == D.FilterStateRunning
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(23)))
+	require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(53)))
+	require.NoError(t, api.SendFromStack(handle, udpFrame(123)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, api.WaitDelivered(ctx, 2))
+
+	require.NoError(t, filter.Close())
+	assert.Equal(t, D.FilterStateStopped, filter.GetFilterState())
+
+	stack := api.StackPackets(handle)
+	require.Len(t, stack, 1)
+	assert.Equal(t, uint16(53), binary.BigEndian.Uint16(stack[0].Data[14+20+2:]))
+
+	wire := api.WirePackets(handle)
+	require.Len(t, wire, 1)
+	assert.Equal(t, uint16(123), binary.BigEndian.Uint16(wire[0].Data[14+20+2:]))
+}
@@ -0,0 +1,329 @@
+package driver
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	A "github.com/wiresock/ndisapi-go"
+	N "github.com/wiresock/ndisapi-go/netlib"
+)
+
+// PipelineStats is a snapshot of the counters of a packet filter since it was created.
+type PipelineStats struct {
+	Time time.Time `json:"time"`
+	// In and Out sum the counters of the adapters for the packets received from the network
+	// and the packets sent by the protocol stack.
+	In       PacketCounters          `json:"in"`
+	Out      PacketCounters          `json:"out"`
+	Adapters []AdapterPacketCounters `json:"adapters"`
+	// MstcpSendFailures and AdapterSendFailures count the packets the driver failed to pass
+	// to the protocol stack and to the network.
+	MstcpSendFailures   uint64 `json:"mstcpSendFailures"`
+	AdapterSendFailures uint64 `json:"adapterSendFailures"`
+	// ReadBatches counts the reads returning packets by the number of packets they returned.
+	ReadBatches []HistogramBucket `json:"readBatches"`
+	// Queues holds the queues between the stages of the queued filters, nil for the other filters.
+	Queues []QueueStats `json:"queues,omitempty"`
+	// CallbackLatency is the time the filter callbacks took to give their verdicts.
+	CallbackLatency LatencyStats `json:"callbackLatency"`
+}
+
+// PacketCounters holds the packets and the bytes read by a packet filter and what their verdicts
+// did with them: passed in their direction, dropped, or redirected in the opposite direction.
+// The packets handed to the listener are counted by where else they went, if anywhere.
+type PacketCounters struct {
+	ReadPackets       uint64 `json:"readPackets"`
+	ReadBytes         uint64 `json:"readBytes"`
+	PassedPackets     uint64 `json:"passedPackets"`
+	PassedBytes       uint64 `json:"passedBytes"`
+	DroppedPackets    uint64 `json:"droppedPackets"`
+	DroppedBytes      uint64 `json:"droppedBytes"`
+	RedirectedPackets uint64 `json:"redirectedPackets"`
+	RedirectedBytes   uint64 `json:"redirectedBytes"`
+}
+
+// add adds the counters of another adapter or direction.
+func (c *PacketCounters) add(other *PacketCounters) {
+	c.ReadPackets += other.ReadPackets
+	c.ReadBytes += other.ReadBytes
+	c.PassedPackets += other.PassedPackets
+	c.PassedBytes += other.PassedBytes
+	c.DroppedPackets += other.DroppedPackets
+	c.DroppedBytes += other.DroppedBytes
+	c.RedirectedPackets += other.RedirectedPackets
+	c.RedirectedBytes += other.RedirectedBytes
+}
+
+// AdapterPacketCounters holds the counters of the packets intercepted on an adapter.
+type AdapterPacketCounters struct {
+	Handle A.Handle `json:"-"`
+	// Name is the internal name of the adapter, empty once the adapter is gone.
+	Name string         `json:"name"`
+	In   PacketCounters `json:"in"`
+	Out  PacketCounters `json:"out"`
+	// DriverQueueSize is the number of packets the driver held in the adapter queue when the snapshot
+	// was taken, as reported by GetAdapterPacketQueueSize, zero once the adapter is gone.
+	DriverQueueSize uint32 `json:"driverQueueSize"`
+}
+
+// HistogramBucket counts the values up to UpperBound and above the UpperBound of the previous bucket.
+// The UpperBound of the last bucket is math.MaxUint32.
+type HistogramBucket struct {
+	UpperBound uint32 `json:"upperBound"`
+	Count      uint64 `json:"count"`
+}
+
+// QueueStats holds the number of packet blocks waiting in a queue of the pipeline.
+type QueueStats struct {
+	Name     string `json:"name"`
+	Length   int    `json:"length"`
+	Capacity int    `json:"capacity"`
+}
+
+// LatencyStats holds the percentiles of the latencies, which are estimated within 1/8 of their value.
+type LatencyStats struct {
+	Count uint64        `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+const (
+	// batchBuckets bound the read batches by the powers of two from 1 to 32768 packets.
+	batchBuckets = 16
+	// latencyBuckets hold the latencies below 8ns and, for the powers of two up to 2^41ns,
+	// 8 linear sub-buckets each.
+	latencyBuckets = 8 + 38*8
+)
+
+// telemetry holds the counters of a packet filter. They are updated by the working threads
+// with atomic operations, so they are cheap to keep and read at any time.
+type telemetry struct {
+	batches           [batchBuckets]uint64
+	latencies         [latencyBuckets]uint64
+	maxLatency        uint64
+	mstcpSendFailed   uint64
+	adapterSendFailed uint64
+
+	mu       sync.Mutex
+	adapters atomic.Value // map[A.Handle]*adapterCounters, copied on write
+}
+
+// adapterCounters holds the counters of an adapter, in the field order of PacketCounters,
+// for the incoming and the outgoing packets.
+type adapterCounters struct {
+	directions [2][8]uint64
+}
+
+// newTelemetry creates the counters of a packet filter. They are allocated separately
+// so the 64-bit counters are aligned for the atomic operations on 32-bit platforms.
+func newTelemetry() *telemetry {
+	t := &telemetry{}
+	t.adapters.Store(map[A.Handle]*adapterCounters{})
+	return t
+}
+
+// adapter returns the counters of the adapter, creating them on first use.
+func (t *telemetry) adapter(handle A.Handle) *adapterCounters {
+	if counters, ok := t.adapters.Load().(map[A.Handle]*adapterCounters)[handle]; ok {
+		return counters
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	adapters := t.adapters.Load().(map[A.Handle]*adapterCounters)
+	if counters, ok := adapters[handle]; ok {
+		return counters
+	}
+	updated := make(map[A.Handle]*adapterCounters, len(adapters)+1)
+	for key, value := range adapters {
+		updated[key] = value
+	}
+	counters := &adapterCounters{}
+	updated[handle] = counters
+	t.adapters.Store(updated)
+
+	return counters
+}
+
+// batch counts a read returning the packets.
+func (t *telemetry) batch(packets uint32) {
+	if packets == 0 {
+		return
+	}
+	bucket := bits.Len32(packets - 1)
+	if bucket >= batchBuckets {
+		bucket = batchBuckets - 1
+	}
+	atomic.AddUint64(&t.batches[bucket], 1)
+}
+
+// callback counts the latency of a filter callback started at the time.
+func (t *telemetry) callback(start time.Time) {
+	latency := uint64(time.Since(start))
+	atomic.AddUint64(&t.latencies[latencyBucket(latency)], 1)
+	for {
+		max := atomic.LoadUint64(&t.maxLatency)
+		if latency <= max || atomic.CompareAndSwapUint64(&t.maxLatency, max, latency) {
+			return
+		}
+	}
+}
+
+// packet counts a packet intercepted on the adapter with the destinations its verdict resolved to.
+func (t *telemetry) packet(handle A.Handle, buffer *A.IntermediateBuffer, toAdapter, toMstcp bool) {
+	outgoing := buffer.DeviceFlags == A.PACKET_FLAG_ON_SEND
+	direction := 0
+	if outgoing {
+		direction = 1
+	}
+	counters := &t.adapter(handle).directions[direction]
+	length := uint64(buffer.Length)
+
+	atomic.AddUint64(&counters[0], 1)
+	atomic.AddUint64(&counters[1], length)
+	switch {
+	case !toAdapter && !toMstcp:
+		atomic.AddUint64(&counters[4], 1)
+		atomic.AddUint64(&counters[5], length)
+	case toAdapter == outgoing:
+		atomic.AddUint64(&counters[2], 1)
+		atomic.AddUint64(&counters[3], length)
+	default:
+		atomic.AddUint64(&counters[6], 1)
+		atomic.AddUint64(&counters[7], length)
+	}
+}
+
+// sent counts the packets a send to the protocol stack or to the network failed to pass.
+func (t *telemetry) sent(toMstcp bool, packets, sent uint32, err error) {
+	if err == nil && sent >= packets {
+		return
+	}
+	failed := uint64(packets)
+	if sent < packets {
+		failed = uint64(packets - sent)
+	}
+	if toMstcp {
+		atomic.AddUint64(&t.mstcpSendFailed, failed)
+	} else {
+		atomic.AddUint64(&t.adapterSendFailed, failed)
+	}
+}
+
+// snapshot returns the counters. The adapters are named after the network interfaces, whose
+// driver queues are queried, and the filtered ones are reported even before their first packet.
+func (t *telemetry) snapshot(api A.NdisApiInterface, interfaces []*N.NetworkAdapter) PipelineStats {
+	stats := PipelineStats{
+		Time:                time.Now(),
+		MstcpSendFailures:   atomic.LoadUint64(&t.mstcpSendFailed),
+		AdapterSendFailures: atomic.LoadUint64(&t.adapterSendFailed),
+		ReadBatches:         make([]HistogramBucket, batchBuckets),
+	}
+
+	for i := range stats.ReadBatches {
+		stats.ReadBatches[i] = HistogramBucket{UpperBound: 1 << uint(i), Count: atomic.LoadUint64(&t.batches[i])}
+	}
+	stats.ReadBatches[batchBuckets-1].UpperBound = math.MaxUint32
+
+	for handle, counters := range t.adapters.Load().(map[A.Handle]*adapterCounters) {
+		adapter := AdapterPacketCounters{
+			Handle: handle,
+			In:     counters.load(0),
+			Out:    counters.load(1),
+		}
+		for _, networkInterface := range interfaces {
+			if networkInterface.GetAdapter() == handle {
+				adapter.Name = networkInterface.InternalName
+				_ = api.GetAdapterPacketQueueSize(handle, &adapter.DriverQueueSize)
+				break
+			}
+		}
+		stats.In.add(&adapter.In)
+		stats.Out.add(&adapter.Out)
+		stats.Adapters = append(stats.Adapters, adapter)
+	}
+	sort.Slice(stats.Adapters, func(i, j int) bool {
+		return stats.Adapters[i].Name < stats.Adapters[j].Name
+	})
+
+	stats.CallbackLatency = t.latency()
+
+	return stats
+}
+
+// load returns the counters of the direction.
+func (c *adapterCounters) load(direction int) PacketCounters {
+	counters := &c.directions[direction]
+	return PacketCounters{
+		ReadPackets:       atomic.LoadUint64(&counters[0]),
+		ReadBytes:         atomic.LoadUint64(&counters[1]),
+		PassedPackets:     atomic.LoadUint64(&counters[2]),
+		PassedBytes:       atomic.LoadUint64(&counters[3]),
+		DroppedPackets:    atomic.LoadUint64(&counters[4]),
+		DroppedBytes:      atomic.LoadUint64(&counters[5]),
+		RedirectedPackets: atomic.LoadUint64(&counters[6]),
+		RedirectedBytes:   atomic.LoadUint64(&counters[7]),
+	}
+}
+
+// latency returns the percentiles of the callback latencies.
+func (t *telemetry) latency() LatencyStats {
+	var latencies [latencyBuckets]uint64
+	var stats LatencyStats
+	for i := range latencies {
+		latencies[i] = atomic.LoadUint64(&t.latencies[i])
+		stats.Count += latencies[i]
+	}
+	stats.Max = time.Duration(atomic.LoadUint64(&t.maxLatency))
+
+	percentile := func(p float64) time.Duration {
+		if stats.Count == 0 {
+			return 0
+		}
+		rank := uint64(math.Ceil(p * float64(stats.Count)))
+		var seen uint64
+		for i, count := range latencies {
+			if seen += count; seen >= rank {
+				if bound := time.Duration(latencyUpperBound(i)); bound < stats.Max {
+					return bound
+				}
+				break
+			}
+		}
+		return stats.Max
+	}
+	stats.P50 = percentile(0.50)
+	stats.P90 = percentile(0.90)
+	stats.P99 = percentile(0.99)
+
+	return stats
+}
+
+// latencyBucket returns the bucket of the latency in nanoseconds.
+func latencyBucket(latency uint64) int {
+	if latency < 8 {
+		return int(latency)
+	}
+	octave := bits.Len64(latency) - 4
+	bucket := 8 + octave*8 + int(latency>>uint(octave)&7)
+	if bucket >= latencyBuckets {
+		bucket = latencyBuckets - 1
+	}
+	return bucket
+}
+
+// latencyUpperBound returns the greatest latency in nanoseconds counted by the bucket.
+func latencyUpperBound(bucket int) uint64 {
+	if bucket < 8 {
+		return uint64(bucket)
+	}
+	octave := uint((bucket - 8) / 8)
+	return (uint64(9+(bucket-8)%8) << octave) - 1
+}
@@ -0,0 +1,139 @@
+package driver_test
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	D "github.com/wiresock/ndisapi-go/driver"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+func TestPacketFilter_Stats(t *testing.T) {
+	for _, pipeline := range verdictPipelines {
+		pipeline := pipeline
+		t.Run(pipeline.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			api := sim.NewDriver()
+			handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+			adapters, err := api.GetTcpipBoundAdaptersInfo()
+			require.NoError(t, err)
+
+			verdict := func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction {
+				return A.FilterAction(dstPort(buffer) % 1000)
+			}
+
+			filter, err := pipeline.create(ctx, api, adapters, verdict, verdict)
+			require.NoError(t, err)
+			require.NoError(t, startPipeline(pipeline, filter))
+
+			// The filtered adapter is reported before its first packet
+			stats := filter.Stats()
+			require.Len(t, stats.Adapters, 1)
+			assert.Equal(t, `\DEVICE\{SIM-0}`, stats.Adapters[0].Name)
+			assert.Zero(t, stats.In.ReadPackets)
+
+			for _, action := range verdicts {
+				require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(inPortBase+uint16(action))))
+				require.NoError(t, api.SendFromStack(handle, udpFrame(outPortBase+uint16(action))))
+			}
+
+			require.Eventually(t, func() bool {
+				stats = filter.Stats()
+				return stats.In.ReadPackets+stats.Out.ReadPackets == uint64(2*len(verdicts))
+			}, 5*time.Second, time.Millisecond)
+			require.NoError(t, filter.Close())
+
+			// Pass and PassRedirect pass the packet, Drop and DropRedirect drop it, Redirect reverses it
+			frameLength := uint64(len(udpFrame(0)))
+			expected := D.PacketCounters{
+				ReadPackets:       5,
+				ReadBytes:         5 * frameLength,
+				PassedPackets:     2,
+				PassedBytes:       2 * frameLength,
+				DroppedPackets:    2,
+				DroppedBytes:      2 * frameLength,
+				RedirectedPackets: 1,
+				RedirectedBytes:   frameLength,
+			}
+			assert.Equal(t, expected, stats.In)
+			assert.Equal(t, expected, stats.Out)
+			require.Len(t, stats.Adapters, 1)
+			assert.Equal(t, expected, stats.Adapters[0].In)
+			assert.Equal(t, expected, stats.Adapters[0].Out)
+			assert.Zero(t, stats.MstcpSendFailures)
+			assert.Zero(t, stats.AdapterSendFailures)
+
+			var reads, readPackets uint64
+			for _, bucket := range stats.ReadBatches {
+				reads += bucket.Count
+				readPackets += bucket.Count * uint64(bucket.UpperBound)
+			}
+			assert.Positive(t, reads)
+			assert.GreaterOrEqual(t, readPackets, uint64(2*len(verdicts)))
+			assert.Equal(t, uint32(math.MaxUint32), stats.ReadBatches[len(stats.ReadBatches)-1].UpperBound)
+
+			latency := stats.CallbackLatency
+			assert.Equal(t, uint64(2*len(verdicts)), latency.Count)
+			assert.True(t, latency.P50 <= latency.P90 && latency.P90 <= latency.P99 && latency.P99 <= latency.Max)
+
+			if strings.HasPrefix(pipeline.name, "Queued") {
+				require.NotEmpty(t, stats.Queues)
+				assert.Equal(t, "read", stats.Queues[0].Name)
+			} else {
+				assert.Empty(t, stats.Queues)
+			}
+		})
+	}
+}
+
+func TestPacketFilter_StatsLatency(t *testing.T) {
+	api := sim.NewDriver()
+	handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+	adapters, err := api.GetTcpipBoundAdaptersInfo()
+	require.NoError(t, err)
+
+	// One packet in ten takes 20ms to filter
+	slow := func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction {
+		if dstPort(buffer)%10 == 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return A.FilterActionPass
+	}
+
+	filter, err := D.NewSimplePacketFilter(context.Background(), api, adapters, slow, nil)
+	require.NoError(t, err)
+	require.NoError(t, filter.StartFilter(0))
+	waitRunning(filter)
+
+	for port := uint16(0); port < 100; port++ {
+		require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(port)))
+	}
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer waitCancel()
+	require.NoError(t, api.WaitDelivered(waitCtx, 100))
+	require.NoError(t, filter.Close())
+
+	latency := filter.Stats().CallbackLatency
+	assert.Equal(t, uint64(100), latency.Count)
+	assert.Less(t, int64(latency.P50), int64(20*time.Millisecond))
+	assert.GreaterOrEqual(t, int64(latency.P99), int64(20*time.Millisecond))
+	assert.GreaterOrEqual(t, int64(latency.Max), int64(20*time.Millisecond))
+
+	// The snapshot is ready to be exported
+	data, err := json.Marshal(filter.Stats())
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"readPackets":100`)
+}
@@ -0,0 +1,135 @@
+package driver
+
+import (
+	"bytes"
+	"fmt"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// QueuedConfig configures the pipeline of a QueuedPacketFilter. The zero value of a field selects its default.
+type QueuedConfig struct {
+	// Workers is the number of goroutines running the filter callbacks, 1 by default. With more
+	// than one worker the packets are distributed by their flow: the packets of a flow are always
+	// filtered by the same worker and written in the order they were read, while the callbacks,
+	// the listener and the contexts of different flows run concurrently.
+	Workers int
+	// BlockCount is the number of packet blocks in flight, each holding up to MaximumPacketBlock packets,
+	// MaximumBlockNum by default.
+	BlockCount int
+	// QueueDepth is the capacity of the queues between the stages of the pipeline, MaximumPacketBlock by default.
+	// A queue shorter than BlockCount makes a slow stage hold back the preceding ones.
+	QueueDepth int
+}
+
+// DefaultQueuedConfig returns the configuration the QueuedPacketFilter uses unless told otherwise.
+func DefaultQueuedConfig() QueuedConfig {
+	return QueuedConfig{
+		Workers:    1,
+		BlockCount: A.MaximumBlockNum,
+		QueueDepth: A.MaximumPacketBlock,
+	}
+}
+
+// withDefaults returns the configuration with the zero fields set to their defaults.
+func (c QueuedConfig) withDefaults() QueuedConfig {
+	defaults := DefaultQueuedConfig()
+	if c.Workers == 0 {
+		c.Workers = defaults.Workers
+	}
+	if c.BlockCount == 0 {
+		c.BlockCount = defaults.BlockCount
+	}
+	if c.QueueDepth == 0 {
+		c.QueueDepth = defaults.QueueDepth
+	}
+	return c
+}
+
+// validate checks the configuration with the defaults applied.
+func (c QueuedConfig) validate() error {
+	if c.Workers < 1 {
+		return fmt.Errorf("invalid worker count %d", c.Workers)
+	}
+	if c.BlockCount < 1 {
+		return fmt.Errorf("invalid packet block count %d", c.BlockCount)
+	}
+	if c.QueueDepth < 1 {
+		return fmt.Errorf("invalid queue depth %d", c.QueueDepth)
+	}
+	return nil
+}
+
+// queuedBlock is a packet block passed through the pipeline of a QueuedPacketFilter, together
+// with the state of its packets distributed among the workers.
+type queuedBlock struct {
+	*PacketBlock
+	workers   [][]uint16 // indices of the packets filtered by each worker
+	routes    [A.MaximumPacketBlock]packetRoute
+	remaining int32         // workers yet to filter their packets of the block
+	done      chan struct{} // signaled by the last worker done with the block
+}
+
+// packetRoute holds the destinations of a filtered packet.
+type packetRoute struct {
+	toAdapter, toMstcp bool
+}
+
+// newQueuedBlock creates a packet block for the adapter, distributed among the workers.
+func newQueuedBlock(adapter A.Handle, workers int) *queuedBlock {
+	return &queuedBlock{
+		PacketBlock: NewPacketBlock(adapter),
+		workers:     make([][]uint16, workers),
+		done:        make(chan struct{}, 1),
+	}
+}
+
+// flowHash hashes the addresses, the transport protocol and the ports of an IP packet. The endpoints
+// are ordered, so both directions of a flow hash the same. The non-first fragments carry no ports
+// and hash by the addresses and the protocol only, the receiver reassembles them in any order.
+// Packets of other protocols hash to zero.
+func flowHash(packet *A.DecodedPacket) uint32 {
+	var src, dst []byte
+	switch packet.IPVersion() {
+	case 4:
+		header := packet.IPv4()
+		src, dst = header.Source(), header.Destination()
+	case 6:
+		header := packet.IPv6()
+		src, dst = header.Source(), header.Destination()
+	default:
+		return 0
+	}
+
+	var srcPort, dstPort uint16
+	if tcp := packet.TCP(); tcp != nil {
+		srcPort, dstPort = tcp.SourcePort(), tcp.DestinationPort()
+	} else if udp := packet.UDP(); udp != nil {
+		srcPort, dstPort = udp.SourcePort(), udp.DestinationPort()
+	}
+
+	if order := bytes.Compare(src, dst); order > 0 || order == 0 && srcPort > dstPort {
+		src, dst = dst, src
+		srcPort, dstPort = dstPort, srcPort
+	}
+
+	// FNV-1a
+	hash := uint32(2166136261)
+	add := func(b byte) {
+		hash ^= uint32(b)
+		hash *= 16777619
+	}
+	for _, b := range src {
+		add(b)
+	}
+	for _, b := range dst {
+		add(b)
+	}
+	add(byte(srcPort >> 8))
+	add(byte(srcPort))
+	add(byte(dstPort >> 8))
+	add(byte(dstPort))
+	add(packet.Protocol())
+
+	return hash
+}
@@ -0,0 +1,154 @@
+package driver_test
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	D "github.com/wiresock/ndisapi-go/driver"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+// flowFrame builds an Ethernet/IPv4/UDP frame of a flow between 192.168.1.2:port and 192.168.1.1:53,
+// carrying the sequence number as its payload. Outgoing frames are sent from the port, incoming ones to it.
+func flowFrame(port, seq uint16, outgoing bool) []byte {
+	frame := make([]byte, 14+20+8+2)
+	binary.BigEndian.PutUint16(frame[12:14], 0x0800)
+
+	ip := frame[14:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], 30)
+	ip[8] = 64
+	ip[9] = 17
+
+	udp := ip[20:]
+	binary.BigEndian.PutUint16(udp[4:6], 10)
+	binary.BigEndian.PutUint16(udp[8:10], seq)
+
+	if outgoing {
+		copy(ip[12:16], []byte{192, 168, 1, 2})
+		copy(ip[16:20], []byte{192, 168, 1, 1})
+		binary.BigEndian.PutUint16(udp[0:2], port)
+		binary.BigEndian.PutUint16(udp[2:4], 53)
+	} else {
+		copy(ip[12:16], []byte{192, 168, 1, 1})
+		copy(ip[16:20], []byte{192, 168, 1, 2})
+		binary.BigEndian.PutUint16(udp[0:2], 53)
+		binary.BigEndian.PutUint16(udp[2:4], port)
+	}
+
+	return frame
+}
+
+// flowOf returns the local port and the sequence number of a frame built by flowFrame.
+func flowOf(data []byte) (port, seq uint16) {
+	udp := data[14+20:]
+	port = binary.BigEndian.Uint16(udp[0:2])
+	if port == 53 {
+		port = binary.BigEndian.Uint16(udp[2:4])
+	}
+	return port, binary.BigEndian.Uint16(udp[8:10])
+}
+
+func TestQueuedConfig(t *testing.T) {
+	api := sim.NewDriver()
+	api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+	adapters, err := api.GetTcpipBoundAdaptersInfo()
+	require.NoError(t, err)
+
+	filter, err := D.NewQueuedPacketFilter(context.Background(), api, adapters, nil, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, D.QueuedConfig{Workers: 1, BlockCount: A.MaximumBlockNum, QueueDepth: A.MaximumPacketBlock}, D.DefaultQueuedConfig())
+
+	assert.Error(t, filter.SetQueuedConfig(D.QueuedConfig{Workers: -1}))
+	assert.Error(t, filter.SetQueuedConfig(D.QueuedConfig{BlockCount: -1}))
+	assert.Error(t, filter.SetQueuedConfig(D.QueuedConfig{QueueDepth: -1}))
+	assert.NoError(t, filter.SetQueuedConfig(D.QueuedConfig{Workers: 8, BlockCount: 2, QueueDepth: 1}))
+}
+
+func TestQueuedPacketFilter_Workers(t *testing.T) {
+	// Both directions of the flows fit in a packet block
+	const (
+		flows   = 8
+		packets = 24
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	api := sim.NewDriver()
+	handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+	adapters, err := api.GetTcpipBoundAdaptersInfo()
+	require.NoError(t, err)
+
+	// The callbacks track how many of them run at once, in total and for each flow
+	var mu sync.Mutex
+	running, peak := 0, 0
+	runningFlows := make(map[uint16]int)
+	overlapped := false
+	filter := func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction {
+		port, _ := flowOf(buffer.Buffer[:buffer.Length])
+
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		runningFlows[port]++
+		if runningFlows[port] > 1 {
+			overlapped = true
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		running--
+		runningFlows[port]--
+		mu.Unlock()
+
+		return A.FilterActionPass
+	}
+
+	queued, err := D.NewQueuedPacketFilter(ctx, api, adapters, filter, filter)
+	require.NoError(t, err)
+	require.NoError(t, queued.SetQueuedConfig(D.QueuedConfig{Workers: 4, BlockCount: 4, QueueDepth: 2}))
+	require.NoError(t, queued.StartFilter(0))
+	waitRunning(queued)
+
+	for seq := uint16(0); seq < packets; seq++ {
+		for port := uint16(40000); port < 40000+flows; port++ {
+			require.NoError(t, api.ReceiveFromNetwork(handle, flowFrame(port, seq, false)))
+			require.NoError(t, api.SendFromStack(handle, flowFrame(port, seq, true)))
+		}
+	}
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer waitCancel()
+	require.NoError(t, api.WaitDelivered(waitCtx, 2*flows*packets))
+	require.NoError(t, queued.Close())
+
+	// Each flow was filtered by a single worker at a time, while the flows were filtered concurrently
+	assert.False(t, overlapped)
+	assert.Greater(t, peak, 1)
+
+	// The packets of each flow were written in the order they were read
+	for _, delivered := range [][]sim.Packet{api.StackPackets(handle), api.WirePackets(handle)} {
+		require.Len(t, delivered, flows*packets)
+		next := make(map[uint16]uint16)
+		for _, packet := range delivered {
+			port, seq := flowOf(packet.Data)
+			assert.Equal(t, next[port], seq, "flow %d", port)
+			next[port] = seq + 1
+		}
+	}
+}
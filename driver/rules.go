@@ -0,0 +1,684 @@
+package driver
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// The rule language describes static filters, one rule per line:
+//
+//	<action> [in|out|both] [inet|inet6] [ether <type>] [tcp|udp|icmp|icmpv6|proto <number>]
+//	    [from <endpoint>] [to <endpoint>] [flags <flags>]
+//	    [icmp-type <range>] [icmp-code <range>] [on <adapter>]
+//
+// where the action is pass, drop, redirect, pass-redirect or drop-redirect and an endpoint is
+//
+//	any|<address>|<address>/<prefix>|<address>/<mask>|<address>-<address> [port <range>] [mac <address>]
+//
+// The clauses following the action may appear in any order. The direction defaults to both,
+// unset criteria match any packet. TCP flags are given as a comma separated list of fin, syn,
+// rst, psh, ack, urg, ece and cwr. The adapter is either a name known to AdapterNames, quoted
+// when it contains spaces, or the hexadecimal handle. Text following # is a comment.
+//
+// For example:
+//
+//	drop out tcp from 10.0.0.0/8 to any port 445 on "Ethernet"
+//	redirect in icmp icmp-type 8
+//	pass both
+
+// RuleError reports a syntax error in the rule text.
+type RuleError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// AdapterNames maps the adapter names used by the rules to adapter handles and back.
+// A nil *AdapterNames knows no names.
+type AdapterNames struct {
+	handles map[string]A.Handle
+	names   map[A.Handle]string
+}
+
+// NewAdapterNames returns the friendly and internal names of the adapters.
+func NewAdapterNames(api A.NdisApiInterface, adapters *A.TcpAdapterList) *AdapterNames {
+	names := &AdapterNames{}
+	for i := 0; i < int(adapters.AdapterCount); i++ {
+		name := adapters.AdapterName(i)
+		handle := adapters.AdapterHandle[i]
+
+		names.Add(api.ConvertWindows2000AdapterName(name), handle)
+		names.Add(name, handle)
+	}
+	return names
+}
+
+// Add adds a name of the adapter. The first name added for a handle is used to format the rules.
+func (n *AdapterNames) Add(name string, handle A.Handle) {
+	if name == "" {
+		return
+	}
+	if n.handles == nil {
+		n.handles = make(map[string]A.Handle)
+		n.names = make(map[A.Handle]string)
+	}
+	if _, ok := n.handles[strings.ToLower(name)]; !ok {
+		n.handles[strings.ToLower(name)] = handle
+	}
+	if _, ok := n.names[handle]; !ok {
+		n.names[handle] = name
+	}
+}
+
+// Handle returns the handle of the named adapter, the match is case insensitive.
+func (n *AdapterNames) Handle(name string) (A.Handle, bool) {
+	if n == nil {
+		return A.Handle{}, false
+	}
+	handle, ok := n.handles[strings.ToLower(name)]
+	return handle, ok
+}
+
+// Name returns the name of the adapter.
+func (n *AdapterNames) Name(handle A.Handle) (string, bool) {
+	if n == nil {
+		return "", false
+	}
+	name, ok := n.names[handle]
+	return name, ok
+}
+
+var ruleActions = []struct {
+	name   string
+	action A.FilterAction
+}{
+	{"pass", A.FilterActionPass},
+	{"drop", A.FilterActionDrop},
+	{"redirect", A.FilterActionRedirect},
+	{"pass-redirect", A.FilterActionPassRedirect},
+	{"drop-redirect", A.FilterActionDropRedirect},
+}
+
+var ruleDirections = []struct {
+	name      string
+	direction PacketDirection
+}{
+	{"in", PacketDirectionIn},
+	{"out", PacketDirectionOut},
+	{"both", PacketDirectionBoth},
+}
+
+var ruleProtocols = []struct {
+	name     string
+	protocol uint8
+}{
+	{"tcp", A.IPProtocolTCP},
+	{"udp", A.IPProtocolUDP},
+	{"icmp", A.IPProtocolICMP},
+	{"icmpv6", A.IPProtocolICMPv6},
+}
+
+var ruleTCPFlags = []struct {
+	name string
+	flag uint8
+}{
+	{"fin", A.TCPFlagFIN},
+	{"syn", A.TCPFlagSYN},
+	{"rst", A.TCPFlagRST},
+	{"psh", A.TCPFlagPSH},
+	{"ack", A.TCPFlagACK},
+	{"urg", A.TCPFlagURG},
+	{"ece", A.TCPFlagECE},
+	{"cwr", A.TCPFlagCWR},
+}
+
+// ruleToken is a word or a quoted string of the rule text.
+type ruleToken struct {
+	text   string
+	quoted bool
+	line   int
+	column int
+}
+
+// ruleParser parses the tokens of a single rule.
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+	line   int
+	end    int
+	names  *AdapterNames
+}
+
+// ParseRules parses the rules of the text into filters, keeping their order.
+// The adapter names are resolved with names, which may be nil.
+func ParseRules(text string, names *AdapterNames) ([]Filter, error) {
+	var filters []Filter
+
+	for i, line := range strings.Split(text, "\n") {
+		tokens, err := tokenizeRule(line, i+1)
+		if err != nil {
+			return nil, err
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+
+		parser := &ruleParser{
+			tokens: tokens,
+			line:   i + 1,
+			end:    len(strings.TrimRight(line, " \t\r")) + 1,
+			names:  names,
+		}
+		filter, err := parser.parse()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, *filter)
+	}
+
+	return filters, nil
+}
+
+// tokenizeRule splits a line into words and quoted strings, dropping the comment.
+func tokenizeRule(line string, lineNumber int) ([]ruleToken, error) {
+	var tokens []ruleToken
+
+	for i := 0; i < len(line); {
+		switch c := line[i]; {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			return tokens, nil
+		case c == '"':
+			var text strings.Builder
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' && j+1 < len(line) {
+					j++
+				}
+				text.WriteByte(line[j])
+			}
+			if j == len(line) {
+				return nil, &RuleError{Line: lineNumber, Column: i + 1, Msg: "unterminated string"}
+			}
+			tokens = append(tokens, ruleToken{text: text.String(), quoted: true, line: lineNumber, column: i + 1})
+			i = j + 1
+		default:
+			j := i
+			for j < len(line) && !strings.ContainsRune(" \t\r#\"", rune(line[j])) {
+				j++
+			}
+			tokens = append(tokens, ruleToken{text: line[i:j], line: lineNumber, column: i + 1})
+			i = j
+		}
+	}
+
+	return tokens, nil
+}
+
+// errorf returns a RuleError at the token.
+func (p *ruleParser) errorf(token ruleToken, format string, args ...interface{}) error {
+	return &RuleError{Line: token.line, Column: token.column, Msg: fmt.Sprintf(format, args...)}
+}
+
+// next returns the next token, or an error if the rule ends.
+func (p *ruleParser) next(expected string) (ruleToken, error) {
+	if p.pos == len(p.tokens) {
+		return ruleToken{}, &RuleError{Line: p.line, Column: p.end, Msg: "expected " + expected}
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	return token, nil
+}
+
+// peek returns the next word, empty if the rule ends or the next token is quoted.
+func (p *ruleParser) peek() string {
+	if p.pos == len(p.tokens) || p.tokens[p.pos].quoted {
+		return ""
+	}
+	return strings.ToLower(p.tokens[p.pos].text)
+}
+
+// parse parses the rule.
+func (p *ruleParser) parse() (*Filter, error) {
+	filter := &Filter{Direction: PacketDirectionBoth}
+
+	token, _ := p.next("action")
+	action, ok := parseAction(token)
+	if !ok {
+		return nil, p.errorf(token, "unknown action %q", token.text)
+	}
+	filter.Action = action
+
+	var icmpv6 *ruleToken
+	seen := make(map[string]bool)
+	for p.pos < len(p.tokens) {
+		token, _ := p.next("")
+		word := strings.ToLower(token.text)
+		if token.quoted {
+			return nil, p.errorf(token, "unexpected string %q", token.text)
+		}
+
+		clause := word
+		switch word {
+		case "in", "out", "both":
+			clause = "direction"
+		case "inet", "inet6":
+			clause = "address family"
+		case "tcp", "udp", "icmp", "icmpv6", "proto":
+			clause = "protocol"
+		}
+		if seen[clause] {
+			return nil, p.errorf(token, "duplicate %s", clause)
+		}
+		seen[clause] = true
+
+		var err error
+		switch word {
+		case "in", "out", "both":
+			for _, d := range ruleDirections {
+				if d.name == word {
+					filter.Direction = d.direction
+				}
+			}
+		case "inet":
+			filter.IPVersion = 4
+		case "inet6":
+			filter.IPVersion = 6
+		case "tcp", "udp", "icmp", "icmpv6":
+			for _, protocol := range ruleProtocols {
+				if protocol.name == word {
+					filter.Protocol = protocol.protocol
+				}
+			}
+			if word == "icmpv6" {
+				icmpv6 = &token
+			}
+		case "proto":
+			filter.Protocol, err = p.parseUint8("protocol number")
+			if err == nil && filter.Protocol == 0 {
+				err = p.errorf(p.tokens[p.pos-1], "protocol number must not be zero")
+			}
+		case "ether":
+			err = p.parseEtherType(filter)
+		case "from":
+			err = p.parseEndpoint(&filter.SourceAddress, &filter.SourceAddressRange, &filter.SourcePort, &filter.SourceMacAddress)
+		case "to":
+			err = p.parseEndpoint(&filter.DestinationAddress, &filter.DestinationAddressRange, &filter.DestinationPort, &filter.DestinationMacAddress)
+		case "flags":
+			err = p.parseTCPFlags(filter)
+		case "icmp-type":
+			filter.ICMPType, err = p.parseByteRange("ICMP type")
+		case "icmp-code":
+			filter.ICMPCode, err = p.parseByteRange("ICMP code")
+		case "on":
+			err = p.parseAdapter(filter)
+		default:
+			return nil, p.errorf(token, "unexpected %q", token.text)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if icmpv6 != nil {
+		if filter.IPVersion == 4 {
+			return nil, p.errorf(*icmpv6, "icmpv6 can't be combined with inet")
+		}
+		filter.IPVersion = 6
+	}
+
+	if err := filter.Validate(); err != nil {
+		return nil, &RuleError{Line: p.line, Column: p.tokens[0].column, Msg: err.Error()}
+	}
+
+	return filter, nil
+}
+
+// parseAction parses the action of the rule.
+func parseAction(token ruleToken) (A.FilterAction, bool) {
+	if token.quoted {
+		return 0, false
+	}
+	for _, action := range ruleActions {
+		if action.name == strings.ToLower(token.text) {
+			return action.action, true
+		}
+	}
+	return 0, false
+}
+
+// parseUint8 parses a byte value.
+func (p *ruleParser) parseUint8(expected string) (uint8, error) {
+	token, err := p.next(expected)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseUint(token.text, 0, 8)
+	if err != nil {
+		return 0, p.errorf(token, "invalid %s %q", expected, token.text)
+	}
+	return uint8(value), nil
+}
+
+// parseEtherType parses the EtherType of the ether clause.
+func (p *ruleParser) parseEtherType(filter *Filter) error {
+	token, err := p.next("EtherType")
+	if err != nil {
+		return err
+	}
+	value, err := strconv.ParseUint(token.text, 0, 16)
+	if err != nil || value == 0 {
+		return p.errorf(token, "invalid EtherType %q", token.text)
+	}
+	filter.EthernetType = uint16(value)
+	return nil
+}
+
+// parseEndpoint parses the address, port and MAC address following from or to.
+func (p *ruleParser) parseEndpoint(address *net.IPNet, addressRange *IPRange, port *[2]uint16, mac *net.HardwareAddr) error {
+	token, err := p.next("address, port or mac")
+	if err != nil {
+		return err
+	}
+
+	switch word := strings.ToLower(token.text); {
+	case token.quoted:
+		return p.errorf(token, "expected address, port or mac")
+	case word == "port" || word == "mac":
+		p.pos--
+	case word != "any":
+		if *address, *addressRange, err = parseAddress(token.text); err != nil {
+			return p.errorf(token, "%v", err)
+		}
+	}
+
+	for _, keyword := range []string{"port", "mac"} {
+		if p.peek() != keyword {
+			continue
+		}
+		p.pos++
+
+		token, err := p.next(keyword)
+		if err != nil {
+			return err
+		}
+		if keyword == "port" {
+			start, end, err := parseRange(token.text, 16)
+			if err != nil || (start == 0 && end == 0) {
+				return p.errorf(token, "invalid port %q", token.text)
+			}
+			*port = [2]uint16{uint16(start), uint16(end)}
+		} else {
+			hwAddr, err := net.ParseMAC(token.text)
+			if err != nil || len(hwAddr) != A.ETHER_ADDR_LENGTH {
+				return p.errorf(token, "invalid MAC address %q", token.text)
+			}
+			*mac = hwAddr
+		}
+	}
+
+	return nil
+}
+
+// parseAddress parses an address, a subnet or an address range.
+func parseAddress(text string) (net.IPNet, IPRange, error) {
+	if i := strings.IndexByte(text, '-'); i >= 0 {
+		start, end := parseIP(text[:i]), parseIP(text[i+1:])
+		if start == nil || end == nil || len(start) != len(end) {
+			return net.IPNet{}, IPRange{}, fmt.Errorf("invalid address range %q", text)
+		}
+		return net.IPNet{}, IPRange{Start: start, End: end}, nil
+	}
+
+	ipText, maskText := text, ""
+	if i := strings.IndexByte(text, '/'); i >= 0 {
+		ipText, maskText = text[:i], text[i+1:]
+	}
+
+	ip := parseIP(ipText)
+	if ip == nil {
+		return net.IPNet{}, IPRange{}, fmt.Errorf("invalid address %q", text)
+	}
+	bits := 8 * len(ip)
+
+	mask := net.CIDRMask(bits, bits)
+	if maskText != "" {
+		if prefix, err := strconv.Atoi(maskText); err == nil && prefix >= 0 && prefix <= bits {
+			mask = net.CIDRMask(prefix, bits)
+		} else if maskIP := parseIP(maskText); maskIP != nil && len(maskIP) == len(ip) {
+			mask = net.IPMask(maskIP)
+		} else {
+			return net.IPNet{}, IPRange{}, fmt.Errorf("invalid mask %q", maskText)
+		}
+	}
+
+	return net.IPNet{IP: ip, Mask: mask}, IPRange{}, nil
+}
+
+// parseIP parses an address into its 4 or 16 byte form.
+func parseIP(text string) net.IP {
+	ip := net.ParseIP(text)
+	if ip == nil {
+		return nil
+	}
+	if strings.IndexByte(text, ':') < 0 {
+		return ip.To4()
+	}
+	return ip
+}
+
+// parseRange parses a value or a range of values separated by a dash.
+func parseRange(text string, bitSize int) (uint64, uint64, error) {
+	startText, endText := text, text
+	if i := strings.IndexByte(text, '-'); i >= 0 {
+		startText, endText = text[:i], text[i+1:]
+	}
+
+	start, err := strconv.ParseUint(startText, 10, bitSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := strconv.ParseUint(endText, 10, bitSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// parseByteRange parses the ICMP type or code range.
+func (p *ruleParser) parseByteRange(expected string) (*A.ByteRange, error) {
+	token, err := p.next(expected)
+	if err != nil {
+		return nil, err
+	}
+	start, end, err := parseRange(token.text, 8)
+	if err != nil {
+		return nil, p.errorf(token, "invalid %s %q", expected, token.text)
+	}
+	return &A.ByteRange{StartRange: uint8(start), EndRange: uint8(end)}, nil
+}
+
+// parseTCPFlags parses the comma separated TCP flags.
+func (p *ruleParser) parseTCPFlags(filter *Filter) error {
+	token, err := p.next("TCP flags")
+	if err != nil {
+		return err
+	}
+
+	for _, name := range strings.Split(strings.ToLower(token.text), ",") {
+		found := false
+		for _, flag := range ruleTCPFlags {
+			if flag.name == name {
+				filter.TCPFlags |= flag.flag
+				found = true
+			}
+		}
+		if !found {
+			return p.errorf(token, "unknown TCP flag %q", name)
+		}
+	}
+
+	return nil
+}
+
+// parseAdapter parses the adapter name or handle.
+func (p *ruleParser) parseAdapter(filter *Filter) error {
+	token, err := p.next("adapter")
+	if err != nil {
+		return err
+	}
+
+	if handle, ok := p.names.Handle(token.text); ok {
+		filter.AdapterHandle = handle
+		return nil
+	}
+
+	if !token.quoted && strings.HasPrefix(token.text, "0x") {
+		if raw, err := hex.DecodeString(token.text[2:]); err == nil && len(raw) == len(filter.AdapterHandle) {
+			copy(filter.AdapterHandle[:], raw)
+			return nil
+		}
+	}
+
+	return p.errorf(token, "unknown adapter %q", token.text)
+}
+
+// FormatRule formats the filter as a rule, naming the adapter with names, which may be nil.
+func FormatRule(filter *Filter, names *AdapterNames) string {
+	var parts []string
+
+	for _, action := range ruleActions {
+		if action.action == filter.Action {
+			parts = append(parts, action.name)
+		}
+	}
+	for _, direction := range ruleDirections {
+		if direction.direction == filter.Direction {
+			parts = append(parts, direction.name)
+		}
+	}
+
+	version := filter.ipVersion()
+	hasAddress := filter.SourceAddress.IP != nil || filter.DestinationAddress.IP != nil ||
+		!filter.SourceAddressRange.IsZero() || !filter.DestinationAddressRange.IsZero()
+	// The address family is only written when the other criteria do not imply it
+	if !hasAddress && ((version == 4 && filter.Protocol == 0) || (version == 6 && filter.Protocol != A.IPProtocolICMPv6)) {
+		parts = append(parts, map[uint8]string{4: "inet", 6: "inet6"}[version])
+	}
+
+	if filter.EthernetType != 0 {
+		parts = append(parts, "ether", fmt.Sprintf("0x%04x", filter.EthernetType))
+	}
+
+	if protocol := filter.Protocol; protocol != 0 {
+		name := ""
+		for _, p := range ruleProtocols {
+			if p.protocol == protocol && (protocol != A.IPProtocolICMPv6 || version == 6) {
+				name = p.name
+			}
+		}
+		if name != "" {
+			parts = append(parts, name)
+		} else {
+			parts = append(parts, "proto", strconv.Itoa(int(protocol)))
+		}
+	}
+
+	parts = appendEndpoint(parts, "from", filter.SourceAddress, filter.SourceAddressRange, filter.SourcePort, filter.SourceMacAddress)
+	parts = appendEndpoint(parts, "to", filter.DestinationAddress, filter.DestinationAddressRange, filter.DestinationPort, filter.DestinationMacAddress)
+
+	if filter.TCPFlags != 0 {
+		var flags []string
+		for _, flag := range ruleTCPFlags {
+			if filter.TCPFlags&flag.flag != 0 {
+				flags = append(flags, flag.name)
+			}
+		}
+		parts = append(parts, "flags", strings.Join(flags, ","))
+	}
+
+	if filter.ICMPType != nil {
+		parts = append(parts, "icmp-type", formatRange(uint64(filter.ICMPType.StartRange), uint64(filter.ICMPType.EndRange)))
+	}
+	if filter.ICMPCode != nil {
+		parts = append(parts, "icmp-code", formatRange(uint64(filter.ICMPCode.StartRange), uint64(filter.ICMPCode.EndRange)))
+	}
+
+	if filter.AdapterHandle != (A.Handle{}) {
+		if name, ok := names.Name(filter.AdapterHandle); ok {
+			parts = append(parts, "on", strconv.Quote(name))
+		} else {
+			parts = append(parts, "on", "0x"+hex.EncodeToString(filter.AdapterHandle[:]))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// FormatRules formats the filters as rules, one per line.
+func FormatRules(filters []Filter, names *AdapterNames) string {
+	var text strings.Builder
+	for i := range filters {
+		text.WriteString(FormatRule(&filters[i], names))
+		text.WriteByte('\n')
+	}
+	return text.String()
+}
+
+// appendEndpoint appends the from or to clause if any of its criteria is set.
+func appendEndpoint(parts []string, keyword string, address net.IPNet, addressRange IPRange, port [2]uint16, mac net.HardwareAddr) []string {
+	if address.IP == nil && addressRange.IsZero() && port == [2]uint16{} && mac == nil {
+		return parts
+	}
+
+	switch {
+	case address.IP != nil:
+		parts = append(parts, keyword, formatAddress(address))
+	case !addressRange.IsZero():
+		version := ipVersionOf(addressRange.Start)
+		parts = append(parts, keyword, ipAddress(addressRange.Start, version).String()+"-"+ipAddress(addressRange.End, version).String())
+	default:
+		parts = append(parts, keyword, "any")
+	}
+
+	if port != [2]uint16{} {
+		parts = append(parts, "port", formatRange(uint64(port[0]), uint64(port[1])))
+	}
+	if mac != nil {
+		parts = append(parts, "mac", mac.String())
+	}
+
+	return parts
+}
+
+// formatAddress formats the subnet as an address, a prefix or an address with a mask.
+func formatAddress(address net.IPNet) string {
+	ip := ipAddress(address.IP, ipVersionOf(address.IP))
+	ones, bits := address.Mask.Size()
+	switch {
+	case bits == 0:
+		return ip.String() + "/" + net.IP(address.Mask).String()
+	case ones == bits:
+		return ip.String()
+	default:
+		return ip.String() + "/" + strconv.Itoa(ones)
+	}
+}
+
+// formatRange formats a value or a range of values.
+func formatRange(start, end uint64) string {
+	if start == end {
+		return strconv.FormatUint(start, 10)
+	}
+	return strconv.FormatUint(start, 10) + "-" + strconv.FormatUint(end, 10)
+}
@@ -0,0 +1,141 @@
+package driver_test
+
+import (
+	"net"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	D "github.com/wiresock/ndisapi-go/driver"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+func TestParseRules(t *testing.T) {
+	api := sim.NewDriver()
+	handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`, FriendlyName: "Ethernet"})
+	adapters, err := api.GetTcpipBoundAdaptersInfo()
+	require.NoError(t, err)
+	names := D.NewAdapterNames(api, adapters)
+
+	filters, err := D.ParseRules(`
+		# Block SMB leaving the private network
+		drop out tcp from 10.0.0.0/8 to any port 445 on "Ethernet"
+
+		redirect in inet6 icmpv6 icmp-type 128-129 # echo
+		pass-redirect both tcp flags syn,ack from 192.168.1.1-192.168.1.9 port 1024-65535 mac 02:00:00:00:00:01
+		pass on \DEVICE\{SIM-0}
+	`, names)
+	require.NoError(t, err)
+
+	expected := []D.Filter{
+		{
+			AdapterHandle:   handle,
+			SourceAddress:   net.IPNet{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(8, 32)},
+			DestinationPort: [2]uint16{445, 445},
+			Protocol:        A.IPProtocolTCP,
+			Direction:       D.PacketDirectionOut,
+			Action:          A.FilterActionDrop,
+		},
+		{
+			IPVersion: 6,
+			Protocol:  A.IPProtocolICMPv6,
+			ICMPType:  &A.ByteRange{StartRange: 128, EndRange: 129},
+			Direction: D.PacketDirectionIn,
+			Action:    A.FilterActionRedirect,
+		},
+		{
+			SourceMacAddress:   net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+			SourceAddressRange: D.IPRange{Start: net.IPv4(192, 168, 1, 1).To4(), End: net.IPv4(192, 168, 1, 9).To4()},
+			SourcePort:         [2]uint16{1024, 65535},
+			Protocol:           A.IPProtocolTCP,
+			TCPFlags:           A.TCPFlagSYN | A.TCPFlagACK,
+			Direction:          D.PacketDirectionBoth,
+			Action:             A.FilterActionPassRedirect,
+		},
+		{
+			AdapterHandle: handle,
+			Direction:     D.PacketDirectionBoth,
+			Action:        A.FilterActionPass,
+		},
+	}
+	require.Len(t, filters, len(expected))
+	for i := range expected {
+		assert.True(t, expected[i].Equal(&filters[i]), "%d: %+v", i, filters[i])
+	}
+
+	assert.Equal(t, `drop out tcp from 10.0.0.0/8 to any port 445 on "Ethernet"`, D.FormatRule(&filters[0], names))
+	assert.Equal(t, `redirect in icmpv6 icmp-type 128-129`, D.FormatRule(&filters[1], names))
+}
+
+func TestParseRules_Errors(t *testing.T) {
+	tests := []struct {
+		rules  string
+		line   int
+		column int
+	}{
+		{"allow in", 1, 1},
+		{"pass\n  drop sideways", 2, 8},
+		{"drop in out", 1, 9},
+		{"drop from 10.0.0.300/8", 1, 11},
+		{"drop from 10.0.0.0/33", 1, 11},
+		{"drop to any port", 1, 17},
+		{"drop to any port 70000", 1, 18},
+		{"drop flags syn,foo", 1, 12},
+		{`drop on "Wi-Fi"`, 1, 9},
+		{`drop on "Wi-Fi`, 1, 9},
+		{"drop inet from fe80::1", 1, 1},
+		{"drop tcp from any port 80 icmp-type 8", 1, 1},
+		{"drop inet icmpv6", 1, 11},
+	}
+
+	for _, test := range tests {
+		_, err := D.ParseRules(test.rules, nil)
+		var ruleErr *D.RuleError
+		if assert.ErrorAs(t, err, &ruleErr, test.rules) {
+			assert.Equal(t, test.line, ruleErr.Line, test.rules)
+			assert.Equal(t, test.column, ruleErr.Column, test.rules)
+		}
+	}
+}
+
+func TestFormatRules_RoundTrip(t *testing.T) {
+	names := &D.AdapterNames{}
+	names.Add("Local Area Connection", A.Handle{1})
+
+	roundTrip := func(generated randomFilter) bool {
+		filter := generated.Filter
+		text := D.FormatRules([]D.Filter{filter}, names)
+
+		filters, err := D.ParseRules(text, names)
+		if !assert.NoError(t, err, text) || !assert.Len(t, filters, 1, text) {
+			return false
+		}
+		return assert.True(t, filters[0].Equal(&filter), "%s%+v\n%+v", text, filter, filters[0])
+	}
+	require.NoError(t, quick.Check(roundTrip, quickConfig))
+}
+
+func TestStaticFilters_StoreTable(t *testing.T) {
+	api := sim.NewDriver()
+	filters, err := D.NewStaticFilters(api, true, false)
+	require.NoError(t, err)
+	defer filters.Close()
+
+	rules := "drop out tcp from 10.0.0.0/8 to any port 445\n" +
+		"redirect in udp to fe80::/10 port 53\n" +
+		"pass both\n"
+	filters.Filters, err = D.ParseRules(rules, nil)
+	require.NoError(t, err)
+	require.NoError(t, filters.StoreTable())
+
+	filters.Filters = nil
+	_, err = filters.LoadTable()
+	require.NoError(t, err)
+	assert.Equal(t, rules, D.FormatRules(filters.Filters, nil))
+
+	filters.Filters = append(filters.Filters, D.Filter{SourceMacAddress: net.HardwareAddr{1}})
+	assert.Error(t, filters.StoreTable())
+}
@@ -1,38 +1,48 @@
-//go:build windows
-
 package driver
 
 import (
+	"encoding/binary"
 	"fmt"
-	"unsafe"
+	"net"
 
 	A "github.com/wiresock/ndisapi-go"
 )
 
 type StaticFilters struct {
-	*A.NdisApi
+	A.NdisApiInterface
 	Filters []Filter
+
+	capabilities A.Capabilities
 }
 
-// NewStaticFilter constructs a StaticFilter.
-func NewStaticFilters(api *A.NdisApi, filterCache, fragmentCache bool) (*StaticFilters, error) {
+// NewStaticFilter constructs a StaticFilter. The caches are left alone on drivers not supporting them,
+// and on drivers which can't edit the filter table by index every change stores the whole table.
+func NewStaticFilters(api A.NdisApiInterface, filterCache, fragmentCache bool) (*StaticFilters, error) {
 	if !api.IsDriverLoaded() {
-		return nil, fmt.Errorf("windows packet filter driver is not available")
+		return nil, fmt.Errorf("windows packet filter driver is not available: %w", A.ErrDriverNotLoaded)
+	}
+
+	capabilities, err := A.GetCapabilities(api)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get driver version: %w", err)
 	}
 
 	staticFilter := &StaticFilters{
-		NdisApi: api,
-		Filters: []Filter{},
+		NdisApiInterface: api,
+		Filters:          []Filter{},
+		capabilities:     capabilities,
 	}
 
-	err := api.SetPacketFilterCacheState(filterCache)
-	if err != nil {
-		return nil, fmt.Errorf("failed to set packet filter cache state: %v", err)
+	if capabilities.FilterCache {
+		if err := api.SetPacketFilterCacheState(filterCache); err != nil {
+			return nil, fmt.Errorf("failed to set packet filter cache state: %v", err)
+		}
 	}
 
-	err = api.SetPacketFragmentCacheState(fragmentCache)
-	if err != nil {
-		return nil, fmt.Errorf("failed to set packet fragment cache state: %v", err)
+	if capabilities.FragmentCache {
+		if err := api.SetPacketFragmentCacheState(fragmentCache); err != nil {
+			return nil, fmt.Errorf("failed to set packet fragment cache state: %v", err)
+		}
 	}
 
 	return staticFilter, nil
@@ -45,8 +55,14 @@ func (f *StaticFilters) Close() {
 
 // AddFilterFront adds a filter to the front of the filter list.
 func (f *StaticFilters) AddFilterFront(filter *Filter) bool {
-	staticFilter := f.toStaticFilter(filter)
-	if err := f.NdisApi.AddStaticFilterFront(staticFilter); err == nil {
+	staticFilter, err := toStaticFilter(filter)
+	if err != nil {
+		return false
+	}
+	if !f.capabilities.FilterInsertByIndex {
+		return f.storeFilters(append([]Filter{*filter}, f.Filters...))
+	}
+	if err := f.NdisApiInterface.AddStaticFilterFront(staticFilter); err == nil {
 		f.Filters = append([]Filter{*filter}, f.Filters...)
 		return true
 	}
@@ -55,8 +71,14 @@ func (f *StaticFilters) AddFilterFront(filter *Filter) bool {
 
 // AddFilterBack adds a filter to the back of the filter list.
 func (f *StaticFilters) AddFilterBack(filter *Filter) bool {
-	staticFilter := f.toStaticFilter(filter)
-	if err := f.NdisApi.AddStaticFilterBack(staticFilter); err == nil {
+	staticFilter, err := toStaticFilter(filter)
+	if err != nil {
+		return false
+	}
+	if !f.capabilities.FilterInsertByIndex {
+		return f.storeFilters(append(append([]Filter{}, f.Filters...), *filter))
+	}
+	if err := f.NdisApiInterface.AddStaticFilterBack(staticFilter); err == nil {
 		f.Filters = append(f.Filters, *filter)
 		return true
 	}
@@ -69,8 +91,15 @@ func (f *StaticFilters) InsertFilter(filter *Filter, position int) bool {
 		return false
 	}
 
-	staticFilter := f.toStaticFilter(filter)
-	if err := f.NdisApi.InsertStaticFilter(staticFilter, uint32(position)); err == nil {
+	staticFilter, err := toStaticFilter(filter)
+	if err != nil {
+		return false
+	}
+	if !f.capabilities.FilterInsertByIndex {
+		filters := append(append([]Filter{}, f.Filters[:position]...), *filter)
+		return f.storeFilters(append(filters, f.Filters[position:]...))
+	}
+	if err := f.NdisApiInterface.InsertStaticFilter(staticFilter, uint32(position)); err == nil {
 		f.Filters = append(f.Filters[:position], append([]Filter{*filter}, f.Filters[position:]...)...)
 		return true
 	}
@@ -83,29 +112,36 @@ func (f *StaticFilters) RemoveFilter(position int) bool {
 		return false
 	}
 
-	if err := f.NdisApi.RemoveStaticFilter(uint32(position)); err == nil {
+	if !f.capabilities.FilterInsertByIndex {
+		return f.storeFilters(append(append([]Filter{}, f.Filters[:position]...), f.Filters[position+1:]...))
+	}
+	if err := f.NdisApiInterface.RemoveStaticFilter(uint32(position)); err == nil {
 		f.Filters = append(f.Filters[:position], f.Filters[position+1:]...)
 		return true
 	}
 	return false
 }
 
+// storeFilters replaces the filter table of the driver and the filter list with the filters,
+// for the drivers which can't edit the table by index.
+func (f *StaticFilters) storeFilters(filters []Filter) bool {
+	table, err := CompileFilterTable(filters)
+	if err != nil {
+		return false
+	}
+	if err := f.SetPacketFilterTable(table); err != nil {
+		return false
+	}
+	f.Filters = filters
+	return true
+}
+
 // RemoveFiltersIf removes filters from the list based on a predicate.
 func (f *StaticFilters) RemoveFiltersIf(predicate func(*Filter) bool) {
-	position := 0
 	for it := 0; it < len(f.Filters); {
-		if predicate(&f.Filters[it]) {
-			if f.RemoveFilter(position) {
-				f.Filters = append(f.Filters[:it], f.Filters[it+1:]...)
-				// Do not increment position since we removed an element
-			} else {
-				// fmt.Printf("Failed to remove filter at position: %d\n", position)
-				it++
-				position++
-			}
-		} else {
+		// RemoveFilter shifts the remaining filters, so the position is only advanced when the filter stays
+		if !predicate(&f.Filters[it]) || !f.RemoveFilter(it) {
 			it++
-			position++
 		}
 	}
 }
@@ -122,57 +158,202 @@ func (f *StaticFilters) Contains(filter *Filter) bool {
 
 // StoreTable stores the current filter table to the driver.
 func (f *StaticFilters) StoreTable() error {
-	filterSize := len(f.Filters)
-	tableBuffer := make([]byte, int(unsafe.Sizeof(A.StaticFilterTable{}))+(filterSize)*int(unsafe.Sizeof(A.StaticFilter{})))
-	table := (*A.StaticFilterTable)(unsafe.Pointer(&tableBuffer[0]))
+	table, err := CompileFilterTable(f.Filters)
+	if err != nil {
+		return err
+	}
+
+	if err := f.SetPacketFilterTable(table); err != nil {
+		return fmt.Errorf("failed to store filter table: %v", err)
+	}
+
+	return nil
+}
 
-	for i := range tableBuffer {
-		tableBuffer[i] = 0
+// CompileFilterTable converts the filters to a static filter table keeping their order.
+func CompileFilterTable(filters []Filter) (*A.StaticFilterTable, error) {
+	table := &A.StaticFilterTable{
+		TableSize:     uint32(len(filters)),
+		StaticFilters: make([]A.StaticFilter, len(filters)),
 	}
 
-	table.TableSize = uint32(filterSize)
+	for i := range filters {
+		staticFilter, err := toStaticFilter(&filters[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter at position %d: %v", i, err)
+		}
+		table.StaticFilters[i] = *staticFilter
+	}
+
+	return table, nil
+}
+
+// Apply replaces the filter table of the driver with the filters. It reads the table from the
+// driver, so changes made by other processes are taken into account, and only removes and inserts
+// the filters that differ. If any change fails the previous table is restored. Drivers which can't
+// edit the table by index get the whole table stored instead.
+func (f *StaticFilters) Apply(filters []Filter) error {
+	desired, err := CompileFilterTable(filters)
+	if err != nil {
+		return err
+	}
 
-	for i, filter := range f.Filters {
-		table.StaticFilters[i] = *f.toStaticFilter(&filter)
+	if !f.capabilities.FilterInsertByIndex {
+		if err := f.SetPacketFilterTable(desired); err != nil {
+			return fmt.Errorf("failed to apply filter table: %v", err)
+		}
+		f.Filters = append([]Filter{}, filters...)
+		return nil
 	}
 
-	if err := f.SetPacketFilterTable(table); err != nil {
-		return fmt.Errorf("failed to store filter table: %v", err)
+	current, err := f.readTable()
+	if err != nil {
+		return err
+	}
+
+	removals, insertions := diffFilterTables(current.StaticFilters, desired.StaticFilters)
+
+	if err := f.applyDiff(desired.StaticFilters, removals, insertions); err != nil {
+		if rollbackErr := f.SetPacketFilterTable(current); rollbackErr != nil {
+			return fmt.Errorf("failed to apply filter table: %v, failed to restore the previous table: %v", err, rollbackErr)
+		}
+		return fmt.Errorf("failed to apply filter table: %v", err)
 	}
 
+	f.Filters = append([]Filter{}, filters...)
+
 	return nil
 }
 
-// LoadTable loads the filter table from the driver.
-func (f *StaticFilters) LoadTable() (*A.StaticFilterTable, error) {
-	var err error
-	var tableSize uint32
-	tableSize, err = f.GetPacketFilterTableSize()
-	if err != nil || tableSize == 0 {
-		// Failed to get table size or table is empty
-		return nil, fmt.Errorf("failed to get packet filter table size: %v", err)
+// Drifted reports whether the filter table of the driver differs from the filter list,
+// e.g. because another process changed it.
+func (f *StaticFilters) Drifted() (bool, error) {
+	desired, err := CompileFilterTable(f.Filters)
+	if err != nil {
+		return false, err
+	}
+
+	current, err := f.readTable()
+	if err != nil {
+		return false, err
+	}
+
+	removals, insertions := diffFilterTables(current.StaticFilters, desired.StaticFilters)
+	return len(removals) != 0 || len(insertions) != 0, nil
+}
+
+// Reconcile restores the filter list in the driver, undoing the changes made by other processes.
+func (f *StaticFilters) Reconcile() error {
+	return f.Apply(f.Filters)
+}
+
+// readTable reads the filter table of the driver, which may be empty.
+func (f *StaticFilters) readTable() (*A.StaticFilterTable, error) {
+	tableSize, err := f.GetPacketFilterTableSize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get packet filter table size: %w", err)
+	}
+	if tableSize == 0 {
+		return &A.StaticFilterTable{}, nil
 	}
 
 	table, err := f.GetPacketFilterTable(tableSize)
 	if err != nil {
-		// Failed to get the filter table
-		return nil, fmt.Errorf("failed to get the filter table: %v", err)
+		return nil, fmt.Errorf("failed to get the filter table: %w", err)
+	}
+	table.StaticFilters = table.StaticFilters[:table.TableSize]
+
+	return table, nil
+}
+
+// applyDiff removes the filters at the positions of the current table, in descending order,
+// and inserts the desired filters at the positions of the desired table, in ascending order.
+func (f *StaticFilters) applyDiff(desired []A.StaticFilter, removals, insertions []int) error {
+	for _, position := range removals {
+		if err := f.RemoveStaticFilter(uint32(position)); err != nil {
+			return fmt.Errorf("failed to remove filter at position %d: %v", position, err)
+		}
+	}
+
+	for _, position := range insertions {
+		if err := f.InsertStaticFilter(&desired[position], uint32(position)); err != nil {
+			return fmt.Errorf("failed to insert filter at position %d: %v", position, err)
+		}
+	}
+
+	return nil
+}
+
+// diffFilterTables returns the minimal edit turning the current table into the desired one: the
+// positions of the current filters to remove, in descending order, followed by the positions of the
+// desired filters to insert, in ascending order. The filters are compared without their statistics.
+func diffFilterTables(current, desired []A.StaticFilter) (removals, insertions []int) {
+	// lcs[i][j] is the length of the longest common subsequence of current[i:] and desired[j:]
+	lcs := make([][]int, len(current)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(desired)+1)
+	}
+	for i := len(current) - 1; i >= 0; i-- {
+		for j := len(desired) - 1; j >= 0; j-- {
+			switch {
+			case equalStaticFilter(&current[i], &desired[j]):
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	for i, j := 0, 0; i < len(current) || j < len(desired); {
+		switch {
+		case i < len(current) && j < len(desired) && equalStaticFilter(&current[i], &desired[j]):
+			i++
+			j++
+		case j == len(desired) || (i < len(current) && lcs[i+1][j] >= lcs[i][j+1]):
+			removals = append([]int{i}, removals...)
+			i++
+		default:
+			insertions = append(insertions, j)
+			j++
+		}
+	}
+
+	return removals, insertions
+}
+
+// equalStaticFilter checks if two static filters have the same criteria and action.
+func equalStaticFilter(a, b *A.StaticFilter) bool {
+	return withoutCounters(a) == withoutCounters(b)
+}
+
+// LoadTable loads the filter table from the driver. An empty table is loaded as an empty filter list,
+// so an error is only returned when the driver fails to report the table.
+func (f *StaticFilters) LoadTable() (*A.StaticFilterTable, error) {
+	table, err := f.readTable()
+	if err != nil {
+		return nil, err
 	}
 
 	// Clear the current filters list
 	f.Filters = []Filter{}
 
 	// Iterate through the STATIC_FILTER entries and reconstruct the filters list
-	for i := 0; i < int(tableSize); i++ {
+	for i := range table.StaticFilters {
 		staticFilter := table.StaticFilters[i]
-		f.Filters = append(f.Filters, *f.fromStaticFilter(&staticFilter))
+		f.Filters = append(f.Filters, *fromStaticFilter(&staticFilter))
 	}
 
 	return table, nil
 }
 
 // toStaticFilter converts a Filter instance to a StaticFilterEntry.
-func (f *StaticFilters) toStaticFilter(filter *Filter) *A.StaticFilter {
+func toStaticFilter(filter *Filter) (*A.StaticFilter, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+
 	var staticFilter A.StaticFilter
 	staticFilter.Adapter = filter.AdapterHandle
 
@@ -222,92 +403,109 @@ func (f *StaticFilters) toStaticFilter(filter *Filter) *A.StaticFilter {
 	}
 
 	// Network Layer
-	if filter.SourceAddress.IP != nil || filter.DestinationAddress.IP != nil || filter.Protocol > 0 {
+	switch filter.ipVersion() {
+	case 4:
 		staticFilter.ValidFields |= A.NETWORK_LAYER_VALID
-		if filter.SourceAddress.IP != nil {
-			if filter.SourceAddress.IP.To4() != nil {
-				staticFilter.NetworkFilter.Selector = A.IPV4
-			} else {
-				staticFilter.NetworkFilter.Selector = A.IPV6
-			}
+
+		var ipv4 A.IPv4Filter
+		if srcAddr := filter.SourceAddress; srcAddr.IP != nil {
+			ipv4.ValidFields |= A.IP_V4_FILTER_SRC_ADDRESS
+			ipv4.SourceAddress = *A.IPv4AddressFromIP(srcAddr)
+		} else if srcRange := filter.SourceAddressRange; !srcRange.IsZero() {
+			ipv4.ValidFields |= A.IP_V4_FILTER_SRC_ADDRESS
+			ipv4.SourceAddress = ipv4AddressFromRange(srcRange)
+		}
+
+		if destAddr := filter.DestinationAddress; destAddr.IP != nil {
+			ipv4.ValidFields |= A.IP_V4_FILTER_DEST_ADDRESS
+			ipv4.DestinationAddress = *A.IPv4AddressFromIP(destAddr)
+		} else if destRange := filter.DestinationAddressRange; !destRange.IsZero() {
+			ipv4.ValidFields |= A.IP_V4_FILTER_DEST_ADDRESS
+			ipv4.DestinationAddress = ipv4AddressFromRange(destRange)
+		}
+
+		if protocol := filter.Protocol; protocol > 0 {
+			ipv4.ValidFields |= A.IP_V4_FILTER_PROTOCOL
+			ipv4.Protocol = protocol
 		}
 
+		staticFilter.NetworkFilter.SetIPv4(ipv4)
+	case 6:
+		staticFilter.ValidFields |= A.NETWORK_LAYER_VALID
+
+		var ipv6 A.IPv6Filter
 		if srcAddr := filter.SourceAddress; srcAddr.IP != nil {
-			if srcAddr.IP.To4() != nil {
-				staticFilter.NetworkFilter.SetIPv4(A.IPv4Filter{
-					ValidFields:   A.IP_V4_FILTER_SRC_ADDRESS,
-					SourceAddress: *A.IPv4AddressFromIP(srcAddr),
-				})
-			} else {
-				staticFilter.NetworkFilter.SetIPv6(A.IPv6Filter{
-					ValidFields:   A.IP_V6_FILTER_SRC_ADDRESS,
-					SourceAddress: *A.IPv6AddressFromIP(srcAddr),
-				})
-			}
+			ipv6.ValidFields |= A.IP_V6_FILTER_SRC_ADDRESS
+			ipv6.SourceAddress = *A.IPv6AddressFromIP(srcAddr)
+		} else if srcRange := filter.SourceAddressRange; !srcRange.IsZero() {
+			ipv6.ValidFields |= A.IP_V6_FILTER_SRC_ADDRESS
+			ipv6.SourceAddress = ipv6AddressFromRange(srcRange)
 		}
 
 		if destAddr := filter.DestinationAddress; destAddr.IP != nil {
-			if destAddr.IP.To4() != nil {
-				staticFilter.NetworkFilter.SetIPv4(A.IPv4Filter{
-					ValidFields:        A.IP_V4_FILTER_DEST_ADDRESS,
-					DestinationAddress: *A.IPv4AddressFromIP(destAddr),
-				})
-			} else {
-				staticFilter.NetworkFilter.SetIPv6(A.IPv6Filter{
-					ValidFields:        A.IP_V6_FILTER_DEST_ADDRESS,
-					DestinationAddress: *A.IPv6AddressFromIP(destAddr),
-				})
-			}
+			ipv6.ValidFields |= A.IP_V6_FILTER_DEST_ADDRESS
+			ipv6.DestinationAddress = *A.IPv6AddressFromIP(destAddr)
+		} else if destRange := filter.DestinationAddressRange; !destRange.IsZero() {
+			ipv6.ValidFields |= A.IP_V6_FILTER_DEST_ADDRESS
+			ipv6.DestinationAddress = ipv6AddressFromRange(destRange)
 		}
 
 		if protocol := filter.Protocol; protocol > 0 {
-			if filter.SourceAddress.IP != nil {
-				if filter.SourceAddress.IP.To4() != nil {
-					staticFilter.NetworkFilter.SetIPv4(A.IPv4Filter{
-						ValidFields: A.IP_V4_FILTER_PROTOCOL,
-						Protocol:    protocol,
-					})
-				} else {
-					staticFilter.NetworkFilter.SetIPv6(A.IPv6Filter{
-						ValidFields: A.IP_V6_FILTER_PROTOCOL,
-						Protocol:    protocol,
-					})
-				}
-			}
+			ipv6.ValidFields |= A.IP_V6_FILTER_PROTOCOL
+			ipv6.Protocol = protocol
 		}
+
+		staticFilter.NetworkFilter.SetIPv6(ipv6)
 	}
 
 	// Transport Layer
-	if filter.SourcePort != [2]uint16{} || filter.DestinationPort != [2]uint16{} {
+	if filter.ICMPType != nil || filter.ICMPCode != nil {
+		staticFilter.ValidFields |= A.TRANSPORT_LAYER_VALID
+
+		var icmp A.ICMPFilter
+		if icmpType := filter.ICMPType; icmpType != nil {
+			icmp.ValidFields |= A.ICMP_TYPE
+			icmp.TypeRange = *icmpType
+		}
+		if icmpCode := filter.ICMPCode; icmpCode != nil {
+			icmp.ValidFields |= A.ICMP_CODE
+			icmp.CodeRange = *icmpCode
+		}
+
+		staticFilter.TransportFilter.SetICMP(icmp)
+	} else if filter.SourcePort != [2]uint16{} || filter.DestinationPort != [2]uint16{} || filter.TCPFlags != 0 {
 		staticFilter.ValidFields |= A.TRANSPORT_LAYER_VALID
-		staticFilter.TransportFilter.Selector = A.TCPUDP
 
+		var tcpudp A.TCPUDPFilter
 		if srcPort := filter.SourcePort; srcPort != [2]uint16{} {
-			staticFilter.TransportFilter.SetTCPUDP(A.TCPUDPFilter{
-				ValidFields: A.TCPUDP_SRC_PORT,
-				SourcePort: A.PortRange{
-					StartRange: srcPort[0],
-					EndRange:   srcPort[1],
-				},
-			})
+			tcpudp.ValidFields |= A.TCPUDP_SRC_PORT
+			tcpudp.SourcePort = A.PortRange{
+				StartRange: srcPort[0],
+				EndRange:   srcPort[1],
+			}
 		}
 
 		if destinationPort := filter.DestinationPort; destinationPort != [2]uint16{} {
-			staticFilter.TransportFilter.SetTCPUDP(A.TCPUDPFilter{
-				ValidFields: A.TCPUDP_DEST_PORT,
-				DestinationPort: A.PortRange{
-					StartRange: destinationPort[0],
-					EndRange:   destinationPort[1],
-				},
-			})
+			tcpudp.ValidFields |= A.TCPUDP_DEST_PORT
+			tcpudp.DestinationPort = A.PortRange{
+				StartRange: destinationPort[0],
+				EndRange:   destinationPort[1],
+			}
+		}
+
+		if tcpFlags := filter.TCPFlags; tcpFlags != 0 {
+			tcpudp.ValidFields |= A.TCPUDP_TCP_FLAGS
+			tcpudp.TCPFlags = tcpFlags
 		}
+
+		staticFilter.TransportFilter.SetTCPUDP(tcpudp)
 	}
 
-	return &staticFilter
+	return &staticFilter, nil
 }
 
 // fromStaticFilter converts a StaticFilterEntry to a Filter instance.
-func (f *StaticFilters) fromStaticFilter(staticFilter *A.StaticFilter) *Filter {
+func fromStaticFilter(staticFilter *A.StaticFilter) *Filter {
 	var filter Filter
 	filter.AdapterHandle = staticFilter.Adapter
 
@@ -340,10 +538,10 @@ func (f *StaticFilters) fromStaticFilter(staticFilter *A.StaticFilter) *Filter {
 	if staticFilter.ValidFields&A.DATA_LINK_LAYER_VALID != 0 {
 		if staticFilter.DataLinkFilter.Selector == A.ETH_802_3 {
 			if staticFilter.DataLinkFilter.Eth8023Filter.ValidFields&A.ETH_802_3_SRC_ADDRESS != 0 {
-				filter.SourceMacAddress = staticFilter.DataLinkFilter.Eth8023Filter.SourceAddress[:]
+				filter.SourceMacAddress = append(net.HardwareAddr(nil), staticFilter.DataLinkFilter.Eth8023Filter.SourceAddress[:]...)
 			}
 			if staticFilter.DataLinkFilter.Eth8023Filter.ValidFields&A.ETH_802_3_DEST_ADDRESS != 0 {
-				filter.DestinationMacAddress = staticFilter.DataLinkFilter.Eth8023Filter.DestinationAddress[:]
+				filter.DestinationMacAddress = append(net.HardwareAddr(nil), staticFilter.DataLinkFilter.Eth8023Filter.DestinationAddress[:]...)
 			}
 			if staticFilter.DataLinkFilter.Eth8023Filter.ValidFields&A.ETH_802_3_PROTOCOL != 0 {
 				filter.EthernetType = staticFilter.DataLinkFilter.Eth8023Filter.Protocol
@@ -353,39 +551,97 @@ func (f *StaticFilters) fromStaticFilter(staticFilter *A.StaticFilter) *Filter {
 
 	// Network Layer
 	if staticFilter.ValidFields&A.NETWORK_LAYER_VALID != 0 {
-		if staticFilter.NetworkFilter.Selector == A.IPV4 {
-			if staticFilter.NetworkFilter.GetIPv4().ValidFields&A.IP_V4_FILTER_SRC_ADDRESS != 0 {
-				filter.SourceAddress = A.IPv4AddressToIPNet(&staticFilter.NetworkFilter.GetIPv4().SourceAddress)
+		if ipv4 := staticFilter.NetworkFilter.GetIPv4(); ipv4 != nil {
+			filter.IPVersion = 4
+			if ipv4.ValidFields&A.IP_V4_FILTER_SRC_ADDRESS != 0 {
+				filter.SourceAddress, filter.SourceAddressRange = ipv4AddressToFilter(&ipv4.SourceAddress)
 			}
-			if staticFilter.NetworkFilter.GetIPv4().ValidFields&A.IP_V4_FILTER_DEST_ADDRESS != 0 {
-				filter.DestinationAddress = A.IPv4AddressToIPNet(&staticFilter.NetworkFilter.GetIPv4().DestinationAddress)
+			if ipv4.ValidFields&A.IP_V4_FILTER_DEST_ADDRESS != 0 {
+				filter.DestinationAddress, filter.DestinationAddressRange = ipv4AddressToFilter(&ipv4.DestinationAddress)
 			}
-		} else if staticFilter.NetworkFilter.Selector == A.IPV6 {
-			if staticFilter.NetworkFilter.GetIPv6().ValidFields&A.IP_V6_FILTER_SRC_ADDRESS != 0 {
-				filter.SourceAddress = A.IPv6AddressToIPNet(&staticFilter.NetworkFilter.GetIPv6().SourceAddress)
+			if ipv4.ValidFields&A.IP_V4_FILTER_PROTOCOL != 0 {
+				filter.Protocol = ipv4.Protocol
 			}
-			if staticFilter.NetworkFilter.GetIPv6().ValidFields&A.IP_V6_FILTER_DEST_ADDRESS != 0 {
-				filter.DestinationAddress = A.IPv6AddressToIPNet(&staticFilter.NetworkFilter.GetIPv6().DestinationAddress)
+		} else if ipv6 := staticFilter.NetworkFilter.GetIPv6(); ipv6 != nil {
+			filter.IPVersion = 6
+			if ipv6.ValidFields&A.IP_V6_FILTER_SRC_ADDRESS != 0 {
+				filter.SourceAddress, filter.SourceAddressRange = ipv6AddressToFilter(&ipv6.SourceAddress)
+			}
+			if ipv6.ValidFields&A.IP_V6_FILTER_DEST_ADDRESS != 0 {
+				filter.DestinationAddress, filter.DestinationAddressRange = ipv6AddressToFilter(&ipv6.DestinationAddress)
+			}
+			if ipv6.ValidFields&A.IP_V6_FILTER_PROTOCOL != 0 {
+				filter.Protocol = ipv6.Protocol
 			}
-		}
-		if staticFilter.NetworkFilter.Selector == A.IPV4 && staticFilter.NetworkFilter.GetIPv4().ValidFields&A.IP_V4_FILTER_PROTOCOL != 0 {
-			filter.Protocol = staticFilter.NetworkFilter.GetIPv4().Protocol
-		} else if staticFilter.NetworkFilter.Selector == A.IPV6 && staticFilter.NetworkFilter.GetIPv6().ValidFields&A.IP_V6_FILTER_PROTOCOL != 0 {
-			filter.Protocol = staticFilter.NetworkFilter.GetIPv6().Protocol
 		}
 	}
 
 	// Transport Layer
 	if staticFilter.ValidFields&A.TRANSPORT_LAYER_VALID != 0 {
-		if staticFilter.TransportFilter.Selector == A.TCPUDP {
-			if staticFilter.TransportFilter.GetTCPUDP().ValidFields&A.TCPUDP_SRC_PORT != 0 {
-				filter.SourcePort = [2]uint16{staticFilter.TransportFilter.GetTCPUDP().SourcePort.StartRange, staticFilter.TransportFilter.GetTCPUDP().SourcePort.EndRange}
+		if tcpudp := staticFilter.TransportFilter.GetTCPUDP(); tcpudp != nil {
+			if tcpudp.ValidFields&A.TCPUDP_SRC_PORT != 0 {
+				filter.SourcePort = [2]uint16{tcpudp.SourcePort.StartRange, tcpudp.SourcePort.EndRange}
+			}
+			if tcpudp.ValidFields&A.TCPUDP_DEST_PORT != 0 {
+				filter.DestinationPort = [2]uint16{tcpudp.DestinationPort.StartRange, tcpudp.DestinationPort.EndRange}
+			}
+			if tcpudp.ValidFields&A.TCPUDP_TCP_FLAGS != 0 {
+				filter.TCPFlags = tcpudp.TCPFlags
 			}
-			if staticFilter.TransportFilter.GetTCPUDP().ValidFields&A.TCPUDP_DEST_PORT != 0 {
-				filter.DestinationPort = [2]uint16{staticFilter.TransportFilter.GetTCPUDP().DestinationPort.StartRange, staticFilter.TransportFilter.GetTCPUDP().DestinationPort.EndRange}
+		} else if icmp := staticFilter.TransportFilter.GetICMP(); icmp != nil {
+			if icmp.ValidFields&A.ICMP_TYPE != 0 {
+				typeRange := icmp.TypeRange
+				filter.ICMPType = &typeRange
+			}
+			if icmp.ValidFields&A.ICMP_CODE != 0 {
+				codeRange := icmp.CodeRange
+				filter.ICMPCode = &codeRange
 			}
 		}
 	}
 	filter.StaticFilter = staticFilter
 	return &filter
 }
+
+// ipv4AddressFromRange converts an IPv4 address range to an IPv4Address.
+func ipv4AddressFromRange(r IPRange) A.IPv4Address {
+	var address A.IPv4Address
+	address.SetRange(A.IPv4Range{
+		StartIP: binary.LittleEndian.Uint32(r.Start.To4()),
+		EndIP:   binary.LittleEndian.Uint32(r.End.To4()),
+	})
+	return address
+}
+
+// ipv6AddressFromRange converts an IPv6 address range to an IPv6Address.
+func ipv6AddressFromRange(r IPRange) A.IPv6Address {
+	var rng A.IPv6Range
+	copy(rng.StartIP[:], r.Start.To16())
+	copy(rng.EndIP[:], r.End.To16())
+
+	var address A.IPv6Address
+	address.SetRange(rng)
+	return address
+}
+
+// ipv4AddressToFilter converts an IPv4Address to either a subnet or a range.
+func ipv4AddressToFilter(address *A.IPv4Address) (net.IPNet, IPRange) {
+	if rng := address.GetRange(); rng != nil {
+		start, end := make(net.IP, net.IPv4len), make(net.IP, net.IPv4len)
+		binary.LittleEndian.PutUint32(start, rng.StartIP)
+		binary.LittleEndian.PutUint32(end, rng.EndIP)
+		return net.IPNet{}, IPRange{Start: start, End: end}
+	}
+	return A.IPv4AddressToIPNet(address), IPRange{}
+}
+
+// ipv6AddressToFilter converts an IPv6Address to either a subnet or a range.
+func ipv6AddressToFilter(address *A.IPv6Address) (net.IPNet, IPRange) {
+	if rng := address.GetRange(); rng != nil {
+		return net.IPNet{}, IPRange{
+			Start: append(net.IP(nil), rng.StartIP[:]...),
+			End:   append(net.IP(nil), rng.EndIP[:]...),
+		}
+	}
+	return A.IPv6AddressToIPNet(address), IPRange{}
+}
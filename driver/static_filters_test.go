@@ -0,0 +1,263 @@
+package driver_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	D "github.com/wiresock/ndisapi-go/driver"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+func TestStaticFilters_DriverNotLoaded(t *testing.T) {
+	api := sim.NewDriver()
+	api.SetDriverLoaded(false)
+
+	filters, err := D.NewStaticFilters(api, true, false)
+	assert.ErrorIs(t, err, A.ErrDriverNotLoaded)
+	assert.Nil(t, filters)
+}
+
+func TestStaticFilters_AddAndLoadTable(t *testing.T) {
+	api := sim.NewDriver()
+	handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+	filters, err := D.NewStaticFilters(api, true, false)
+	require.NoError(t, err)
+	defer filters.Close()
+
+	expected := []D.Filter{
+		{
+			AdapterHandle: handle,
+			SourcePort:    [2]uint16{53, 53},
+			Direction:     D.PacketDirectionBoth,
+			Action:        A.FilterActionDrop,
+		},
+		{
+			DestinationMacAddress: net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02},
+			Direction:             D.PacketDirectionOut,
+			Action:                A.FilterActionRedirect,
+		},
+		{
+			DestinationAddress: net.IPNet{IP: net.ParseIP("10.0.0.0").To4(), Mask: net.CIDRMask(8, 32)},
+			Direction:          D.PacketDirectionIn,
+			Action:             A.FilterActionPass,
+		},
+	}
+
+	assert.True(t, filters.AddFilterBack(&expected[1]))
+	assert.True(t, filters.AddFilterBack(&expected[2]))
+	assert.True(t, filters.AddFilterFront(&expected[0]))
+
+	size, err := api.GetPacketFilterTableSize()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), size)
+
+	table, err := filters.LoadTable()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), table.TableSize)
+
+	require.Len(t, filters.Filters, len(expected))
+	for i := range expected {
+		assert.True(t, filters.Filters[i].Equal(&expected[i]), "filter %d", i)
+		assert.True(t, filters.Contains(&expected[i]), "filter %d", i)
+	}
+
+	assert.True(t, filters.RemoveFilter(0))
+	assert.False(t, filters.Contains(&expected[0]))
+
+	size, err = api.GetPacketFilterTableSize()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), size)
+}
+
+// failingTableSizeDriver fails to report the size of the static filter table.
+type failingTableSizeDriver struct {
+	*sim.Driver
+}
+
+func (d *failingTableSizeDriver) GetPacketFilterTableSize() (uint32, error) {
+	return 0, A.ErrDriverNotLoaded
+}
+
+func TestStaticFilters_LoadTableEmpty(t *testing.T) {
+	api := sim.NewDriver()
+	filters, err := D.NewStaticFilters(api, true, false)
+	require.NoError(t, err)
+	defer filters.Close()
+
+	// An empty table is not an error
+	filters.Filters, err = D.ParseRules("drop tcp\n", nil)
+	require.NoError(t, err)
+	table, err := filters.LoadTable()
+	require.NoError(t, err)
+	assert.Zero(t, table.TableSize)
+	assert.Empty(t, filters.Filters)
+
+	// A failure to query the table is
+	filters, err = D.NewStaticFilters(&failingTableSizeDriver{api}, true, false)
+	require.NoError(t, err)
+	_, err = filters.LoadTable()
+	assert.ErrorIs(t, err, A.ErrDriverNotLoaded)
+}
+
+func TestStaticFilters_OldDriver(t *testing.T) {
+	api := sim.NewDriver()
+	// The driver has neither the caches nor the table editing by index
+	api.SetVersion(uint32(A.NewDriverVersion(3, 2, 29)))
+
+	filters, err := D.NewStaticFilters(api, true, true)
+	require.NoError(t, err)
+	defer filters.Close()
+
+	rules, err := D.ParseRules("drop tcp\npass udp\ndrop icmp\n", nil)
+	require.NoError(t, err)
+
+	// The changes store the whole table instead
+	assert.True(t, filters.AddFilterBack(&rules[0]))
+	assert.True(t, filters.AddFilterFront(&rules[1]))
+	assert.True(t, filters.InsertFilter(&rules[2], 1))
+	assert.True(t, filters.RemoveFilter(0))
+	assert.Equal(t, []D.Filter{rules[2], rules[0]}, filters.Filters)
+
+	require.NoError(t, filters.Apply(rules))
+	assert.Equal(t, rules, filters.Filters)
+	drifted, err := filters.Drifted()
+	require.NoError(t, err)
+	assert.False(t, drifted)
+
+	size, err := api.GetPacketFilterTableSize()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), size)
+}
+
+func TestStaticFilters_RemoveFiltersIf(t *testing.T) {
+	api := sim.NewDriver()
+	filters, err := D.NewStaticFilters(api, true, false)
+	require.NoError(t, err)
+	defer filters.Close()
+
+	rules, err := D.ParseRules("drop tcp\npass udp\ndrop icmp\ndrop tcp to any port 80\npass both\n", nil)
+	require.NoError(t, err)
+	for i := range rules {
+		require.True(t, filters.AddFilterBack(&rules[i]))
+	}
+
+	filters.RemoveFiltersIf(func(filter *D.Filter) bool { return filter.Action == A.FilterActionDrop })
+
+	assert.Equal(t, "pass both udp\npass both\n", D.FormatRules(filters.Filters, nil))
+	drifted, err := filters.Drifted()
+	require.NoError(t, err)
+	assert.False(t, drifted)
+}
+
+// recordingDriver counts the static filter table changes and fails the insertion after failAfter of them.
+type recordingDriver struct {
+	*sim.Driver
+	changes   int
+	failAfter int
+}
+
+func (d *recordingDriver) InsertStaticFilter(filter *A.StaticFilter, position uint32) error {
+	if d.failAfter > 0 && d.changes == d.failAfter {
+		return sim.ErrInvalidParameter
+	}
+	d.changes++
+	return d.Driver.InsertStaticFilter(filter, position)
+}
+
+func (d *recordingDriver) RemoveStaticFilter(filterID uint32) error {
+	d.changes++
+	return d.Driver.RemoveStaticFilter(filterID)
+}
+
+func TestStaticFilters_Apply(t *testing.T) {
+	api := &recordingDriver{Driver: sim.NewDriver()}
+	filters, err := D.NewStaticFilters(api, true, false)
+	require.NoError(t, err)
+	defer filters.Close()
+
+	initial, err := D.ParseRules("drop tcp\npass udp\ndrop icmp\npass both\n", nil)
+	require.NoError(t, err)
+	require.NoError(t, filters.Apply(initial))
+	assert.Equal(t, 4, api.changes)
+
+	// One filter removed and one inserted, the others are left in place along with their counters.
+	api.changes = 0
+	desired, err := D.ParseRules("drop tcp\ndrop icmp\ndrop out udp\npass both\n", nil)
+	require.NoError(t, err)
+	require.NoError(t, filters.Apply(desired))
+	assert.Equal(t, 2, api.changes)
+
+	filters.Filters = nil
+	_, err = filters.LoadTable()
+	require.NoError(t, err)
+	assert.Equal(t, D.FormatRules(desired, nil), D.FormatRules(filters.Filters, nil))
+
+	api.changes = 0
+	require.NoError(t, filters.Apply(desired))
+	assert.Equal(t, 0, api.changes)
+
+	require.NoError(t, filters.Apply(nil))
+	size, err := api.GetPacketFilterTableSize()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), size)
+	assert.Empty(t, filters.Filters)
+}
+
+func TestStaticFilters_ApplyRollback(t *testing.T) {
+	api := &recordingDriver{Driver: sim.NewDriver()}
+	filters, err := D.NewStaticFilters(api, true, false)
+	require.NoError(t, err)
+	defer filters.Close()
+
+	initial, err := D.ParseRules("drop tcp\npass udp\n", nil)
+	require.NoError(t, err)
+	require.NoError(t, filters.Apply(initial))
+
+	// The removal of udp and the first insertion succeed, the second insertion fails.
+	api.changes, api.failAfter = 0, 2
+	desired, err := D.ParseRules("drop icmp\ndrop tcp\npass both\n", nil)
+	require.NoError(t, err)
+	assert.Error(t, filters.Apply(desired))
+
+	assert.Equal(t, D.FormatRules(initial, nil), D.FormatRules(filters.Filters, nil))
+	drifted, err := filters.Drifted()
+	require.NoError(t, err)
+	assert.False(t, drifted)
+
+	assert.Error(t, filters.Apply([]D.Filter{{SourceMacAddress: net.HardwareAddr{1}}}))
+	assert.Equal(t, D.FormatRules(initial, nil), D.FormatRules(filters.Filters, nil))
+}
+
+func TestStaticFilters_Reconcile(t *testing.T) {
+	api := sim.NewDriver()
+	filters, err := D.NewStaticFilters(api, true, false)
+	require.NoError(t, err)
+	defer filters.Close()
+
+	rules, err := D.ParseRules("drop tcp\npass udp\n", nil)
+	require.NoError(t, err)
+	require.NoError(t, filters.Apply(rules))
+
+	drifted, err := filters.Drifted()
+	require.NoError(t, err)
+	assert.False(t, drifted)
+
+	// Another process removes a filter and adds its own.
+	require.NoError(t, api.RemoveStaticFilter(0))
+	require.NoError(t, api.AddStaticFilterBack(&A.StaticFilter{DirectionFlags: A.PACKET_FLAG_ON_SEND, FilterAction: A.FILTER_PACKET_DROP}))
+
+	drifted, err = filters.Drifted()
+	require.NoError(t, err)
+	assert.True(t, drifted)
+
+	require.NoError(t, filters.Reconcile())
+	drifted, err = filters.Drifted()
+	require.NoError(t, err)
+	assert.False(t, drifted)
+	assert.Equal(t, D.FormatRules(rules, nil), D.FormatRules(filters.Filters, nil))
+}
@@ -0,0 +1,223 @@
+package driver_test
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	D "github.com/wiresock/ndisapi-go/driver"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+// Incoming packets are addressed to inPortBase+verdict and outgoing packets to outPortBase+verdict,
+// so the filter callbacks can derive the verdict from the destination port.
+const (
+	inPortBase  = 1000
+	outPortBase = 2000
+)
+
+var verdicts = []A.FilterAction{
+	A.FilterActionPass,
+	A.FilterActionDrop,
+	A.FilterActionRedirect,
+	A.FilterActionPassRedirect,
+	A.FilterActionDropRedirect,
+}
+
+type filterFunc = func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction
+
+// testFilter is the part of the packet filter API shared by all pipelines.
+type testFilter interface {
+	D.PacketFilter
+	SetListener(listen D.ListenFunc)
+	SetContextFilter(in, out D.ContextFilterFunc)
+	SetLogger(logger A.Logger)
+	SetErrorHandler(handler D.ErrorFunc)
+}
+
+// verdictPipeline creates and starts a packet filter on the first adapter of the simulated driver.
+type verdictPipeline struct {
+	name     string
+	requires A.DriverVersion // the oldest driver supporting the filter, zero for all of them
+	create   func(ctx context.Context, api A.NdisApiInterface, adapters *A.TcpAdapterList, in, out filterFunc) (testFilter, error)
+	start    func(filter testFilter) error
+}
+
+var verdictPipelines = []verdictPipeline{
+	{
+		name: "SimplePacketFilter",
+		create: func(ctx context.Context, api A.NdisApiInterface, adapters *A.TcpAdapterList, in, out filterFunc) (testFilter, error) {
+			return D.NewSimplePacketFilter(ctx, api, adapters, in, out)
+		},
+		start: func(filter testFilter) error {
+			return filter.(*D.SimplePacketFilter).StartFilter(0)
+		},
+	},
+	{
+		name: "QueuedPacketFilter",
+		create: func(ctx context.Context, api A.NdisApiInterface, adapters *A.TcpAdapterList, in, out filterFunc) (testFilter, error) {
+			return D.NewQueuedPacketFilter(ctx, api, adapters, in, out)
+		},
+		start: func(filter testFilter) error {
+			return filter.(*D.QueuedPacketFilter).StartFilter(0)
+		},
+	},
+	{
+		name: "QueuedPacketFilterWorkers",
+		create: func(ctx context.Context, api A.NdisApiInterface, adapters *A.TcpAdapterList, in, out filterFunc) (testFilter, error) {
+			filter, err := D.NewQueuedPacketFilter(ctx, api, adapters, in, out)
+			if err != nil {
+				return nil, err
+			}
+			return filter, filter.SetQueuedConfig(D.QueuedConfig{Workers: 4})
+		},
+		start: func(filter testFilter) error {
+			return filter.(*D.QueuedPacketFilter).StartFilter(0)
+		},
+	},
+	{
+		name:     "FastIOPacketFilter",
+		requires: A.SecondaryFastIODriverVersion,
+		create: func(ctx context.Context, api A.NdisApiInterface, adapters *A.TcpAdapterList, in, out filterFunc) (testFilter, error) {
+			return D.NewFastIOPacketFilter(ctx, api, adapters, in, out, true)
+		},
+		start: func(filter testFilter) error {
+			return filter.(*D.FastIOPacketFilter).StartFilter(0)
+		},
+	},
+	{
+		name:     "QueuedMultiInterfacePacketFilter",
+		requires: A.UnsortedIODriverVersion,
+		create: func(ctx context.Context, api A.NdisApiInterface, adapters *A.TcpAdapterList, in, out filterFunc) (testFilter, error) {
+			return D.NewQueuedMultiInterfacePacketFilter(ctx, api, adapters, withoutTarget(in), withoutTarget(out))
+		},
+		start: func(filter testFilter) error {
+			return filter.(*D.QueuedMultiInterfacePacketFilter).StartFilter(0)
+		},
+	},
+	{
+		name:     "FastIOMultiInterfacePacketFilter",
+		requires: A.SecondaryFastIODriverVersion,
+		create: func(ctx context.Context, api A.NdisApiInterface, adapters *A.TcpAdapterList, in, out filterFunc) (testFilter, error) {
+			return D.NewFastIOMultiInterfacePacketFilter(ctx, api, adapters, withoutTarget(in), withoutTarget(out))
+		},
+		start: func(filter testFilter) error {
+			return filter.(*D.FastIOMultiInterfacePacketFilter).StartFilter(0)
+		},
+	},
+}
+
+// withoutTarget adapts a single interface filter callback to the multi-interface filters.
+func withoutTarget(filter filterFunc) D.PacketFilterFunc {
+	if filter == nil {
+		return nil
+	}
+	return func(handle A.Handle, buffer *A.IntermediateBuffer) (A.FilterAction, *A.Handle) {
+		return filter(handle, buffer), nil
+	}
+}
+
+// startPipeline starts the packet filter and waits for its working threads to run.
+func startPipeline(pipeline verdictPipeline, filter testFilter) error {
+	if err := pipeline.start(filter); err != nil {
+		return err
+	}
+	waitRunning(filter)
+	return nil
+}
+
+// waitRunning waits for the filter working threads to start.
+func waitRunning(filter D.PacketFilter) {
+	for i := 0; i < 1000 && filter.GetFilterState() != D.FilterStateRunning; i++ {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// ports returns the sorted UDP destination ports of the packets.
+func ports(packets []sim.Packet) []int {
+	result := make([]int, 0, len(packets))
+	for _, packet := range packets {
+		result = append(result, int(binary.BigEndian.Uint16(packet.Data[14+20+2:])))
+	}
+	sort.Ints(result)
+	return result
+}
+
+func TestPacketFilter_VerdictConformance(t *testing.T) {
+	for _, pipeline := range verdictPipelines {
+		pipeline := pipeline
+		t.Run(pipeline.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			api := sim.NewDriver()
+			handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+			adapters, err := api.GetTcpipBoundAdaptersInfo()
+			require.NoError(t, err)
+
+			verdict := func(handle A.Handle, buffer *A.IntermediateBuffer) A.FilterAction {
+				return A.FilterAction(dstPort(buffer) % 1000)
+			}
+
+			var mu sync.Mutex
+			var listened []int
+			listen := func(handle A.Handle, buffer *A.IntermediateBuffer) {
+				mu.Lock()
+				defer mu.Unlock()
+				listened = append(listened, int(dstPort(buffer)))
+			}
+
+			filter, err := pipeline.create(ctx, api, adapters, verdict, verdict)
+			require.NoError(t, err)
+			filter.SetListener(listen)
+			require.NoError(t, startPipeline(pipeline, filter))
+
+			for _, action := range verdicts {
+				require.NoError(t, api.ReceiveFromNetwork(handle, udpFrame(inPortBase+uint16(action))))
+				require.NoError(t, api.SendFromStack(handle, udpFrame(outPortBase+uint16(action))))
+			}
+
+			waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+			defer waitCancel()
+			require.NoError(t, api.WaitDelivered(waitCtx, 6))
+			require.Eventually(t, func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(listened) == 4
+			}, 5*time.Second, time.Millisecond)
+
+			require.NoError(t, filter.Close())
+
+			// Pass and PassRedirect keep the direction, Redirect reverses it, Drop and DropRedirect discard the packet.
+			assert.Equal(t, []int{
+				inPortBase + int(A.FilterActionPass),
+				inPortBase + int(A.FilterActionPassRedirect),
+				outPortBase + int(A.FilterActionRedirect),
+			}, ports(api.StackPackets(handle)))
+			assert.Equal(t, []int{
+				inPortBase + int(A.FilterActionRedirect),
+				outPortBase + int(A.FilterActionPass),
+				outPortBase + int(A.FilterActionPassRedirect),
+			}, ports(api.WirePackets(handle)))
+
+			// Listen-mode verdicts hand a copy to the listener.
+			mu.Lock()
+			sort.Ints(listened)
+			assert.Equal(t, []int{
+				inPortBase + int(A.FilterActionPassRedirect),
+				inPortBase + int(A.FilterActionDropRedirect),
+				outPortBase + int(A.FilterActionPassRedirect),
+				outPortBase + int(A.FilterActionDropRedirect),
+			}, listened)
+			mu.Unlock()
+		})
+	}
+}
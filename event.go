@@ -0,0 +1,201 @@
+package ndisapi
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// WaitInfinite makes Event.WaitContext wait until the event is signaled or the context is done.
+const WaitInfinite time.Duration = -1
+
+var (
+	// ErrWaitTimeout is returned by Event.WaitContext when the timeout elapses before the event is signaled.
+	ErrWaitTimeout = errors.New("wait timed out")
+	// ErrEventClosed is returned when an operation is performed on a closed event.
+	ErrEventClosed = errors.New("event is closed")
+)
+
+// Event is a manual-reset waitable event which can be submitted to the driver
+// to be signaled when packets are queued or the adapter list changes.
+type Event interface {
+	// Signal sets the event to a signaled state.
+	Signal() error
+	// Reset sets the event to a non-signaled state.
+	Reset() error
+	// WaitContext waits until the event is signaled, the timeout elapses or the context is done.
+	// A negative timeout (WaitInfinite) waits without a time limit.
+	WaitContext(ctx context.Context, timeout time.Duration) error
+	// Handle returns the handle submitted to the driver.
+	Handle() EventHandle
+	// Close releases the event.
+	Close() error
+}
+
+var _ Event = (*ChanEvent)(nil)
+
+// ChanEvent is a channel based Event implementation.
+//
+// Its handle is a pseudo handle which is only understood by SignalEventHandle,
+// so it is meant to be used with drivers signaling events through it, e.g. sim.Driver.
+type ChanEvent struct {
+	mu       sync.Mutex
+	signaled chan struct{}
+	set      bool
+	closed   bool
+	handle   EventHandle
+}
+
+// chanEvents maps the pseudo handles to the channel based events.
+var chanEvents = struct {
+	sync.Mutex
+	next   EventHandle
+	events map[EventHandle]*ChanEvent
+}{
+	next:   0x40000000,
+	events: make(map[EventHandle]*ChanEvent),
+}
+
+// NewChanEvent creates a non-signaled channel based event.
+func NewChanEvent() *ChanEvent {
+	event := &ChanEvent{
+		signaled: make(chan struct{}),
+	}
+
+	chanEvents.Lock()
+	defer chanEvents.Unlock()
+
+	event.handle = chanEvents.next
+	chanEvents.next += 4
+	chanEvents.events[event.handle] = event
+
+	return event
+}
+
+// Signal sets the event to a signaled state and releases all waiters.
+func (e *ChanEvent) Signal() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return ErrEventClosed
+	}
+	if !e.set {
+		close(e.signaled)
+		e.set = true
+	}
+
+	return nil
+}
+
+// Reset sets the event to a non-signaled state.
+func (e *ChanEvent) Reset() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return ErrEventClosed
+	}
+	if e.set {
+		e.signaled = make(chan struct{})
+		e.set = false
+	}
+
+	return nil
+}
+
+// WaitContext waits until the event is signaled, the timeout elapses or the context is done.
+func (e *ChanEvent) WaitContext(ctx context.Context, timeout time.Duration) error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return ErrEventClosed
+	}
+	if e.set {
+		e.mu.Unlock()
+		return nil
+	}
+	signaled := e.signaled
+	e.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-signaled:
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.closed {
+			return ErrEventClosed
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-expired:
+		return ErrWaitTimeout
+	}
+}
+
+// Handle returns the pseudo handle of the event.
+func (e *ChanEvent) Handle() EventHandle {
+	return e.handle
+}
+
+// Close releases the pending waiters and unregisters the pseudo handle.
+func (e *ChanEvent) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	if !e.set {
+		close(e.signaled)
+		e.set = true
+	}
+
+	chanEvents.Lock()
+	delete(chanEvents.events, e.handle)
+	chanEvents.Unlock()
+
+	return nil
+}
+
+// SignalEventHandle sets the event identified by the handle to a signaled state.
+// Pseudo handles of channel based events are resolved first, other handles are
+// signaled as native events where the platform supports them.
+func SignalEventHandle(handle EventHandle) error {
+	if handle == 0 {
+		return nil
+	}
+
+	chanEvents.Lock()
+	event, ok := chanEvents.events[handle]
+	chanEvents.Unlock()
+
+	if ok {
+		return event.Signal()
+	}
+
+	return signalNativeEvent(handle)
+}
+
+// CloseEventHandle closes the channel based event identified by the pseudo handle, so its waits fail
+// with ErrEventClosed. Native events are closed by their Event only.
+func CloseEventHandle(handle EventHandle) error {
+	chanEvents.Lock()
+	event, ok := chanEvents.events[handle]
+	chanEvents.Unlock()
+
+	if !ok {
+		return errors.New("invalid handle")
+	}
+
+	return event.Close()
+}
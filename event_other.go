@@ -0,0 +1,18 @@
+//go:build !windows
+
+package ndisapi
+
+import (
+	"errors"
+)
+
+// NewEvent creates a non-signaled manual-reset event. There are no native events
+// on this platform, so a channel based event is returned.
+func NewEvent() (Event, error) {
+	return NewChanEvent(), nil
+}
+
+// signalNativeEvent fails as there are no native events to signal on this platform.
+func signalNativeEvent(handle EventHandle) error {
+	return errors.New("invalid handle")
+}
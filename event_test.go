@@ -0,0 +1,78 @@
+package ndisapi_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wiresock/ndisapi-go"
+)
+
+func TestChanEvent_SignalWaitReset(t *testing.T) {
+	event := ndisapi.NewChanEvent()
+	defer event.Close()
+
+	assert.NotZero(t, event.Handle())
+	assert.Equal(t, ndisapi.ErrWaitTimeout, event.WaitContext(context.Background(), 10*time.Millisecond))
+
+	require.NoError(t, event.Signal())
+	assert.NoError(t, event.WaitContext(context.Background(), 0))
+	assert.NoError(t, event.WaitContext(context.Background(), ndisapi.WaitInfinite), "manual-reset event stays signaled")
+
+	require.NoError(t, event.Reset())
+	assert.Equal(t, ndisapi.ErrWaitTimeout, event.WaitContext(context.Background(), 0))
+}
+
+func TestChanEvent_WaitContextCanceled(t *testing.T) {
+	event := ndisapi.NewChanEvent()
+	defer event.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	assert.Equal(t, context.Canceled, event.WaitContext(ctx, ndisapi.WaitInfinite))
+}
+
+func TestChanEvent_SignalEventHandle(t *testing.T) {
+	event := ndisapi.NewChanEvent()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- event.WaitContext(context.Background(), ndisapi.WaitInfinite)
+	}()
+
+	require.NoError(t, ndisapi.SignalEventHandle(event.Handle()))
+	assert.NoError(t, <-done)
+
+	require.NoError(t, event.Close())
+	assert.Equal(t, ndisapi.ErrEventClosed, event.Signal())
+	assert.Equal(t, ndisapi.ErrEventClosed, event.WaitContext(context.Background(), 0))
+	assert.NoError(t, ndisapi.SignalEventHandle(0))
+}
+
+func TestCloseEventHandle(t *testing.T) {
+	event := ndisapi.NewChanEvent()
+
+	require.NoError(t, ndisapi.CloseEventHandle(event.Handle()))
+	assert.Equal(t, ndisapi.ErrEventClosed, event.WaitContext(context.Background(), ndisapi.WaitInfinite))
+	assert.Error(t, ndisapi.CloseEventHandle(event.Handle()))
+}
+
+func TestChanEvent_CloseReleasesWaiters(t *testing.T) {
+	event := ndisapi.NewChanEvent()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- event.WaitContext(context.Background(), ndisapi.WaitInfinite)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, event.Close())
+	assert.Equal(t, ndisapi.ErrEventClosed, <-done)
+}
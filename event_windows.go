@@ -0,0 +1,22 @@
+//go:build windows
+
+package ndisapi
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// NewEvent creates a non-signaled manual-reset Win32 event.
+func NewEvent() (Event, error) {
+	handle, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSafeEvent(handle), nil
+}
+
+// signalNativeEvent sets the Win32 event to a signaled state.
+func signalNativeEvent(handle EventHandle) error {
+	return windows.SetEvent(handle)
+}
@@ -114,7 +114,7 @@ func main() {
 func getInputs(api *A.NdisApi, adapters *A.TcpAdapterList) (int, string) {
 	// list adapters
 	for i := range adapters.AdapterCount {
-		adapterName := api.ConvertWindows2000AdapterName(string(adapters.AdapterNameList[i][:]))
+		adapterName := api.ConvertWindows2000AdapterName(adapters.AdapterName(int(i)))
 		fmt.Println(i, "->", adapterName)
 	}
 
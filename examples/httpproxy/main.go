@@ -63,7 +63,7 @@ func main() {
 
 	// list adapters
 	for i := range adapters.AdapterCount {
-		adapterName := api.ConvertWindows2000AdapterName(string(adapters.AdapterNameList[i][:]))
+		adapterName := api.ConvertWindows2000AdapterName(adapters.AdapterName(int(i)))
 		fmt.Println(i, "->", adapterName)
 	}
 
@@ -154,13 +154,11 @@ func main() {
 
 		if redirected {
 			// Swap Ethernet addresses
-			ethernet.SrcMAC, ethernet.DstMAC = ethernet.DstMAC, ethernet.SrcMAC
+			b.SwapMACs()
 			// Swap IP addresses
-			ip.DstIP, ip.SrcIP = ip.SrcIP, ip.DstIP
-			// Change destination port to the transparent proxy
-			tcp.DstPort = layers.TCPPort(port)
-			// Compute checksums & serialize the packet
-			serializePacket(b, packet, ip, tcp)
+			b.SwapIPs()
+			// Change destination port to the transparent proxy, updating the checksum in place
+			b.SetDestinationPort(port)
 
 			log.Printf("[CMS] %s - %s", src.String(), dst.String())
 
@@ -181,14 +179,12 @@ func main() {
 			}
 			mu.Unlock()
 
-			// Redirect the packet back to the original destination
-			tcp.SrcPort = it
+			// Redirect the packet back to the original destination, updating the checksum in place
+			b.SetSourcePort(uint16(it))
 			// Swap Ethernet addresses
-			ethernet.SrcMAC, ethernet.DstMAC = ethernet.DstMAC, ethernet.SrcMAC
+			b.SwapMACs()
 			// Swap IP addresses
-			ip.DstIP, ip.SrcIP = ip.SrcIP, ip.DstIP
-			// Compute checksums & serialize the packet
-			serializePacket(b, packet, ip, tcp)
+			b.SwapIPs()
 
 			log.Printf("[SMC] %s - %s", src.String(), dst.String())
 
@@ -213,24 +209,6 @@ func main() {
 	}
 }
 
-func serializePacket(b *A.IntermediateBuffer, packet gopacket.Packet, ip *layers.IPv4, tcp *layers.TCP) {
-	opts := gopacket.SerializeOptions{
-		FixLengths:       false,
-		ComputeChecksums: true,
-	}
-	tcp.SetNetworkLayerForChecksum(ip)
-	buffer := gopacket.NewSerializeBuffer()
-	err := gopacket.SerializePacket(buffer, opts, packet)
-	if err != nil {
-		fmt.Println(fmt.Errorf("Could not serialize modified packet: %s", err))
-		return
-	}
-
-	serializedBytes := buffer.Bytes()
-	copySize := len(serializedBytes)
-	copy(b.Buffer[:copySize], serializedBytes[:copySize])
-}
-
 func getUserInput(adapters *A.TcpAdapterList) error {
 	reader := bufio.NewReader(os.Stdin)
 
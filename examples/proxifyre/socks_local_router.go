@@ -171,14 +171,11 @@ func NewSocksLocalRouter(api *A.NdisApi, debug bool) (*SocksLocalRouter, error)
 
 				if redirected {
 					// Swap Ethernet addresses
-					ethernet.SrcMAC, ethernet.DstMAC = ethernet.DstMAC, ethernet.SrcMAC
+					b.SwapMACs()
 					// Swap IP addresses
-					ip.DstIP, ip.SrcIP = ip.SrcIP, ip.DstIP
-					// Change destination port to the transparent proxy
-					tcp.DstPort = layers.TCPPort(proxyPort)
-					// Compute checksums & serialize the packet
-					tcp.SetNetworkLayerForChecksum(ip)
-					serializePacket(b, packet)
+					b.SwapIPs()
+					// Change destination port to the transparent proxy, updating the checksum in place
+					b.SetDestinationPort(proxyPort)
 
 					return A.FilterActionRedirect
 				} else if socksLocalRouter.IsTCPProxyPort(tcp.SrcPort) {
@@ -197,15 +194,12 @@ func NewSocksLocalRouter(api *A.NdisApi, debug bool) (*SocksLocalRouter, error)
 					}
 					socksLocalRouter.tcpMutex.Unlock()
 
-					// Redirect the packet back to the original destination
-					tcp.SrcPort = layers.TCPPort(it.DstPort)
+					// Redirect the packet back to the original destination, updating the checksum in place
+					b.SetSourcePort(uint16(it.DstPort))
 					// Swap Ethernet addresses
-					ethernet.SrcMAC, ethernet.DstMAC = ethernet.DstMAC, ethernet.SrcMAC
+					b.SwapMACs()
 					// Swap IP addresses
-					ip.DstIP, ip.SrcIP = ip.SrcIP, ip.DstIP
-					// Compute checksums & serialize the packet
-					tcp.SetNetworkLayerForChecksum(ip)
-					serializePacket(b, packet)
+					b.SwapIPs()
 
 					return A.FilterActionRedirect
 				}
@@ -259,14 +253,11 @@ func NewSocksLocalRouter(api *A.NdisApi, debug bool) (*SocksLocalRouter, error)
 
 				if redirected {
 					// Swap Ethernet addresses
-					ethernet.SrcMAC, ethernet.DstMAC = ethernet.DstMAC, ethernet.SrcMAC
+					b.SwapMACs()
 					// Swap IP addresses
-					ip.DstIP, ip.SrcIP = ip.SrcIP, ip.DstIP
-					// Change destination port to the transparent proxy
-					udp.DstPort = layers.UDPPort(proxyPort)
-					// Compute checksums & serialize the packet
-					udp.SetNetworkLayerForChecksum(ip)
-					serializePacket(b, packet)
+					b.SwapIPs()
+					// Change destination port to the transparent proxy, updating the checksum in place
+					b.SetDestinationPort(proxyPort)
 
 					return A.FilterActionRedirect
 				} else if socksLocalRouter.IsUDPProxyPort(udp.SrcPort) {
@@ -280,15 +271,12 @@ func NewSocksLocalRouter(api *A.NdisApi, debug bool) (*SocksLocalRouter, error)
 					}
 					socksLocalRouter.udpMutex.Unlock()
 
-					// Redirect the packet back to the original destination
-					udp.SrcPort = layers.UDPPort(it.DstPort)
+					// Redirect the packet back to the original destination, updating the checksum in place
+					b.SetSourcePort(uint16(it.DstPort))
 					// Swap Ethernet addresses
-					ethernet.SrcMAC, ethernet.DstMAC = ethernet.DstMAC, ethernet.SrcMAC
+					b.SwapMACs()
 					// Swap IP addresses
-					ip.DstIP, ip.SrcIP = ip.SrcIP, ip.DstIP
-					// Compute checksums & serialize the packet
-					udp.SetNetworkLayerForChecksum(ip)
-					serializePacket(b, packet)
+					b.SwapIPs()
 
 					return A.FilterActionRedirect
 				}
@@ -427,8 +415,8 @@ func (s *SocksLocalRouter) AddSocks5Proxy(endpoint *string) (int, error) {
 	s.staticFilter.AddFilterBack(&D.Filter{
 		Action:             A.FilterActionPass,
 		Direction:          D.PacketDirectionIn,
-		SourceAddress:      net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
-		DestinationAddress: net.IPNet{IP: endpointIP.IP, Mask: net.CIDRMask(32, 32)},
+		SourceAddress:      net.IPNet{IP: endpointIP.IP, Mask: net.CIDRMask(32, 32)},
+		DestinationAddress: net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
 		Protocol:           syscall.IPPROTO_TCP,
 		SourcePort:         [2]uint16{uint16(endpointPort), uint16(endpointPort)},
 	})
@@ -445,8 +433,8 @@ func (s *SocksLocalRouter) AddSocks5Proxy(endpoint *string) (int, error) {
 	s.staticFilter.AddFilterBack(&D.Filter{
 		Action:             A.FilterActionPass,
 		Direction:          D.PacketDirectionIn,
-		SourceAddress:      net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
-		DestinationAddress: net.IPNet{IP: endpointIP.IP, Mask: net.CIDRMask(32, 32)},
+		SourceAddress:      net.IPNet{IP: endpointIP.IP, Mask: net.CIDRMask(32, 32)},
+		DestinationAddress: net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
 		Protocol:           syscall.IPPROTO_UDP,
 		SourcePort:         [2]uint16{uint16(endpointPort), uint16(endpointPort)},
 	})
@@ -615,23 +603,6 @@ func (s *SocksLocalRouter) ipInterfaceChangedCallback(callerContext uintptr, row
 	return 0
 }
 
-func serializePacket(b *A.IntermediateBuffer, packet gopacket.Packet) {
-	opts := gopacket.SerializeOptions{
-		FixLengths:       false,
-		ComputeChecksums: true,
-	}
-	buffer := gopacket.NewSerializeBuffer()
-	err := gopacket.SerializePacket(buffer, opts, packet)
-	if err != nil {
-		fmt.Println(fmt.Errorf("Could not serialize modified packet: %s", err))
-		return
-	}
-
-	serializedBytes := buffer.Bytes()
-	copySize := len(serializedBytes)
-	copy(b.Buffer[:copySize], serializedBytes[:copySize])
-}
-
 // parseEndpoint parses an endpoint string into an IP address and port.
 func parseEndpoint(endpoint string) (*net.IPAddr, uint16, error) {
 	pos := strings.LastIndex(endpoint, ":")
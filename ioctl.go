@@ -1,5 +1,3 @@
-//go:build windows
-
 package ndisapi
 
 // IOCTL Codes For NDIS Packet redirect Driver
@@ -0,0 +1,26 @@
+package ndisapi
+
+// Logger receives the diagnostics of the library. Its methods match those of *slog.Logger, so
+// a *slog.Logger can be used directly. The args alternate the attribute keys and values.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// NopLogger discards the diagnostics, it is the logger used when none is set.
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, args ...interface{}) {}
+func (NopLogger) Info(msg string, args ...interface{})  {}
+func (NopLogger) Warn(msg string, args ...interface{})  {}
+func (NopLogger) Error(msg string, args ...interface{}) {}
+
+// LoggerOrNop returns the logger, or a NopLogger if it is nil.
+func LoggerOrNop(logger Logger) Logger {
+	if logger == nil {
+		return NopLogger{}
+	}
+	return logger
+}
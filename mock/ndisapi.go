@@ -10,7 +10,6 @@ import (
 
 	gomock "github.com/golang/mock/gomock"
 	ndisapi "github.com/wiresock/ndisapi-go"
-	windows "golang.org/x/sys/windows"
 )
 
 // MockNdisApiInterface is a mock of NdisApiInterface interface.
@@ -50,6 +49,20 @@ func (mr *MockNdisApiInterfaceMockRecorder) AddSecondaryFastIo(fastIo, size inte
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddSecondaryFastIo", reflect.TypeOf((*MockNdisApiInterface)(nil).AddSecondaryFastIo), fastIo, size)
 }
 
+// AddSecondaryFastIoErr mocks base method.
+func (m *MockNdisApiInterface) AddSecondaryFastIoErr(fastIo *ndisapi.InitializeFastIOSection, size uint32) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddSecondaryFastIoErr", fastIo, size)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddSecondaryFastIoErr indicates an expected call of AddSecondaryFastIoErr.
+func (mr *MockNdisApiInterfaceMockRecorder) AddSecondaryFastIoErr(fastIo, size interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddSecondaryFastIoErr", reflect.TypeOf((*MockNdisApiInterface)(nil).AddSecondaryFastIoErr), fastIo, size)
+}
+
 // AddStaticFilterBack mocks base method.
 func (m *MockNdisApiInterface) AddStaticFilterBack(filter *ndisapi.StaticFilter) error {
 	m.ctrl.T.Helper()
@@ -105,7 +118,7 @@ func (mr *MockNdisApiInterfaceMockRecorder) ConvertWindows2000AdapterName(adapte
 }
 
 // DeviceIoControl mocks base method.
-func (m *MockNdisApiInterface) DeviceIoControl(service uint32, in unsafe.Pointer, sizeIn uint32, out unsafe.Pointer, sizeOut uint32, SizeRet *uint32, overlapped *windows.Overlapped) error {
+func (m *MockNdisApiInterface) DeviceIoControl(service uint32, in unsafe.Pointer, sizeIn uint32, out unsafe.Pointer, sizeOut uint32, SizeRet *uint32, overlapped *ndisapi.Overlapped) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "DeviceIoControl", service, in, sizeIn, out, sizeOut, SizeRet, overlapped)
 	ret0, _ := ret[0].(error)
@@ -216,6 +229,21 @@ func (mr *MockNdisApiInterfaceMockRecorder) GetAdapterPacketQueueSize(adapter, s
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAdapterPacketQueueSize", reflect.TypeOf((*MockNdisApiInterface)(nil).GetAdapterPacketQueueSize), adapter, size)
 }
 
+// GetInterfaceIndex mocks base method.
+func (m *MockNdisApiInterface) GetInterfaceIndex(adapterName string) (uint32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetInterfaceIndex", adapterName)
+	ret0, _ := ret[0].(uint32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetInterfaceIndex indicates an expected call of GetInterfaceIndex.
+func (mr *MockNdisApiInterfaceMockRecorder) GetInterfaceIndex(adapterName interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInterfaceIndex", reflect.TypeOf((*MockNdisApiInterface)(nil).GetInterfaceIndex), adapterName)
+}
+
 // GetIntermediateBufferPoolSize mocks base method.
 func (m *MockNdisApiInterface) GetIntermediateBufferPoolSize(size uint32) error {
 	m.ctrl.T.Helper()
@@ -275,6 +303,21 @@ func (mr *MockNdisApiInterfaceMockRecorder) GetPacketFilterTableSize() *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPacketFilterTableSize", reflect.TypeOf((*MockNdisApiInterface)(nil).GetPacketFilterTableSize))
 }
 
+// GetRasLinks mocks base method.
+func (m *MockNdisApiInterface) GetRasLinks(adapter ndisapi.Handle) (*ndisapi.RASLinks, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRasLinks", adapter)
+	ret0, _ := ret[0].(*ndisapi.RASLinks)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRasLinks indicates an expected call of GetRasLinks.
+func (mr *MockNdisApiInterfaceMockRecorder) GetRasLinks(adapter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRasLinks", reflect.TypeOf((*MockNdisApiInterface)(nil).GetRasLinks), adapter)
+}
+
 // GetTcpipBoundAdaptersInfo mocks base method.
 func (m *MockNdisApiInterface) GetTcpipBoundAdaptersInfo() (*ndisapi.TcpAdapterList, error) {
 	m.ctrl.T.Helper()
@@ -319,6 +362,20 @@ func (mr *MockNdisApiInterfaceMockRecorder) InitializeFastIo(pFastIo, dwSize int
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InitializeFastIo", reflect.TypeOf((*MockNdisApiInterface)(nil).InitializeFastIo), pFastIo, dwSize)
 }
 
+// InitializeFastIoErr mocks base method.
+func (m *MockNdisApiInterface) InitializeFastIoErr(fastIo *ndisapi.InitializeFastIOSection, size uint32) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InitializeFastIoErr", fastIo, size)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// InitializeFastIoErr indicates an expected call of InitializeFastIoErr.
+func (mr *MockNdisApiInterfaceMockRecorder) InitializeFastIoErr(fastIo, size interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InitializeFastIoErr", reflect.TypeOf((*MockNdisApiInterface)(nil).InitializeFastIoErr), fastIo, size)
+}
+
 // InsertStaticFilter mocks base method.
 func (m *MockNdisApiInterface) InsertStaticFilter(filter *ndisapi.StaticFilter, position uint32) error {
 	m.ctrl.T.Helper()
@@ -417,6 +474,35 @@ func (mr *MockNdisApiInterfaceMockRecorder) IsWindows10OrGreater() *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsWindows10OrGreater", reflect.TypeOf((*MockNdisApiInterface)(nil).IsWindows10OrGreater))
 }
 
+// NdisGetRequest mocks base method.
+func (m *MockNdisApiInterface) NdisGetRequest(adapter ndisapi.Handle, oid uint32, data []byte) (uint32, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NdisGetRequest", adapter, oid, data)
+	ret0, _ := ret[0].(uint32)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NdisGetRequest indicates an expected call of NdisGetRequest.
+func (mr *MockNdisApiInterfaceMockRecorder) NdisGetRequest(adapter, oid, data interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NdisGetRequest", reflect.TypeOf((*MockNdisApiInterface)(nil).NdisGetRequest), adapter, oid, data)
+}
+
+// NdisSetRequest mocks base method.
+func (m *MockNdisApiInterface) NdisSetRequest(adapter ndisapi.Handle, oid uint32, data []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NdisSetRequest", adapter, oid, data)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NdisSetRequest indicates an expected call of NdisSetRequest.
+func (mr *MockNdisApiInterfaceMockRecorder) NdisSetRequest(adapter, oid, data interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NdisSetRequest", reflect.TypeOf((*MockNdisApiInterface)(nil).NdisSetRequest), adapter, oid, data)
+}
+
 // ReadPacket mocks base method.
 func (m *MockNdisApiInterface) ReadPacket(packet *ndisapi.EtherRequest) bool {
 	m.ctrl.T.Helper()
@@ -431,6 +517,20 @@ func (mr *MockNdisApiInterfaceMockRecorder) ReadPacket(packet interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadPacket", reflect.TypeOf((*MockNdisApiInterface)(nil).ReadPacket), packet)
 }
 
+// ReadPacketErr mocks base method.
+func (m *MockNdisApiInterface) ReadPacketErr(packet *ndisapi.EtherRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadPacketErr", packet)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReadPacketErr indicates an expected call of ReadPacketErr.
+func (mr *MockNdisApiInterfaceMockRecorder) ReadPacketErr(packet interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadPacketErr", reflect.TypeOf((*MockNdisApiInterface)(nil).ReadPacketErr), packet)
+}
+
 // ReadPackets mocks base method.
 func (m *MockNdisApiInterface) ReadPackets(packet *ndisapi.EtherMultiRequest) bool {
 	m.ctrl.T.Helper()
@@ -445,6 +545,20 @@ func (mr *MockNdisApiInterfaceMockRecorder) ReadPackets(packet interface{}) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadPackets", reflect.TypeOf((*MockNdisApiInterface)(nil).ReadPackets), packet)
 }
 
+// ReadPacketsErr mocks base method.
+func (m *MockNdisApiInterface) ReadPacketsErr(packet *ndisapi.EtherMultiRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadPacketsErr", packet)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReadPacketsErr indicates an expected call of ReadPacketsErr.
+func (mr *MockNdisApiInterfaceMockRecorder) ReadPacketsErr(packet interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadPacketsErr", reflect.TypeOf((*MockNdisApiInterface)(nil).ReadPacketsErr), packet)
+}
+
 // ReadPacketsUnsorted mocks base method.
 func (m *MockNdisApiInterface) ReadPacketsUnsorted(packets []*ndisapi.IntermediateBuffer, dwPacketsNum uint32, pdwPacketsSuccess *uint32) bool {
 	m.ctrl.T.Helper()
@@ -459,6 +573,20 @@ func (mr *MockNdisApiInterfaceMockRecorder) ReadPacketsUnsorted(packets, dwPacke
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadPacketsUnsorted", reflect.TypeOf((*MockNdisApiInterface)(nil).ReadPacketsUnsorted), packets, dwPacketsNum, pdwPacketsSuccess)
 }
 
+// ReadPacketsUnsortedErr mocks base method.
+func (m *MockNdisApiInterface) ReadPacketsUnsortedErr(packets []*ndisapi.IntermediateBuffer, packetsNum uint32, packetsSuccess *uint32) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadPacketsUnsortedErr", packets, packetsNum, packetsSuccess)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReadPacketsUnsortedErr indicates an expected call of ReadPacketsUnsortedErr.
+func (mr *MockNdisApiInterfaceMockRecorder) ReadPacketsUnsortedErr(packets, packetsNum, packetsSuccess interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadPacketsUnsortedErr", reflect.TypeOf((*MockNdisApiInterface)(nil).ReadPacketsUnsortedErr), packets, packetsNum, packetsSuccess)
+}
+
 // RemoveStaticFilter mocks base method.
 func (m *MockNdisApiInterface) RemoveStaticFilter(filterID uint32) error {
 	m.ctrl.T.Helper()
@@ -543,6 +671,20 @@ func (mr *MockNdisApiInterfaceMockRecorder) SendPacketsToAdaptersUnsorted(packet
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendPacketsToAdaptersUnsorted", reflect.TypeOf((*MockNdisApiInterface)(nil).SendPacketsToAdaptersUnsorted), packets, dwPacketsNum, pdwPacketSuccess)
 }
 
+// SendPacketsToAdaptersUnsortedErr mocks base method.
+func (m *MockNdisApiInterface) SendPacketsToAdaptersUnsortedErr(packets []*ndisapi.IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendPacketsToAdaptersUnsortedErr", packets, packetsNum, packetSuccess)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendPacketsToAdaptersUnsortedErr indicates an expected call of SendPacketsToAdaptersUnsortedErr.
+func (mr *MockNdisApiInterfaceMockRecorder) SendPacketsToAdaptersUnsortedErr(packets, packetsNum, packetSuccess interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendPacketsToAdaptersUnsortedErr", reflect.TypeOf((*MockNdisApiInterface)(nil).SendPacketsToAdaptersUnsortedErr), packets, packetsNum, packetSuccess)
+}
+
 // SendPacketsToMstcp mocks base method.
 func (m *MockNdisApiInterface) SendPacketsToMstcp(packet *ndisapi.EtherMultiRequest) error {
 	m.ctrl.T.Helper()
@@ -571,6 +713,20 @@ func (mr *MockNdisApiInterfaceMockRecorder) SendPacketsToMstcpUnsorted(packets,
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendPacketsToMstcpUnsorted", reflect.TypeOf((*MockNdisApiInterface)(nil).SendPacketsToMstcpUnsorted), packets, dwPacketsNum, pdwPacketSuccess)
 }
 
+// SendPacketsToMstcpUnsortedErr mocks base method.
+func (m *MockNdisApiInterface) SendPacketsToMstcpUnsortedErr(packets []*ndisapi.IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendPacketsToMstcpUnsortedErr", packets, packetsNum, packetSuccess)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SendPacketsToMstcpUnsortedErr indicates an expected call of SendPacketsToMstcpUnsortedErr.
+func (mr *MockNdisApiInterfaceMockRecorder) SendPacketsToMstcpUnsortedErr(packets, packetsNum, packetSuccess interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendPacketsToMstcpUnsortedErr", reflect.TypeOf((*MockNdisApiInterface)(nil).SendPacketsToMstcpUnsortedErr), packets, packetsNum, packetSuccess)
+}
+
 // SetAdapterMode mocks base method.
 func (m *MockNdisApiInterface) SetAdapterMode(currentMode *ndisapi.AdapterMode) error {
 	m.ctrl.T.Helper()
@@ -585,8 +741,22 @@ func (mr *MockNdisApiInterfaceMockRecorder) SetAdapterMode(currentMode interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetAdapterMode", reflect.TypeOf((*MockNdisApiInterface)(nil).SetAdapterMode), currentMode)
 }
 
+// SetHWFilterEvent mocks base method.
+func (m *MockNdisApiInterface) SetHWFilterEvent(win32Event ndisapi.EventHandle) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetHWFilterEvent", win32Event)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetHWFilterEvent indicates an expected call of SetHWFilterEvent.
+func (mr *MockNdisApiInterfaceMockRecorder) SetHWFilterEvent(win32Event interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetHWFilterEvent", reflect.TypeOf((*MockNdisApiInterface)(nil).SetHWFilterEvent), win32Event)
+}
+
 // SetPacketEvent mocks base method.
-func (m *MockNdisApiInterface) SetPacketEvent(adapter ndisapi.Handle, win32Event windows.Handle) error {
+func (m *MockNdisApiInterface) SetPacketEvent(adapter ndisapi.Handle, win32Event ndisapi.EventHandle) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "SetPacketEvent", adapter, win32Event)
 	ret0, _ := ret[0].(error)
@@ -599,6 +769,20 @@ func (mr *MockNdisApiInterfaceMockRecorder) SetPacketEvent(adapter, win32Event i
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPacketEvent", reflect.TypeOf((*MockNdisApiInterface)(nil).SetPacketEvent), adapter, win32Event)
 }
 
+// SetWANEvent mocks base method.
+func (m *MockNdisApiInterface) SetWANEvent(win32Event ndisapi.EventHandle) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetWANEvent", win32Event)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetWANEvent indicates an expected call of SetWANEvent.
+func (mr *MockNdisApiInterfaceMockRecorder) SetWANEvent(win32Event interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetWANEvent", reflect.TypeOf((*MockNdisApiInterface)(nil).SetWANEvent), win32Event)
+}
+
 // SetPacketFilterCacheState mocks base method.
 func (m *MockNdisApiInterface) SetPacketFilterCacheState(state bool) error {
 	m.ctrl.T.Helper()
@@ -8,29 +8,11 @@ import (
 	"golang.org/x/sys/windows"
 )
 
-const (
-	DEVICE_NDISWANIP               = `\\DEVICE\\NDISWANIP`
-	USER_NDISWANIP                 = `WAN Network Interface (IP)`
-	DEVICE_NDISWANBH               = `\\DEVICE\\NDISWANBH`
-	USER_NDISWANBH                 = `WAN Network Interface (BH)`
-	DEVICE_NDISWANIPV6             = `\\DEVICE\\NDISWANIPV6`
-	USER_NDISWANIPV6               = `WAN Network Interface (IPv6)`
-	REGSTR_COMPONENTID_NDISWANIP   = `ms_ndiswanip`
-	REGSTR_COMPONENTID_NDISWANIPV6 = `ms_ndiswanipv6`
-	REGSTR_COMPONENTID_NDISWANBH   = `ms_ndiswanbh`
-	REGSTR_VAL_CONNECTION          = `\Connection`
-	REGSTR_VAL_NAME                = `Name`
-	REGSTR_VAL_SERVICE_NAME        = `ServiceName`
-	REGSTR_VAL_DRIVER_DESC         = `DriverDesc`
-	REGSTR_VAL_TITLE               = `Title`
-
-	REGSTR_NETWORK_CONTROL_KEY   = `SYSTEM\CurrentControlSet\Control\Network\{4D36E972-E325-11CE-BFC1-08002BE10318}\`
-	REGSTR_NETWORK_CARDS         = `SOFTWARE\Microsoft\Windows NT\CurrentVersion\NetworkCards`
-	REGSTR_MSTCP_CLASS_NET       = `SYSTEM\CurrentControlSet\Services\Class\Net\`
-	REGSTR_NETWORK_CONTROL_CLASS = `SYSTEM\CurrentControlSet\Control\Class\{4D36E972-E325-11CE-BFC1-08002BE10318}`
-
-	OID_GEN_CURRENT_PACKET_FILTER = 0x0001010E
-)
+// EventHandle is a native Win32 event handle which the driver signals.
+type EventHandle = windows.Handle
+
+// Overlapped is the native OVERLAPPED structure passed to DeviceIoControl.
+type Overlapped = windows.Overlapped
 
 var _ NdisApiInterface = (*NdisApi)(nil)
 
@@ -43,7 +25,13 @@ type NdisApi struct {
 }
 
 // NewNdisApi initializes a new instance of NdisApi.
-func NewNdisApi() (*NdisApi, error) {
+// With WithMinimumDriverVersion it also verifies that the driver is recent enough.
+func NewNdisApi(opts ...NdisApiOption) (*NdisApi, error) {
+	var options ndisApiOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	devicePath, err := windows.UTF16PtrFromString("\\\\.\\NDISRD")
 	if err != nil {
 		return nil, err
@@ -63,7 +51,7 @@ func NewNdisApi() (*NdisApi, error) {
 		0,
 	)
 	if err != nil {
-		return nil, err
+		return nil, driverError("CreateFile", err)
 	}
 
 	if fileHandle == windows.InvalidHandle {
@@ -81,11 +69,20 @@ func NewNdisApi() (*NdisApi, error) {
 		isDriverLoaded: isLoadSuccessfully,
 	}
 
+	if options.minimumVersion != 0 {
+		if err := RequireDriverVersion(ndisApi, options.minimumVersion, ""); err != nil {
+			ndisApi.Close()
+			return nil, err
+		}
+	}
+
 	return ndisApi, nil
 }
 
-// Close closes the NDISAPI driver handle and event handle.
+// Close closes the NDISAPI driver handle and event handle, after calling the functions registered with OnClose.
 func (a *NdisApi) Close() {
+	RunCloseHooks(a)
+
 	if a.fileHandle != windows.InvalidHandle {
 		windows.CloseHandle(a.fileHandle)
 	}
@@ -106,13 +103,14 @@ func (a *NdisApi) GetBytesReturned() uint32 {
 }
 
 // DeviceIoControl sends a control code directly to the NDISAPI driver.
+// A failure is returned as a *DriverError wrapping the Win32 errno.
 func (a *NdisApi) DeviceIoControl(service uint32, in unsafe.Pointer, sizeIn uint32, out unsafe.Pointer, sizeOut uint32, SizeRet *uint32, overlapped *windows.Overlapped) error {
 	var returnedBytes uint32
 	if SizeRet == nil {
 		SizeRet = &returnedBytes
 	}
 
-	return windows.DeviceIoControl(
+	err := windows.DeviceIoControl(
 		a.fileHandle,
 		service,
 		(*byte)(in),
@@ -121,6 +119,8 @@ func (a *NdisApi) DeviceIoControl(service uint32, in unsafe.Pointer, sizeIn uint
 		sizeOut,
 		SizeRet,
 		overlapped)
+
+	return driverError(IoctlName(service), err)
 }
 
 // GetVersion retrieves the NDISAPI driver version.
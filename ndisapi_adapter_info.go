@@ -0,0 +1,186 @@
+package ndisapi
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// NdisMedium is the NDIS_MEDIUM type of a network adapter.
+type NdisMedium uint32
+
+const (
+	NdisMedium802_3        NdisMedium = iota // Ethernet
+	NdisMedium802_5                          // Token Ring
+	NdisMediumFddi                           // FDDI
+	NdisMediumWan                            // WAN, e.g. the NDISWAN adapters
+	NdisMediumLocalTalk                      // LocalTalk
+	NdisMediumDix                            // Ethernet with DIX headers
+	NdisMediumArcnetRaw                      // ARCNET
+	NdisMediumArcnet878_2                    // ARCNET 878.2
+	NdisMediumAtm                            // ATM
+	NdisMediumWirelessWan                    // Mobile broadband
+	NdisMediumIrda                           // Infrared
+	NdisMediumBpc                            // Broadcast PC
+	NdisMediumCoWan                          // Connection-oriented WAN
+	NdisMedium1394                           // IEEE 1394
+	NdisMediumInfiniBand                     // InfiniBand
+	NdisMediumTunnel                         // Tunnel
+	NdisMediumNative802_11                   // Native 802.11 Wi-Fi
+	NdisMediumLoopback                       // Loopback
+	NdisMediumWiMAX                          // WiMAX
+	NdisMediumIP                             // Raw IP, no link layer header
+)
+
+var ndisMediumNames = [...]string{
+	"802.3", "802.5", "FDDI", "WAN", "LocalTalk", "DIX", "ARCNET", "ARCNET 878.2", "ATM", "Wireless WAN",
+	"IrDA", "BPC", "CoWAN", "1394", "InfiniBand", "Tunnel", "Native 802.11", "Loopback", "WiMAX", "IP",
+}
+
+func (m NdisMedium) String() string {
+	if int(m) < len(ndisMediumNames) {
+		return ndisMediumNames[m]
+	}
+	return fmt.Sprintf("NdisMedium(%d)", uint32(m))
+}
+
+// NdisWanType classifies the NDISWAN adapters.
+type NdisWanType int
+
+const (
+	NdisWanNone NdisWanType = iota // not an NDISWAN adapter
+	NdisWanIP                      // NDISWANIP, the IPv4 RAS links
+	NdisWanIPv6                    // NDISWANIPV6, the IPv6 RAS links
+	NdisWanBH                      // NDISWANBH, the network monitor binding
+)
+
+// AdapterInfo describes a TCP/IP bound network adapter.
+type AdapterInfo struct {
+	Handle         Handle
+	Name           string // internal name, e.g. \DEVICE\{GUID}
+	GUID           string // GUID of the internal name with the braces, empty for the NDISWAN adapters
+	FriendlyName   string
+	Medium         NdisMedium
+	HardwareAddr   net.HardwareAddr
+	MTU            uint16
+	NdisWanType    NdisWanType
+	InterfaceIndex uint32 // Windows interface index, zero if unknown
+}
+
+// IsNdiswan reports whether the adapter is an NDISWAN adapter.
+func (i *AdapterInfo) IsNdiswan() bool {
+	return i.NdisWanType != NdisWanNone
+}
+
+// AdapterInfoList is the list of the TCP/IP bound network adapters in the driver order.
+type AdapterInfoList []AdapterInfo
+
+// ByHandle returns the adapter with the handle, nil if there is none.
+func (l AdapterInfoList) ByHandle(handle Handle) *AdapterInfo {
+	for i := range l {
+		if l[i].Handle == handle {
+			return &l[i]
+		}
+	}
+	return nil
+}
+
+// ByGUID returns the adapter with the GUID, nil if there is none. The braces are optional and the case is ignored.
+func (l AdapterInfoList) ByGUID(guid string) *AdapterInfo {
+	guid = strings.Trim(guid, "{}")
+	if guid == "" {
+		return nil
+	}
+	for i := range l {
+		if strings.EqualFold(strings.Trim(l[i].GUID, "{}"), guid) {
+			return &l[i]
+		}
+	}
+	return nil
+}
+
+// ByName returns the adapter with the internal or the friendly name, nil if there is none. The case is ignored.
+func (l AdapterInfoList) ByName(name string) *AdapterInfo {
+	for i := range l {
+		if strings.EqualFold(l[i].Name, name) || strings.EqualFold(l[i].FriendlyName, name) {
+			return &l[i]
+		}
+	}
+	return nil
+}
+
+// ByIndex returns the adapter with the Windows interface index, nil if there is none.
+func (l AdapterInfoList) ByIndex(index uint32) *AdapterInfo {
+	if index == 0 {
+		return nil
+	}
+	for i := range l {
+		if l[i].InterfaceIndex == index {
+			return &l[i]
+		}
+	}
+	return nil
+}
+
+// AdapterName returns the internal name of the i-th adapter without the trailing NULs.
+func (l *TcpAdapterList) AdapterName(i int) string {
+	return strings.TrimRight(string(l.AdapterNameList[i][:]), "\x00")
+}
+
+// GetTcpipBoundAdapters retrieves the TCP/IP bound adapters, see GetTcpipBoundAdaptersInfo.
+func GetTcpipBoundAdapters(api NdisApiInterface) (AdapterInfoList, error) {
+	adapters, err := api.GetTcpipBoundAdaptersInfo()
+	if err != nil {
+		return nil, err
+	}
+	return NewAdapterInfoList(api, adapters), nil
+}
+
+// NewAdapterInfoList converts the adapter list returned by GetTcpipBoundAdaptersInfo.
+func NewAdapterInfoList(api NdisApiInterface, adapters *TcpAdapterList) AdapterInfoList {
+	count := int(adapters.AdapterCount)
+	if count > ADAPTER_LIST_SIZE {
+		count = ADAPTER_LIST_SIZE
+	}
+
+	list := make(AdapterInfoList, 0, count)
+	for i := 0; i < count; i++ {
+		name := adapters.AdapterName(i)
+		info := AdapterInfo{
+			Handle:       adapters.AdapterHandle[i],
+			Name:         name,
+			GUID:         adapterGUID(name),
+			FriendlyName: api.ConvertWindows2000AdapterName(name),
+			Medium:       NdisMedium(adapters.AdapterMediumList[i]),
+			HardwareAddr: append(net.HardwareAddr(nil), adapters.CurrentAddress[i][:]...),
+			MTU:          adapters.MTU[i],
+		}
+
+		switch {
+		case api.IsNdiswanIP(name):
+			info.NdisWanType = NdisWanIP
+		case api.IsNdiswanIPv6(name):
+			info.NdisWanType = NdisWanIPv6
+		case api.IsNdiswanBh(name):
+			info.NdisWanType = NdisWanBH
+		}
+
+		// The NDISWAN adapters have no interface of their own
+		if index, err := api.GetInterfaceIndex(name); err == nil {
+			info.InterfaceIndex = index
+		}
+
+		list = append(list, info)
+	}
+
+	return list
+}
+
+// adapterGUID returns the GUID of the internal adapter name with the braces, empty if the name has none.
+func adapterGUID(name string) string {
+	start := strings.LastIndexByte(name, '{')
+	if start < 0 || !strings.HasSuffix(name, "}") {
+		return ""
+	}
+	return strings.ToUpper(name[start:])
+}
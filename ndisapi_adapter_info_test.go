@@ -0,0 +1,58 @@
+package ndisapi_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wiresock/ndisapi-go"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+func TestGetTcpipBoundAdapters(t *testing.T) {
+	api := sim.NewDriver()
+	ethernet := api.AddAdapter(sim.AdapterConfig{
+		Name:           `\DEVICE\{4D36E972-E325-11CE-BFC1-08002BE10318}`,
+		FriendlyName:   "Ethernet",
+		Medium:         uint32(ndisapi.NdisMedium802_3),
+		HardwareAddr:   [ndisapi.ETHER_ADDR_LENGTH]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		MTU:            9000,
+		InterfaceIndex: 12,
+	})
+	wan := api.AddAdapter(sim.AdapterConfig{Name: ndisapi.DEVICE_NDISWANIP, Medium: uint32(ndisapi.NdisMediumWan)})
+
+	adapters, err := ndisapi.GetTcpipBoundAdapters(api)
+	require.NoError(t, err)
+	require.Len(t, adapters, 2)
+
+	assert.Equal(t, ndisapi.AdapterInfo{
+		Handle:         ethernet,
+		Name:           `\DEVICE\{4D36E972-E325-11CE-BFC1-08002BE10318}`,
+		GUID:           "{4D36E972-E325-11CE-BFC1-08002BE10318}",
+		FriendlyName:   "Ethernet",
+		Medium:         ndisapi.NdisMedium802_3,
+		HardwareAddr:   net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01},
+		MTU:            9000,
+		InterfaceIndex: 12,
+	}, adapters[0])
+	assert.False(t, adapters[0].IsNdiswan())
+
+	assert.Equal(t, wan, adapters[1].Handle)
+	assert.Equal(t, ndisapi.DEVICE_NDISWANIP, adapters[1].Name)
+	assert.Equal(t, ndisapi.USER_NDISWANIP, adapters[1].FriendlyName)
+	assert.Equal(t, ndisapi.NdisWanIP, adapters[1].NdisWanType)
+	assert.Empty(t, adapters[1].GUID)
+	assert.Zero(t, adapters[1].InterfaceIndex)
+	assert.Equal(t, "WAN", adapters[1].Medium.String())
+
+	assert.Equal(t, ethernet, adapters.ByGUID("4d36e972-e325-11ce-bfc1-08002be10318").Handle)
+	assert.Equal(t, ethernet, adapters.ByName("ethernet").Handle)
+	assert.Equal(t, wan, adapters.ByName(ndisapi.DEVICE_NDISWANIP).Handle)
+	assert.Equal(t, ethernet, adapters.ByIndex(12).Handle)
+	assert.Equal(t, wan, adapters.ByHandle(wan).Handle)
+	assert.Nil(t, adapters.ByGUID(""))
+	assert.Nil(t, adapters.ByIndex(0))
+	assert.Nil(t, adapters.ByName("Wi-Fi"))
+}
@@ -0,0 +1,264 @@
+//go:build windows
+
+package ndisapi
+
+import (
+	"bytes"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+var (
+	modIphlpapi = windows.NewLazySystemDLL("iphlpapi.dll")
+
+	procConvertInterfaceGuidToLuid  = modIphlpapi.NewProc("ConvertInterfaceGuidToLuid")
+	procConvertInterfaceLuidToIndex = modIphlpapi.NewProc("ConvertInterfaceLuidToIndex")
+)
+
+// GetTcpipBoundAdaptersInfo retrieves the list of TCPIP-bound adapters.
+func (a *NdisApi) GetTcpipBoundAdaptersInfo() (*TcpAdapterList, error) {
+	var tcpAdapterList TcpAdapterList
+
+	err := a.DeviceIoControl(
+		IOCTL_NDISRD_GET_TCPIP_INTERFACES,
+		unsafe.Pointer(&tcpAdapterList),
+		uint32(unsafe.Sizeof(tcpAdapterList)),
+		unsafe.Pointer(&tcpAdapterList),
+		uint32(unsafe.Sizeof(tcpAdapterList)),
+		&a.bytesReturned,
+		nil,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &tcpAdapterList, nil
+}
+
+// SetAdapterMode sets the filter mode of the network adapter.
+func (a *NdisApi) SetAdapterMode(currentMode *AdapterMode) error {
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_SET_ADAPTER_MODE,
+		unsafe.Pointer(currentMode),
+		uint32(unsafe.Sizeof(AdapterMode{})),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// GetAdapterMode retrieves the filter mode of the network adapter.
+func (a *NdisApi) GetAdapterMode(currentMode *AdapterMode) error {
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_SET_ADAPTER_MODE,
+		unsafe.Pointer(currentMode),
+		uint32(unsafe.Sizeof(AdapterMode{})),
+		unsafe.Pointer(currentMode),
+		uint32(unsafe.Sizeof(AdapterMode{})),
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// FlushAdapterPacketQueue flushes the packet queue of the specified network adapter.
+func (a *NdisApi) FlushAdapterPacketQueue(adapter Handle) error {
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_FLUSH_ADAPTER_QUEUE,
+		unsafe.Pointer(&adapter),
+		uint32(len(adapter)),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// GetAdapterPacketQueueSize retrieves the size of the packet queue for the specified network adapter.
+func (a *NdisApi) GetAdapterPacketQueueSize(adapter Handle, size *uint32) error {
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_GET_VERSION,
+		unsafe.Pointer(&adapter),
+		uint32(len(adapter)),
+		unsafe.Pointer(&size),
+		uint32(unsafe.Sizeof(size)),
+		nil,
+		nil,
+	)
+}
+
+// SetPacketEvent sets a Win32 event to be signaled when a packet arrives at the specified network adapter.
+func (a *NdisApi) SetPacketEvent(adapter Handle, win32Event windows.Handle) error {
+	adapterEvent := AdapterEvent{
+		AdapterHandle: adapter,
+		Event:         win32Event,
+	}
+
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_SET_EVENT,
+		unsafe.Pointer(&adapterEvent),
+		uint32(unsafe.Sizeof(adapterEvent)),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// SetWANEvent sets a Win32 event to be signaled when a NDISWAN adapter connect/disconnect occurs.
+func (a *NdisApi) SetWANEvent(win32Event windows.Handle) error {
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_SET_WAN_EVENT,
+		unsafe.Pointer(&win32Event),
+		uint32(unsafe.Sizeof(win32Event)),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// GetRasLinks retrieves the active RAS links of the NDISWAN adapter.
+func (a *NdisApi) GetRasLinks(adapter Handle) (*RASLinks, error) {
+	links := new(RASLinks)
+
+	err := a.DeviceIoControl(
+		IOCTL_NDISRD_GET_RAS_LINKS,
+		unsafe.Pointer(&adapter),
+		uint32(len(adapter)),
+		unsafe.Pointer(links),
+		uint32(unsafe.Sizeof(*links)),
+		&a.bytesReturned,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// SetPacketEvent a Win32 event to be signaled when a network adapter list change occurs.
+func (a *NdisApi) SetAdapterListChangeEvent(win32Event windows.Handle) error {
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_SET_ADAPTER_EVENT,
+		unsafe.Pointer(&win32Event),
+		uint32(unsafe.Sizeof(win32Event)),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// SetHWFilterEvent sets a Win32 event to be signaled when the hardware packet filter of a network adapter changes.
+func (a *NdisApi) SetHWFilterEvent(win32Event windows.Handle) error {
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_SET_ADAPTER_HWFILTER_EVENT,
+		unsafe.Pointer(&win32Event),
+		uint32(unsafe.Sizeof(win32Event)),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// ConvertWindows2000AdapterName converts an adapter's internal name to a user-friendly name on Windows 2000 and later.
+func (a *NdisApi) ConvertWindows2000AdapterName(adapterName string) string {
+	if a.IsNdiswanIP(adapterName) {
+		return USER_NDISWANIP
+	}
+	if a.IsNdiswanBh(adapterName) {
+		return USER_NDISWANBH
+	}
+	if a.IsNdiswanIPv6(adapterName) {
+		return USER_NDISWANIPV6
+	}
+
+	adapterNameBytes := []byte((strings.TrimPrefix(adapterName, `\DEVICE\`)))
+	adapterNameBytes = bytes.Trim(adapterNameBytes, "\x00")
+
+	keyPath := REGSTR_NETWORK_CONTROL_KEY + string(adapterNameBytes) + REGSTR_VAL_CONNECTION
+
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, keyPath, registry.READ)
+	if err != nil {
+		return string(adapterNameBytes)
+	}
+	defer key.Close()
+
+	val, _, err := key.GetStringValue(REGSTR_VAL_NAME)
+	if err != nil {
+		return string(adapterNameBytes)
+	}
+
+	return val
+}
+
+// GetInterfaceIndex converts an adapter's internal name to the Windows interface index.
+func (a *NdisApi) GetInterfaceIndex(adapterName string) (uint32, error) {
+	guid, err := windows.GUIDFromString(adapterGUID(strings.TrimRight(adapterName, "\x00")))
+	if err != nil {
+		return 0, err
+	}
+
+	var luid uint64
+	if ret, _, _ := procConvertInterfaceGuidToLuid.Call(uintptr(unsafe.Pointer(&guid)), uintptr(unsafe.Pointer(&luid))); ret != 0 {
+		return 0, windows.Errno(ret)
+	}
+
+	var index uint32
+	if ret, _, _ := procConvertInterfaceLuidToIndex.Call(uintptr(unsafe.Pointer(&luid)), uintptr(unsafe.Pointer(&index))); ret != 0 {
+		return 0, windows.Errno(ret)
+	}
+
+	return index, nil
+}
+
+// NdisGetRequest queries the OID of the network adapter into data and returns the length of the OID data.
+func (a *NdisApi) NdisGetRequest(adapter Handle, oid uint32, data []byte) (uint32, error) {
+	return a.ndisRequest(IOCTL_NDISRD_NDIS_GET_REQUEST, adapter, oid, data)
+}
+
+// NdisSetRequest sets the OID of the network adapter to data.
+func (a *NdisApi) NdisSetRequest(adapter Handle, oid uint32, data []byte) error {
+	_, err := a.ndisRequest(IOCTL_NDISRD_NDIS_SET_REQUEST, adapter, oid, data)
+	return err
+}
+
+// ndisRequest passes the OID request to the driver in a PacketOidData followed by the data.
+func (a *NdisApi) ndisRequest(service uint32, adapter Handle, oid uint32, data []byte) (uint32, error) {
+	headerSize := int(unsafe.Offsetof(PacketOidData{}.Data))
+	bufferSize := headerSize + len(data)
+	if bufferSize < int(unsafe.Sizeof(PacketOidData{})) {
+		bufferSize = int(unsafe.Sizeof(PacketOidData{}))
+	}
+	buffer := make([]byte, bufferSize)
+
+	request := (*PacketOidData)(unsafe.Pointer(&buffer[0]))
+	request.AdapterHandle = adapter
+	request.Oid = oid
+	request.Length = uint32(len(data))
+	copy(buffer[headerSize:], data)
+
+	err := a.DeviceIoControl(
+		service,
+		unsafe.Pointer(&buffer[0]),
+		uint32(bufferSize),
+		unsafe.Pointer(&buffer[0]),
+		uint32(bufferSize),
+		&a.bytesReturned,
+		nil,
+	)
+	if err != nil {
+		return 0, &OidRequestError{Adapter: adapter, Oid: oid, Set: service == IOCTL_NDISRD_NDIS_SET_REQUEST, Err: err}
+	}
+
+	copy(data, buffer[headerSize:])
+
+	return request.Length, nil
+}
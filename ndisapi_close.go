@@ -0,0 +1,50 @@
+package ndisapi
+
+import "sync"
+
+// closeHooks holds the functions called when a driver api is closed, by api.
+var closeHooks struct {
+	sync.Mutex
+	hooks map[NdisApiInterface]map[int]func()
+	next  int
+}
+
+// OnClose registers a function called when the api is closed, before its driver handle is released,
+// e.g. to restore the adapter state changed through it. The returned function unregisters it.
+func OnClose(api NdisApiInterface, hook func()) (unregister func()) {
+	closeHooks.Lock()
+	defer closeHooks.Unlock()
+
+	if closeHooks.hooks == nil {
+		closeHooks.hooks = make(map[NdisApiInterface]map[int]func())
+	}
+	if closeHooks.hooks[api] == nil {
+		closeHooks.hooks[api] = make(map[int]func())
+	}
+	id := closeHooks.next
+	closeHooks.next++
+	closeHooks.hooks[api][id] = hook
+
+	return func() {
+		closeHooks.Lock()
+		defer closeHooks.Unlock()
+
+		delete(closeHooks.hooks[api], id)
+		if len(closeHooks.hooks[api]) == 0 {
+			delete(closeHooks.hooks, api)
+		}
+	}
+}
+
+// RunCloseHooks unregisters the functions registered for the api with OnClose and calls them.
+// The implementations of NdisApiInterface call it first in Close.
+func RunCloseHooks(api NdisApiInterface) {
+	closeHooks.Lock()
+	hooks := closeHooks.hooks[api]
+	delete(closeHooks.hooks, api)
+	closeHooks.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+}
@@ -0,0 +1,33 @@
+package ndisapi_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/wiresock/ndisapi-go"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+func TestOnClose(t *testing.T) {
+	api := sim.NewDriver()
+	other := sim.NewDriver()
+
+	var closed, unregistered, otherClosed int
+	ndisapi.OnClose(api, func() { closed++ })
+	unregister := ndisapi.OnClose(api, func() { unregistered++ })
+	ndisapi.OnClose(other, func() { otherClosed++ })
+	unregister()
+
+	api.Close()
+	assert.Equal(t, 1, closed)
+	assert.Zero(t, unregistered)
+	assert.Zero(t, otherClosed)
+
+	// The hooks are called once
+	api.Close()
+	assert.Equal(t, 1, closed)
+
+	other.Close()
+	assert.Equal(t, 1, otherClosed)
+}
@@ -0,0 +1,96 @@
+package ndisapi
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrDriverNotLoaded is returned when the NDISRD driver is not installed or its device can't be used.
+	ErrDriverNotLoaded = errors.New("driver is not loaded")
+	// ErrAdapterNotFound is returned when a request references an adapter the driver doesn't know.
+	ErrAdapterNotFound = errors.New("adapter not found")
+	// ErrQueueEmpty is returned by the packet reads when no packets are queued.
+	ErrQueueEmpty = errors.New("packet queue is empty")
+	// ErrBufferTooSmall is returned when a buffer passed to the driver can't hold the request or its result.
+	ErrBufferTooSmall = errors.New("buffer too small")
+	// ErrVersionMismatch is returned when the driver version doesn't support the request.
+	ErrVersionMismatch = errors.New("driver version mismatch")
+)
+
+// DriverError reports a failed driver request. It matches the sentinel error classifying the failure
+// with errors.Is, and unwraps to the cause, the Win32 errno returned by DeviceIoControl.
+type DriverError struct {
+	Op   string // the request, e.g. IOCTL_NDISRD_READ_PACKETS
+	Kind error  // the sentinel error classifying the failure, nil if the failure is not classified
+	Err  error  // the cause, nil if the request succeeded but its result is a failure, e.g. no packets read
+}
+
+func (e *DriverError) Error() string {
+	switch {
+	case e.Err == nil:
+		return fmt.Sprintf("%s: %v", e.Op, e.Kind)
+	case e.Kind == nil:
+		return fmt.Sprintf("%s failed: %v", e.Op, e.Err)
+	default:
+		return fmt.Sprintf("%s failed: %v: %v", e.Op, e.Kind, e.Err)
+	}
+}
+
+func (e *DriverError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the failure is classified as the target sentinel error.
+func (e *DriverError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+// ioctlNames holds the names of the control codes reported by DriverError.
+var ioctlNames = map[uint32]string{
+	IOCTL_NDISRD_GET_VERSION:                     "IOCTL_NDISRD_GET_VERSION",
+	IOCTL_NDISRD_GET_TCPIP_INTERFACES:            "IOCTL_NDISRD_GET_TCPIP_INTERFACES",
+	IOCTL_NDISRD_SEND_PACKET_TO_ADAPTER:          "IOCTL_NDISRD_SEND_PACKET_TO_ADAPTER",
+	IOCTL_NDISRD_SEND_PACKET_TO_MSTCP:            "IOCTL_NDISRD_SEND_PACKET_TO_MSTCP",
+	IOCTL_NDISRD_READ_PACKET:                     "IOCTL_NDISRD_READ_PACKET",
+	IOCTL_NDISRD_SET_ADAPTER_MODE:                "IOCTL_NDISRD_SET_ADAPTER_MODE",
+	IOCTL_NDISRD_FLUSH_ADAPTER_QUEUE:             "IOCTL_NDISRD_FLUSH_ADAPTER_QUEUE",
+	IOCTL_NDISRD_SET_EVENT:                       "IOCTL_NDISRD_SET_EVENT",
+	IOCTL_NDISRD_NDIS_SET_REQUEST:                "IOCTL_NDISRD_NDIS_SET_REQUEST",
+	IOCTL_NDISRD_NDIS_GET_REQUEST:                "IOCTL_NDISRD_NDIS_GET_REQUEST",
+	IOCTL_NDISRD_SET_WAN_EVENT:                   "IOCTL_NDISRD_SET_WAN_EVENT",
+	IOCTL_NDISRD_SET_ADAPTER_EVENT:               "IOCTL_NDISRD_SET_ADAPTER_EVENT",
+	IOCTL_NDISRD_ADAPTER_QUEUE_SIZE:              "IOCTL_NDISRD_ADAPTER_QUEUE_SIZE",
+	IOCTL_NDISRD_GET_ADAPTER_MODE:                "IOCTL_NDISRD_GET_ADAPTER_MODE",
+	IOCTL_NDISRD_SET_PACKET_FILTERS:              "IOCTL_NDISRD_SET_PACKET_FILTERS",
+	IOCTL_NDISRD_RESET_PACKET_FILTERS:            "IOCTL_NDISRD_RESET_PACKET_FILTERS",
+	IOCTL_NDISRD_GET_PACKET_FILTERS_TABLESIZE:    "IOCTL_NDISRD_GET_PACKET_FILTERS_TABLESIZE",
+	IOCTL_NDISRD_GET_PACKET_FILTERS:              "IOCTL_NDISRD_GET_PACKET_FILTERS",
+	IOCTL_NDISRD_GET_PACKET_FILTERS_RESET_STATS:  "IOCTL_NDISRD_GET_PACKET_FILTERS_RESET_STATS",
+	IOCTL_NDISRD_GET_RAS_LINKS:                   "IOCTL_NDISRD_GET_RAS_LINKS",
+	IOCTL_NDISRD_SEND_PACKETS_TO_ADAPTER:         "IOCTL_NDISRD_SEND_PACKETS_TO_ADAPTER",
+	IOCTL_NDISRD_SEND_PACKETS_TO_MSTCP:           "IOCTL_NDISRD_SEND_PACKETS_TO_MSTCP",
+	IOCTL_NDISRD_READ_PACKETS:                    "IOCTL_NDISRD_READ_PACKETS",
+	IOCTL_NDISRD_SET_ADAPTER_HWFILTER_EVENT:      "IOCTL_NDISRD_SET_ADAPTER_HWFILTER_EVENT",
+	IOCTL_NDISRD_INITIALIZE_FAST_IO:              "IOCTL_NDISRD_INITIALIZE_FAST_IO",
+	IOCTL_NDISRD_READ_PACKETS_UNSORTED:           "IOCTL_NDISRD_READ_PACKETS_UNSORTED",
+	IOCTL_NDISRD_SEND_PACKET_TO_ADAPTER_UNSORTED: "IOCTL_NDISRD_SEND_PACKET_TO_ADAPTER_UNSORTED",
+	IOCTL_NDISRD_SEND_PACKET_TO_MSTCP_UNSORTED:   "IOCTL_NDISRD_SEND_PACKET_TO_MSTCP_UNSORTED",
+	IOCTL_NDISRD_ADD_SECOND_FAST_IO_SECTION:      "IOCTL_NDISRD_ADD_SECOND_FAST_IO_SECTION",
+	IOCTL_NDISRD_QUERY_IB_POOL_SIZE:              "IOCTL_NDISRD_QUERY_IB_POOL_SIZE",
+	IOCTL_NDISRD_ADD_PACKET_FILTER_FRONT:         "IOCTL_NDISRD_ADD_PACKET_FILTER_FRONT",
+	IOCTL_NDISRD_ADD_PACKET_FILTER_BACK:          "IOCTL_NDISRD_ADD_PACKET_FILTER_BACK",
+	IOCTL_NDISRD_REMOVE_FILTER_BY_INDEX:          "IOCTL_NDISRD_REMOVE_FILTER_BY_INDEX",
+	IOCTL_NDISRD_GET_ADP_FILTERS_LIST:            "IOCTL_NDISRD_GET_ADP_FILTERS_LIST",
+	IOCTL_NDISRD_INSERT_FILTER_BY_INDEX:          "IOCTL_NDISRD_INSERT_FILTER_BY_INDEX",
+	IOCTL_NDISRD_SET_FILTER_CACHE_STATE:          "IOCTL_NDISRD_SET_FILTER_CACHE_STATE",
+	IOCTL_NDISRD_SET_FRAGMENT_CACHE_STATE:        "IOCTL_NDISRD_SET_FRAGMENT_CACHE_STATE",
+}
+
+// IoctlName returns the name of the driver control code, or its value if it is unknown.
+func IoctlName(service uint32) string {
+	if name, ok := ioctlNames[service]; ok {
+		return name
+	}
+	return fmt.Sprintf("IOCTL 0x%08X", service)
+}
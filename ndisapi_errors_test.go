@@ -0,0 +1,39 @@
+package ndisapi_test
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/wiresock/ndisapi-go"
+)
+
+func TestDriverError(t *testing.T) {
+	cause := syscall.Errno(122)
+	err := error(&ndisapi.DriverError{Op: ndisapi.IoctlName(ndisapi.IOCTL_NDISRD_GET_RAS_LINKS), Kind: ndisapi.ErrBufferTooSmall, Err: cause})
+
+	assert.True(t, errors.Is(err, ndisapi.ErrBufferTooSmall))
+	assert.False(t, errors.Is(err, ndisapi.ErrQueueEmpty))
+	assert.True(t, errors.Is(err, cause))
+	assert.Contains(t, err.Error(), "IOCTL_NDISRD_GET_RAS_LINKS failed: buffer too small")
+
+	var errno syscall.Errno
+	assert.True(t, errors.As(err, &errno))
+	assert.Equal(t, cause, errno)
+
+	err = &ndisapi.DriverError{Op: ndisapi.IoctlName(ndisapi.IOCTL_NDISRD_READ_PACKETS), Kind: ndisapi.ErrQueueEmpty}
+	assert.True(t, errors.Is(err, ndisapi.ErrQueueEmpty))
+	assert.Nil(t, errors.Unwrap(err))
+	assert.Equal(t, "IOCTL_NDISRD_READ_PACKETS: packet queue is empty", err.Error())
+
+	err = &ndisapi.DriverError{Op: "CreateFile", Err: cause}
+	assert.False(t, errors.Is(err, ndisapi.ErrDriverNotLoaded))
+	assert.Equal(t, "CreateFile failed: "+cause.Error(), err.Error())
+}
+
+func TestIoctlName(t *testing.T) {
+	assert.Equal(t, "IOCTL_NDISRD_READ_PACKETS_UNSORTED", ndisapi.IoctlName(ndisapi.IOCTL_NDISRD_READ_PACKETS_UNSORTED))
+	assert.Equal(t, "IOCTL 0x00000001", ndisapi.IoctlName(1))
+}
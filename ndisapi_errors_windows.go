@@ -0,0 +1,60 @@
+//go:build windows
+
+package ndisapi
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// classifyErrno returns the sentinel error of the Win32 errno, nil if it has none.
+func classifyErrno(err error) error {
+	var errno windows.Errno
+	if !errors.As(err, &errno) {
+		return nil
+	}
+
+	switch errno {
+	case windows.ERROR_FILE_NOT_FOUND, windows.ERROR_PATH_NOT_FOUND, windows.ERROR_INVALID_HANDLE:
+		return ErrDriverNotLoaded
+	case windows.ERROR_DEV_NOT_EXIST, windows.ERROR_NOT_FOUND:
+		return ErrAdapterNotFound
+	case windows.ERROR_INSUFFICIENT_BUFFER, windows.ERROR_MORE_DATA, windows.ERROR_BAD_LENGTH:
+		return ErrBufferTooSmall
+	case windows.ERROR_REVISION_MISMATCH, windows.ERROR_INVALID_FUNCTION:
+		// Drivers predating a control code fail it as an invalid function
+		return ErrVersionMismatch
+	default:
+		return nil
+	}
+}
+
+// driverError wraps the error of a driver request into a DriverError.
+func driverError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var driverErr *DriverError
+	if errors.As(err, &driverErr) {
+		return err
+	}
+
+	return &DriverError{Op: op, Kind: classifyErrno(err), Err: err}
+}
+
+// readError returns the error of a packet read. The driver fails the read of an empty queue with
+// ERROR_NO_MORE_ITEMS, which is classified as ErrQueueEmpty. The other failures are left as they are.
+func readError(err error) error {
+	var driverErr *DriverError
+	if errors.As(err, &driverErr) && driverErr.Kind == nil && errors.Is(driverErr.Err, windows.ERROR_NO_MORE_ITEMS) {
+		driverErr.Kind = ErrQueueEmpty
+	}
+	return err
+}
+
+// queueEmpty returns the error of a read request which succeeded without reading any packet.
+func queueEmpty(service uint32) error {
+	return &DriverError{Op: IoctlName(service), Kind: ErrQueueEmpty}
+}
@@ -0,0 +1,26 @@
+package ndisapi
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/windows"
+)
+
+func TestReadError(t *testing.T) {
+	op := IoctlName(IOCTL_NDISRD_READ_PACKET)
+
+	err := readError(driverError(op, windows.ERROR_NO_MORE_ITEMS))
+	assert.True(t, errors.Is(err, ErrQueueEmpty))
+
+	for _, errno := range []windows.Errno{windows.ERROR_INVALID_PARAMETER, windows.ERROR_GEN_FAILURE} {
+		err := readError(driverError(op, errno))
+		assert.False(t, errors.Is(err, ErrQueueEmpty), errno.Error())
+		assert.True(t, errors.Is(err, errno))
+	}
+
+	err = readError(driverError(op, windows.ERROR_INVALID_HANDLE))
+	assert.False(t, errors.Is(err, ErrQueueEmpty))
+	assert.True(t, errors.Is(err, ErrDriverNotLoaded))
+}
@@ -1,11 +1,5 @@
-//go:build windows
-
 package ndisapi
 
-import (
-	"unsafe"
-)
-
 const UnsortedMaximumBlockNum = 16
 const UnsortedMaximumPacketBlock = 512
 const FastIOMaximumPacketBlock = 2048 * 3
@@ -69,117 +63,3 @@ type UnsortedReadSendRequest struct {
 	Packets    []*IntermediateBuffer
 	PacketsNum uint32
 }
-
-// InitializeFastIo initializes the Fast I/O shared memory section.
-func (a *NdisApi) InitializeFastIo(fastIo *InitializeFastIOSection, size uint32) bool {
-	if size < uint32(unsafe.Sizeof(InitializeFastIOSection{})) {
-		return false
-	}
-
-	params := InitializeFastIOParams{Header: fastIo, DataSize: size}
-
-	err := a.DeviceIoControl(
-		IOCTL_NDISRD_INITIALIZE_FAST_IO,
-		unsafe.Pointer(&params),
-		uint32(unsafe.Sizeof(params)),
-		nil,
-		0,
-		&a.bytesReturned,
-		nil,
-	)
-
-	return err == nil
-}
-
-// AddSecondaryFastIo adds a secondary Fast I/O shared memory section.
-func (a *NdisApi) AddSecondaryFastIo(fastIo *InitializeFastIOSection, size uint32) bool {
-	if size < uint32(unsafe.Sizeof(InitializeFastIOSection{})) {
-		return false
-	}
-
-	params := InitializeFastIOParams{Header: fastIo, DataSize: size}
-
-	err := a.DeviceIoControl(
-		IOCTL_NDISRD_ADD_SECOND_FAST_IO_SECTION,
-		unsafe.Pointer(&params),
-		uint32(unsafe.Sizeof(params)),
-		nil,
-		0,
-		&a.bytesReturned,
-		nil,
-	)
-
-	return err == nil
-}
-
-// ReadPacketsUnsorted reads a bunch of packets from the driver packet queues without sorting by network adapter.
-func (a *NdisApi) ReadPacketsUnsorted(packets []*IntermediateBuffer, packetsNum uint32, packetsSuccess *uint32) bool {
-	request := UnsortedReadSendRequest{
-		Packets:    make([]*IntermediateBuffer, packetsNum),
-		PacketsNum: packetsNum,
-	}
-	copy(request.Packets, packets[:packetsNum])
-
-	err := a.DeviceIoControl(
-		IOCTL_NDISRD_READ_PACKETS_UNSORTED,
-		unsafe.Pointer(&request),
-		uint32(unsafe.Sizeof(request)),
-		unsafe.Pointer(&request),
-		uint32(unsafe.Sizeof(request)),
-		&a.bytesReturned,
-		nil,
-	)
-
-	len := uint32(len(request.Packets))
-	copy(packets[0:len], request.Packets[0:len])
-
-	*packetsSuccess = len
-
-	return err == nil
-}
-
-// SendPacketsToAdaptersUnsorted sends a bunch of packets to the network adapters.
-func (a *NdisApi) SendPacketsToAdaptersUnsorted(packets []*IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) bool {
-	request := UnsortedReadSendRequest{
-		Packets:    make([]*IntermediateBuffer, packetsNum),
-		PacketsNum: packetsNum,
-	}
-	copy(request.Packets, packets[:packetsNum])
-
-	err := a.DeviceIoControl(
-		IOCTL_NDISRD_SEND_PACKET_TO_ADAPTER_UNSORTED,
-		unsafe.Pointer(&request),
-		uint32(unsafe.Sizeof(request)),
-		unsafe.Pointer(&request),
-		uint32(unsafe.Sizeof(request)),
-		&a.bytesReturned,
-		nil,
-	)
-
-	*packetSuccess = request.PacketsNum
-
-	return err == nil
-}
-
-// SendPacketsToMstcpUnsorted indicates a bunch of packets to the MSTCP (and other upper layer network protocols).
-func (a *NdisApi) SendPacketsToMstcpUnsorted(packets []*IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) bool {
-	request := UnsortedReadSendRequest{
-		Packets:    make([]*IntermediateBuffer, packetsNum),
-		PacketsNum: packetsNum,
-	}
-	copy(request.Packets, packets[:packetsNum])
-
-	err := a.DeviceIoControl(
-		IOCTL_NDISRD_SEND_PACKET_TO_MSTCP_UNSORTED,
-		unsafe.Pointer(&request),
-		uint32(unsafe.Sizeof(request)),
-		unsafe.Pointer(&request),
-		uint32(unsafe.Sizeof(request)),
-		&a.bytesReturned,
-		nil,
-	)
-
-	*packetSuccess = request.PacketsNum
-
-	return err == nil
-}
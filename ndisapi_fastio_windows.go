@@ -0,0 +1,150 @@
+//go:build windows
+
+package ndisapi
+
+import (
+	"unsafe"
+)
+
+// InitializeFastIo initializes the Fast I/O shared memory section.
+func (a *NdisApi) InitializeFastIo(fastIo *InitializeFastIOSection, size uint32) bool {
+	return a.InitializeFastIoErr(fastIo, size) == nil
+}
+
+// InitializeFastIoErr is InitializeFastIo returning the cause of the failure.
+func (a *NdisApi) InitializeFastIoErr(fastIo *InitializeFastIOSection, size uint32) error {
+	if size < uint32(unsafe.Sizeof(InitializeFastIOSection{})) {
+		return &DriverError{Op: IoctlName(IOCTL_NDISRD_INITIALIZE_FAST_IO), Kind: ErrBufferTooSmall}
+	}
+
+	params := InitializeFastIOParams{Header: fastIo, DataSize: size}
+
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_INITIALIZE_FAST_IO,
+		unsafe.Pointer(&params),
+		uint32(unsafe.Sizeof(params)),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// AddSecondaryFastIo adds a secondary Fast I/O shared memory section.
+func (a *NdisApi) AddSecondaryFastIo(fastIo *InitializeFastIOSection, size uint32) bool {
+	return a.AddSecondaryFastIoErr(fastIo, size) == nil
+}
+
+// AddSecondaryFastIoErr is AddSecondaryFastIo returning the cause of the failure.
+func (a *NdisApi) AddSecondaryFastIoErr(fastIo *InitializeFastIOSection, size uint32) error {
+	if size < uint32(unsafe.Sizeof(InitializeFastIOSection{})) {
+		return &DriverError{Op: IoctlName(IOCTL_NDISRD_ADD_SECOND_FAST_IO_SECTION), Kind: ErrBufferTooSmall}
+	}
+
+	params := InitializeFastIOParams{Header: fastIo, DataSize: size}
+
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_ADD_SECOND_FAST_IO_SECTION,
+		unsafe.Pointer(&params),
+		uint32(unsafe.Sizeof(params)),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// ReadPacketsUnsorted reads a bunch of packets from the driver packet queues without sorting by network adapter.
+func (a *NdisApi) ReadPacketsUnsorted(packets []*IntermediateBuffer, packetsNum uint32, packetsSuccess *uint32) bool {
+	return a.ReadPacketsUnsortedErr(packets, packetsNum, packetsSuccess) == nil
+}
+
+// ReadPacketsUnsortedErr is ReadPacketsUnsorted returning the cause of the failure.
+// It returns ErrQueueEmpty when no packets are queued.
+func (a *NdisApi) ReadPacketsUnsortedErr(packets []*IntermediateBuffer, packetsNum uint32, packetsSuccess *uint32) error {
+	request := UnsortedReadSendRequest{
+		Packets:    make([]*IntermediateBuffer, packetsNum),
+		PacketsNum: packetsNum,
+	}
+	copy(request.Packets, packets[:packetsNum])
+
+	err := a.DeviceIoControl(
+		IOCTL_NDISRD_READ_PACKETS_UNSORTED,
+		unsafe.Pointer(&request),
+		uint32(unsafe.Sizeof(request)),
+		unsafe.Pointer(&request),
+		uint32(unsafe.Sizeof(request)),
+		&a.bytesReturned,
+		nil,
+	)
+
+	len := uint32(len(request.Packets))
+	copy(packets[0:len], request.Packets[0:len])
+
+	*packetsSuccess = len
+
+	if err != nil {
+		return readError(err)
+	}
+	if len == 0 {
+		return queueEmpty(IOCTL_NDISRD_READ_PACKETS_UNSORTED)
+	}
+
+	return nil
+}
+
+// SendPacketsToAdaptersUnsorted sends a bunch of packets to the network adapters.
+func (a *NdisApi) SendPacketsToAdaptersUnsorted(packets []*IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) bool {
+	return a.SendPacketsToAdaptersUnsortedErr(packets, packetsNum, packetSuccess) == nil
+}
+
+// SendPacketsToAdaptersUnsortedErr is SendPacketsToAdaptersUnsorted returning the cause of the failure.
+func (a *NdisApi) SendPacketsToAdaptersUnsortedErr(packets []*IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) error {
+	request := UnsortedReadSendRequest{
+		Packets:    make([]*IntermediateBuffer, packetsNum),
+		PacketsNum: packetsNum,
+	}
+	copy(request.Packets, packets[:packetsNum])
+
+	err := a.DeviceIoControl(
+		IOCTL_NDISRD_SEND_PACKET_TO_ADAPTER_UNSORTED,
+		unsafe.Pointer(&request),
+		uint32(unsafe.Sizeof(request)),
+		unsafe.Pointer(&request),
+		uint32(unsafe.Sizeof(request)),
+		&a.bytesReturned,
+		nil,
+	)
+
+	*packetSuccess = request.PacketsNum
+
+	return err
+}
+
+// SendPacketsToMstcpUnsorted indicates a bunch of packets to the MSTCP (and other upper layer network protocols).
+func (a *NdisApi) SendPacketsToMstcpUnsorted(packets []*IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) bool {
+	return a.SendPacketsToMstcpUnsortedErr(packets, packetsNum, packetSuccess) == nil
+}
+
+// SendPacketsToMstcpUnsortedErr is SendPacketsToMstcpUnsorted returning the cause of the failure.
+func (a *NdisApi) SendPacketsToMstcpUnsortedErr(packets []*IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) error {
+	request := UnsortedReadSendRequest{
+		Packets:    make([]*IntermediateBuffer, packetsNum),
+		PacketsNum: packetsNum,
+	}
+	copy(request.Packets, packets[:packetsNum])
+
+	err := a.DeviceIoControl(
+		IOCTL_NDISRD_SEND_PACKET_TO_MSTCP_UNSORTED,
+		unsafe.Pointer(&request),
+		uint32(unsafe.Sizeof(request)),
+		unsafe.Pointer(&request),
+		uint32(unsafe.Sizeof(request)),
+		&a.bytesReturned,
+		nil,
+	)
+
+	*packetSuccess = request.PacketsNum
+
+	return err
+}
@@ -1,16 +1,12 @@
-//go:build windows
-
 package ndisapi
 
 import (
 	"unsafe"
-
-	"golang.org/x/sys/windows"
 )
 
 // NdisApiInterface defines the interface for NDISAPI driver interactions.
 type NdisApiInterface interface {
-	DeviceIoControl(service uint32, in unsafe.Pointer, sizeIn uint32, out unsafe.Pointer, sizeOut uint32, SizeRet *uint32, overlapped *windows.Overlapped) error
+	DeviceIoControl(service uint32, in unsafe.Pointer, sizeIn uint32, out unsafe.Pointer, sizeOut uint32, SizeRet *uint32, overlapped *Overlapped) error
 	IsDriverLoaded() bool
 	Close()
 	GetVersion() (uint32, error)
@@ -32,9 +28,15 @@ type NdisApiAdapter interface {
 	GetAdapterMode(currentMode *AdapterMode) error
 	FlushAdapterPacketQueue(adapter Handle) error
 	GetAdapterPacketQueueSize(adapter Handle, size *uint32) error
-	SetPacketEvent(adapter Handle, win32Event windows.Handle) error
-	SetAdapterListChangeEvent(win32Event windows.Handle) error
+	SetPacketEvent(adapter Handle, win32Event EventHandle) error
+	SetAdapterListChangeEvent(win32Event EventHandle) error
+	SetHWFilterEvent(win32Event EventHandle) error
+	SetWANEvent(win32Event EventHandle) error
+	GetRasLinks(adapter Handle) (*RASLinks, error)
 	ConvertWindows2000AdapterName(adapterName string) string
+	GetInterfaceIndex(adapterName string) (uint32, error)
+	NdisGetRequest(adapter Handle, oid uint32, data []byte) (uint32, error)
+	NdisSetRequest(adapter Handle, oid uint32, data []byte) error
 }
 
 type NdisApiFastIO interface {
@@ -43,6 +45,13 @@ type NdisApiFastIO interface {
 	ReadPacketsUnsorted(packets []*IntermediateBuffer, packetsNum uint32, packetsSuccess *uint32) bool
 	SendPacketsToAdaptersUnsorted(packets []*IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) bool
 	SendPacketsToMstcpUnsorted(packets []*IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) bool
+
+	// The error returning variants tell the failures apart, e.g. ErrQueueEmpty from ErrDriverNotLoaded.
+	InitializeFastIoErr(fastIo *InitializeFastIOSection, size uint32) error
+	AddSecondaryFastIoErr(fastIo *InitializeFastIOSection, size uint32) error
+	ReadPacketsUnsortedErr(packets []*IntermediateBuffer, packetsNum uint32, packetsSuccess *uint32) error
+	SendPacketsToAdaptersUnsortedErr(packets []*IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) error
+	SendPacketsToMstcpUnsortedErr(packets []*IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) error
 }
 
 type NdisApiIO interface {
@@ -52,6 +61,10 @@ type NdisApiIO interface {
 	SendPacketsToMstcp(packet *EtherMultiRequest) error
 	SendPacketsToAdapter(packet *EtherMultiRequest) error
 	ReadPackets(packet *EtherMultiRequest) bool
+
+	// The error returning variants of the reads tell ErrQueueEmpty apart from the failures.
+	ReadPacketErr(packet *EtherRequest) error
+	ReadPacketsErr(packet *EtherMultiRequest) error
 }
 
 type NdisApiStaticFilters interface {
@@ -70,4 +83,4 @@ type NdisApiStaticFilters interface {
 	DisablePacketFilterCache() error
 	EnablePacketFragmentCache() error
 	DisablePacketFragmentCache() error
-}
\ No newline at end of file
+}
@@ -0,0 +1,111 @@
+//go:build windows
+
+package ndisapi
+
+import (
+	"unsafe"
+)
+
+// SendPacketToMstcp sends a packet to the MSTCP.
+func (a *NdisApi) SendPacketToMstcp(packet *EtherRequest) error {
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_SEND_PACKET_TO_MSTCP,
+		unsafe.Pointer(packet),
+		uint32(unsafe.Sizeof(EtherRequest{})),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// SendPacketToAdapter sends a packet to the network adapter.
+func (a *NdisApi) SendPacketToAdapter(packet *EtherRequest) error {
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_SEND_PACKET_TO_ADAPTER,
+		unsafe.Pointer(packet),
+		uint32(unsafe.Sizeof(EtherRequest{})),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// ReadPacket reads a packet from the Windows Packet Filter driver.
+// It reports true when the request failed, e.g. the queue was empty.
+func (a *NdisApi) ReadPacket(packet *EtherRequest) bool {
+	return a.ReadPacketErr(packet) != nil
+}
+
+// ReadPacketErr reads a packet from the Windows Packet Filter driver.
+// It returns ErrQueueEmpty when no packet is queued.
+func (a *NdisApi) ReadPacketErr(packet *EtherRequest) error {
+	size := uint32(unsafe.Sizeof(EtherRequest{}))
+	err := a.DeviceIoControl(
+		IOCTL_NDISRD_READ_PACKET,
+		unsafe.Pointer(packet),
+		size,
+		unsafe.Pointer(packet),
+		size,
+		nil,
+		nil,
+	)
+
+	return readError(err)
+}
+
+// SendPacketsToMstcp sends multiple packets to the Microsoft TCP/IP stack.
+func (a *NdisApi) SendPacketsToMstcp(packet *EtherMultiRequest) error {
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_SEND_PACKETS_TO_MSTCP,
+		unsafe.Pointer(packet),
+		uint32(unsafe.Sizeof(EtherMultiRequest{}))+uint32(unsafe.Sizeof(EthernetPacket{}))*(packet.PacketsNumber-1),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// SendPacketsToAdapter sends multiple packets to the network adapter.
+func (a *NdisApi) SendPacketsToAdapter(packet *EtherMultiRequest) error {
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_SEND_PACKETS_TO_ADAPTER,
+		unsafe.Pointer(packet),
+		uint32(unsafe.Sizeof(EtherMultiRequest{}))+uint32(unsafe.Sizeof(EthernetPacket{}))*(packet.PacketsNumber-1),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// ReadPackets reads multiple packets from the network adapter.
+// It reports true when the request failed or no packets were read.
+func (a *NdisApi) ReadPackets(packet *EtherMultiRequest) bool {
+	return a.ReadPacketsErr(packet) != nil
+}
+
+// ReadPacketsErr reads multiple packets from the network adapter.
+// It returns ErrQueueEmpty when no packets are queued.
+func (a *NdisApi) ReadPacketsErr(packet *EtherMultiRequest) error {
+	size := uint32(unsafe.Sizeof(EtherMultiRequest{})) + uint32(unsafe.Sizeof(EthernetPacket{}))*(packet.PacketsNumber-1)
+	err := a.DeviceIoControl(
+		IOCTL_NDISRD_READ_PACKETS,
+		unsafe.Pointer(packet),
+		size,
+		unsafe.Pointer(packet),
+		size,
+		&a.bytesReturned,
+		nil,
+	)
+	if err != nil {
+		return readError(err)
+	}
+	if packet.PacketsSuccess == 0 {
+		return queueEmpty(IOCTL_NDISRD_READ_PACKETS)
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package ndisapi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrOidDataLength is returned when an OID request returns less data than the OID requires.
+var ErrOidDataLength = errors.New("unexpected OID data length")
+
+// OidRequestError reports a failed NDIS OID request.
+type OidRequestError struct {
+	Adapter Handle
+	Oid     uint32
+	Set     bool
+	Err     error
+}
+
+func (e *OidRequestError) Error() string {
+	request := "get"
+	if e.Set {
+		request = "set"
+	}
+	return fmt.Sprintf("NDIS %s request for OID 0x%08X failed: %v", request, e.Oid, e.Err)
+}
+
+func (e *OidRequestError) Unwrap() error {
+	return e.Err
+}
+
+// AdapterStatistics holds the general statistics counters of the network adapter.
+type AdapterStatistics struct {
+	TransmitOK      uint64 // OID_GEN_XMIT_OK
+	ReceiveOK       uint64 // OID_GEN_RCV_OK
+	TransmitError   uint64 // OID_GEN_XMIT_ERROR
+	ReceiveError    uint64 // OID_GEN_RCV_ERROR
+	ReceiveNoBuffer uint64 // OID_GEN_RCV_NO_BUFFER
+}
+
+// GetLinkSpeed returns the link speed of the network adapter in bits per second.
+func GetLinkSpeed(api NdisApiAdapter, adapter Handle) (uint64, error) {
+	speed, err := getOidUint32(api, adapter, OID_GEN_LINK_SPEED)
+	// NDIS reports the speed in units of 100 bps
+	return uint64(speed) * 100, err
+}
+
+// IsMediaConnected reports whether the network adapter is connected to the network.
+func IsMediaConnected(api NdisApiAdapter, adapter Handle) (bool, error) {
+	status, err := getOidUint32(api, adapter, OID_GEN_MEDIA_CONNECT_STATUS)
+	return err == nil && status == NdisMediaStateConnected, err
+}
+
+// GetPermanentAddress returns the MAC address burned into the network adapter.
+func GetPermanentAddress(api NdisApiAdapter, adapter Handle) ([ETHER_ADDR_LENGTH]byte, error) {
+	return getOidAddress(api, adapter, OID_802_3_PERMANENT_ADDRESS)
+}
+
+// GetCurrentAddress returns the MAC address the network adapter currently uses.
+func GetCurrentAddress(api NdisApiAdapter, adapter Handle) ([ETHER_ADDR_LENGTH]byte, error) {
+	return getOidAddress(api, adapter, OID_802_3_CURRENT_ADDRESS)
+}
+
+// GetAdapterStatistics returns the general statistics counters of the network adapter.
+func GetAdapterStatistics(api NdisApiAdapter, adapter Handle) (*AdapterStatistics, error) {
+	var statistics AdapterStatistics
+	for _, counter := range []struct {
+		oid   uint32
+		value *uint64
+	}{
+		{OID_GEN_XMIT_OK, &statistics.TransmitOK},
+		{OID_GEN_RCV_OK, &statistics.ReceiveOK},
+		{OID_GEN_XMIT_ERROR, &statistics.TransmitError},
+		{OID_GEN_RCV_ERROR, &statistics.ReceiveError},
+		{OID_GEN_RCV_NO_BUFFER, &statistics.ReceiveNoBuffer},
+	} {
+		value, err := getOidCounter(api, adapter, counter.oid)
+		if err != nil {
+			return nil, err
+		}
+		*counter.value = value
+	}
+	return &statistics, nil
+}
+
+// GetHardwarePacketFilter returns the NDIS_PACKET_TYPE_* bits of the network adapter hardware filter.
+func GetHardwarePacketFilter(api NdisApiAdapter, adapter Handle) (uint32, error) {
+	return getOidUint32(api, adapter, OID_GEN_CURRENT_PACKET_FILTER)
+}
+
+// SetHardwarePacketFilter sets the NDIS_PACKET_TYPE_* bits of the network adapter hardware filter,
+// e.g. NDIS_PACKET_TYPE_PROMISCUOUS to receive all the packets of the network segment.
+func SetHardwarePacketFilter(api NdisApiAdapter, adapter Handle, filter uint32) error {
+	var data [4]byte
+	binary.LittleEndian.PutUint32(data[:], filter)
+	return api.NdisSetRequest(adapter, OID_GEN_CURRENT_PACKET_FILTER, data[:])
+}
+
+// getOidUint32 queries an OID whose value is a ULONG.
+func getOidUint32(api NdisApiAdapter, adapter Handle, oid uint32) (uint32, error) {
+	var data [4]byte
+	if err := getOid(api, adapter, oid, data[:], len(data)); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(data[:]), nil
+}
+
+// getOidCounter queries a statistics OID, which miniports report as either a ULONG or a ULONG64.
+func getOidCounter(api NdisApiAdapter, adapter Handle, oid uint32) (uint64, error) {
+	var data [8]byte
+	if err := getOid(api, adapter, oid, data[:], 4); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(data[:]), nil
+}
+
+// getOidAddress queries an OID whose value is a MAC address.
+func getOidAddress(api NdisApiAdapter, adapter Handle, oid uint32) ([ETHER_ADDR_LENGTH]byte, error) {
+	var address [ETHER_ADDR_LENGTH]byte
+	err := getOid(api, adapter, oid, address[:], len(address))
+	return address, err
+}
+
+// getOid queries the OID into data, which must be filled with at least minLength bytes.
+func getOid(api NdisApiAdapter, adapter Handle, oid uint32, data []byte, minLength int) error {
+	length, err := api.NdisGetRequest(adapter, oid, data)
+	if err != nil {
+		return err
+	}
+	if int(length) < minLength || int(length) > len(data) {
+		return &OidRequestError{Adapter: adapter, Oid: oid, Err: ErrOidDataLength}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package ndisapi_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wiresock/ndisapi-go"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+func TestOidHelpers(t *testing.T) {
+	api := sim.NewDriver()
+	mac := [ndisapi.ETHER_ADDR_LENGTH]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`, HardwareAddr: mac})
+
+	speed, err := ndisapi.GetLinkSpeed(api, handle)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1000000000), speed)
+
+	connected, err := ndisapi.IsMediaConnected(api, handle)
+	require.NoError(t, err)
+	assert.True(t, connected)
+
+	require.NoError(t, api.SetOid(handle, ndisapi.OID_GEN_MEDIA_CONNECT_STATUS, []byte{ndisapi.NdisMediaStateDisconnected, 0, 0, 0}))
+	connected, err = ndisapi.IsMediaConnected(api, handle)
+	require.NoError(t, err)
+	assert.False(t, connected)
+
+	permanent, err := ndisapi.GetPermanentAddress(api, handle)
+	require.NoError(t, err)
+	assert.Equal(t, mac, permanent)
+
+	current, err := ndisapi.GetCurrentAddress(api, handle)
+	require.NoError(t, err)
+	assert.Equal(t, mac, current)
+
+	// Miniports report the counters as either 32 or 64 bit values.
+	require.NoError(t, api.SetOid(handle, ndisapi.OID_GEN_XMIT_OK, []byte{1, 0, 0, 0}))
+	require.NoError(t, api.SetOid(handle, ndisapi.OID_GEN_RCV_OK, []byte{0, 0, 0, 0, 1, 0, 0, 0}))
+	statistics, err := ndisapi.GetAdapterStatistics(api, handle)
+	require.NoError(t, err)
+	assert.Equal(t, ndisapi.AdapterStatistics{TransmitOK: 1, ReceiveOK: 1 << 32}, *statistics)
+
+	filter, err := ndisapi.GetHardwarePacketFilter(api, handle)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(ndisapi.NDIS_PACKET_TYPE_DIRECTED|ndisapi.NDIS_PACKET_TYPE_MULTICAST|ndisapi.NDIS_PACKET_TYPE_BROADCAST), filter)
+
+	require.NoError(t, ndisapi.SetHardwarePacketFilter(api, handle, filter|ndisapi.NDIS_PACKET_TYPE_PROMISCUOUS))
+	filter, err = ndisapi.GetHardwarePacketFilter(api, handle)
+	require.NoError(t, err)
+	assert.NotZero(t, filter&ndisapi.NDIS_PACKET_TYPE_PROMISCUOUS)
+}
+
+func TestOidHelpers_Errors(t *testing.T) {
+	api := sim.NewDriver()
+	handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+
+	var oidErr *ndisapi.OidRequestError
+
+	_, err := ndisapi.GetLinkSpeed(api, ndisapi.Handle{0xFF})
+	require.True(t, errors.As(err, &oidErr))
+	assert.Equal(t, uint32(ndisapi.OID_GEN_LINK_SPEED), oidErr.Oid)
+	assert.False(t, oidErr.Set)
+	assert.True(t, errors.Is(err, sim.ErrAdapterNotFound))
+
+	_, err = api.NdisGetRequest(handle, 0x00FFFFFF, make([]byte, 4))
+	assert.True(t, errors.Is(err, sim.ErrNotSupported))
+
+	_, err = api.NdisGetRequest(handle, ndisapi.OID_802_3_CURRENT_ADDRESS, make([]byte, 4))
+	assert.True(t, errors.Is(err, sim.ErrInvalidParameter))
+
+	err = api.NdisSetRequest(handle, ndisapi.OID_GEN_LINK_SPEED, make([]byte, 4))
+	require.True(t, errors.As(err, &oidErr))
+	assert.True(t, oidErr.Set)
+	assert.True(t, errors.Is(err, sim.ErrNotSupported))
+
+	require.NoError(t, api.SetOid(handle, ndisapi.OID_GEN_LINK_SPEED, []byte{1, 0}))
+	_, err = ndisapi.GetLinkSpeed(api, handle)
+	assert.True(t, errors.Is(err, ndisapi.ErrOidDataLength))
+}
@@ -0,0 +1,51 @@
+package ndisapi_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wiresock/ndisapi-go"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+func TestRASLinkInfo_Addresses(t *testing.T) {
+	var link ndisapi.RASLinkInfo
+	assert.Nil(t, link.IPv4Address())
+	assert.Nil(t, link.IPv6Address())
+
+	link.ProtocolBufferLength = ndisapi.RAS_LINK_IPV6_ADDRESS_OFFSET + 8
+	copy(link.ProtocolBuffer[ndisapi.RAS_LINK_IPV4_ADDRESS_OFFSET:], []byte{192, 168, 100, 7})
+	copy(link.ProtocolBuffer[ndisapi.RAS_LINK_IPV6_ADDRESS_OFFSET:], []byte{0x02, 0x11, 0x22, 0xff, 0xfe, 0x33, 0x44, 0x55})
+
+	assert.Equal(t, net.IPv4(192, 168, 100, 7).To4(), link.IPv4Address())
+	assert.Equal(t, net.ParseIP("fe80::211:22ff:fe33:4455"), link.IPv6Address())
+
+	// The addresses past the valid length of the protocol buffer are ignored.
+	link.ProtocolBufferLength = ndisapi.RAS_LINK_IPV4_ADDRESS_OFFSET + net.IPv4len
+	assert.NotNil(t, link.IPv4Address())
+	assert.Nil(t, link.IPv6Address())
+}
+
+func TestGetRasLinks(t *testing.T) {
+	api := sim.NewDriver()
+	handle := api.AddAdapter(sim.AdapterConfig{Name: ndisapi.DEVICE_NDISWANIPV6})
+	assert.True(t, api.IsNdiswanIPv6(ndisapi.DEVICE_NDISWANIPV6))
+	assert.False(t, api.IsNdiswanIP(ndisapi.DEVICE_NDISWANIPV6))
+
+	links, err := api.GetRasLinks(handle)
+	require.NoError(t, err)
+	assert.Zero(t, links.NumberOfLinks)
+
+	require.NoError(t, api.SetRasLinks(handle, []ndisapi.RASLinkInfo{{LinkSpeed: 1}, {LinkSpeed: 2}}))
+	links, err = api.GetRasLinks(handle)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), links.NumberOfLinks)
+	assert.Equal(t, uint32(2), links.RASLinks[1].LinkSpeed)
+
+	_, err = api.GetRasLinks(ndisapi.Handle{0xFF})
+	assert.True(t, errors.Is(err, sim.ErrAdapterNotFound))
+}
@@ -1,12 +1,8 @@
-//go:build windows
-
 package ndisapi
 
 import (
 	"encoding/binary"
 	"net"
-	"strings"
-	"syscall"
 	"unsafe"
 )
 
@@ -22,10 +18,10 @@ type RASLinkInfo struct {
 	MaximumTotalSize uint32 // Maximum number of bytes per packet
 
 	// Represents the address of the remote node on the link in Ethernet-style format. NDISWAN supplies this value.
-	RemoteAddress net.HardwareAddr // Remote node address in Ethernet format
+	RemoteAddress [ETHER_ADDR_LENGTH]byte // Remote node address in Ethernet format
 
 	// Represents the protocol-determined context for indications on this link in Ethernet-style format.
-	LocalAddress net.HardwareAddr // Local node address in Ethernet format
+	LocalAddress [ETHER_ADDR_LENGTH]byte // Local node address in Ethernet format
 
 	ProtocolBufferLength uint32 // Number of bytes in protocol buffer
 	// Containing protocol-specific information supplied by a higher-level component that makes connections through NDISWAN
@@ -33,6 +29,48 @@ type RASLinkInfo struct {
 	ProtocolBuffer [RAS_LINK_BUFFER_LENGTH]byte // protocol-specific information
 }
 
+// Offsets of the link addresses in the protocol buffer of the NDISWANIP and NDISWANIPV6 links
+const (
+	RAS_LINK_IPV4_ADDRESS_OFFSET = 584 // IPv4 address of the link
+	RAS_LINK_IPV6_ADDRESS_OFFSET = 588 // interface identifier of the IPv6 link-local address
+)
+
+// IPv4Address returns the IPv4 address of an NDISWANIP link, nil if the protocol buffer doesn't carry one.
+func (l *RASLinkInfo) IPv4Address() net.IP {
+	data := l.protocolData(RAS_LINK_IPV4_ADDRESS_OFFSET, net.IPv4len)
+	if data == nil {
+		return nil
+	}
+	return net.IPv4(data[0], data[1], data[2], data[3]).To4()
+}
+
+// IPv6Address returns the link-local IPv6 address of an NDISWANIPV6 link, nil if the protocol buffer
+// doesn't carry one. The protocol buffer holds the interface identifier of the address.
+func (l *RASLinkInfo) IPv6Address() net.IP {
+	data := l.protocolData(RAS_LINK_IPV6_ADDRESS_OFFSET, 8)
+	if data == nil {
+		return nil
+	}
+	ip := make(net.IP, net.IPv6len)
+	ip[0], ip[1] = 0xfe, 0x80
+	copy(ip[8:], data)
+	return ip
+}
+
+// protocolData returns the bytes of the protocol buffer at the offset, nil if they are missing or all zero.
+func (l *RASLinkInfo) protocolData(offset, length int) []byte {
+	if int(l.ProtocolBufferLength) < offset+length || len(l.ProtocolBuffer) < offset+length {
+		return nil
+	}
+	data := l.ProtocolBuffer[offset : offset+length]
+	for _, b := range data {
+		if b != 0 {
+			return data
+		}
+	}
+	return nil
+}
+
 const RAS_LINKS_MAX = 256
 
 // RASLinks holds a collection of RAS link info
@@ -500,273 +538,6 @@ type StaticFilterTable struct {
 
 // StaticFilterWithPosition represents a static filter with a specific insertion position.
 type StaticFilterWithPosition struct {
-	Position     uint32
+	Position      uint32
 	StaticFilters StaticFilter
 }
-
-// SetPacketFilterTable sets the static packet filter table for the Windows Packet Filter driver.
-func (a *NdisApi) SetPacketFilterTable(packet *StaticFilterTable) error {
-	var size uint32 = 0
-	if packet != nil {
-		size = uint32(unsafe.Sizeof(InitialStaticFilterTable{})) + (packet.TableSize-1)*uint32(unsafe.Sizeof(StaticFilter{}))
-	}
-
-	return a.DeviceIoControl(
-		IOCTL_NDISRD_SET_PACKET_FILTERS,
-		unsafe.Pointer(packet),
-		size,
-		nil,
-		0,
-		&a.bytesReturned,
-		nil,
-	)
-}
-
-// AddStaticFilterFront adds a static filter to the front of the filter list in the Windows Packet Filter driver.
-func (a *NdisApi) AddStaticFilterFront(filter *StaticFilter) error {
-	return a.DeviceIoControl(
-		IOCTL_NDISRD_ADD_PACKET_FILTER_FRONT,
-		unsafe.Pointer(filter),
-		uint32(unsafe.Sizeof(StaticFilter{})),
-		nil,
-		0,
-		&a.bytesReturned,
-		nil,
-	)
-}
-
-// AddStaticFilterBack adds a static filter to the end of the filter chain.
-func (a *NdisApi) AddStaticFilterBack(filter *StaticFilter) error {
-	return a.DeviceIoControl(
-		IOCTL_NDISRD_ADD_PACKET_FILTER_BACK,
-		unsafe.Pointer(filter),
-		uint32(unsafe.Sizeof(StaticFilter{})),
-		nil,
-		0,
-		&a.bytesReturned,
-		nil,
-	)
-}
-
-// InsertStaticFilter inserts a static filter at a specified position in the filter chain.
-func (a *NdisApi) InsertStaticFilter(filter *StaticFilter, position uint32) error {
-	staticFilter := StaticFilterWithPosition{
-		Position:     position,
-		StaticFilters: *filter,
-	}
-	return a.DeviceIoControl(
-		IOCTL_NDISRD_INSERT_FILTER_BY_INDEX,
-		unsafe.Pointer(&staticFilter),
-		uint32(unsafe.Sizeof(StaticFilterWithPosition{})),
-		nil,
-		0,
-		&a.bytesReturned,
-		nil,
-	)
-}
-
-// InsertStaticFilter removes a static filter by its unique identifier.
-func (a *NdisApi) RemoveStaticFilter(filterID uint32) error {
-	return a.DeviceIoControl(
-		IOCTL_NDISRD_REMOVE_FILTER_BY_INDEX,
-		unsafe.Pointer(&filterID),
-		uint32(unsafe.Sizeof(filterID)),
-		nil,
-		0,
-		&a.bytesReturned,
-		nil,
-	)
-}
-
-// ResetPacketFilterTable resets the static packet filter table for the Windows Packet Filter driver.
-func (a *NdisApi) ResetPacketFilterTable() error {
-	return a.DeviceIoControl(
-		IOCTL_NDISRD_RESET_PACKET_FILTERS,
-		nil,
-		0,
-		nil,
-		0,
-		&a.bytesReturned,
-		nil,
-	)
-}
-
-// GetPacketFilterTableSize retrieves the size of the static packet filter table from the Windows Packet Filter driver.
-func (a *NdisApi) GetPacketFilterTableSize() (uint32, error) {
-	var tableSize uint32
-
-	err := a.DeviceIoControl(
-		IOCTL_NDISRD_GET_PACKET_FILTERS_TABLESIZE,
-		nil,
-		0,
-		unsafe.Pointer(&tableSize),
-		uint32(unsafe.Sizeof(tableSize)),
-		nil,
-		nil,
-	)
-
-	if err != nil {
-		return 0, err
-	}
-
-	return tableSize, nil
-}
-
-// GetPacketFilterTable retrieves the static packet filter table from the Windows Packet Filter driver.
-func (a *NdisApi) GetPacketFilterTable(tableSize uint32) (*StaticFilterTable, error) {
-	// Allocate memory for the filter table
-	var bufferSize int = int(unsafe.Sizeof(InitialStaticFilterTable{})) + (int(tableSize)-AnySize)*int(unsafe.Sizeof(StaticFilter{}))
-	tableBuffer := make([]byte, bufferSize)
-
-	err := a.DeviceIoControl(
-		IOCTL_NDISRD_GET_PACKET_FILTERS,
-		nil,
-		0,
-		unsafe.Pointer(&tableBuffer[0]),
-		uint32(bufferSize),
-		&a.bytesReturned,
-		nil,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	filterList := &StaticFilterTable{
-		TableSize:     tableSize,
-		Padding:       0,
-		StaticFilters: make([]StaticFilter, tableSize),
-	}
-
-	for i := 0; i < int(tableSize); i++ {
-		offset := 8 + i*int(unsafe.Sizeof(StaticFilter{}))
-		filterList.StaticFilters[i] = *(*StaticFilter)(unsafe.Pointer(&tableBuffer[offset]))
-	}
-
-	return filterList, nil
-}
-
-// GetPacketFilterTableResetStats retrieves the static packet filter table and resets statistics for the Windows Packet Filter driver.
-func (a *NdisApi) GetPacketFilterTableResetStats() (*StaticFilterTable, error) {
-	var staticFilterTable StaticFilterTable
-
-	err := a.DeviceIoControl(
-		IOCTL_NDISRD_GET_PACKET_FILTERS_RESET_STATS,
-		nil,
-		0,
-		unsafe.Pointer(&staticFilterTable),
-		uint32(unsafe.Sizeof(StaticFilterTable{}))+(staticFilterTable.TableSize-AnySize)*uint32(unsafe.Sizeof(StaticFilter{})),
-		&a.bytesReturned,
-		nil,
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	return &staticFilterTable, nil
-}
-
-// SetPacketFilterCacheState sets the state of the packet filter cache.
-func (a *NdisApi) SetPacketFilterCacheState(state bool) error {
-	var cacheState uint32
-	if state {
-		cacheState = 1
-	} else {
-		cacheState = 0
-	}
-	return a.DeviceIoControl(
-		IOCTL_NDISRD_SET_FILTER_CACHE_STATE,
-		unsafe.Pointer(&cacheState),
-		uint32(unsafe.Sizeof(cacheState)),
-		nil,
-		0,
-		&a.bytesReturned,
-		nil,
-	)
-}
-
-// SetPacketFragmentCacheState sets the state of the packet fragment cache.
-func (a *NdisApi) SetPacketFragmentCacheState(state bool) error {
-	var cacheState uint32
-	if state {
-		cacheState = 1
-	} else {
-		cacheState = 0
-	}
-	return a.DeviceIoControl(
-		IOCTL_NDISRD_SET_FRAGMENT_CACHE_STATE,
-		unsafe.Pointer(&cacheState),
-		uint32(unsafe.Sizeof(cacheState)),
-		nil,
-		0,
-		&a.bytesReturned,
-		nil,
-	)
-}
-
-// EnablePacketFilterCache enables the packet filter cache.
-func (a *NdisApi) EnablePacketFilterCache() error {
-	return a.SetPacketFilterCacheState(true)
-}
-
-// DisablePacketFilterCache disables the packet filter cache.
-func (a *NdisApi) DisablePacketFilterCache() error {
-	return a.SetPacketFilterCacheState(false)
-}
-
-// EnablePacketFragmentCache enables the packet fragment cache.
-func (a *NdisApi) EnablePacketFragmentCache() error {
-	return a.SetPacketFragmentCacheState(true)
-}
-
-// DisablePacketFragmentCache disables the packet fragment cache.
-func (a *NdisApi) DisablePacketFragmentCache() error {
-	return a.SetPacketFragmentCacheState(false)
-}
-
-// IsNdiswanInterfaces checks if the given adapter is an NDISWAN interface.
-func (a *NdisApi) IsNdiswanInterfaces(adapterName, ndiswanName string) bool {
-	isNdiswanInterface := false
-
-	// TODO:
-
-	return isNdiswanInterface
-}
-
-// IsNdiswanIP checks if the given adapter is an NDISWANIP interface.
-func (a *NdisApi) IsNdiswanIP(adapterName string) bool {
-	if a.IsWindows10OrGreater() && strings.Contains(adapterName, DEVICE_NDISWANIP) {
-		return true
-	}
-
-	return a.IsNdiswanInterfaces(adapterName, REGSTR_COMPONENTID_NDISWANIP)
-}
-
-// IsNdiswanIPv6 checks if the given adapter is an NDISWANIPV6 interface.
-func (a *NdisApi) IsNdiswanIPv6(adapterName string) bool {
-	if a.IsWindows10OrGreater() && strings.Contains(adapterName, DEVICE_NDISWANIPV6) {
-		return true
-	}
-
-	return a.IsNdiswanInterfaces(adapterName, REGSTR_COMPONENTID_NDISWANIPV6)
-}
-
-// IsNdiswanBh checks if the given adapter is an NDISWANBH interface.
-func (a *NdisApi) IsNdiswanBh(adapterName string) bool {
-	if a.IsWindows10OrGreater() && strings.Contains(adapterName, DEVICE_NDISWANBH) {
-		return true
-	}
-
-	return a.IsNdiswanInterfaces(adapterName, REGSTR_COMPONENTID_NDISWANBH)
-}
-
-var mod = syscall.NewLazyDLL("kernel32.dll")
-var proc = mod.NewProc("GetVersion")
-
-// IsWindows10OrGreater checks if the operating system is Windows 10 or greater.
-func (a *NdisApi) IsWindows10OrGreater() bool {
-	version, _, _ := proc.Call()
-	major := byte(version)
-	minor := byte(version >> 8)
-
-	return major > 6 || (major == 6 && minor >= 2)
-}
@@ -0,0 +1,306 @@
+//go:build windows
+
+package ndisapi
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// SetPacketFilterTable sets the static packet filter table for the Windows Packet Filter driver.
+func (a *NdisApi) SetPacketFilterTable(packet *StaticFilterTable) error {
+	if packet == nil {
+		return a.DeviceIoControl(
+			IOCTL_NDISRD_SET_PACKET_FILTERS,
+			nil,
+			0,
+			nil,
+			0,
+			&a.bytesReturned,
+			nil,
+		)
+	}
+
+	// The driver expects the filters to follow the table header contiguously
+	tableSize := int(packet.TableSize)
+	if tableSize > len(packet.StaticFilters) {
+		tableSize = len(packet.StaticFilters)
+	}
+	bufferSize := int(unsafe.Sizeof(InitialStaticFilterTable{})) + (tableSize-AnySize)*int(unsafe.Sizeof(StaticFilter{}))
+	if bufferSize < int(unsafe.Sizeof(InitialStaticFilterTable{})) {
+		bufferSize = int(unsafe.Sizeof(InitialStaticFilterTable{}))
+	}
+	tableBuffer := make([]byte, bufferSize)
+
+	table := (*InitialStaticFilterTable)(unsafe.Pointer(&tableBuffer[0]))
+	table.TableSize = uint32(tableSize)
+	for i := 0; i < tableSize; i++ {
+		offset := int(unsafe.Offsetof(InitialStaticFilterTable{}.StaticFilters)) + i*int(unsafe.Sizeof(StaticFilter{}))
+		*(*StaticFilter)(unsafe.Pointer(&tableBuffer[offset])) = packet.StaticFilters[i]
+	}
+
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_SET_PACKET_FILTERS,
+		unsafe.Pointer(&tableBuffer[0]),
+		uint32(bufferSize),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// AddStaticFilterFront adds a static filter to the front of the filter list in the Windows Packet Filter driver.
+func (a *NdisApi) AddStaticFilterFront(filter *StaticFilter) error {
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_ADD_PACKET_FILTER_FRONT,
+		unsafe.Pointer(filter),
+		uint32(unsafe.Sizeof(StaticFilter{})),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// AddStaticFilterBack adds a static filter to the end of the filter chain.
+func (a *NdisApi) AddStaticFilterBack(filter *StaticFilter) error {
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_ADD_PACKET_FILTER_BACK,
+		unsafe.Pointer(filter),
+		uint32(unsafe.Sizeof(StaticFilter{})),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// InsertStaticFilter inserts a static filter at a specified position in the filter chain.
+func (a *NdisApi) InsertStaticFilter(filter *StaticFilter, position uint32) error {
+	staticFilter := StaticFilterWithPosition{
+		Position:      position,
+		StaticFilters: *filter,
+	}
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_INSERT_FILTER_BY_INDEX,
+		unsafe.Pointer(&staticFilter),
+		uint32(unsafe.Sizeof(StaticFilterWithPosition{})),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// InsertStaticFilter removes a static filter by its unique identifier.
+func (a *NdisApi) RemoveStaticFilter(filterID uint32) error {
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_REMOVE_FILTER_BY_INDEX,
+		unsafe.Pointer(&filterID),
+		uint32(unsafe.Sizeof(filterID)),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// ResetPacketFilterTable resets the static packet filter table for the Windows Packet Filter driver.
+func (a *NdisApi) ResetPacketFilterTable() error {
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_RESET_PACKET_FILTERS,
+		nil,
+		0,
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// GetPacketFilterTableSize retrieves the size of the static packet filter table from the Windows Packet Filter driver.
+func (a *NdisApi) GetPacketFilterTableSize() (uint32, error) {
+	var tableSize uint32
+
+	err := a.DeviceIoControl(
+		IOCTL_NDISRD_GET_PACKET_FILTERS_TABLESIZE,
+		nil,
+		0,
+		unsafe.Pointer(&tableSize),
+		uint32(unsafe.Sizeof(tableSize)),
+		nil,
+		nil,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return tableSize, nil
+}
+
+// GetPacketFilterTable retrieves the static packet filter table from the Windows Packet Filter driver.
+func (a *NdisApi) GetPacketFilterTable(tableSize uint32) (*StaticFilterTable, error) {
+	// Allocate memory for the filter table
+	var bufferSize int = int(unsafe.Sizeof(InitialStaticFilterTable{})) + (int(tableSize)-AnySize)*int(unsafe.Sizeof(StaticFilter{}))
+	tableBuffer := make([]byte, bufferSize)
+
+	err := a.DeviceIoControl(
+		IOCTL_NDISRD_GET_PACKET_FILTERS,
+		nil,
+		0,
+		unsafe.Pointer(&tableBuffer[0]),
+		uint32(bufferSize),
+		&a.bytesReturned,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	filterList := &StaticFilterTable{
+		TableSize:     tableSize,
+		Padding:       0,
+		StaticFilters: make([]StaticFilter, tableSize),
+	}
+
+	for i := 0; i < int(tableSize); i++ {
+		offset := int(unsafe.Offsetof(InitialStaticFilterTable{}.StaticFilters)) + i*int(unsafe.Sizeof(StaticFilter{}))
+		filterList.StaticFilters[i] = *(*StaticFilter)(unsafe.Pointer(&tableBuffer[offset]))
+	}
+
+	return filterList, nil
+}
+
+// GetPacketFilterTableResetStats retrieves the static packet filter table and resets statistics for the Windows Packet Filter driver.
+func (a *NdisApi) GetPacketFilterTableResetStats() (*StaticFilterTable, error) {
+	var staticFilterTable StaticFilterTable
+
+	err := a.DeviceIoControl(
+		IOCTL_NDISRD_GET_PACKET_FILTERS_RESET_STATS,
+		nil,
+		0,
+		unsafe.Pointer(&staticFilterTable),
+		uint32(unsafe.Sizeof(StaticFilterTable{}))+(staticFilterTable.TableSize-AnySize)*uint32(unsafe.Sizeof(StaticFilter{})),
+		&a.bytesReturned,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &staticFilterTable, nil
+}
+
+// SetPacketFilterCacheState sets the state of the packet filter cache.
+func (a *NdisApi) SetPacketFilterCacheState(state bool) error {
+	var cacheState uint32
+	if state {
+		cacheState = 1
+	} else {
+		cacheState = 0
+	}
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_SET_FILTER_CACHE_STATE,
+		unsafe.Pointer(&cacheState),
+		uint32(unsafe.Sizeof(cacheState)),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// SetPacketFragmentCacheState sets the state of the packet fragment cache.
+func (a *NdisApi) SetPacketFragmentCacheState(state bool) error {
+	var cacheState uint32
+	if state {
+		cacheState = 1
+	} else {
+		cacheState = 0
+	}
+	return a.DeviceIoControl(
+		IOCTL_NDISRD_SET_FRAGMENT_CACHE_STATE,
+		unsafe.Pointer(&cacheState),
+		uint32(unsafe.Sizeof(cacheState)),
+		nil,
+		0,
+		&a.bytesReturned,
+		nil,
+	)
+}
+
+// EnablePacketFilterCache enables the packet filter cache.
+func (a *NdisApi) EnablePacketFilterCache() error {
+	return a.SetPacketFilterCacheState(true)
+}
+
+// DisablePacketFilterCache disables the packet filter cache.
+func (a *NdisApi) DisablePacketFilterCache() error {
+	return a.SetPacketFilterCacheState(false)
+}
+
+// EnablePacketFragmentCache enables the packet fragment cache.
+func (a *NdisApi) EnablePacketFragmentCache() error {
+	return a.SetPacketFragmentCacheState(true)
+}
+
+// DisablePacketFragmentCache disables the packet fragment cache.
+func (a *NdisApi) DisablePacketFragmentCache() error {
+	return a.SetPacketFragmentCacheState(false)
+}
+
+// IsNdiswanInterfaces checks if the given adapter is an NDISWAN interface.
+func (a *NdisApi) IsNdiswanInterfaces(adapterName, ndiswanName string) bool {
+	isNdiswanInterface := false
+
+	// TODO:
+
+	return isNdiswanInterface
+}
+
+// IsNdiswanIP checks if the given adapter is an NDISWANIP interface.
+func (a *NdisApi) IsNdiswanIP(adapterName string) bool {
+	if a.IsWindows10OrGreater() && isNdiswanDevice(adapterName, DEVICE_NDISWANIP) {
+		return true
+	}
+
+	return a.IsNdiswanInterfaces(adapterName, REGSTR_COMPONENTID_NDISWANIP)
+}
+
+// IsNdiswanIPv6 checks if the given adapter is an NDISWANIPV6 interface.
+func (a *NdisApi) IsNdiswanIPv6(adapterName string) bool {
+	if a.IsWindows10OrGreater() && isNdiswanDevice(adapterName, DEVICE_NDISWANIPV6) {
+		return true
+	}
+
+	return a.IsNdiswanInterfaces(adapterName, REGSTR_COMPONENTID_NDISWANIPV6)
+}
+
+// IsNdiswanBh checks if the given adapter is an NDISWANBH interface.
+func (a *NdisApi) IsNdiswanBh(adapterName string) bool {
+	if a.IsWindows10OrGreater() && isNdiswanDevice(adapterName, DEVICE_NDISWANBH) {
+		return true
+	}
+
+	return a.IsNdiswanInterfaces(adapterName, REGSTR_COMPONENTID_NDISWANBH)
+}
+
+// isNdiswanDevice reports whether the adapter is the NDISWAN device, ignoring case and the trailing NULs.
+func isNdiswanDevice(adapterName, device string) bool {
+	return strings.EqualFold(strings.TrimRight(adapterName, "\x00"), device)
+}
+
+var mod = syscall.NewLazyDLL("kernel32.dll")
+var proc = mod.NewProc("GetVersion")
+
+// IsWindows10OrGreater checks if the operating system is Windows 10 or greater.
+func (a *NdisApi) IsWindows10OrGreater() bool {
+	version, _, _ := proc.Call()
+	major := byte(version)
+	minor := byte(version >> 8)
+
+	return major > 6 || (major == 6 && minor >= 2)
+}
@@ -9,7 +9,6 @@ import (
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
-	"golang.org/x/sys/windows"
 
 	"github.com/wiresock/ndisapi-go"
 	mock_ndisapi "github.com/wiresock/ndisapi-go/mock"
@@ -160,7 +159,7 @@ func TestNdisApi_SetPacketEvent(t *testing.T) {
 
 	mockNdis := mock_ndisapi.NewMockNdisApiInterface(ctrl)
 	adapter := ndisapi.Handle{}
-	event := windows.Handle(1234)
+	event := ndisapi.EventHandle(1234)
 	mockNdis.EXPECT().SetPacketEvent(adapter, event).Return(nil)
 
 	err := mockNdis.SetPacketEvent(adapter, event)
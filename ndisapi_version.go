@@ -0,0 +1,138 @@
+package ndisapi
+
+import "fmt"
+
+// DriverVersion is the driver version in the encoding returned by GetVersion: the major version
+// in bits 12-15, the minor version in bits 24-31 and the revision in bits 16-23.
+type DriverVersion uint32
+
+// The first driver versions supporting the optional features, the IOCTLs were added in this order.
+const (
+	FastIODriverVersion          DriverVersion = 0x02183000 // 3.2.24, IOCTL_NDISRD_INITIALIZE_FAST_IO
+	UnsortedIODriverVersion      DriverVersion = 0x021D3000 // 3.2.29, IOCTL_NDISRD_READ_PACKETS_UNSORTED and sends
+	SecondaryFastIODriverVersion DriverVersion = 0x021D3000 // 3.2.29, IOCTL_NDISRD_ADD_SECOND_FAST_IO_SECTION
+	IBPoolQueryDriverVersion     DriverVersion = 0x02203000 // 3.2.32, IOCTL_NDISRD_QUERY_IB_POOL_SIZE
+	FilterInsertDriverVersion    DriverVersion = 0x04033000 // 3.4.3, IOCTL_NDISRD_INSERT_FILTER_BY_INDEX and its siblings
+	FilterCacheDriverVersion     DriverVersion = 0x04083000 // 3.4.8, IOCTL_NDISRD_SET_FILTER_CACHE_STATE
+	FragmentCacheDriverVersion   DriverVersion = 0x04083000 // 3.4.8, IOCTL_NDISRD_SET_FRAGMENT_CACHE_STATE
+)
+
+// NewDriverVersion encodes the version the way the driver reports it.
+func NewDriverVersion(major, minor, revision uint8) DriverVersion {
+	return DriverVersion(uint32(minor)<<24 | uint32(revision)<<16 | uint32(major&0xF)<<12)
+}
+
+// Major returns the major version, NDISRD_MAJOR_VERSION for the drivers this package is built for.
+func (v DriverVersion) Major() uint8 {
+	return uint8(v >> 12 & 0xF)
+}
+
+// Minor returns the minor version.
+func (v DriverVersion) Minor() uint8 {
+	return uint8(v >> 24)
+}
+
+// Revision returns the revision.
+func (v DriverVersion) Revision() uint8 {
+	return uint8(v >> 16)
+}
+
+// Less reports whether the version is older than the other one.
+func (v DriverVersion) Less(other DriverVersion) bool {
+	if v.Major() != other.Major() {
+		return v.Major() < other.Major()
+	}
+	if v.Minor() != other.Minor() {
+		return v.Minor() < other.Minor()
+	}
+	return v.Revision() < other.Revision()
+}
+
+func (v DriverVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Revision())
+}
+
+// Capabilities holds the optional features supported by the driver.
+type Capabilities struct {
+	Version             DriverVersion
+	FastIO              bool // InitializeFastIo
+	SecondaryFastIO     bool // AddSecondaryFastIo
+	UnsortedIO          bool // ReadPacketsUnsorted, SendPacketsToAdaptersUnsorted and SendPacketsToMstcpUnsorted
+	FilterCache         bool // SetPacketFilterCacheState
+	FragmentCache       bool // SetPacketFragmentCacheState
+	FilterInsertByIndex bool // AddStaticFilterFront, AddStaticFilterBack, InsertStaticFilter and RemoveStaticFilter
+	IBPoolQuery         bool // GetIntermediateBufferPoolSize
+}
+
+// CapabilitiesOf returns the features supported by the driver version.
+func CapabilitiesOf(version DriverVersion) Capabilities {
+	supports := func(required DriverVersion) bool {
+		return !version.Less(required)
+	}
+
+	return Capabilities{
+		Version:             version,
+		FastIO:              supports(FastIODriverVersion),
+		SecondaryFastIO:     supports(SecondaryFastIODriverVersion),
+		UnsortedIO:          supports(UnsortedIODriverVersion),
+		FilterCache:         supports(FilterCacheDriverVersion),
+		FragmentCache:       supports(FragmentCacheDriverVersion),
+		FilterInsertByIndex: supports(FilterInsertDriverVersion),
+		IBPoolQuery:         supports(IBPoolQueryDriverVersion),
+	}
+}
+
+// GetCapabilities queries the driver version and returns the features it supports.
+func GetCapabilities(api NdisApiInterface) (Capabilities, error) {
+	version, err := api.GetVersion()
+	if err != nil {
+		return Capabilities{}, err
+	}
+	return CapabilitiesOf(DriverVersion(version)), nil
+}
+
+// VersionError reports a driver too old for the requested feature. It matches ErrVersionMismatch with errors.Is.
+type VersionError struct {
+	Feature  string // the feature requiring the version, empty for the version check of NewNdisApi
+	Version  DriverVersion
+	Required DriverVersion
+}
+
+func (e *VersionError) Error() string {
+	if e.Feature == "" {
+		return fmt.Sprintf("driver version %s is older than the required %s", e.Version, e.Required)
+	}
+	return fmt.Sprintf("%s requires driver version %s, the driver is %s", e.Feature, e.Required, e.Version)
+}
+
+// Is reports whether the target is ErrVersionMismatch.
+func (e *VersionError) Is(target error) bool {
+	return target == ErrVersionMismatch
+}
+
+// RequireDriverVersion returns a *VersionError if the driver is older than the required version.
+func RequireDriverVersion(api NdisApiInterface, required DriverVersion, feature string) error {
+	version, err := api.GetVersion()
+	if err != nil {
+		return err
+	}
+	if DriverVersion(version).Less(required) {
+		return &VersionError{Feature: feature, Version: DriverVersion(version), Required: required}
+	}
+	return nil
+}
+
+// NdisApiOption configures NewNdisApi.
+type NdisApiOption func(options *ndisApiOptions)
+
+type ndisApiOptions struct {
+	minimumVersion DriverVersion
+}
+
+// WithMinimumDriverVersion makes NewNdisApi fail with a *VersionError when the driver is older than the version,
+// e.g. NewDriverVersion(3, 2, 29) for the fast I/O packet filter.
+func WithMinimumDriverVersion(version DriverVersion) NdisApiOption {
+	return func(options *ndisApiOptions) {
+		options.minimumVersion = version
+	}
+}
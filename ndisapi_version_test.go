@@ -0,0 +1,67 @@
+package ndisapi_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wiresock/ndisapi-go"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+func TestDriverVersion(t *testing.T) {
+	version := ndisapi.DriverVersion(ndisapi.NDISRD_VERSION)
+	assert.Equal(t, ndisapi.NewDriverVersion(3, 6, 1), version)
+	assert.Equal(t, uint8(ndisapi.NDISRD_MAJOR_VERSION), version.Major())
+	assert.Equal(t, "3.6.1", version.String())
+
+	// The components are compared in order of significance, not the encoded values
+	assert.True(t, ndisapi.NewDriverVersion(3, 2, 32).Less(version))
+	assert.True(t, ndisapi.NewDriverVersion(3, 6, 0).Less(version))
+	assert.False(t, version.Less(version))
+	assert.False(t, ndisapi.NewDriverVersion(4, 0, 0).Less(version))
+	assert.Equal(t, "3.2.24", ndisapi.FastIODriverVersion.String())
+}
+
+func TestCapabilitiesOf(t *testing.T) {
+	assert.Equal(t, ndisapi.Capabilities{
+		Version:             ndisapi.NDISRD_VERSION,
+		FastIO:              true,
+		SecondaryFastIO:     true,
+		UnsortedIO:          true,
+		FilterCache:         true,
+		FragmentCache:       true,
+		FilterInsertByIndex: true,
+		IBPoolQuery:         true,
+	}, ndisapi.CapabilitiesOf(ndisapi.NDISRD_VERSION))
+
+	assert.Equal(t, ndisapi.Capabilities{
+		Version: ndisapi.FastIODriverVersion,
+		FastIO:  true,
+	}, ndisapi.CapabilitiesOf(ndisapi.FastIODriverVersion))
+}
+
+func TestRequireDriverVersion(t *testing.T) {
+	api := sim.NewDriver()
+	api.SetVersion(uint32(ndisapi.NewDriverVersion(3, 2, 24)))
+
+	capabilities, err := ndisapi.GetCapabilities(api)
+	require.NoError(t, err)
+	assert.True(t, capabilities.FastIO)
+	assert.False(t, capabilities.UnsortedIO)
+
+	assert.NoError(t, ndisapi.RequireDriverVersion(api, ndisapi.FastIODriverVersion, "fast I/O"))
+
+	err = ndisapi.RequireDriverVersion(api, ndisapi.UnsortedIODriverVersion, "unsorted I/O")
+	assert.ErrorIs(t, err, ndisapi.ErrVersionMismatch)
+	assert.Equal(t, "unsorted I/O requires driver version 3.2.29, the driver is 3.2.24", err.Error())
+
+	var versionErr *ndisapi.VersionError
+	require.True(t, errors.As(err, &versionErr))
+	assert.Equal(t, ndisapi.UnsortedIODriverVersion, versionErr.Required)
+
+	err = ndisapi.RequireDriverVersion(api, ndisapi.NDISRD_VERSION, "")
+	assert.Equal(t, "driver version 3.2.24 is older than the required 3.6.1", err.Error())
+}
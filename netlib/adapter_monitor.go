@@ -0,0 +1,243 @@
+package netlib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// AdapterEventType is the type of an adapter list change.
+type AdapterEventType int
+
+const (
+	AdapterAdded   AdapterEventType = iota // the adapter was plugged in
+	AdapterRemoved                         // the adapter was unplugged
+	AdapterChanged                         // the adapter was re-bound, e.g. its handle, MAC address or MTU changed
+)
+
+func (t AdapterEventType) String() string {
+	switch t {
+	case AdapterAdded:
+		return "added"
+	case AdapterRemoved:
+		return "removed"
+	case AdapterChanged:
+		return "changed"
+	default:
+		return fmt.Sprintf("AdapterEventType(%d)", int(t))
+	}
+}
+
+// AdapterEvent reports an adapter added to, removed from or changed in the driver adapter list.
+type AdapterEvent struct {
+	Type AdapterEventType
+	// ID is the stable identity of the adapter, its internal name. Unlike the handle and the
+	// position in the adapter list it survives the adapter being re-bound or the list being reordered.
+	ID       string
+	Adapter  A.AdapterInfo // the adapter after the change, the removed adapter for AdapterRemoved
+	Previous A.AdapterInfo // the adapter before the change, for AdapterChanged only
+}
+
+// AdapterMonitor tracks the driver adapter list and reports its changes to the subscribers.
+// The driver has a single adapter list change event, so the monitor is shared by all the users of an api.
+type AdapterMonitor struct {
+	sync.Mutex
+	api         A.NdisApiInterface
+	event       A.Event
+	adapters    A.AdapterInfoList
+	handlers    map[int]func(event AdapterEvent)
+	nextHandler int
+	refs        int
+	logger      loggerOption
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// adapterMonitors holds the monitors shared by the users of each api.
+var adapterMonitors = struct {
+	sync.Mutex
+	monitors map[A.NdisApiInterface]*AdapterMonitor
+}{monitors: make(map[A.NdisApiInterface]*AdapterMonitor)}
+
+// NewAdapterMonitor returns the adapter monitor of the api, starting it on first use.
+// Each call must be paired with a call to Close.
+func NewAdapterMonitor(api A.NdisApiInterface) (*AdapterMonitor, error) {
+	adapterMonitors.Lock()
+	defer adapterMonitors.Unlock()
+
+	if m, ok := adapterMonitors.monitors[api]; ok {
+		m.Lock()
+		m.refs++
+		m.Unlock()
+		return m, nil
+	}
+
+	event, err := A.NewEvent()
+	if err != nil {
+		return nil, fmt.Errorf("error creating event for adapter list changes: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &AdapterMonitor{
+		api:      api,
+		event:    event,
+		handlers: make(map[int]func(event AdapterEvent)),
+		refs:     1,
+		cancel:   cancel,
+	}
+
+	if err := api.SetAdapterListChangeEvent(event.Handle()); err != nil {
+		cancel()
+		_ = event.Close()
+		return nil, fmt.Errorf("failed to set adapter list change event: %v", err)
+	}
+
+	// The current adapters are the baseline of the first changes
+	m.update()
+
+	m.wg.Add(1)
+	go m.watch(ctx)
+
+	adapterMonitors.monitors[api] = m
+
+	return m, nil
+}
+
+// Close releases the monitor, which stops when its last user closes it.
+func (m *AdapterMonitor) Close() {
+	adapterMonitors.Lock()
+	defer adapterMonitors.Unlock()
+
+	m.Lock()
+	m.refs--
+	last := m.refs == 0
+	m.Unlock()
+
+	// The monitor is stopped before a new one can register its event with the driver
+	if last {
+		delete(adapterMonitors.monitors, m.api)
+		m.cancel()
+		m.wg.Wait()
+	}
+}
+
+// SetLogger sets the logger receiving the diagnostics of the monitor, nil discards them.
+func (m *AdapterMonitor) SetLogger(logger A.Logger) {
+	m.logger.set(logger)
+}
+
+// Adapters returns the adapters known to the monitor.
+func (m *AdapterMonitor) Adapters() A.AdapterInfoList {
+	m.Lock()
+	defer m.Unlock()
+
+	return append(A.AdapterInfoList(nil), m.adapters...)
+}
+
+// Subscribe calls the handler for each change of the adapter list until the returned function is called.
+// The handlers are called one event at a time from the monitor goroutine.
+func (m *AdapterMonitor) Subscribe(handler func(event AdapterEvent)) (unsubscribe func()) {
+	m.Lock()
+	defer m.Unlock()
+
+	id := m.nextHandler
+	m.nextHandler++
+	m.handlers[id] = handler
+
+	return func() {
+		m.Lock()
+		defer m.Unlock()
+
+		delete(m.handlers, id)
+	}
+}
+
+// watch waits for the driver to signal an adapter list change and reports the changes to the subscribers.
+func (m *AdapterMonitor) watch(ctx context.Context) {
+	defer m.wg.Done()
+	defer func() {
+		_ = m.api.SetAdapterListChangeEvent(0)
+		_ = m.event.Close()
+	}()
+
+	for {
+		if err := m.event.WaitContext(ctx, A.WaitInfinite); err != nil {
+			return
+		}
+		_ = m.event.Reset()
+
+		events := m.update()
+
+		m.Lock()
+		handlers := make([]func(event AdapterEvent), 0, len(m.handlers))
+		for id := 0; id < m.nextHandler; id++ {
+			if handler, ok := m.handlers[id]; ok {
+				handlers = append(handlers, handler)
+			}
+		}
+		m.Unlock()
+
+		for _, event := range events {
+			m.logger.get().Debug("adapter list changed", "change", event.Type.String(), "adapter", event.ID)
+			for _, handler := range handlers {
+				handler(event)
+			}
+		}
+	}
+}
+
+// update queries the adapter list and returns the changes since the previous update.
+func (m *AdapterMonitor) update() []AdapterEvent {
+	adapters, err := A.GetTcpipBoundAdapters(m.api)
+	if err != nil {
+		m.logger.get().Warn("failed to query the adapter list", "error", err)
+		return nil
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	previous := make(map[string]*A.AdapterInfo, len(m.adapters))
+	for i := range m.adapters {
+		previous[adapterID(&m.adapters[i])] = &m.adapters[i]
+	}
+
+	var removed, changed, added []AdapterEvent
+	current := make(map[string]bool, len(adapters))
+	for i := range adapters {
+		adapter := &adapters[i]
+		id := adapterID(adapter)
+		current[id] = true
+
+		old, ok := previous[id]
+		switch {
+		case !ok:
+			added = append(added, AdapterEvent{Type: AdapterAdded, ID: adapter.Name, Adapter: *adapter})
+		case !equalAdapterInfo(old, adapter):
+			changed = append(changed, AdapterEvent{Type: AdapterChanged, ID: adapter.Name, Adapter: *adapter, Previous: *old})
+		}
+	}
+	for i := range m.adapters {
+		if adapter := &m.adapters[i]; !current[adapterID(adapter)] {
+			removed = append(removed, AdapterEvent{Type: AdapterRemoved, ID: adapter.Name, Adapter: *adapter})
+		}
+	}
+	m.adapters = adapters
+
+	return append(append(removed, changed...), added...)
+}
+
+// adapterID returns the key of the adapter identity.
+func adapterID(adapter *A.AdapterInfo) string {
+	return strings.ToUpper(adapter.Name)
+}
+
+// equalAdapterInfo reports whether the adapter was not changed.
+func equalAdapterInfo(a, b *A.AdapterInfo) bool {
+	return a.Handle == b.Handle && a.FriendlyName == b.FriendlyName && a.Medium == b.Medium &&
+		bytes.Equal(a.HardwareAddr, b.HardwareAddr) && a.MTU == b.MTU && a.InterfaceIndex == b.InterfaceIndex
+}
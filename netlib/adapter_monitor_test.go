@@ -0,0 +1,75 @@
+package netlib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	N "github.com/wiresock/ndisapi-go/netlib"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+func TestAdapterMonitor(t *testing.T) {
+	api := sim.NewDriver()
+	first := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`, FriendlyName: "Ethernet"})
+
+	monitor, err := N.NewAdapterMonitor(api)
+	require.NoError(t, err)
+	defer monitor.Close()
+
+	// The monitor is shared by the users of the api.
+	shared, err := N.NewAdapterMonitor(api)
+	require.NoError(t, err)
+	assert.Same(t, monitor, shared)
+	shared.Close()
+
+	require.Len(t, monitor.Adapters(), 1)
+	assert.Equal(t, first, monitor.Adapters()[0].Handle)
+
+	events := make(chan N.AdapterEvent, 8)
+	proceed := make(chan struct{}, 8)
+	unsubscribe := monitor.Subscribe(func(event N.AdapterEvent) {
+		events <- event
+		<-proceed
+	})
+	defer unsubscribe()
+
+	next := func() N.AdapterEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("adapter list change was not reported")
+			return N.AdapterEvent{}
+		}
+	}
+
+	second := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-1}`})
+	event := next()
+	assert.Equal(t, N.AdapterAdded, event.Type)
+	assert.Equal(t, `\DEVICE\{SIM-1}`, event.ID)
+	assert.Equal(t, second, event.Adapter.Handle)
+
+	// The first adapter is re-bound while the monitor delivers the event, and is reported changed.
+	require.NoError(t, api.RemoveAdapter(first))
+	rebound := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`, FriendlyName: "Ethernet"})
+	proceed <- struct{}{}
+
+	event = next()
+	assert.Equal(t, N.AdapterChanged, event.Type)
+	assert.Equal(t, `\DEVICE\{SIM-0}`, event.ID)
+	assert.Equal(t, first, event.Previous.Handle)
+	assert.Equal(t, rebound, event.Adapter.Handle)
+	proceed <- struct{}{}
+
+	require.NoError(t, api.RemoveAdapter(second))
+	event = next()
+	assert.Equal(t, N.AdapterRemoved, event.Type)
+	assert.Equal(t, second, event.Adapter.Handle)
+	proceed <- struct{}{}
+
+	require.Len(t, monitor.Adapters(), 1)
+	assert.Equal(t, rebound, monitor.Adapters()[0].Handle)
+}
@@ -0,0 +1,145 @@
+package netlib
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// driverEvents holds the events of a kind registered with the driver, one per api. The driver keeps
+// a single event of each kind, so the watchers of an api share it rather than replacing each other's.
+type driverEvents struct {
+	sync.Mutex
+	kind   string
+	set    func(api A.NdisApiInterface, event A.EventHandle) error
+	events map[A.NdisApiInterface]*driverEvent
+}
+
+// driverEvent is an event registered with the driver, it notifies its subscribers each time it is signaled.
+type driverEvent struct {
+	sync.Mutex
+	api            A.NdisApiInterface
+	event          A.Event
+	subscribers    map[int]chan struct{}
+	nextSubscriber int
+	refs           int
+	cancel         context.CancelFunc
+	wg             sync.WaitGroup
+}
+
+// hwFilterEvents are the hardware packet filter change events set with SetHWFilterEvent.
+var hwFilterEvents = &driverEvents{
+	kind: "hardware filter",
+	set: func(api A.NdisApiInterface, event A.EventHandle) error {
+		return api.SetHWFilterEvent(event)
+	},
+}
+
+// wanEvents are the WAN connection and disconnection events set with SetWANEvent.
+var wanEvents = &driverEvents{
+	kind: "WAN",
+	set: func(api A.NdisApiInterface, event A.EventHandle) error {
+		return api.SetWANEvent(event)
+	},
+}
+
+// subscribe returns a channel receiving a value after the driver signals the event of the api, registering
+// the event on first use. The signals are coalesced. Each call must be paired with a call to unsubscribe.
+func (r *driverEvents) subscribe(api A.NdisApiInterface) (signals <-chan struct{}, unsubscribe func(), err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	e, ok := r.events[api]
+	if !ok {
+		if e, err = r.register(api); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	id := e.nextSubscriber
+	e.nextSubscriber++
+	e.refs++
+	subscriber := make(chan struct{}, 1)
+	e.subscribers[id] = subscriber
+
+	return subscriber, func() { r.unsubscribe(e, id) }, nil
+}
+
+// register sets a new event with the driver and starts waiting for it. Must be called locked.
+func (r *driverEvents) register(api A.NdisApiInterface) (*driverEvent, error) {
+	event, err := A.NewEvent()
+	if err != nil {
+		return nil, fmt.Errorf("error creating event for %s changes: %v", r.kind, err)
+	}
+	if err := r.set(api, event.Handle()); err != nil {
+		_ = event.Close()
+		return nil, fmt.Errorf("failed to set %s event: %v", r.kind, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &driverEvent{
+		api:         api,
+		event:       event,
+		subscribers: make(map[int]chan struct{}),
+		cancel:      cancel,
+	}
+
+	e.wg.Add(1)
+	go r.watch(ctx, e)
+
+	if r.events == nil {
+		r.events = make(map[A.NdisApiInterface]*driverEvent)
+	}
+	r.events[api] = e
+
+	return e, nil
+}
+
+// unsubscribe releases the event, which is unregistered from the driver when its last subscriber leaves.
+func (r *driverEvents) unsubscribe(e *driverEvent, id int) {
+	r.Lock()
+	defer r.Unlock()
+
+	e.Lock()
+	delete(e.subscribers, id)
+	e.refs--
+	last := e.refs == 0
+	e.Unlock()
+
+	// The event is unregistered before a new one can be set with the driver
+	if last {
+		delete(r.events, e.api)
+		e.cancel()
+		e.wg.Wait()
+	}
+}
+
+// watch waits for the driver to signal the event and notifies the subscribers.
+func (r *driverEvents) watch(ctx context.Context, e *driverEvent) {
+	defer e.wg.Done()
+	defer func() {
+		_ = r.set(e.api, 0)
+		_ = e.event.Close()
+	}()
+
+	for {
+		if err := e.event.WaitContext(ctx, A.WaitInfinite); err != nil {
+			return
+		}
+		_ = e.event.Reset()
+
+		e.Lock()
+		for _, subscriber := range e.subscribers {
+			select {
+			case subscriber <- struct{}{}:
+			default:
+			}
+		}
+		e.Unlock()
+	}
+}
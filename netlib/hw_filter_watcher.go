@@ -0,0 +1,140 @@
+package netlib
+
+import (
+	"context"
+	"sync"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// HWFilterEvent reports a change of the hardware packet filter of a network adapter.
+type HWFilterEvent struct {
+	Adapter      A.Handle
+	InternalName string
+	OldFilter    uint32 // NDIS_PACKET_TYPE_* bits before the change
+	NewFilter    uint32 // NDIS_PACKET_TYPE_* bits after the change
+}
+
+// Enabled returns the filter bits set by the change.
+func (e *HWFilterEvent) Enabled() uint32 {
+	return e.NewFilter &^ e.OldFilter
+}
+
+// Disabled returns the filter bits cleared by the change.
+func (e *HWFilterEvent) Disabled() uint32 {
+	return e.OldFilter &^ e.NewFilter
+}
+
+// HWFilterWatcher reports the changes of the hardware packet filters of the network adapters,
+// e.g. when another application enables promiscuous mode.
+// The watchers of an api share the event registered with the driver.
+type HWFilterWatcher struct {
+	api         A.NdisApiInterface
+	signals     <-chan struct{}
+	unsubscribe func()
+	filters     map[A.Handle]uint32
+	events      chan HWFilterEvent
+	logger      loggerOption
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// NewHWFilterWatcher starts watching the hardware packet filters until the context is done or the watcher is closed.
+func NewHWFilterWatcher(ctx context.Context, api A.NdisApiInterface) (*HWFilterWatcher, error) {
+	signals, unsubscribe, err := hwFilterEvents.subscribe(api)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	w := &HWFilterWatcher{
+		api:         api,
+		signals:     signals,
+		unsubscribe: unsubscribe,
+		filters:     make(map[A.Handle]uint32),
+		events:      make(chan HWFilterEvent, A.ADAPTER_LIST_SIZE),
+		cancel:      cancel,
+	}
+
+	// The current filters are the baseline of the first changes
+	w.update()
+
+	w.wg.Add(1)
+	go w.watch(ctx)
+
+	return w, nil
+}
+
+// Events returns the channel of the hardware filter changes, it is closed when the watcher stops.
+func (w *HWFilterWatcher) Events() <-chan HWFilterEvent {
+	return w.events
+}
+
+// SetLogger sets the logger receiving the diagnostics of the watcher, nil discards them.
+func (w *HWFilterWatcher) SetLogger(logger A.Logger) {
+	w.logger.set(logger)
+}
+
+// Close stops watching the hardware packet filters.
+func (w *HWFilterWatcher) Close() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+// watch waits for the driver to signal a hardware filter change and reports the changed adapters.
+func (w *HWFilterWatcher) watch(ctx context.Context) {
+	defer w.wg.Done()
+	defer func() {
+		w.unsubscribe()
+		close(w.events)
+	}()
+
+	for {
+		select {
+		case <-w.signals:
+		case <-ctx.Done():
+			return
+		}
+
+		for _, event := range w.update() {
+			select {
+			case w.events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// update queries the hardware filters of the adapters and returns the changes since the previous update.
+func (w *HWFilterWatcher) update() []HWFilterEvent {
+	adapters, err := w.api.GetTcpipBoundAdaptersInfo()
+	if err != nil {
+		w.logger.get().Warn("failed to query the adapters for hardware filter changes", "error", err)
+		return nil
+	}
+
+	var events []HWFilterEvent
+	filters := make(map[A.Handle]uint32, adapters.AdapterCount)
+	for i := 0; i < int(adapters.AdapterCount); i++ {
+		handle := adapters.AdapterHandle[i]
+		filter, err := A.GetHardwarePacketFilter(w.api, handle)
+		if err != nil {
+			// Adapters not supporting the OID, e.g. NDISWAN, have no hardware filter to watch
+			continue
+		}
+		filters[handle] = filter
+
+		if old, ok := w.filters[handle]; ok && old != filter {
+			events = append(events, HWFilterEvent{
+				Adapter:      handle,
+				InternalName: adapters.AdapterName(i),
+				OldFilter:    old,
+				NewFilter:    filter,
+			})
+		}
+	}
+	w.filters = filters
+
+	return events
+}
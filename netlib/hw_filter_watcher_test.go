@@ -0,0 +1,73 @@
+package netlib_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	N "github.com/wiresock/ndisapi-go/netlib"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+func TestHWFilterWatcher(t *testing.T) {
+	api := sim.NewDriver()
+	adapter := newSimAdapter(t, api)
+	original := hardwareFilter(t, api, adapter)
+
+	watcher, err := N.NewHWFilterWatcher(context.Background(), api)
+	require.NoError(t, err)
+
+	// Another application enables promiscuous mode.
+	require.NoError(t, A.SetHardwarePacketFilter(api, adapter.GetAdapter(), original|A.NDIS_PACKET_TYPE_PROMISCUOUS))
+
+	select {
+	case event := <-watcher.Events():
+		assert.Equal(t, adapter.GetAdapter(), event.Adapter)
+		assert.Equal(t, `\DEVICE\{SIM-0}`, event.InternalName)
+		assert.Equal(t, original, event.OldFilter)
+		assert.Equal(t, original|A.NDIS_PACKET_TYPE_PROMISCUOUS, event.NewFilter)
+		assert.Equal(t, uint32(A.NDIS_PACKET_TYPE_PROMISCUOUS), event.Enabled())
+		assert.Zero(t, event.Disabled())
+	case <-time.After(5 * time.Second):
+		t.Fatal("hardware filter change was not reported")
+	}
+
+	require.NoError(t, A.SetHardwarePacketFilter(api, adapter.GetAdapter(), original))
+	select {
+	case event := <-watcher.Events():
+		assert.Equal(t, uint32(A.NDIS_PACKET_TYPE_PROMISCUOUS), event.Disabled())
+	case <-time.After(5 * time.Second):
+		t.Fatal("hardware filter change was not reported")
+	}
+
+	watcher.Close()
+	_, ok := <-watcher.Events()
+	assert.False(t, ok)
+}
+
+func TestHWFilterWatcher_Shared(t *testing.T) {
+	api := sim.NewDriver()
+	adapter := newSimAdapter(t, api)
+	original := hardwareFilter(t, api, adapter)
+
+	first, err := N.NewHWFilterWatcher(context.Background(), api)
+	require.NoError(t, err)
+	second, err := N.NewHWFilterWatcher(context.Background(), api)
+	require.NoError(t, err)
+	defer second.Close()
+
+	// Closing a watcher keeps the event of the others registered with the driver.
+	first.Close()
+	require.NoError(t, A.SetHardwarePacketFilter(api, adapter.GetAdapter(), original|A.NDIS_PACKET_TYPE_PROMISCUOUS))
+
+	select {
+	case event := <-second.Events():
+		assert.Equal(t, uint32(A.NDIS_PACKET_TYPE_PROMISCUOUS), event.Enabled())
+	case <-time.After(5 * time.Second):
+		t.Fatal("hardware filter change was not reported")
+	}
+}
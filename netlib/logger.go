@@ -0,0 +1,29 @@
+package netlib
+
+import (
+	"sync"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// loggerOption holds the logger of a type whose goroutines may log while the logger is replaced.
+type loggerOption struct {
+	mu     sync.Mutex
+	logger A.Logger
+}
+
+// set replaces the logger, nil discards the diagnostics.
+func (o *loggerOption) set(logger A.Logger) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.logger = logger
+}
+
+// get returns the logger, a NopLogger if none is set.
+func (o *loggerOption) get() A.Logger {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return A.LoggerOrNop(o.logger)
+}
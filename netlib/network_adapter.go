@@ -1,27 +1,25 @@
-//go:build windows
-
 package netlib
 
 import (
+	"context"
 	"fmt"
-
-	"golang.org/x/sys/windows"
+	"time"
 
 	A "github.com/wiresock/ndisapi-go"
 )
 
-type NdisWanType int
+type NdisWanType = A.NdisWanType
 type MacAddress [6]byte
 
 const (
-	NdisWanNone NdisWanType = iota
-	NdisWanIP
-	NdisWanIPv6
-	NdisWanBH
+	NdisWanNone = A.NdisWanNone
+	NdisWanIP   = A.NdisWanIP
+	NdisWanIPv6 = A.NdisWanIPv6
+	NdisWanBH   = A.NdisWanBH
 )
 
 type NetworkAdapter struct {
-	API          *A.NdisApi
+	API          A.NdisApiInterface
 	HardwareAddr MacAddress
 	InternalName string
 	FriendlyName string
@@ -29,12 +27,14 @@ type NetworkAdapter struct {
 	MTU          uint16
 	CurrentMode  A.AdapterMode
 	NdisWanType  NdisWanType
+	// Logger receives the diagnostics of the adapter, nil discards them.
+	Logger A.Logger
 
-	packetEvent *A.SafeEvent
+	packetEvent A.Event
 }
 
 // NewNetworkAdapter constructs a NetworkAdapter instance using the provided parameters.
-func NewNetworkAdapter(api *A.NdisApi, adapterHandle A.Handle, macAddr MacAddress, internalName, friendlyName string, medium uint32, mtu uint16, packetEventHandle *windows.Handle) (*NetworkAdapter, error) {
+func NewNetworkAdapter(api A.NdisApiInterface, adapterHandle A.Handle, macAddr MacAddress, internalName, friendlyName string, medium uint32, mtu uint16, packetEvent A.Event) (*NetworkAdapter, error) {
 	adapter := &NetworkAdapter{
 		API:          api,
 		HardwareAddr: macAddr,
@@ -48,14 +48,14 @@ func NewNetworkAdapter(api *A.NdisApi, adapterHandle A.Handle, macAddr MacAddres
 		},
 	}
 
-	if packetEventHandle == nil {
-		eventHandle, err := windows.CreateEvent(nil, 1, 0, nil)
+	if packetEvent == nil {
+		event, err := A.NewEvent()
 		if err != nil {
 			return nil, fmt.Errorf("error creating event for adapter: %s", err.Error())
 		}
-		adapter.packetEvent = A.NewSafeEvent(eventHandle)
+		adapter.packetEvent = event
 	} else {
-		adapter.packetEvent = A.NewSafeEvent(*packetEventHandle)
+		adapter.packetEvent = packetEvent
 	}
 
 	// Initialize NDISWAN type
@@ -72,12 +72,20 @@ func NewNetworkAdapter(api *A.NdisApi, adapterHandle A.Handle, macAddr MacAddres
 	return adapter, nil
 }
 
-// WaitEvent waits for the network interface event to be signaled.
-func (na *NetworkAdapter) WaitEvent(timeout uint32) (uint32, error) {
+// NewNetworkAdapterFromInfo constructs a NetworkAdapter instance for the adapter returned by A.GetTcpipBoundAdapters.
+func NewNetworkAdapterFromInfo(api A.NdisApiInterface, info *A.AdapterInfo, packetEvent A.Event) (*NetworkAdapter, error) {
+	var macAddr MacAddress
+	copy(macAddr[:], info.HardwareAddr)
+
+	return NewNetworkAdapter(api, info.Handle, macAddr, info.Name, info.FriendlyName, uint32(info.Medium), info.MTU, packetEvent)
+}
+
+// WaitEvent waits for the network interface event to be signaled, the timeout to elapse or the context to be done.
+func (na *NetworkAdapter) WaitEvent(ctx context.Context, timeout time.Duration) error {
 	if na.packetEvent == nil {
-		return 0, fmt.Errorf("event is not initialized")
+		return fmt.Errorf("event is not initialized")
 	}
-	return na.packetEvent.Wait(timeout)
+	return na.packetEvent.WaitContext(ctx, timeout)
 }
 
 // SignalEvent signals the packet event.
@@ -92,7 +100,7 @@ func (na *NetworkAdapter) ResetEvent() error {
 
 // SetPacketEvent submits the packet event into the driver.
 func (na *NetworkAdapter) SetPacketEvent() error {
-	return na.API.SetPacketEvent(na.CurrentMode.AdapterHandle, *na.packetEvent.Get())
+	return na.API.SetPacketEvent(na.CurrentMode.AdapterHandle, na.packetEvent.Handle())
 }
 
 // ResetPacketEvent submits the packet event into the driver.
@@ -104,15 +112,30 @@ func (na *NetworkAdapter) ResetPacketEvent() error {
 func (na *NetworkAdapter) Close() {
 	na.SignalEvent()
 
+	// Restore the state changed by SetPromiscuous on this instance
+	_ = na.closePromiscuous()
+
 	// Reset adapter mode and flush the packet queue
-	na.CurrentMode.Flags = 0
+	_ = na.SetMode(0)
 
-	na.API.SetAdapterMode(&na.CurrentMode)
 	na.API.FlushAdapterPacketQueue(na.CurrentMode.AdapterHandle)
 }
 
-// SetMode sets the filtering mode for the network interface.
+// SetMode sets the filtering mode for the network interface. The PromiscuousModeFlags
+// added by SetPromiscuous are kept until the promiscuous mode is disabled.
 func (na *NetworkAdapter) SetMode(flags uint32) error {
+	promiscuous.Lock()
+	defer promiscuous.Unlock()
+
+	if state := promiscuous.adapters[na.promiscuousKey()]; state != nil {
+		flags |= state.addedFlags
+	}
+
+	return na.setMode(flags)
+}
+
+// setMode sets the filtering mode as is.
+func (na *NetworkAdapter) setMode(flags uint32) error {
 	na.CurrentMode.Flags = flags
 
 	return na.API.SetAdapterMode(&na.CurrentMode)
@@ -120,10 +143,10 @@ func (na *NetworkAdapter) SetMode(flags uint32) error {
 
 // GetMode returns the current adapter mode.
 func (na *NetworkAdapter) GetMode() A.AdapterMode {
-	adapterMode := &A.AdapterMode{}
+	adapterMode := &A.AdapterMode{AdapterHandle: na.CurrentMode.AdapterHandle}
 	err := na.API.GetAdapterMode(adapterMode)
 	if err != nil {
-		fmt.Println(err)
+		A.LoggerOrNop(na.Logger).Error("failed to get adapter mode", "adapter", na.InternalName, "error", err)
 	}
 
 	return *adapterMode
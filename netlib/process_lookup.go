@@ -13,6 +13,8 @@ import (
 	"unsafe"
 
 	"golang.org/x/sys/windows"
+
+	A "github.com/wiresock/ndisapi-go"
 )
 
 type sourceDestination struct {
@@ -29,6 +31,7 @@ type ProcessInfo struct {
 type ProcessLookup struct {
 	sync.RWMutex
 	mapper map[sourceDestination]ProcessInfo
+	logger loggerOption
 }
 
 func NewProcessLookup() *ProcessLookup {
@@ -37,6 +40,11 @@ func NewProcessLookup() *ProcessLookup {
 	}
 }
 
+// SetLogger sets the logger receiving the diagnostics of the lookup, nil discards them.
+func (s *ProcessLookup) SetLogger(logger A.Logger) {
+	s.logger.set(logger)
+}
+
 func (s *ProcessLookup) FindProcessInfo(ctx context.Context, isUDP bool, source netip.AddrPort, destination netip.AddrPort, establishedOnly bool) (*ProcessInfo, error) {
 	s.RLock()
 	if info, ok := s.mapper[sourceDestination{source, destination}]; ok {
@@ -46,6 +54,7 @@ func (s *ProcessLookup) FindProcessInfo(ctx context.Context, isUDP bool, source
 	s.RUnlock()
 	processName, pid, err := findProcessName(isUDP, source.Addr(), int(source.Port()), establishedOnly)
 	if err != nil {
+		s.logger.get().Debug("process lookup failed", "source", source.String(), "destination", destination.String(), "error", err)
 		return nil, err
 	}
 	s.Lock()
@@ -132,7 +141,6 @@ func (s *searcher) search(b []byte, ip netip.Addr, port uint16, establishedOnly
 		row := b[4+itemSize*i : 4+itemSize*(i+1)]
 
 		if establishedOnly && s.tcpState >= 0 {
-			fmt.Println(s.tcpState, ip.String())
 			tcpState := readNativeUint32(row[s.tcpState : s.tcpState+4])
 			// MIB_TCP_STATE_ESTAB, only check established connections for TCP
 			if tcpState != 5 {
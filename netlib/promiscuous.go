@@ -0,0 +1,181 @@
+package netlib
+
+import (
+	"fmt"
+	"sync"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// PromiscuousModeFlags are the adapter mode flags applied in promiscuous mode. They keep the
+// TCP/IP stack from receiving the packets addressed to other hosts and drop the loopback packets.
+const PromiscuousModeFlags = A.MSTCP_FLAG_FILTER_DIRECT | A.MSTCP_FLAG_LOOPBACK_BLOCK
+
+// promiscuous tracks the adapters in promiscuous mode by their driver handle, as a packet filter
+// drives the same adapter through NetworkAdapter instances of its own. The adapters of an api are
+// restored when it is closed.
+var promiscuous struct {
+	sync.Mutex
+	adapters       map[promiscuousKey]*promiscuousState
+	unregisterHook map[A.NdisApiInterface]func()
+}
+
+// promiscuousKey identifies an adapter of a driver.
+type promiscuousKey struct {
+	api    A.NdisApiInterface
+	handle A.Handle
+}
+
+// promiscuousState is the state of the adapter saved when entering promiscuous mode.
+type promiscuousState struct {
+	adapter        *NetworkAdapter
+	hardwareFilter uint32
+	addedFlags     uint32
+}
+
+func (na *NetworkAdapter) promiscuousKey() promiscuousKey {
+	return promiscuousKey{api: na.API, handle: na.CurrentMode.AdapterHandle}
+}
+
+// SetPromiscuous enables or disables the promiscuous mode of the network adapter. Enabling saves the
+// hardware packet filter and applies the PromiscuousModeFlags, disabling restores both. The flags are
+// kept while the adapter mode changes, e.g. when a packet filter is started, paused or stopped through
+// instances of its own. The original state is restored by Close, by RestorePromiscuous and when the
+// api is closed on shutdown.
+func (na *NetworkAdapter) SetPromiscuous(enable bool) error {
+	promiscuous.Lock()
+	defer promiscuous.Unlock()
+
+	key := na.promiscuousKey()
+	if !enable {
+		return restorePromiscuous(key)
+	}
+	if promiscuous.adapters[key] != nil {
+		return nil
+	}
+
+	// The mode may have been set through another instance
+	handle := na.CurrentMode.AdapterHandle
+	mode := A.AdapterMode{AdapterHandle: handle}
+	if err := na.API.GetAdapterMode(&mode); err != nil {
+		return fmt.Errorf("failed to get adapter mode: %v", err)
+	}
+	hardwareFilter, err := A.GetHardwarePacketFilter(na.API, handle)
+	if err != nil {
+		return fmt.Errorf("failed to get hardware packet filter: %v", err)
+	}
+	if err := A.SetHardwarePacketFilter(na.API, handle, hardwareFilter|A.NDIS_PACKET_TYPE_PROMISCUOUS); err != nil {
+		return fmt.Errorf("failed to set hardware packet filter: %v", err)
+	}
+
+	state := &promiscuousState{
+		adapter:        na,
+		hardwareFilter: hardwareFilter,
+		addedFlags:     PromiscuousModeFlags &^ mode.Flags,
+	}
+	if err := na.setMode(mode.Flags | state.addedFlags); err != nil {
+		_ = A.SetHardwarePacketFilter(na.API, handle, hardwareFilter)
+		return fmt.Errorf("failed to set adapter mode: %v", err)
+	}
+
+	if promiscuous.adapters == nil {
+		promiscuous.adapters = make(map[promiscuousKey]*promiscuousState)
+		promiscuous.unregisterHook = make(map[A.NdisApiInterface]func())
+	}
+	promiscuous.adapters[key] = state
+	if promiscuous.unregisterHook[na.API] == nil {
+		api := na.API
+		promiscuous.unregisterHook[api] = A.OnClose(api, func() { _ = restoreAPIPromiscuous(api) })
+	}
+
+	return nil
+}
+
+// IsPromiscuous reports whether the network adapter was put into promiscuous mode by SetPromiscuous.
+func (na *NetworkAdapter) IsPromiscuous() bool {
+	promiscuous.Lock()
+	defer promiscuous.Unlock()
+
+	return promiscuous.adapters[na.promiscuousKey()] != nil
+}
+
+// RestorePromiscuous takes all the network adapters out of promiscuous mode, those of an api
+// are also restored when it is closed. It returns the first failure.
+func RestorePromiscuous() error {
+	return restoreAPIPromiscuous(nil)
+}
+
+// restoreAPIPromiscuous takes the network adapters of the api out of promiscuous mode, all of
+// them if the api is nil. It returns the first failure.
+func restoreAPIPromiscuous(api A.NdisApiInterface) error {
+	promiscuous.Lock()
+	defer promiscuous.Unlock()
+
+	var err error
+	for key := range promiscuous.adapters {
+		if api != nil && key.api != api {
+			continue
+		}
+		if restoreErr := restorePromiscuous(key); restoreErr != nil && err == nil {
+			err = restoreErr
+		}
+	}
+
+	return err
+}
+
+// closePromiscuous restores the state saved by SetPromiscuous when it was called on this instance.
+// The promiscuous mode enabled through another instance is kept.
+func (na *NetworkAdapter) closePromiscuous() error {
+	promiscuous.Lock()
+	defer promiscuous.Unlock()
+
+	key := na.promiscuousKey()
+	if state := promiscuous.adapters[key]; state == nil || state.adapter != na {
+		return nil
+	}
+
+	return restorePromiscuous(key)
+}
+
+// restorePromiscuous restores the state saved by SetPromiscuous. Must be called locked.
+func restorePromiscuous(key promiscuousKey) error {
+	state := promiscuous.adapters[key]
+	if state == nil {
+		return nil
+	}
+
+	delete(promiscuous.adapters, key)
+	releasePromiscuousHook(key.api)
+
+	if err := A.SetHardwarePacketFilter(key.api, key.handle, state.hardwareFilter); err != nil {
+		return fmt.Errorf("failed to restore hardware packet filter: %v", err)
+	}
+
+	// The mode may have been changed through another instance since
+	mode := A.AdapterMode{AdapterHandle: key.handle}
+	if err := key.api.GetAdapterMode(&mode); err != nil {
+		return fmt.Errorf("failed to get adapter mode: %v", err)
+	}
+	if mode.Flags&state.addedFlags != 0 {
+		if err := state.adapter.setMode(mode.Flags &^ state.addedFlags); err != nil {
+			return fmt.Errorf("failed to restore adapter mode: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// releasePromiscuousHook unregisters the close hook of the api once none of its adapters is left in
+// promiscuous mode. Must be called locked.
+func releasePromiscuousHook(api A.NdisApiInterface) {
+	for key := range promiscuous.adapters {
+		if key.api == api {
+			return
+		}
+	}
+	if unregister := promiscuous.unregisterHook[api]; unregister != nil {
+		delete(promiscuous.unregisterHook, api)
+		unregister()
+	}
+}
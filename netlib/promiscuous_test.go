@@ -0,0 +1,121 @@
+package netlib_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	N "github.com/wiresock/ndisapi-go/netlib"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+func newSimAdapter(t *testing.T, api *sim.Driver) *N.NetworkAdapter {
+	handle := api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+	adapter, err := N.NewNetworkAdapter(api, handle, N.MacAddress{}, `\DEVICE\{SIM-0}`, "Ethernet", 0, 1500, nil)
+	require.NoError(t, err)
+	return adapter
+}
+
+func hardwareFilter(t *testing.T, api *sim.Driver, adapter *N.NetworkAdapter) uint32 {
+	filter, err := A.GetHardwarePacketFilter(api, adapter.GetAdapter())
+	require.NoError(t, err)
+	return filter
+}
+
+func TestNetworkAdapter_SetPromiscuous(t *testing.T) {
+	api := sim.NewDriver()
+	adapter := newSimAdapter(t, api)
+	original := hardwareFilter(t, api, adapter)
+
+	require.NoError(t, adapter.SetMode(A.MSTCP_FLAG_RECV_TUNNEL|A.MSTCP_FLAG_LOOPBACK_BLOCK))
+	require.NoError(t, adapter.SetPromiscuous(true))
+	assert.True(t, adapter.IsPromiscuous())
+	assert.Equal(t, original|A.NDIS_PACKET_TYPE_PROMISCUOUS, hardwareFilter(t, api, adapter))
+	assert.Equal(t, uint32(A.MSTCP_FLAG_RECV_TUNNEL|A.MSTCP_FLAG_FILTER_DIRECT|A.MSTCP_FLAG_LOOPBACK_BLOCK), adapter.CurrentMode.Flags)
+
+	// Enabling twice keeps the filter saved the first time.
+	require.NoError(t, adapter.SetPromiscuous(true))
+
+	require.NoError(t, adapter.SetPromiscuous(false))
+	assert.False(t, adapter.IsPromiscuous())
+	assert.Equal(t, original, hardwareFilter(t, api, adapter))
+	assert.Equal(t, uint32(A.MSTCP_FLAG_RECV_TUNNEL|A.MSTCP_FLAG_LOOPBACK_BLOCK), adapter.CurrentMode.Flags)
+
+	require.NoError(t, adapter.SetPromiscuous(false))
+}
+
+func TestNetworkAdapter_PromiscuousRestore(t *testing.T) {
+	api := sim.NewDriver()
+	adapter := newSimAdapter(t, api)
+	original := hardwareFilter(t, api, adapter)
+
+	require.NoError(t, adapter.SetPromiscuous(true))
+	adapter.Close()
+	assert.False(t, adapter.IsPromiscuous())
+	assert.Equal(t, original, hardwareFilter(t, api, adapter))
+	assert.Zero(t, adapter.GetMode().Flags)
+
+	require.NoError(t, adapter.SetPromiscuous(true))
+	require.NoError(t, N.RestorePromiscuous())
+	assert.False(t, adapter.IsPromiscuous())
+	assert.Equal(t, original, hardwareFilter(t, api, adapter))
+	assert.Zero(t, adapter.GetMode().Flags)
+}
+
+func TestNetworkAdapter_PromiscuousRestoredOnAPIClose(t *testing.T) {
+	api := sim.NewDriver()
+	adapter := newSimAdapter(t, api)
+	original := hardwareFilter(t, api, adapter)
+
+	other := sim.NewDriver()
+	otherAdapter := newSimAdapter(t, other)
+	defer func() {
+		assert.NoError(t, N.RestorePromiscuous())
+	}()
+
+	require.NoError(t, adapter.SetPromiscuous(true))
+	require.NoError(t, otherAdapter.SetPromiscuous(true))
+
+	// Closing the api on shutdown restores its adapters only.
+	api.Close()
+	assert.False(t, adapter.IsPromiscuous())
+	assert.Equal(t, original, hardwareFilter(t, api, adapter))
+	assert.True(t, otherAdapter.IsPromiscuous())
+}
+
+func TestNetworkAdapter_PromiscuousKeptByMode(t *testing.T) {
+	api := sim.NewDriver()
+	adapter := newSimAdapter(t, api)
+	require.NoError(t, adapter.SetPromiscuous(true))
+	defer func() {
+		assert.NoError(t, N.RestorePromiscuous())
+	}()
+
+	// A packet filter drives the adapter through an instance of its own.
+	filterAdapter, err := N.NewNetworkAdapter(api, adapter.GetAdapter(), N.MacAddress{}, `\DEVICE\{SIM-0}`, "Ethernet", 0, 1500, nil)
+	require.NoError(t, err)
+	assert.True(t, filterAdapter.IsPromiscuous())
+
+	require.NoError(t, filterAdapter.SetMode(A.MSTCP_FLAG_SENT_TUNNEL|A.MSTCP_FLAG_RECV_TUNNEL))
+	assert.Equal(t, uint32(A.MSTCP_FLAG_SENT_TUNNEL|A.MSTCP_FLAG_RECV_TUNNEL|N.PromiscuousModeFlags), adapter.GetMode().Flags)
+
+	require.NoError(t, filterAdapter.SetMode(0))
+	filterAdapter.Close()
+	assert.Equal(t, uint32(N.PromiscuousModeFlags), adapter.GetMode().Flags)
+	assert.True(t, adapter.IsPromiscuous())
+
+	require.NoError(t, filterAdapter.SetMode(A.MSTCP_FLAG_RECV_TUNNEL))
+	require.NoError(t, adapter.SetPromiscuous(false))
+	assert.Equal(t, uint32(A.MSTCP_FLAG_RECV_TUNNEL), adapter.GetMode().Flags)
+}
+
+func TestNetworkAdapter_SetPromiscuousUnknownAdapter(t *testing.T) {
+	api := sim.NewDriver()
+	adapter, err := N.NewNetworkAdapter(api, A.Handle{0xFF}, N.MacAddress{}, `\DEVICE\{SIM-0}`, "Ethernet", 0, 1500, nil)
+	require.NoError(t, err)
+
+	assert.Error(t, adapter.SetPromiscuous(true))
+	assert.False(t, adapter.IsPromiscuous())
+}
@@ -0,0 +1,176 @@
+package netlib
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// WANLinkEventType is the type of a WAN link event.
+type WANLinkEventType int
+
+const (
+	WANLinkUp   WANLinkEventType = iota // the link was established
+	WANLinkDown                         // the link was closed
+)
+
+func (t WANLinkEventType) String() string {
+	switch t {
+	case WANLinkUp:
+		return "up"
+	case WANLinkDown:
+		return "down"
+	default:
+		return fmt.Sprintf("WANLinkEventType(%d)", int(t))
+	}
+}
+
+// WANLinkEvent reports a RAS link, e.g. a VPN or PPP connection, coming up or going down on an NDISWAN adapter.
+type WANLinkEvent struct {
+	Type         WANLinkEventType
+	Adapter      A.Handle
+	InternalName string
+	Link         A.RASLinkInfo
+}
+
+// WANLinkWatcher reports the RAS links established and closed on the NDISWAN adapters.
+// The watchers of an api share the event registered with the driver.
+type WANLinkWatcher struct {
+	api         A.NdisApiInterface
+	signals     <-chan struct{}
+	unsubscribe func()
+	links       map[wanLinkKey]A.RASLinkInfo
+	events      chan WANLinkEvent
+	logger      loggerOption
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+// wanLinkKey identifies a RAS link by its adapter and its Ethernet-style addresses.
+type wanLinkKey struct {
+	adapter       A.Handle
+	remoteAddress [A.ETHER_ADDR_LENGTH]byte
+	localAddress  [A.ETHER_ADDR_LENGTH]byte
+}
+
+// NewWANLinkWatcher starts watching the RAS links until the context is done or the watcher is closed.
+func NewWANLinkWatcher(ctx context.Context, api A.NdisApiInterface) (*WANLinkWatcher, error) {
+	signals, unsubscribe, err := wanEvents.subscribe(api)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	w := &WANLinkWatcher{
+		api:         api,
+		signals:     signals,
+		unsubscribe: unsubscribe,
+		links:       make(map[wanLinkKey]A.RASLinkInfo),
+		events:      make(chan WANLinkEvent, A.ADAPTER_LIST_SIZE),
+		cancel:      cancel,
+	}
+
+	// The links already established are the baseline of the first changes
+	w.update()
+
+	w.wg.Add(1)
+	go w.watch(ctx)
+
+	return w, nil
+}
+
+// Events returns the channel of the WAN link changes, it is closed when the watcher stops.
+func (w *WANLinkWatcher) Events() <-chan WANLinkEvent {
+	return w.events
+}
+
+// SetLogger sets the logger receiving the diagnostics of the watcher, nil discards them.
+func (w *WANLinkWatcher) SetLogger(logger A.Logger) {
+	w.logger.set(logger)
+}
+
+// Close stops watching the RAS links.
+func (w *WANLinkWatcher) Close() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+// watch waits for the driver to signal a WAN connection or disconnection and reports the changed links.
+func (w *WANLinkWatcher) watch(ctx context.Context) {
+	defer w.wg.Done()
+	defer func() {
+		w.unsubscribe()
+		close(w.events)
+	}()
+
+	for {
+		select {
+		case <-w.signals:
+		case <-ctx.Done():
+			return
+		}
+
+		for _, event := range w.update() {
+			select {
+			case w.events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// update queries the RAS links of the NDISWAN adapters and returns the changes since the previous update.
+func (w *WANLinkWatcher) update() []WANLinkEvent {
+	adapters, err := w.api.GetTcpipBoundAdaptersInfo()
+	if err != nil {
+		w.logger.get().Warn("failed to query the adapters for WAN link changes", "error", err)
+		return nil
+	}
+
+	var events []WANLinkEvent
+	links := make(map[wanLinkKey]A.RASLinkInfo)
+	names := make(map[A.Handle]string)
+	for i := 0; i < int(adapters.AdapterCount); i++ {
+		name := adapters.AdapterName(i)
+		if !w.api.IsNdiswanIP(name) && !w.api.IsNdiswanIPv6(name) {
+			continue
+		}
+
+		handle := adapters.AdapterHandle[i]
+		names[handle] = name
+
+		rasLinks, err := w.api.GetRasLinks(handle)
+		if err != nil {
+			w.logger.get().Warn("failed to query the RAS links", "adapter", name, "error", err)
+			// Keep the links of the adapter rather than reporting them down on a transient failure
+			for key, link := range w.links {
+				if key.adapter == handle {
+					links[key] = link
+				}
+			}
+			continue
+		}
+
+		for j := 0; j < int(rasLinks.NumberOfLinks) && j < len(rasLinks.RASLinks); j++ {
+			link := rasLinks.RASLinks[j]
+			key := wanLinkKey{adapter: handle, remoteAddress: link.RemoteAddress, localAddress: link.LocalAddress}
+			links[key] = link
+
+			if _, ok := w.links[key]; !ok {
+				events = append(events, WANLinkEvent{Type: WANLinkUp, Adapter: handle, InternalName: names[handle], Link: link})
+			}
+		}
+	}
+
+	for key, link := range w.links {
+		if _, ok := links[key]; !ok {
+			events = append(events, WANLinkEvent{Type: WANLinkDown, Adapter: key.adapter, InternalName: names[key.adapter], Link: link})
+		}
+	}
+	w.links = links
+
+	return events
+}
@@ -0,0 +1,82 @@
+package netlib_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	N "github.com/wiresock/ndisapi-go/netlib"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+func TestWANLinkWatcher(t *testing.T) {
+	api := sim.NewDriver()
+	api.AddAdapter(sim.AdapterConfig{Name: `\DEVICE\{SIM-0}`})
+	wan := api.AddAdapter(sim.AdapterConfig{Name: A.DEVICE_NDISWANIP})
+
+	link := A.RASLinkInfo{
+		LinkSpeed:            10000,
+		MaximumTotalSize:     1500,
+		RemoteAddress:        [A.ETHER_ADDR_LENGTH]byte{0x20, 0x41, 0x53, 0x59, 0x4e, 0xff},
+		LocalAddress:         [A.ETHER_ADDR_LENGTH]byte{0x20, 0x41, 0x53, 0x59, 0x4e, 0x01},
+		ProtocolBufferLength: A.RAS_LINK_IPV4_ADDRESS_OFFSET + net.IPv4len,
+	}
+	copy(link.ProtocolBuffer[A.RAS_LINK_IPV4_ADDRESS_OFFSET:], net.IPv4(10, 8, 0, 2).To4())
+
+	watcher, err := N.NewWANLinkWatcher(context.Background(), api)
+	require.NoError(t, err)
+
+	// A VPN connection is established.
+	require.NoError(t, api.SetRasLinks(wan, []A.RASLinkInfo{link}))
+
+	select {
+	case event := <-watcher.Events():
+		assert.Equal(t, N.WANLinkUp, event.Type)
+		assert.Equal(t, wan, event.Adapter)
+		assert.Equal(t, A.DEVICE_NDISWANIP, event.InternalName)
+		assert.Equal(t, link.RemoteAddress, event.Link.RemoteAddress)
+		assert.Equal(t, net.IPv4(10, 8, 0, 2).To4(), event.Link.IPv4Address())
+	case <-time.After(5 * time.Second):
+		t.Fatal("WAN link was not reported up")
+	}
+
+	require.NoError(t, api.SetRasLinks(wan, nil))
+	select {
+	case event := <-watcher.Events():
+		assert.Equal(t, N.WANLinkDown, event.Type)
+		assert.Equal(t, link.LocalAddress, event.Link.LocalAddress)
+	case <-time.After(5 * time.Second):
+		t.Fatal("WAN link was not reported down")
+	}
+
+	watcher.Close()
+	_, ok := <-watcher.Events()
+	assert.False(t, ok)
+}
+
+func TestWANLinkWatcher_Shared(t *testing.T) {
+	api := sim.NewDriver()
+	wan := api.AddAdapter(sim.AdapterConfig{Name: A.DEVICE_NDISWANIP})
+
+	first, err := N.NewWANLinkWatcher(context.Background(), api)
+	require.NoError(t, err)
+	second, err := N.NewWANLinkWatcher(context.Background(), api)
+	require.NoError(t, err)
+	defer second.Close()
+
+	// Closing a watcher keeps the event of the others registered with the driver.
+	first.Close()
+	require.NoError(t, api.SetRasLinks(wan, []A.RASLinkInfo{{MaximumTotalSize: 1500}}))
+
+	select {
+	case event := <-second.Events():
+		assert.Equal(t, N.WANLinkUp, event.Type)
+	case <-time.After(5 * time.Second):
+		t.Fatal("WAN link was not reported up")
+	}
+}
@@ -1,3 +1,15 @@
 //go:build !windows
 
-package ndisapi
\ No newline at end of file
+package ndisapi
+
+// EventHandle mirrors the Win32 event handle type on platforms without the driver.
+type EventHandle uintptr
+
+// Overlapped mirrors the Win32 OVERLAPPED structure on platforms without the driver.
+type Overlapped struct {
+	Internal     uintptr
+	InternalHigh uintptr
+	Offset       uint32
+	OffsetHigh   uint32
+	HEvent       EventHandle
+}
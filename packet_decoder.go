@@ -0,0 +1,540 @@
+package ndisapi
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// EtherType values recognized by the packet decoder.
+const (
+	EtherTypeIPv4 uint16 = 0x0800
+	EtherTypeARP  uint16 = 0x0806
+	EtherTypeVLAN uint16 = 0x8100
+	EtherTypeQinQ uint16 = 0x88A8
+	EtherTypeIPv6 uint16 = 0x86DD
+)
+
+// IP protocol numbers recognized by the packet decoder.
+const (
+	IPProtocolHopByHop    uint8 = 0
+	IPProtocolICMP        uint8 = 1
+	IPProtocolTCP         uint8 = 6
+	IPProtocolUDP         uint8 = 17
+	IPProtocolRouting     uint8 = 43
+	IPProtocolFragment    uint8 = 44
+	IPProtocolAH          uint8 = 51
+	IPProtocolICMPv6      uint8 = 58
+	IPProtocolDestination uint8 = 60
+)
+
+// TCP header flags.
+const (
+	TCPFlagFIN uint8 = 0x01
+	TCPFlagSYN uint8 = 0x02
+	TCPFlagRST uint8 = 0x04
+	TCPFlagPSH uint8 = 0x08
+	TCPFlagACK uint8 = 0x10
+	TCPFlagURG uint8 = 0x20
+	TCPFlagECE uint8 = 0x40
+	TCPFlagCWR uint8 = 0x80
+)
+
+// Header lengths used by the packet decoder.
+const (
+	EthernetHeaderLength = 14
+	VLANTagLength        = 4
+	IPv4MinHeaderLength  = 20
+	IPv6HeaderLength     = 40
+	TCPMinHeaderLength   = 20
+	UDPHeaderLength      = 8
+	ICMPHeaderLength     = 8
+)
+
+// ErrPacketTruncated is returned by the packet decoder when a header is cut short.
+// The layers preceding the truncated header are still decoded.
+var ErrPacketTruncated = errors.New("packet truncated")
+
+// DecodedPacket is a view of the headers of an Ethernet frame decoded in place.
+//
+// It only stores offsets into the decoded data, so decoding does not allocate and
+// the same DecodedPacket can be reused for every packet. The headers it returns
+// alias the decoded data and are valid as long as the data is not reused.
+type DecodedPacket struct {
+	data      []byte
+	etherType uint16
+	vlan      uint16
+	tagged    bool
+	ipVersion uint8
+	protocol  uint8
+	fragment  bool
+
+	network   int
+	transport int
+	payload   int
+	end       int // the end of the IP packet, the Ethernet padding follows
+}
+
+// Decode decodes the packet data of the buffer, i.e. Buffer[:Length].
+func (ib *IntermediateBuffer) Decode(packet *DecodedPacket) error {
+	length := ib.Length
+	if length > MAX_ETHER_FRAME {
+		length = MAX_ETHER_FRAME
+	}
+	return packet.Decode(ib.Buffer[:length])
+}
+
+// Decode decodes the Ethernet, 802.1Q, IPv4 or IPv6 including its extension headers
+// and the TCP, UDP, ICMP or ICMPv6 headers of the frame.
+// Frames carrying other protocols are decoded up to the last recognized header.
+func (p *DecodedPacket) Decode(data []byte) error {
+	*p = DecodedPacket{data: data}
+
+	if len(data) < EthernetHeaderLength {
+		return ErrPacketTruncated
+	}
+
+	offset := 12
+	etherType := binary.BigEndian.Uint16(data[offset:])
+	for etherType == EtherTypeVLAN || etherType == EtherTypeQinQ {
+		if len(data) < offset+VLANTagLength+2 {
+			return ErrPacketTruncated
+		}
+		if !p.tagged {
+			p.vlan = binary.BigEndian.Uint16(data[offset+2:])
+			p.tagged = true
+		}
+		offset += VLANTagLength
+		etherType = binary.BigEndian.Uint16(data[offset:])
+	}
+	offset += 2
+
+	p.etherType = etherType
+	p.network = offset
+
+	switch etherType {
+	case EtherTypeIPv4:
+		if len(data) < offset+IPv4MinHeaderLength {
+			return ErrPacketTruncated
+		}
+		headerLength := int(data[offset]&0x0F) * 4
+		if data[offset]>>4 != 4 || headerLength < IPv4MinHeaderLength {
+			return nil
+		}
+		if len(data) < offset+headerLength {
+			return ErrPacketTruncated
+		}
+		p.ipVersion = 4
+		p.end = len(data)
+		// A zero total length is left to be filled in by the large send offload
+		if totalLength := int(binary.BigEndian.Uint16(data[offset+2:])); totalLength != 0 {
+			p.end = packetEnd(data, offset+totalLength)
+		}
+		p.protocol = data[offset+9]
+		p.fragment = binary.BigEndian.Uint16(data[offset+6:])&0x1FFF != 0
+		offset += headerLength
+	case EtherTypeIPv6:
+		if len(data) < offset+IPv6HeaderLength {
+			return ErrPacketTruncated
+		}
+		if data[offset]>>4 != 6 {
+			return nil
+		}
+		p.ipVersion = 6
+		p.end = len(data)
+		// A zero payload length denotes a jumbogram, its length is carried in an extension header
+		if payloadLength := int(binary.BigEndian.Uint16(data[offset+4:])); payloadLength != 0 {
+			p.end = packetEnd(data, offset+IPv6HeaderLength+payloadLength)
+		}
+		p.protocol = data[offset+6]
+		offset += IPv6HeaderLength
+		p.transport = offset
+
+		for isIPv6ExtensionHeader(p.protocol) {
+			if len(data) < offset+8 {
+				return ErrPacketTruncated
+			}
+
+			var length int
+			switch p.protocol {
+			case IPProtocolFragment:
+				length = 8
+				p.fragment = binary.BigEndian.Uint16(data[offset+2:])&0xFFF8 != 0
+			case IPProtocolAH:
+				length = (int(data[offset+1]) + 2) * 4
+			default:
+				length = (int(data[offset+1]) + 1) * 8
+			}
+			if len(data) < offset+length {
+				return ErrPacketTruncated
+			}
+
+			p.protocol = data[offset]
+			offset += length
+			p.transport = offset
+		}
+	default:
+		return nil
+	}
+
+	p.transport = offset
+
+	// Non-first fragments carry no transport header
+	if p.fragment {
+		return nil
+	}
+
+	var headerLength int
+	switch p.protocol {
+	case IPProtocolTCP:
+		if len(data) < offset+TCPMinHeaderLength {
+			return ErrPacketTruncated
+		}
+		headerLength = int(data[offset+12]>>4) * 4
+		if headerLength < TCPMinHeaderLength {
+			return nil
+		}
+	case IPProtocolUDP:
+		headerLength = UDPHeaderLength
+	case IPProtocolICMP, IPProtocolICMPv6:
+		headerLength = ICMPHeaderLength
+	default:
+		return nil
+	}
+	if len(data) < offset+headerLength {
+		return ErrPacketTruncated
+	}
+
+	p.payload = offset + headerLength
+
+	return nil
+}
+
+// packetEnd returns the end of the IP packet given by its length field, within the data.
+func packetEnd(data []byte, end int) int {
+	if end > len(data) {
+		return len(data)
+	}
+	return end
+}
+
+// isIPv6ExtensionHeader reports whether the next header value denotes an extension header the decoder skips.
+func isIPv6ExtensionHeader(protocol uint8) bool {
+	switch protocol {
+	case IPProtocolHopByHop, IPProtocolRouting, IPProtocolFragment, IPProtocolAH, IPProtocolDestination:
+		return true
+	}
+	return false
+}
+
+// Data returns the decoded data.
+func (p *DecodedPacket) Data() []byte {
+	return p.data
+}
+
+// Ethernet returns the Ethernet header, nil if the frame is truncated.
+func (p *DecodedPacket) Ethernet() EthernetHeader {
+	if p.network == 0 {
+		return nil
+	}
+	return EthernetHeader(p.data[:EthernetHeaderLength])
+}
+
+// LinkHeader returns the Ethernet header together with the 802.1Q tags, nil if the frame is truncated.
+func (p *DecodedPacket) LinkHeader() []byte {
+	if p.network == 0 {
+		return nil
+	}
+	return p.data[:p.network]
+}
+
+// EtherType returns the EtherType following the 802.1Q tags.
+func (p *DecodedPacket) EtherType() uint16 {
+	return p.etherType
+}
+
+// VLAN returns the tag control information of the outermost 802.1Q tag present in the frame.
+func (p *DecodedPacket) VLAN() (tci uint16, ok bool) {
+	return p.vlan, p.tagged
+}
+
+// IPVersion returns 4 or 6 for IP packets and 0 otherwise.
+func (p *DecodedPacket) IPVersion() uint8 {
+	return p.ipVersion
+}
+
+// IPv4 returns the IPv4 header, nil if the packet is not an IPv4 packet.
+func (p *DecodedPacket) IPv4() IPv4Header {
+	if p.ipVersion != 4 {
+		return nil
+	}
+	return IPv4Header(p.data[p.network:p.transport])
+}
+
+// IPv6 returns the fixed IPv6 header, nil if the packet is not an IPv6 packet.
+func (p *DecodedPacket) IPv6() IPv6Header {
+	if p.ipVersion != 6 {
+		return nil
+	}
+	return IPv6Header(p.data[p.network : p.network+IPv6HeaderLength])
+}
+
+// NetworkHeader returns the IP header including the IPv6 extension headers, nil if the packet is not an IP packet.
+func (p *DecodedPacket) NetworkHeader() []byte {
+	if p.ipVersion == 0 {
+		return nil
+	}
+	return p.data[p.network:p.transport]
+}
+
+// Protocol returns the transport protocol of IP packets, following the IPv6 extension headers.
+func (p *DecodedPacket) Protocol() uint8 {
+	return p.protocol
+}
+
+// Fragment reports whether the packet is a non-first IP fragment, which carries no transport header.
+func (p *DecodedPacket) Fragment() bool {
+	return p.fragment
+}
+
+// TCP returns the TCP header including its options, nil if the packet carries none.
+func (p *DecodedPacket) TCP() TCPHeader {
+	if p.payload == 0 || p.protocol != IPProtocolTCP {
+		return nil
+	}
+	return TCPHeader(p.data[p.transport:p.payload])
+}
+
+// UDP returns the UDP header, nil if the packet carries none.
+func (p *DecodedPacket) UDP() UDPHeader {
+	if p.payload == 0 || p.protocol != IPProtocolUDP {
+		return nil
+	}
+	return UDPHeader(p.data[p.transport:p.payload])
+}
+
+// ICMP returns the ICMP or ICMPv6 header, nil if the packet carries none.
+func (p *DecodedPacket) ICMP() ICMPHeader {
+	if p.payload == 0 || (p.protocol != IPProtocolICMP && p.protocol != IPProtocolICMPv6) {
+		return nil
+	}
+	return ICMPHeader(p.data[p.transport:p.payload])
+}
+
+// TransportHeader returns the TCP, UDP, ICMP or ICMPv6 header, nil if the packet carries none.
+func (p *DecodedPacket) TransportHeader() []byte {
+	if p.payload == 0 {
+		return nil
+	}
+	return p.data[p.transport:p.payload]
+}
+
+// Payload returns the data following the transport header up to the end of the IP packet, without
+// the Ethernet padding of the short frames. It is nil if the packet carries no transport header.
+func (p *DecodedPacket) Payload() []byte {
+	if p.payload == 0 {
+		return nil
+	}
+	if p.end < p.payload {
+		return p.data[p.payload:p.payload]
+	}
+	return p.data[p.payload:p.end]
+}
+
+// EthernetHeader is a view of an Ethernet header.
+type EthernetHeader []byte
+
+// Destination returns the destination MAC address.
+func (h EthernetHeader) Destination() net.HardwareAddr {
+	return net.HardwareAddr(h[0:6])
+}
+
+// Source returns the source MAC address.
+func (h EthernetHeader) Source() net.HardwareAddr {
+	return net.HardwareAddr(h[6:12])
+}
+
+// EtherType returns the EtherType field, which is EtherTypeVLAN for tagged frames.
+func (h EthernetHeader) EtherType() uint16 {
+	return binary.BigEndian.Uint16(h[12:14])
+}
+
+// IPv4Header is a view of an IPv4 header including its options.
+type IPv4Header []byte
+
+// HeaderLength returns the header length in bytes.
+func (h IPv4Header) HeaderLength() int {
+	return int(h[0]&0x0F) * 4
+}
+
+// TOS returns the type of service byte holding the DSCP and ECN fields.
+func (h IPv4Header) TOS() uint8 {
+	return h[1]
+}
+
+// TotalLength returns the length of the IP packet.
+func (h IPv4Header) TotalLength() uint16 {
+	return binary.BigEndian.Uint16(h[2:4])
+}
+
+// ID returns the identification field.
+func (h IPv4Header) ID() uint16 {
+	return binary.BigEndian.Uint16(h[4:6])
+}
+
+// Flags returns the fragmentation flags.
+func (h IPv4Header) Flags() uint8 {
+	return h[6] >> 5
+}
+
+// FragmentOffset returns the fragment offset in 8 byte units.
+func (h IPv4Header) FragmentOffset() uint16 {
+	return binary.BigEndian.Uint16(h[6:8]) & 0x1FFF
+}
+
+// TTL returns the time to live.
+func (h IPv4Header) TTL() uint8 {
+	return h[8]
+}
+
+// Protocol returns the transport protocol.
+func (h IPv4Header) Protocol() uint8 {
+	return h[9]
+}
+
+// Checksum returns the header checksum.
+func (h IPv4Header) Checksum() uint16 {
+	return binary.BigEndian.Uint16(h[10:12])
+}
+
+// Source returns the source address.
+func (h IPv4Header) Source() net.IP {
+	return net.IP(h[12:16])
+}
+
+// Destination returns the destination address.
+func (h IPv4Header) Destination() net.IP {
+	return net.IP(h[16:20])
+}
+
+// IPv6Header is a view of the fixed IPv6 header.
+type IPv6Header []byte
+
+// TrafficClass returns the traffic class holding the DSCP and ECN fields.
+func (h IPv6Header) TrafficClass() uint8 {
+	return uint8(binary.BigEndian.Uint16(h[0:2]) >> 4)
+}
+
+// FlowLabel returns the flow label.
+func (h IPv6Header) FlowLabel() uint32 {
+	return binary.BigEndian.Uint32(h[0:4]) & 0x000FFFFF
+}
+
+// PayloadLength returns the length of the data following the fixed header.
+func (h IPv6Header) PayloadLength() uint16 {
+	return binary.BigEndian.Uint16(h[4:6])
+}
+
+// NextHeader returns the type of the header following the fixed header.
+func (h IPv6Header) NextHeader() uint8 {
+	return h[6]
+}
+
+// HopLimit returns the hop limit.
+func (h IPv6Header) HopLimit() uint8 {
+	return h[7]
+}
+
+// Source returns the source address.
+func (h IPv6Header) Source() net.IP {
+	return net.IP(h[8:24])
+}
+
+// Destination returns the destination address.
+func (h IPv6Header) Destination() net.IP {
+	return net.IP(h[24:40])
+}
+
+// TCPHeader is a view of a TCP header including its options.
+type TCPHeader []byte
+
+// SourcePort returns the source port.
+func (h TCPHeader) SourcePort() uint16 {
+	return binary.BigEndian.Uint16(h[0:2])
+}
+
+// DestinationPort returns the destination port.
+func (h TCPHeader) DestinationPort() uint16 {
+	return binary.BigEndian.Uint16(h[2:4])
+}
+
+// Seq returns the sequence number.
+func (h TCPHeader) Seq() uint32 {
+	return binary.BigEndian.Uint32(h[4:8])
+}
+
+// Ack returns the acknowledgment number.
+func (h TCPHeader) Ack() uint32 {
+	return binary.BigEndian.Uint32(h[8:12])
+}
+
+// HeaderLength returns the header length in bytes.
+func (h TCPHeader) HeaderLength() int {
+	return int(h[12]>>4) * 4
+}
+
+// Flags returns the TCP flags, see TCPFlagFIN and friends.
+func (h TCPHeader) Flags() uint8 {
+	return h[13]
+}
+
+// Window returns the window size.
+func (h TCPHeader) Window() uint16 {
+	return binary.BigEndian.Uint16(h[14:16])
+}
+
+// Checksum returns the checksum.
+func (h TCPHeader) Checksum() uint16 {
+	return binary.BigEndian.Uint16(h[16:18])
+}
+
+// UDPHeader is a view of a UDP header.
+type UDPHeader []byte
+
+// SourcePort returns the source port.
+func (h UDPHeader) SourcePort() uint16 {
+	return binary.BigEndian.Uint16(h[0:2])
+}
+
+// DestinationPort returns the destination port.
+func (h UDPHeader) DestinationPort() uint16 {
+	return binary.BigEndian.Uint16(h[2:4])
+}
+
+// Length returns the length of the UDP header and data.
+func (h UDPHeader) Length() uint16 {
+	return binary.BigEndian.Uint16(h[4:6])
+}
+
+// Checksum returns the checksum.
+func (h UDPHeader) Checksum() uint16 {
+	return binary.BigEndian.Uint16(h[6:8])
+}
+
+// ICMPHeader is a view of an ICMP or ICMPv6 header.
+type ICMPHeader []byte
+
+// Type returns the message type.
+func (h ICMPHeader) Type() uint8 {
+	return h[0]
+}
+
+// Code returns the message code.
+func (h ICMPHeader) Code() uint8 {
+	return h[1]
+}
+
+// Checksum returns the checksum.
+func (h ICMPHeader) Checksum() uint16 {
+	return binary.BigEndian.Uint16(h[2:4])
+}
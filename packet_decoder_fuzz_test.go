@@ -0,0 +1,66 @@
+package ndisapi_test
+
+import (
+	"testing"
+
+	"github.com/wiresock/ndisapi-go"
+)
+
+func FuzzDecodedPacket_Decode(f *testing.F) {
+	f.Add(ethernetFrame(ndisapi.EtherTypeIPv4, nil, ipv4Packet(ndisapi.IPProtocolTCP, tcpSegment(1, 2, ndisapi.TCPFlagSYN, []byte("data")))))
+	f.Add(ethernetFrame(ndisapi.EtherTypeIPv4, []uint16{100, 200}, ipv4Packet(ndisapi.IPProtocolUDP, udpDatagram(1, 2, nil))))
+	f.Add(ethernetFrame(ndisapi.EtherTypeIPv6, nil, ipv6Packet(ndisapi.IPProtocolHopByHop, []byte{ndisapi.IPProtocolICMPv6, 0, 0, 0, 0, 0, 0, 0}, []byte{128, 0, 0, 0, 0, 0, 0, 0})))
+	f.Add(ethernetFrame(ndisapi.EtherTypeARP, nil, make([]byte, 28)))
+	f.Add(paddedFrame(ethernetFrame(ndisapi.EtherTypeIPv4, nil, ipv4Packet(ndisapi.IPProtocolTCP, tcpSegment(1, 2, ndisapi.TCPFlagACK, []byte("x"))))))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var packet ndisapi.DecodedPacket
+		err := packet.Decode(data)
+		if err != nil && err != ndisapi.ErrPacketTruncated {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The views must stay within the data and follow each other.
+		length := len(packet.LinkHeader()) + len(packet.NetworkHeader()) + len(packet.TransportHeader()) + len(packet.Payload())
+		if length > len(data) {
+			t.Fatalf("decoded %d bytes out of %d", length, len(data))
+		}
+		// A payload ends with the IP packet, only the Ethernet padding may follow it
+		if len(packet.Payload()) > 0 && length != ipPacketEnd(&packet, len(data)) {
+			t.Fatalf("decoded %d bytes out of %d", length, len(data))
+		}
+
+		if ip := packet.IPv4(); ip != nil {
+			_ = ip.Source()
+			_ = ip.Destination()
+		}
+		if ip := packet.IPv6(); ip != nil {
+			_ = ip.Source()
+			_ = ip.Destination()
+		}
+		if tcp := packet.TCP(); tcp != nil {
+			_ = tcp.Flags()
+		}
+		if udp := packet.UDP(); udp != nil {
+			_ = udp.Checksum()
+		}
+		if icmp := packet.ICMP(); icmp != nil {
+			_ = icmp.Checksum()
+		}
+	})
+}
+
+// ipPacketEnd returns the end of the IP packet in the data as given by its length field.
+func ipPacketEnd(packet *ndisapi.DecodedPacket, dataLength int) int {
+	end := dataLength
+	if ip := packet.IPv4(); ip != nil && ip.TotalLength() != 0 {
+		end = len(packet.LinkHeader()) + int(ip.TotalLength())
+	}
+	if ip := packet.IPv6(); ip != nil && ip.PayloadLength() != 0 {
+		end = len(packet.LinkHeader()) + ndisapi.IPv6HeaderLength + int(ip.PayloadLength())
+	}
+	if end > dataLength {
+		return dataLength
+	}
+	return end
+}
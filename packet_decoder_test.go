@@ -0,0 +1,293 @@
+package ndisapi_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wiresock/ndisapi-go"
+)
+
+var (
+	decoderSrcMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	decoderDstMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+// ethernetFrame builds an Ethernet frame with optional 802.1Q tags in front of the payload.
+func ethernetFrame(etherType uint16, vlans []uint16, payload []byte) []byte {
+	frame := append([]byte(nil), decoderDstMAC...)
+	frame = append(frame, decoderSrcMAC...)
+	for _, vlan := range vlans {
+		frame = append(frame, 0x81, 0x00, byte(vlan>>8), byte(vlan))
+	}
+	frame = append(frame, byte(etherType>>8), byte(etherType))
+	return append(frame, payload...)
+}
+
+// ipv4Packet builds an IPv4 header with the given protocol in front of the transport data.
+func ipv4Packet(protocol uint8, transport []byte) []byte {
+	ip := make([]byte, 20, 20+len(transport))
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(20+len(transport)))
+	ip[8] = 64
+	ip[9] = protocol
+	copy(ip[12:16], net.IPv4(10, 0, 0, 1).To4())
+	copy(ip[16:20], net.IPv4(10, 0, 0, 2).To4())
+	return append(ip, transport...)
+}
+
+// ipv6Packet builds an IPv6 header followed by the extension headers and the transport data.
+func ipv6Packet(nextHeader uint8, extensions []byte, transport []byte) []byte {
+	ip := make([]byte, 40, 40+len(extensions)+len(transport))
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:6], uint16(len(extensions)+len(transport)))
+	ip[6] = nextHeader
+	ip[7] = 64
+	copy(ip[8:24], net.ParseIP("fd00::1"))
+	copy(ip[24:40], net.ParseIP("fd00::2"))
+	ip = append(ip, extensions...)
+	return append(ip, transport...)
+}
+
+// tcpSegment builds a TCP header with the given ports and flags followed by the payload.
+func tcpSegment(srcPort, dstPort uint16, flags uint8, payload []byte) []byte {
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:2], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:4], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:8], 1000)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:16], 65535)
+	return append(tcp, payload...)
+}
+
+// udpDatagram builds a UDP header with the given ports followed by the payload.
+func udpDatagram(srcPort, dstPort uint16, payload []byte) []byte {
+	udp := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:2], srcPort)
+	binary.BigEndian.PutUint16(udp[2:4], dstPort)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(8+len(payload)))
+	return append(udp, payload...)
+}
+
+func TestDecodedPacket_IPv4TCP(t *testing.T) {
+	frame := ethernetFrame(ndisapi.EtherTypeIPv4, nil, ipv4Packet(ndisapi.IPProtocolTCP, tcpSegment(40000, 443, ndisapi.TCPFlagSYN|ndisapi.TCPFlagACK, []byte("hello"))))
+
+	var packet ndisapi.DecodedPacket
+	require.NoError(t, packet.Decode(frame))
+
+	assert.Equal(t, decoderDstMAC, packet.Ethernet().Destination())
+	assert.Equal(t, decoderSrcMAC, packet.Ethernet().Source())
+	assert.Equal(t, ndisapi.EtherTypeIPv4, packet.EtherType())
+	assert.Equal(t, uint8(4), packet.IPVersion())
+
+	ip := packet.IPv4()
+	require.NotNil(t, ip)
+	assert.Nil(t, packet.IPv6())
+	assert.Equal(t, 20, ip.HeaderLength())
+	assert.Equal(t, uint8(64), ip.TTL())
+	assert.Equal(t, ndisapi.IPProtocolTCP, ip.Protocol())
+	assert.Equal(t, "10.0.0.1", ip.Source().String())
+	assert.Equal(t, "10.0.0.2", ip.Destination().String())
+
+	tcp := packet.TCP()
+	require.NotNil(t, tcp)
+	assert.Nil(t, packet.UDP())
+	assert.Equal(t, uint16(40000), tcp.SourcePort())
+	assert.Equal(t, uint16(443), tcp.DestinationPort())
+	assert.Equal(t, uint32(1000), tcp.Seq())
+	assert.Equal(t, ndisapi.TCPFlagSYN|ndisapi.TCPFlagACK, tcp.Flags())
+	assert.Equal(t, uint16(65535), tcp.Window())
+	assert.Equal(t, []byte("hello"), packet.Payload())
+}
+
+// paddedFrame pads the frame to the minimum Ethernet frame length of 60 bytes.
+func paddedFrame(frame []byte) []byte {
+	for len(frame) < 60 {
+		frame = append(frame, 0)
+	}
+	return frame
+}
+
+func TestDecodedPacket_PaddedFrame(t *testing.T) {
+	frame := paddedFrame(ethernetFrame(ndisapi.EtherTypeIPv4, nil, ipv4Packet(ndisapi.IPProtocolTCP, tcpSegment(40000, 443, ndisapi.TCPFlagACK, []byte("x")))))
+	require.Len(t, frame, 60)
+
+	var packet ndisapi.DecodedPacket
+	require.NoError(t, packet.Decode(frame))
+	assert.Equal(t, []byte("x"), packet.Payload())
+
+	// The IPv6 frames reach the minimum length, the bytes trailing the packet are dropped all the same
+	frame = ethernetFrame(ndisapi.EtherTypeIPv6, nil, ipv6Packet(ndisapi.IPProtocolUDP, nil, udpDatagram(1, 2, []byte("data"))))
+	require.NoError(t, packet.Decode(append(frame, 0, 0, 0, 0)))
+	assert.Equal(t, []byte("data"), packet.Payload())
+
+	// A zero total length leaves the payload to the end of the data
+	frame = ethernetFrame(ndisapi.EtherTypeIPv4, nil, ipv4Packet(ndisapi.IPProtocolUDP, udpDatagram(1, 2, []byte("data"))))
+	frame[16], frame[17] = 0, 0
+	require.NoError(t, packet.Decode(frame))
+	assert.Equal(t, []byte("data"), packet.Payload())
+
+	// A length field beyond the data is clamped to the data
+	frame = ethernetFrame(ndisapi.EtherTypeIPv4, nil, ipv4Packet(ndisapi.IPProtocolUDP, udpDatagram(1, 2, []byte("data"))))
+	frame[16] = 0xFF
+	require.NoError(t, packet.Decode(frame))
+	assert.Equal(t, []byte("data"), packet.Payload())
+}
+
+func TestDecodedPacket_VLANTaggedUDP(t *testing.T) {
+	frame := ethernetFrame(ndisapi.EtherTypeIPv4, []uint16{0x2064, 0x0005}, ipv4Packet(ndisapi.IPProtocolUDP, udpDatagram(5353, 53, []byte{1, 2, 3})))
+
+	var packet ndisapi.DecodedPacket
+	require.NoError(t, packet.Decode(frame))
+
+	tci, ok := packet.VLAN()
+	assert.True(t, ok)
+	assert.Equal(t, uint16(0x2064), tci)
+	assert.Equal(t, ndisapi.EtherTypeVLAN, packet.Ethernet().EtherType())
+	assert.Equal(t, ndisapi.EtherTypeIPv4, packet.EtherType())
+	assert.Len(t, packet.LinkHeader(), 22)
+
+	udp := packet.UDP()
+	require.NotNil(t, udp)
+	assert.Equal(t, uint16(5353), udp.SourcePort())
+	assert.Equal(t, uint16(53), udp.DestinationPort())
+	assert.Equal(t, uint16(11), udp.Length())
+	assert.Equal(t, []byte{1, 2, 3}, packet.Payload())
+}
+
+func TestDecodedPacket_IPv6ExtensionHeaders(t *testing.T) {
+	extensions := make([]byte, 8+16+8)
+	extensions[0] = ndisapi.IPProtocolDestination // hop-by-hop, 8 bytes
+	extensions[8] = ndisapi.IPProtocolFragment    // destination options, 16 bytes
+	extensions[9] = 1
+	extensions[24] = ndisapi.IPProtocolICMPv6 // fragment, first fragment
+	icmp := []byte{128, 0, 0, 0, 0, 1, 0, 1}
+
+	frame := ethernetFrame(ndisapi.EtherTypeIPv6, nil, ipv6Packet(ndisapi.IPProtocolHopByHop, extensions, icmp))
+
+	var packet ndisapi.DecodedPacket
+	require.NoError(t, packet.Decode(frame))
+
+	assert.Equal(t, uint8(6), packet.IPVersion())
+	ip := packet.IPv6()
+	require.NotNil(t, ip)
+	assert.Equal(t, ndisapi.IPProtocolHopByHop, ip.NextHeader())
+	assert.Equal(t, uint8(64), ip.HopLimit())
+	assert.Equal(t, "fd00::1", ip.Source().String())
+	assert.Equal(t, "fd00::2", ip.Destination().String())
+	assert.Len(t, packet.NetworkHeader(), 40+len(extensions))
+
+	assert.Equal(t, ndisapi.IPProtocolICMPv6, packet.Protocol())
+	assert.False(t, packet.Fragment())
+	require.NotNil(t, packet.ICMP())
+	assert.Equal(t, uint8(128), packet.ICMP().Type())
+	assert.Empty(t, packet.Payload())
+
+	// A non-first fragment carries no transport header.
+	binary.BigEndian.PutUint16(frame[14+40+24+2:], 185<<3)
+	require.NoError(t, packet.Decode(frame))
+	assert.True(t, packet.Fragment())
+	assert.Equal(t, ndisapi.IPProtocolICMPv6, packet.Protocol())
+	assert.Nil(t, packet.ICMP())
+	assert.Nil(t, packet.Payload())
+}
+
+func TestDecodedPacket_IPv4Fragment(t *testing.T) {
+	ip := ipv4Packet(ndisapi.IPProtocolUDP, []byte{0xde, 0xad, 0xbe, 0xef})
+	binary.BigEndian.PutUint16(ip[6:8], 100)
+
+	var packet ndisapi.DecodedPacket
+	require.NoError(t, packet.Decode(ethernetFrame(ndisapi.EtherTypeIPv4, nil, ip)))
+
+	assert.True(t, packet.Fragment())
+	assert.Equal(t, uint16(100), packet.IPv4().FragmentOffset())
+	assert.Nil(t, packet.UDP())
+	assert.Nil(t, packet.TransportHeader())
+}
+
+func TestDecodedPacket_Truncated(t *testing.T) {
+	frame := ethernetFrame(ndisapi.EtherTypeIPv4, nil, ipv4Packet(ndisapi.IPProtocolTCP, tcpSegment(1, 2, 0, nil)))
+
+	var packet ndisapi.DecodedPacket
+
+	assert.Equal(t, ndisapi.ErrPacketTruncated, packet.Decode(frame[:10]))
+	assert.Nil(t, packet.Ethernet())
+
+	assert.Equal(t, ndisapi.ErrPacketTruncated, packet.Decode(frame[:30]))
+	assert.NotNil(t, packet.Ethernet())
+	assert.Nil(t, packet.IPv4())
+
+	assert.Equal(t, ndisapi.ErrPacketTruncated, packet.Decode(frame[:40]))
+	assert.NotNil(t, packet.IPv4())
+	assert.Nil(t, packet.TCP())
+
+	assert.Equal(t, ndisapi.ErrPacketTruncated, packet.Decode(ethernetFrame(ndisapi.EtherTypeIPv4, []uint16{1}, nil)[:16]))
+}
+
+func TestDecodedPacket_NonIP(t *testing.T) {
+	var packet ndisapi.DecodedPacket
+	require.NoError(t, packet.Decode(ethernetFrame(ndisapi.EtherTypeARP, nil, make([]byte, 28))))
+
+	assert.Equal(t, ndisapi.EtherTypeARP, packet.EtherType())
+	assert.NotNil(t, packet.Ethernet())
+	assert.Zero(t, packet.IPVersion())
+	assert.Nil(t, packet.NetworkHeader())
+	assert.Nil(t, packet.TransportHeader())
+}
+
+func TestIntermediateBuffer_Decode(t *testing.T) {
+	frame := ethernetFrame(ndisapi.EtherTypeIPv4, nil, ipv4Packet(ndisapi.IPProtocolUDP, udpDatagram(1, 2, []byte("data"))))
+
+	var buffer ndisapi.IntermediateBuffer
+	buffer.Length = uint32(len(frame))
+	copy(buffer.Buffer[:], frame)
+
+	var packet ndisapi.DecodedPacket
+	require.NoError(t, buffer.Decode(&packet))
+	assert.Len(t, packet.Data(), len(frame))
+	assert.Equal(t, []byte("data"), packet.Payload())
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = buffer.Decode(&packet)
+		_ = packet.UDP().DestinationPort()
+		_ = packet.IPv4().Source()
+	})
+	assert.Zero(t, allocs)
+}
+
+func BenchmarkDecodedPacket_IPv4TCP(b *testing.B) {
+	frame := ethernetFrame(ndisapi.EtherTypeIPv4, nil, ipv4Packet(ndisapi.IPProtocolTCP, tcpSegment(40000, 443, ndisapi.TCPFlagACK, make([]byte, 1460))))
+
+	var buffer ndisapi.IntermediateBuffer
+	buffer.Length = uint32(len(frame))
+	copy(buffer.Buffer[:], frame)
+
+	var packet ndisapi.DecodedPacket
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = buffer.Decode(&packet)
+	}
+}
+
+func BenchmarkDecodedPacket_IPv6ExtensionHeaders(b *testing.B) {
+	extensions := make([]byte, 16)
+	extensions[0] = ndisapi.IPProtocolFragment
+	extensions[8] = ndisapi.IPProtocolUDP
+	frame := ethernetFrame(ndisapi.EtherTypeIPv6, []uint16{100}, ipv6Packet(ndisapi.IPProtocolHopByHop, extensions, udpDatagram(1, 2, make([]byte, 512))))
+
+	var buffer ndisapi.IntermediateBuffer
+	buffer.Length = uint32(len(frame))
+	copy(buffer.Buffer[:], frame)
+
+	var packet ndisapi.DecodedPacket
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = buffer.Decode(&packet)
+	}
+}
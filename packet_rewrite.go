@@ -0,0 +1,301 @@
+package ndisapi
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+var (
+	// ErrUnsupportedPacket is returned when the packet does not carry the header to rewrite.
+	ErrUnsupportedPacket = errors.New("unsupported packet")
+	// ErrAddressFamilyMismatch is returned when the address does not match the IP version of the packet.
+	ErrAddressFamilyMismatch = errors.New("address family mismatch")
+)
+
+// The rewrite helpers below modify the packet data in place and never change its Length.
+// The checksums covering the modified fields are updated incrementally (RFC 1624), so the
+// packet stays valid without recomputing the checksums over the whole packet.
+//
+// The transport checksums of non-first IP fragments are carried by the first fragment,
+// so only the IP header of such fragments is updated.
+
+// SetSourceMAC replaces the source MAC address of the frame.
+func (ib *IntermediateBuffer) SetSourceMAC(mac [ETHER_ADDR_LENGTH]byte) error {
+	if ib.Length < EthernetHeaderLength {
+		return ErrPacketTruncated
+	}
+	copy(ib.Buffer[6:12], mac[:])
+	return nil
+}
+
+// SetDestinationMAC replaces the destination MAC address of the frame.
+func (ib *IntermediateBuffer) SetDestinationMAC(mac [ETHER_ADDR_LENGTH]byte) error {
+	if ib.Length < EthernetHeaderLength {
+		return ErrPacketTruncated
+	}
+	copy(ib.Buffer[0:6], mac[:])
+	return nil
+}
+
+// SwapMACs exchanges the source and destination MAC addresses of the frame.
+func (ib *IntermediateBuffer) SwapMACs() error {
+	if ib.Length < EthernetHeaderLength {
+		return ErrPacketTruncated
+	}
+	for i := 0; i < ETHER_ADDR_LENGTH; i++ {
+		ib.Buffer[i], ib.Buffer[i+6] = ib.Buffer[i+6], ib.Buffer[i]
+	}
+	return nil
+}
+
+// SetSourceIP replaces the source address of the IPv4 or IPv6 packet.
+// It updates the IPv4 header checksum and the TCP, UDP or ICMPv6 checksum.
+func (ib *IntermediateBuffer) SetSourceIP(ip net.IP) error {
+	return ib.setIP(ip, false)
+}
+
+// SetDestinationIP replaces the destination address of the IPv4 or IPv6 packet.
+// It updates the IPv4 header checksum and the TCP, UDP or ICMPv6 checksum.
+func (ib *IntermediateBuffer) SetDestinationIP(ip net.IP) error {
+	return ib.setIP(ip, true)
+}
+
+// setIP replaces the source or destination address of the packet.
+func (ib *IntermediateBuffer) setIP(ip net.IP, destination bool) error {
+	var packet DecodedPacket
+	if err := ib.Decode(&packet); err != nil {
+		return err
+	}
+
+	var address []byte
+	switch packet.IPVersion() {
+	case 4:
+		if ip = ip.To4(); ip == nil {
+			return ErrAddressFamilyMismatch
+		}
+		header := packet.IPv4()
+		if destination {
+			address = header[16:20]
+		} else {
+			address = header[12:16]
+		}
+		updateChecksum(header[10:12], address, ip)
+	case 6:
+		if ip.To4() != nil || len(ip) != net.IPv6len {
+			return ErrAddressFamilyMismatch
+		}
+		header := packet.IPv6()
+		if destination {
+			address = header[24:40]
+		} else {
+			address = header[8:24]
+		}
+	default:
+		return ErrUnsupportedPacket
+	}
+
+	if checksum := packet.pseudoHeaderChecksum(); checksum != nil {
+		updateTransportChecksum(&packet, checksum, address, ip)
+	}
+	copy(address, ip)
+
+	return nil
+}
+
+// SwapIPs exchanges the source and destination addresses of the IPv4 or IPv6 packet.
+// Swapping the addresses leaves all checksums valid.
+func (ib *IntermediateBuffer) SwapIPs() error {
+	var packet DecodedPacket
+	if err := ib.Decode(&packet); err != nil {
+		return err
+	}
+
+	var source, destination []byte
+	switch packet.IPVersion() {
+	case 4:
+		header := packet.IPv4()
+		source, destination = header[12:16], header[16:20]
+	case 6:
+		header := packet.IPv6()
+		source, destination = header[8:24], header[24:40]
+	default:
+		return ErrUnsupportedPacket
+	}
+	for i := range source {
+		source[i], destination[i] = destination[i], source[i]
+	}
+
+	return nil
+}
+
+// SetSourcePort replaces the source port of the TCP or UDP packet and updates its checksum.
+func (ib *IntermediateBuffer) SetSourcePort(port uint16) error {
+	return ib.setPort(port, false)
+}
+
+// SetDestinationPort replaces the destination port of the TCP or UDP packet and updates its checksum.
+func (ib *IntermediateBuffer) SetDestinationPort(port uint16) error {
+	return ib.setPort(port, true)
+}
+
+// setPort replaces the source or destination port of the packet.
+func (ib *IntermediateBuffer) setPort(port uint16, destination bool) error {
+	var packet DecodedPacket
+	if err := ib.Decode(&packet); err != nil {
+		return err
+	}
+
+	header := packet.portHeader()
+	if header == nil {
+		return ErrUnsupportedPacket
+	}
+
+	field := header[0:2]
+	if destination {
+		field = header[2:4]
+	}
+
+	var value [2]byte
+	binary.BigEndian.PutUint16(value[:], port)
+	updateTransportChecksum(&packet, packet.pseudoHeaderChecksum(), field, value[:])
+	copy(field, value[:])
+
+	return nil
+}
+
+// SwapPorts exchanges the source and destination ports of the TCP or UDP packet.
+// Swapping the ports leaves the checksum valid.
+func (ib *IntermediateBuffer) SwapPorts() error {
+	var packet DecodedPacket
+	if err := ib.Decode(&packet); err != nil {
+		return err
+	}
+
+	header := packet.portHeader()
+	if header == nil {
+		return ErrUnsupportedPacket
+	}
+	header[0], header[1], header[2], header[3] = header[2], header[3], header[0], header[1]
+
+	return nil
+}
+
+// SetTTL replaces the IPv4 time to live or the IPv6 hop limit of the packet.
+// It updates the IPv4 header checksum.
+func (ib *IntermediateBuffer) SetTTL(ttl uint8) error {
+	var packet DecodedPacket
+	if err := ib.Decode(&packet); err != nil {
+		return err
+	}
+
+	switch packet.IPVersion() {
+	case 4:
+		header := packet.IPv4()
+		// The checksum covers the TTL together with the protocol field
+		value := [2]byte{ttl, header[9]}
+		updateChecksum(header[10:12], header[8:10], value[:])
+		header[8] = ttl
+	case 6:
+		packet.IPv6()[7] = ttl
+	default:
+		return ErrUnsupportedPacket
+	}
+
+	return nil
+}
+
+// SetDSCP replaces the differentiated services code point of the packet, keeping its ECN bits.
+// Only the lower six bits of dscp are used. It updates the IPv4 header checksum.
+func (ib *IntermediateBuffer) SetDSCP(dscp uint8) error {
+	var packet DecodedPacket
+	if err := ib.Decode(&packet); err != nil {
+		return err
+	}
+
+	dscp &= 0x3F
+	switch packet.IPVersion() {
+	case 4:
+		header := packet.IPv4()
+		// The checksum covers the TOS together with the version and header length
+		value := [2]byte{header[0], dscp<<2 | header[1]&0x03}
+		updateChecksum(header[10:12], header[0:2], value[:])
+		header[1] = value[1]
+	case 6:
+		// The traffic class spans bits 4 to 11 of the header, the DSCP being its upper six bits
+		header := packet.IPv6()
+		word := binary.BigEndian.Uint32(header[0:4])
+		word = word&^0x0FC00000 | uint32(dscp)<<22
+		binary.BigEndian.PutUint32(header[0:4], word)
+	default:
+		return ErrUnsupportedPacket
+	}
+
+	return nil
+}
+
+// portHeader returns the TCP or UDP header of the packet, nil if it carries neither.
+func (p *DecodedPacket) portHeader() []byte {
+	if tcp := p.TCP(); tcp != nil {
+		return tcp
+	}
+	if udp := p.UDP(); udp != nil {
+		return udp
+	}
+	return nil
+}
+
+// pseudoHeaderChecksum returns the checksum field of the TCP, UDP or ICMPv6 header,
+// which covers the IP addresses, nil if the packet carries none.
+func (p *DecodedPacket) pseudoHeaderChecksum() []byte {
+	switch {
+	case p.TCP() != nil:
+		return p.data[p.transport+16 : p.transport+18]
+	case p.UDP() != nil:
+		return p.data[p.transport+6 : p.transport+8]
+	case p.ICMP() != nil && p.protocol == IPProtocolICMPv6:
+		return p.data[p.transport+2 : p.transport+4]
+	}
+	return nil
+}
+
+// updateTransportChecksum updates the transport checksum field for the replaced bytes,
+// observing the UDP rules for zero checksums.
+func updateTransportChecksum(packet *DecodedPacket, field, old, new []byte) {
+	if packet.protocol != IPProtocolUDP {
+		updateChecksum(field, old, new)
+		return
+	}
+
+	// A zero UDP checksum means that no checksum was computed, which IPv6 does not allow
+	checksum := binary.BigEndian.Uint16(field)
+	if checksum == 0 && packet.ipVersion == 4 {
+		return
+	}
+	checksum = checksumUpdate(checksum, old, new)
+	if checksum == 0 {
+		checksum = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(field, checksum)
+}
+
+// updateChecksum updates the checksum field for the replaced bytes.
+func updateChecksum(field, old, new []byte) {
+	binary.BigEndian.PutUint16(field, checksumUpdate(binary.BigEndian.Uint16(field), old, new))
+}
+
+// checksumUpdate returns the Internet checksum updated for the 16 bit words of old
+// being replaced by the words of new, as given by equation 3 of RFC 1624:
+//
+//	HC' = ~(~HC + ~m + m')
+func checksumUpdate(checksum uint16, old, new []byte) uint16 {
+	sum := uint32(^checksum)
+	for i := 0; i+1 < len(old); i += 2 {
+		sum += uint32(^binary.BigEndian.Uint16(old[i:]))
+		sum += uint32(binary.BigEndian.Uint16(new[i:]))
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,254 @@
+package ndisapi_test
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/wiresock/ndisapi-go"
+)
+
+// onesComplementSum returns the folded 16 bit one's complement sum of the data.
+func onesComplementSum(sum uint32, data []byte) uint32 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	return sum
+}
+
+// pseudoHeaderSum returns the sum of the IPv4 or IPv6 pseudo header of the decoded packet.
+func pseudoHeaderSum(packet *ndisapi.DecodedPacket) uint32 {
+	length := len(packet.Data()) - len(packet.LinkHeader()) - len(packet.NetworkHeader())
+	sum := uint32(packet.Protocol()) + uint32(length)
+	if ip := packet.IPv4(); ip != nil {
+		return onesComplementSum(sum, ip[12:20])
+	}
+	return onesComplementSum(sum, packet.IPv6()[8:40])
+}
+
+// checksumFields returns the IPv4 header and transport checksum fields of the frame together with the data they cover.
+func checksumFields(t *testing.T, frame []byte) (fields [][]byte, sums []uint32) {
+	var packet ndisapi.DecodedPacket
+	require.NoError(t, packet.Decode(frame))
+
+	if ip := packet.IPv4(); ip != nil {
+		fields = append(fields, ip[10:12])
+		sums = append(sums, onesComplementSum(0, ip))
+	}
+
+	transport := frame[len(packet.LinkHeader())+len(packet.NetworkHeader()):]
+	switch {
+	case packet.TCP() != nil:
+		fields = append(fields, transport[16:18])
+		sums = append(sums, onesComplementSum(pseudoHeaderSum(&packet), transport))
+	case packet.UDP() != nil:
+		fields = append(fields, transport[6:8])
+		sums = append(sums, onesComplementSum(pseudoHeaderSum(&packet), transport))
+	case packet.ICMP() != nil && packet.Protocol() == ndisapi.IPProtocolICMPv6:
+		fields = append(fields, transport[2:4])
+		sums = append(sums, onesComplementSum(pseudoHeaderSum(&packet), transport))
+	case packet.ICMP() != nil:
+		fields = append(fields, transport[2:4])
+		sums = append(sums, onesComplementSum(0, transport))
+	}
+	return fields, sums
+}
+
+// fillChecksums computes the checksums of the frame from scratch.
+func fillChecksums(t *testing.T, frame []byte) []byte {
+	fields, _ := checksumFields(t, frame)
+	for _, field := range fields {
+		field[0], field[1] = 0, 0
+	}
+	fields, sums := checksumFields(t, frame)
+	for i, field := range fields {
+		checksum := ^uint16(sums[i])
+		if checksum == 0 {
+			// Zero denotes a missing UDP checksum
+			checksum = 0xFFFF
+		}
+		binary.BigEndian.PutUint16(field, checksum)
+	}
+	return frame
+}
+
+// assertChecksums verifies the checksums of the frame.
+func assertChecksums(t *testing.T, frame []byte) {
+	_, sums := checksumFields(t, frame)
+	for _, sum := range sums {
+		assert.Equal(t, uint32(0xFFFF), sum)
+	}
+}
+
+// intermediateBuffer copies the frame into an IntermediateBuffer.
+func intermediateBuffer(frame []byte) *ndisapi.IntermediateBuffer {
+	buffer := &ndisapi.IntermediateBuffer{Length: uint32(len(frame))}
+	copy(buffer.Buffer[:], frame)
+	return buffer
+}
+
+func TestIntermediateBuffer_RewriteIPv4TCP(t *testing.T) {
+	frame := fillChecksums(t, ethernetFrame(ndisapi.EtherTypeIPv4, nil, ipv4Packet(ndisapi.IPProtocolTCP, tcpSegment(40000, 443, ndisapi.TCPFlagSYN, []byte("hello")))))
+	buffer := intermediateBuffer(frame)
+
+	require.NoError(t, buffer.SwapMACs())
+	require.NoError(t, buffer.SwapIPs())
+	require.NoError(t, buffer.SetSourceIP(net.IPv4(192, 168, 1, 1)))
+	require.NoError(t, buffer.SetDestinationPort(8080))
+	require.NoError(t, buffer.SetSourcePort(1234))
+	require.NoError(t, buffer.SetTTL(1))
+	require.NoError(t, buffer.SetDSCP(46))
+	require.NoError(t, buffer.SetDestinationMAC([6]byte{2, 0, 0, 0, 0, 9}))
+	assert.Equal(t, uint32(len(frame)), buffer.Length)
+
+	var packet ndisapi.DecodedPacket
+	require.NoError(t, buffer.Decode(&packet))
+	assert.Equal(t, net.HardwareAddr{2, 0, 0, 0, 0, 9}, packet.Ethernet().Destination())
+	assert.Equal(t, decoderDstMAC, packet.Ethernet().Source())
+	assert.Equal(t, "192.168.1.1", packet.IPv4().Source().String())
+	assert.Equal(t, "10.0.0.1", packet.IPv4().Destination().String())
+	assert.Equal(t, uint8(1), packet.IPv4().TTL())
+	assert.Equal(t, uint8(46<<2), packet.IPv4().TOS())
+	assert.Equal(t, uint16(1234), packet.TCP().SourcePort())
+	assert.Equal(t, uint16(8080), packet.TCP().DestinationPort())
+	assert.Equal(t, []byte("hello"), packet.Payload())
+
+	assertChecksums(t, packet.Data())
+}
+
+func TestIntermediateBuffer_RewriteIPv6(t *testing.T) {
+	extensions := []byte{ndisapi.IPProtocolUDP, 0, 0, 0, 0, 0, 0, 0}
+	frames := map[string][]byte{
+		"TCP":    ethernetFrame(ndisapi.EtherTypeIPv6, nil, ipv6Packet(ndisapi.IPProtocolTCP, nil, tcpSegment(1, 2, ndisapi.TCPFlagACK, []byte("data")))),
+		"UDP":    ethernetFrame(ndisapi.EtherTypeIPv6, []uint16{7}, ipv6Packet(ndisapi.IPProtocolHopByHop, extensions, udpDatagram(1, 2, []byte("odd")))),
+		"ICMPv6": ethernetFrame(ndisapi.EtherTypeIPv6, nil, ipv6Packet(ndisapi.IPProtocolICMPv6, nil, []byte{128, 0, 0, 0, 0, 1, 0, 1})),
+	}
+
+	for name, frame := range frames {
+		frame := fillChecksums(t, frame)
+		t.Run(name, func(t *testing.T) {
+			buffer := intermediateBuffer(frame)
+
+			require.NoError(t, buffer.SetSourceIP(net.ParseIP("2001:db8::1")))
+			require.NoError(t, buffer.SetDestinationIP(net.ParseIP("2001:db8::2")))
+			require.NoError(t, buffer.SetTTL(3))
+			require.NoError(t, buffer.SetDSCP(10))
+
+			var packet ndisapi.DecodedPacket
+			require.NoError(t, buffer.Decode(&packet))
+			assert.Equal(t, "2001:db8::1", packet.IPv6().Source().String())
+			assert.Equal(t, "2001:db8::2", packet.IPv6().Destination().String())
+			assert.Equal(t, uint8(3), packet.IPv6().HopLimit())
+			assert.Equal(t, uint8(10<<2), packet.IPv6().TrafficClass())
+			assert.Equal(t, uint8(6), packet.Data()[len(packet.LinkHeader())]>>4)
+
+			assertChecksums(t, packet.Data())
+		})
+	}
+}
+
+func TestIntermediateBuffer_RewriteUDPZeroChecksum(t *testing.T) {
+	frame := ethernetFrame(ndisapi.EtherTypeIPv4, nil, ipv4Packet(ndisapi.IPProtocolUDP, udpDatagram(1, 2, nil)))
+	buffer := intermediateBuffer(frame)
+
+	require.NoError(t, buffer.SetDestinationIP(net.IPv4(1, 1, 1, 1)))
+	require.NoError(t, buffer.SetDestinationPort(53))
+
+	var packet ndisapi.DecodedPacket
+	require.NoError(t, buffer.Decode(&packet))
+	assert.Zero(t, packet.UDP().Checksum(), "a missing checksum stays missing")
+	assert.Equal(t, uint16(53), packet.UDP().DestinationPort())
+}
+
+func TestIntermediateBuffer_RewriteErrors(t *testing.T) {
+	ipv4 := intermediateBuffer(ethernetFrame(ndisapi.EtherTypeIPv4, nil, ipv4Packet(ndisapi.IPProtocolICMP, []byte{8, 0, 0, 0, 0, 0, 0, 0})))
+	ipv6 := intermediateBuffer(ethernetFrame(ndisapi.EtherTypeIPv6, nil, ipv6Packet(ndisapi.IPProtocolICMPv6, nil, []byte{128, 0, 0, 0, 0, 0, 0, 0})))
+	arp := intermediateBuffer(ethernetFrame(ndisapi.EtherTypeARP, nil, make([]byte, 28)))
+	truncated := intermediateBuffer(make([]byte, 10))
+
+	assert.Equal(t, ndisapi.ErrAddressFamilyMismatch, ipv4.SetSourceIP(net.ParseIP("fd00::1")))
+	assert.Equal(t, ndisapi.ErrAddressFamilyMismatch, ipv6.SetSourceIP(net.IPv4(1, 2, 3, 4)))
+	assert.Equal(t, ndisapi.ErrUnsupportedPacket, ipv4.SetDestinationPort(80))
+	assert.Equal(t, ndisapi.ErrUnsupportedPacket, ipv6.SwapPorts())
+	assert.Equal(t, ndisapi.ErrUnsupportedPacket, arp.SwapIPs())
+	assert.Equal(t, ndisapi.ErrUnsupportedPacket, arp.SetTTL(1))
+	assert.Equal(t, ndisapi.ErrUnsupportedPacket, arp.SetDSCP(1))
+	assert.NoError(t, arp.SwapMACs())
+	assert.Equal(t, ndisapi.ErrPacketTruncated, truncated.SwapMACs())
+	assert.Equal(t, ndisapi.ErrPacketTruncated, truncated.SetSourceMAC([6]byte{}))
+	assert.Equal(t, ndisapi.ErrPacketTruncated, truncated.SetDestinationIP(net.IPv4(1, 2, 3, 4)))
+}
+
+func TestIntermediateBuffer_RewriteRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	randomBytes := func(n int) []byte {
+		data := make([]byte, n)
+		random.Read(data)
+		return data
+	}
+
+	for i := 0; i < 1000; i++ {
+		payload := randomBytes(random.Intn(64))
+
+		var frame []byte
+		switch i % 4 {
+		case 0:
+			frame = ethernetFrame(ndisapi.EtherTypeIPv4, nil, ipv4Packet(ndisapi.IPProtocolTCP, tcpSegment(uint16(random.Int()), uint16(random.Int()), 0, payload)))
+		case 1:
+			frame = ethernetFrame(ndisapi.EtherTypeIPv4, nil, ipv4Packet(ndisapi.IPProtocolUDP, udpDatagram(uint16(random.Int()), uint16(random.Int()), payload)))
+		case 2:
+			frame = ethernetFrame(ndisapi.EtherTypeIPv6, nil, ipv6Packet(ndisapi.IPProtocolTCP, nil, tcpSegment(uint16(random.Int()), uint16(random.Int()), 0, payload)))
+		case 3:
+			frame = ethernetFrame(ndisapi.EtherTypeIPv6, nil, ipv6Packet(ndisapi.IPProtocolUDP, nil, udpDatagram(uint16(random.Int()), uint16(random.Int()), payload)))
+		}
+		buffer := intermediateBuffer(fillChecksums(t, frame))
+
+		addressLength := net.IPv4len
+		if i%4 >= 2 {
+			addressLength = net.IPv6len
+		}
+		require.NoError(t, buffer.SetSourceIP(net.IP(randomBytes(addressLength)).To16()))
+		require.NoError(t, buffer.SetDestinationIP(net.IP(randomBytes(addressLength)).To16()))
+		require.NoError(t, buffer.SetSourcePort(uint16(random.Int())))
+		require.NoError(t, buffer.SetDestinationPort(uint16(random.Int())))
+		require.NoError(t, buffer.SetTTL(uint8(random.Int())))
+		require.NoError(t, buffer.SetDSCP(uint8(random.Int())))
+
+		assertChecksums(t, buffer.Buffer[:buffer.Length])
+	}
+}
+
+func TestIntermediateBuffer_RewriteAllocations(t *testing.T) {
+	buffer := intermediateBuffer(ethernetFrame(ndisapi.EtherTypeIPv4, nil, ipv4Packet(ndisapi.IPProtocolTCP, tcpSegment(1, 2, 0, nil))))
+	address := net.IPv4(10, 1, 1, 1)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = buffer.SwapMACs()
+		_ = buffer.SwapIPs()
+		_ = buffer.SetDestinationIP(address)
+		_ = buffer.SetDestinationPort(8080)
+	})
+	assert.Zero(t, allocs)
+}
+
+func BenchmarkIntermediateBuffer_Redirect(b *testing.B) {
+	buffer := intermediateBuffer(ethernetFrame(ndisapi.EtherTypeIPv4, nil, ipv4Packet(ndisapi.IPProtocolTCP, tcpSegment(40000, 443, ndisapi.TCPFlagACK, make([]byte, 1460)))))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = buffer.SwapMACs()
+		_ = buffer.SwapIPs()
+		_ = buffer.SetDestinationPort(8080)
+	}
+}
@@ -3,7 +3,10 @@
 package ndisapi
 
 import (
+	"context"
 	"errors"
+	"sync"
+	"time"
 
 	"golang.org/x/sys/windows"
 )
@@ -38,6 +41,8 @@ func (h *safeObjectHandle) IsValid() bool {
 	return h.handle != windows.InvalidHandle && h.handle != 0
 }
 
+var _ Event = (*SafeEvent)(nil)
+
 // SafeEvent is a wrapper for a Windows event object, extending SafeObjectHandle.
 type SafeEvent struct {
 	*safeObjectHandle
@@ -57,6 +62,56 @@ func (e *SafeEvent) Wait(milliseconds uint32) (uint32, error) {
 	return windows.WaitForSingleObject(e.handle, milliseconds)
 }
 
+// WaitContext waits until the event is signaled, the timeout elapses or the context is done.
+// A negative timeout (WaitInfinite) waits without a time limit.
+func (e *SafeEvent) WaitContext(ctx context.Context, timeout time.Duration) error {
+	if !e.IsValid() {
+		return errors.New("invalid handle")
+	}
+
+	milliseconds := uint32(windows.INFINITE)
+	if timeout >= 0 {
+		milliseconds = uint32(timeout / time.Millisecond)
+	}
+
+	if ctx.Done() == nil {
+		result, err := windows.WaitForSingleObject(e.handle, milliseconds)
+		return waitResult(ctx, result, err)
+	}
+
+	// The cancel event is signaled once the context is done to interrupt the wait.
+	cancelEvent, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(cancelEvent)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-ctx.Done():
+			_ = windows.SetEvent(cancelEvent)
+		case <-done:
+		}
+	}()
+
+	result, err := windows.WaitForMultipleObjects([]windows.Handle{e.handle, cancelEvent}, false, milliseconds)
+
+	close(done)
+	wg.Wait()
+
+	return waitResult(ctx, result, err)
+}
+
+// Handle returns the Win32 event handle.
+func (e *SafeEvent) Handle() EventHandle {
+	return e.handle
+}
+
 // Signal sets the event to a signaled state.
 func (e *SafeEvent) Signal() error {
 	if !e.IsValid() {
@@ -78,3 +133,21 @@ func (e *SafeEvent) Reset() error {
 	}
 	return nil
 }
+
+// waitResult converts the result of a wait function into an error.
+func waitResult(ctx context.Context, result uint32, err error) error {
+	switch result {
+	case windows.WAIT_OBJECT_0:
+		return nil
+	case windows.WAIT_OBJECT_0 + 1:
+		return ctx.Err()
+	case uint32(windows.WAIT_TIMEOUT):
+		return ErrWaitTimeout
+	}
+
+	if err == nil {
+		err = errors.New("wait failed")
+	}
+
+	return err
+}
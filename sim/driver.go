@@ -0,0 +1,323 @@
+// Package sim provides an in-memory emulation of the Windows Packet Filter (NDISRD) driver.
+//
+// Driver implements ndisapi.NdisApiInterface on every platform. It keeps its own adapter list,
+// adapter modes, per-adapter packet queues, static filter table and fast I/O sections, which
+// allows the packet filters from the driver package to be exercised end-to-end without Windows.
+package sim
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"unsafe"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
+var _ A.NdisApiInterface = (*Driver)(nil)
+
+var (
+	// ErrAdapterNotFound is returned when a request references an unknown adapter handle.
+	// It is the ndisapi.ErrAdapterNotFound the real driver failures are classified as.
+	ErrAdapterNotFound = A.ErrAdapterNotFound
+	// ErrNotSupported is returned for requests the simulated driver does not emulate.
+	ErrNotSupported = errors.New("operation is not supported by the simulated driver")
+	// ErrInvalidParameter is returned when a request carries malformed arguments.
+	ErrInvalidParameter = errors.New("invalid parameter")
+)
+
+// Packet describes a frame travelling through a simulated adapter.
+type Packet struct {
+	Adapter     A.Handle
+	DeviceFlags uint32 // A.PACKET_FLAG_ON_SEND or A.PACKET_FLAG_ON_RECEIVE
+	M8021q      uint32
+	Data        []byte
+}
+
+// AdapterConfig describes a simulated network adapter.
+type AdapterConfig struct {
+	Name         string // internal name, e.g. \DEVICE\{GUID}
+	FriendlyName string
+	Medium       uint32
+	HardwareAddr [A.ETHER_ADDR_LENGTH]byte
+	MTU          uint16
+
+	InterfaceIndex uint32 // Windows interface index, zero if the adapter has none
+}
+
+// adapter is the driver side state of a simulated network adapter.
+type adapter struct {
+	AdapterConfig
+	handle A.Handle
+	mode   uint32
+	event  A.EventHandle
+	oids   map[uint32][]byte
+
+	rasLinks []A.RASLinkInfo
+
+	toWire  []Packet
+	toStack []Packet
+}
+
+// queuedPacket is a packet waiting in the driver queue to be read by the client.
+type queuedPacket struct {
+	adapter *adapter
+	buffer  A.IntermediateBuffer
+}
+
+// Driver emulates the NDISRD driver in memory.
+type Driver struct {
+	sync.Mutex
+
+	version        uint32
+	isDriverLoaded bool
+	closed         bool
+	nextHandle     uint64
+	queueLimit     int
+
+	adapters          []*adapter
+	adapterListEvent  A.EventHandle
+	hwFilterEvent     A.EventHandle
+	wanEvent          A.EventHandle
+	queue             []queuedPacket
+	droppedOnOverflow uint64
+	filterTable       []A.StaticFilter
+	filterCache       bool
+	fragmentCache     bool
+	fastIO            []fastIOSection
+	deliveries        int
+	deliveriesChanged chan struct{}
+}
+
+// NewDriver constructs an empty simulated driver which reports itself as loaded.
+func NewDriver() *Driver {
+	return &Driver{
+		version:           A.NDISRD_VERSION,
+		isDriverLoaded:    true,
+		nextHandle:        1,
+		deliveriesChanged: make(chan struct{}),
+	}
+}
+
+// SetVersion changes the version reported by GetVersion. The requests the version
+// doesn't support, see A.CapabilitiesOf, fail with A.ErrVersionMismatch.
+func (d *Driver) SetVersion(version uint32) {
+	d.Lock()
+	defer d.Unlock()
+	d.version = version
+}
+
+// SetDriverLoaded changes the value reported by IsDriverLoaded.
+func (d *Driver) SetDriverLoaded(loaded bool) {
+	d.Lock()
+	defer d.Unlock()
+	d.isDriverLoaded = loaded
+}
+
+// SetQueueLimit limits the number of packets the driver queues for the client.
+// Packets exceeding the limit are dropped. Zero means unlimited.
+func (d *Driver) SetQueueLimit(limit int) {
+	d.Lock()
+	defer d.Unlock()
+	d.queueLimit = limit
+}
+
+// DroppedOnOverflow returns the number of packets dropped because the queue limit was reached.
+func (d *Driver) DroppedOnOverflow() uint64 {
+	d.Lock()
+	defer d.Unlock()
+	return d.droppedOnOverflow
+}
+
+// DeviceIoControl only answers IOCTL_NDISRD_GET_VERSION; other control codes are not emulated.
+func (d *Driver) DeviceIoControl(service uint32, in unsafe.Pointer, sizeIn uint32, out unsafe.Pointer, sizeOut uint32, SizeRet *uint32, overlapped *A.Overlapped) error {
+	if service != A.IOCTL_NDISRD_GET_VERSION {
+		return ErrNotSupported
+	}
+	if out == nil || sizeOut < uint32(unsafe.Sizeof(uint32(0))) {
+		return ErrInvalidParameter
+	}
+
+	version, err := d.GetVersion()
+	if err != nil {
+		return err
+	}
+	*(*uint32)(out) = version
+	if SizeRet != nil {
+		*SizeRet = uint32(unsafe.Sizeof(version))
+	}
+
+	return nil
+}
+
+// IsDriverLoaded reports whether the simulated driver is available.
+func (d *Driver) IsDriverLoaded() bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.isDriverLoaded && !d.closed
+}
+
+// Close releases the simulated driver, after calling the functions registered with A.OnClose.
+// Adapter modes are reset and queued packets discarded.
+func (d *Driver) Close() {
+	A.RunCloseHooks(d)
+
+	d.Lock()
+	defer d.Unlock()
+
+	for _, a := range d.adapters {
+		a.mode = 0
+		a.event = 0
+	}
+	d.queue = nil
+	d.fastIO = nil
+	d.closed = true
+}
+
+// GetVersion returns the emulated driver version.
+func (d *Driver) GetVersion() (uint32, error) {
+	d.Lock()
+	defer d.Unlock()
+	return d.version, nil
+}
+
+// capabilities returns the features of the emulated driver version. Must be called locked.
+func (d *Driver) capabilities() A.Capabilities {
+	return A.CapabilitiesOf(A.DriverVersion(d.version))
+}
+
+// GetIntermediateBufferPoolSize is accepted for compatibility, the simulated pool is unbounded.
+func (d *Driver) GetIntermediateBufferPoolSize(size uint32) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.capabilities().IBPoolQuery {
+		return A.ErrVersionMismatch
+	}
+
+	return nil
+}
+
+// IsNdiswanInterfaces checks if the given adapter is an NDISWAN interface.
+func (d *Driver) IsNdiswanInterfaces(adapterName, ndiswanName string) bool {
+	return false
+}
+
+// IsNdiswanIP checks if the given adapter is an NDISWANIP interface.
+func (d *Driver) IsNdiswanIP(adapterName string) bool {
+	return isNdiswanDevice(adapterName, A.DEVICE_NDISWANIP)
+}
+
+// IsNdiswanIPv6 checks if the given adapter is an NDISWANIPV6 interface.
+func (d *Driver) IsNdiswanIPv6(adapterName string) bool {
+	return isNdiswanDevice(adapterName, A.DEVICE_NDISWANIPV6)
+}
+
+// IsNdiswanBh checks if the given adapter is an NDISWANBH interface.
+func (d *Driver) IsNdiswanBh(adapterName string) bool {
+	return isNdiswanDevice(adapterName, A.DEVICE_NDISWANBH)
+}
+
+// IsWindows10OrGreater always reports true for the simulated driver.
+func (d *Driver) IsWindows10OrGreater() bool {
+	return true
+}
+
+// WaitDelivered blocks until at least n packets have been delivered to the network or
+// to the protocol stack since the driver was created or ClearDelivered was called.
+func (d *Driver) WaitDelivered(ctx context.Context, n int) error {
+	for {
+		d.Lock()
+		delivered := d.deliveries
+		changed := d.deliveriesChanged
+		d.Unlock()
+
+		if delivered >= n {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// ClearDelivered forgets all packets delivered so far.
+func (d *Driver) ClearDelivered() {
+	d.Lock()
+	defer d.Unlock()
+
+	for _, a := range d.adapters {
+		a.toWire = nil
+		a.toStack = nil
+	}
+	d.deliveries = 0
+}
+
+// WirePackets returns the packets delivered to the network through the given adapter.
+func (d *Driver) WirePackets(handle A.Handle) []Packet {
+	d.Lock()
+	defer d.Unlock()
+
+	if a := d.findAdapter(handle); a != nil {
+		return append([]Packet(nil), a.toWire...)
+	}
+	return nil
+}
+
+// StackPackets returns the packets indicated to the protocol stack from the given adapter.
+func (d *Driver) StackPackets(handle A.Handle) []Packet {
+	d.Lock()
+	defer d.Unlock()
+
+	if a := d.findAdapter(handle); a != nil {
+		return append([]Packet(nil), a.toStack...)
+	}
+	return nil
+}
+
+// deliver hands a packet over to the network or to the protocol stack. Must be called locked.
+func (d *Driver) deliver(a *adapter, toWire bool, buffer *A.IntermediateBuffer) {
+	length := buffer.Length
+	if length > A.MAX_ETHER_FRAME {
+		length = A.MAX_ETHER_FRAME
+	}
+
+	packet := Packet{
+		Adapter:     a.handle,
+		DeviceFlags: buffer.DeviceFlags,
+		M8021q:      buffer.M8021q,
+		Data:        append([]byte(nil), buffer.Buffer[:length]...),
+	}
+
+	if toWire {
+		a.toWire = append(a.toWire, packet)
+	} else {
+		a.toStack = append(a.toStack, packet)
+	}
+
+	d.deliveries++
+	close(d.deliveriesChanged)
+	d.deliveriesChanged = make(chan struct{})
+}
+
+// findAdapter looks up an adapter by handle. Must be called locked.
+func (d *Driver) findAdapter(handle A.Handle) *adapter {
+	for _, a := range d.adapters {
+		if a.handle == handle {
+			return a
+		}
+	}
+	return nil
+}
+
+// newHandle allocates a unique adapter handle. Must be called locked.
+func (d *Driver) newHandle() A.Handle {
+	var handle A.Handle
+	binary.LittleEndian.PutUint64(handle[:], d.nextHandle)
+	d.nextHandle++
+	return handle
+}
@@ -0,0 +1,241 @@
+package sim
+
+import (
+	"strings"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// AddAdapter plugs a new simulated adapter in and signals the adapter list change event.
+func (d *Driver) AddAdapter(config AdapterConfig) A.Handle {
+	d.Lock()
+	defer d.Unlock()
+
+	a := &adapter{
+		AdapterConfig: config,
+		handle:        d.newHandle(),
+		oids:          defaultOids(config),
+	}
+	if a.MTU == 0 {
+		a.MTU = 1500
+	}
+	d.adapters = append(d.adapters, a)
+
+	_ = A.SignalEventHandle(d.adapterListEvent)
+
+	return a.handle
+}
+
+// RemoveAdapter unplugs a simulated adapter, discards its queued packets and signals the adapter list change event.
+func (d *Driver) RemoveAdapter(handle A.Handle) error {
+	d.Lock()
+	defer d.Unlock()
+
+	for i, a := range d.adapters {
+		if a.handle != handle {
+			continue
+		}
+
+		d.adapters = append(d.adapters[:i], d.adapters[i+1:]...)
+		d.flushQueue(a)
+		_ = A.SignalEventHandle(a.event)
+		_ = A.SignalEventHandle(d.adapterListEvent)
+		return nil
+	}
+
+	return ErrAdapterNotFound
+}
+
+// GetTcpipBoundAdaptersInfo retrieves the list of simulated adapters.
+func (d *Driver) GetTcpipBoundAdaptersInfo() (*A.TcpAdapterList, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	var list A.TcpAdapterList
+	for i, a := range d.adapters {
+		if i == A.ADAPTER_LIST_SIZE {
+			break
+		}
+
+		copy(list.AdapterNameList[i][:A.ADAPTER_NAME_SIZE-1], a.Name)
+		list.AdapterHandle[i] = a.handle
+		list.AdapterMediumList[i] = a.Medium
+		list.CurrentAddress[i] = a.HardwareAddr
+		list.MTU[i] = a.MTU
+		list.AdapterCount++
+	}
+
+	return &list, nil
+}
+
+// SetAdapterMode sets the filter mode of the simulated adapter.
+func (d *Driver) SetAdapterMode(currentMode *A.AdapterMode) error {
+	d.Lock()
+	defer d.Unlock()
+
+	a := d.findAdapter(currentMode.AdapterHandle)
+	if a == nil {
+		return ErrAdapterNotFound
+	}
+	a.mode = currentMode.Flags
+
+	return nil
+}
+
+// GetAdapterMode retrieves the filter mode of the simulated adapter.
+func (d *Driver) GetAdapterMode(currentMode *A.AdapterMode) error {
+	d.Lock()
+	defer d.Unlock()
+
+	a := d.findAdapter(currentMode.AdapterHandle)
+	if a == nil {
+		return ErrAdapterNotFound
+	}
+	currentMode.Flags = a.mode
+
+	return nil
+}
+
+// FlushAdapterPacketQueue discards the packets queued for the specified adapter.
+func (d *Driver) FlushAdapterPacketQueue(handle A.Handle) error {
+	d.Lock()
+	defer d.Unlock()
+
+	a := d.findAdapter(handle)
+	if a == nil {
+		return ErrAdapterNotFound
+	}
+	d.flushQueue(a)
+
+	return nil
+}
+
+// GetAdapterPacketQueueSize retrieves the number of packets queued for the specified adapter.
+func (d *Driver) GetAdapterPacketQueueSize(handle A.Handle, size *uint32) error {
+	d.Lock()
+	defer d.Unlock()
+
+	a := d.findAdapter(handle)
+	if a == nil {
+		return ErrAdapterNotFound
+	}
+
+	*size = 0
+	for i := range d.queue {
+		if d.queue[i].adapter == a {
+			*size++
+		}
+	}
+
+	return nil
+}
+
+// SetPacketEvent sets the event to be signaled when a packet is queued for the specified adapter.
+func (d *Driver) SetPacketEvent(handle A.Handle, win32Event A.EventHandle) error {
+	d.Lock()
+	defer d.Unlock()
+
+	a := d.findAdapter(handle)
+	if a == nil {
+		return ErrAdapterNotFound
+	}
+	a.event = win32Event
+
+	return nil
+}
+
+// ClosePacketEvent closes the packet event set for the adapter, so the waits of the client fail
+// as if the event was closed under it.
+func (d *Driver) ClosePacketEvent(handle A.Handle) error {
+	d.Lock()
+	defer d.Unlock()
+
+	a := d.findAdapter(handle)
+	if a == nil {
+		return ErrAdapterNotFound
+	}
+
+	return A.CloseEventHandle(a.event)
+}
+
+// SetAdapterListChangeEvent sets the event to be signaled when an adapter is added or removed.
+func (d *Driver) SetAdapterListChangeEvent(win32Event A.EventHandle) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.adapterListEvent = win32Event
+
+	return nil
+}
+
+// SetHWFilterEvent sets the event to be signaled when the hardware packet filter of an adapter changes.
+func (d *Driver) SetHWFilterEvent(win32Event A.EventHandle) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.hwFilterEvent = win32Event
+
+	return nil
+}
+
+// ConvertWindows2000AdapterName returns the friendly name configured for the adapter.
+func (d *Driver) ConvertWindows2000AdapterName(adapterName string) string {
+	adapterName = strings.TrimRight(adapterName, "\x00")
+
+	if d.IsNdiswanIP(adapterName) {
+		return A.USER_NDISWANIP
+	}
+	if d.IsNdiswanBh(adapterName) {
+		return A.USER_NDISWANBH
+	}
+	if d.IsNdiswanIPv6(adapterName) {
+		return A.USER_NDISWANIPV6
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	for _, a := range d.adapters {
+		if a.Name == adapterName && a.FriendlyName != "" {
+			return a.FriendlyName
+		}
+	}
+
+	return adapterName
+}
+
+// GetInterfaceIndex returns the interface index configured for the adapter.
+func (d *Driver) GetInterfaceIndex(adapterName string) (uint32, error) {
+	adapterName = strings.TrimRight(adapterName, "\x00")
+
+	d.Lock()
+	defer d.Unlock()
+
+	for _, a := range d.adapters {
+		if a.Name != adapterName {
+			continue
+		}
+		if a.InterfaceIndex == 0 {
+			return 0, ErrNotSupported
+		}
+		return a.InterfaceIndex, nil
+	}
+
+	return 0, ErrAdapterNotFound
+}
+
+// flushQueue drops every queued packet of the adapter. Must be called locked.
+func (d *Driver) flushQueue(a *adapter) {
+	queue := d.queue[:0]
+	for _, packet := range d.queue {
+		if packet.adapter != a {
+			queue = append(queue, packet)
+		}
+	}
+	d.queue = queue
+}
+
+// isNdiswanDevice reports whether the adapter is the NDISWAN device, ignoring case and the trailing NULs.
+func isNdiswanDevice(adapterName, device string) bool {
+	return strings.EqualFold(strings.TrimRight(adapterName, "\x00"), device)
+}
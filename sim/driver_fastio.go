@@ -0,0 +1,200 @@
+package sim
+
+import (
+	"sync/atomic"
+	"unsafe"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// fastIOSection is a client supplied shared memory section the driver writes redirected packets to.
+type fastIOSection struct {
+	header   *A.InitializeFastIOSection
+	capacity uint32
+}
+
+// newFastIOSection validates the section size and computes how many packets fit into it.
+func newFastIOSection(fastIo *A.InitializeFastIOSection, size uint32) (fastIOSection, error) {
+	if fastIo == nil {
+		return fastIOSection{}, ErrInvalidParameter
+	}
+	if size < uint32(unsafe.Sizeof(A.InitializeFastIOSection{})) {
+		return fastIOSection{}, A.ErrBufferTooSmall
+	}
+
+	capacity := (size - uint32(unsafe.Sizeof(A.FastIOSectionHeader{}))) / uint32(unsafe.Sizeof(A.IntermediateBuffer{}))
+	if capacity > 0xFFFF {
+		capacity = 0xFFFF
+	}
+
+	return fastIOSection{header: fastIo, capacity: capacity}, nil
+}
+
+// packet returns the i-th packet slot of the section.
+func (s *fastIOSection) packet(i uint32) *A.IntermediateBuffer {
+	return (*A.IntermediateBuffer)(unsafe.Pointer(uintptr(unsafe.Pointer(&s.header.FastIOPackets[0])) + uintptr(i)*unsafe.Sizeof(A.IntermediateBuffer{})))
+}
+
+// write appends the packet to the section following the driver write protocol:
+// the packet counter is bumped together with the write-in-progress flag, the packet
+// is copied and the flag is cleared. Sections being read by the client are skipped.
+func (s *fastIOSection) write(buffer *A.IntermediateBuffer) bool {
+	header := &s.header.FastIOHeader
+	if atomic.LoadUint32(&header.ReadInProgressFlag) != 0 {
+		return false
+	}
+
+	join := header.FastIOWriteUnion.GetJoin()
+	current := atomic.LoadUint32(join)
+	count := current & 0xFFFF
+	if current>>16 != 0 || count >= s.capacity {
+		return false
+	}
+
+	if !atomic.CompareAndSwapUint32(join, current, 1<<16|(count+1)) {
+		return false
+	}
+	*s.packet(count) = *buffer
+	atomic.StoreUint32(join, count+1)
+
+	return true
+}
+
+// writeFastIO stores a redirected packet into the first fast I/O section with free space. Must be called locked.
+func (d *Driver) writeFastIO(buffer *A.IntermediateBuffer) bool {
+	for i := range d.fastIO {
+		if d.fastIO[i].write(buffer) {
+			return true
+		}
+	}
+	return false
+}
+
+// InitializeFastIo registers the primary fast I/O shared memory section.
+func (d *Driver) InitializeFastIo(fastIo *A.InitializeFastIOSection, size uint32) bool {
+	return d.InitializeFastIoErr(fastIo, size) == nil
+}
+
+// InitializeFastIoErr is InitializeFastIo returning the cause of the failure.
+func (d *Driver) InitializeFastIoErr(fastIo *A.InitializeFastIOSection, size uint32) error {
+	section, err := newFastIOSection(fastIo, size)
+	if err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.capabilities().FastIO {
+		return A.ErrVersionMismatch
+	}
+	d.fastIO = []fastIOSection{section}
+
+	return nil
+}
+
+// AddSecondaryFastIo registers an additional fast I/O shared memory section.
+func (d *Driver) AddSecondaryFastIo(fastIo *A.InitializeFastIOSection, size uint32) bool {
+	return d.AddSecondaryFastIoErr(fastIo, size) == nil
+}
+
+// AddSecondaryFastIoErr is AddSecondaryFastIo returning the cause of the failure.
+// The primary section must be initialized first.
+func (d *Driver) AddSecondaryFastIoErr(fastIo *A.InitializeFastIOSection, size uint32) error {
+	section, err := newFastIOSection(fastIo, size)
+	if err != nil {
+		return err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.capabilities().SecondaryFastIO {
+		return A.ErrVersionMismatch
+	}
+	if len(d.fastIO) == 0 {
+		return ErrInvalidParameter
+	}
+	d.fastIO = append(d.fastIO, section)
+
+	return nil
+}
+
+// ReadPacketsUnsorted reads queued packets of all adapters in arrival order.
+func (d *Driver) ReadPacketsUnsorted(packets []*A.IntermediateBuffer, packetsNum uint32, packetsSuccess *uint32) bool {
+	return d.ReadPacketsUnsortedErr(packets, packetsNum, packetsSuccess) == nil
+}
+
+// ReadPacketsUnsortedErr is ReadPacketsUnsorted returning A.ErrQueueEmpty when no packets are queued.
+func (d *Driver) ReadPacketsUnsortedErr(packets []*A.IntermediateBuffer, packetsNum uint32, packetsSuccess *uint32) error {
+	if int(packetsNum) > len(packets) {
+		packetsNum = uint32(len(packets))
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.capabilities().UnsortedIO {
+		return A.ErrVersionMismatch
+	}
+	if *packetsSuccess = d.dequeue(nil, packets[:packetsNum]); *packetsSuccess == 0 {
+		return A.ErrQueueEmpty
+	}
+
+	return nil
+}
+
+// SendPacketsToAdaptersUnsorted sends packets to the network through the adapters stored in each packet.
+func (d *Driver) SendPacketsToAdaptersUnsorted(packets []*A.IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) bool {
+	return d.sendPacketsUnsorted(packets, packetsNum, packetSuccess, true) == nil
+}
+
+// SendPacketsToAdaptersUnsortedErr is SendPacketsToAdaptersUnsorted returning the cause of the failure.
+func (d *Driver) SendPacketsToAdaptersUnsortedErr(packets []*A.IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) error {
+	return d.sendPacketsUnsorted(packets, packetsNum, packetSuccess, true)
+}
+
+// SendPacketsToMstcpUnsorted indicates packets to the protocol stack from the adapters stored in each packet.
+func (d *Driver) SendPacketsToMstcpUnsorted(packets []*A.IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) bool {
+	return d.sendPacketsUnsorted(packets, packetsNum, packetSuccess, false) == nil
+}
+
+// SendPacketsToMstcpUnsortedErr is SendPacketsToMstcpUnsorted returning the cause of the failure.
+func (d *Driver) SendPacketsToMstcpUnsortedErr(packets []*A.IntermediateBuffer, packetsNum uint32, packetSuccess *uint32) error {
+	return d.sendPacketsUnsorted(packets, packetsNum, packetSuccess, false)
+}
+
+// sendPacketsUnsorted delivers packets addressed by the adapter handle stored in each of them.
+// The other packets are delivered even if some of them are invalid.
+func (d *Driver) sendPacketsUnsorted(packets []*A.IntermediateBuffer, packetsNum uint32, packetSuccess *uint32, toWire bool) error {
+	if int(packetsNum) > len(packets) {
+		packetsNum = uint32(len(packets))
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	*packetSuccess = 0
+	if !d.capabilities().UnsortedIO {
+		return A.ErrVersionMismatch
+	}
+
+	var err error
+	for _, packet := range packets[:packetsNum] {
+		if packet == nil {
+			err = ErrInvalidParameter
+			continue
+		}
+
+		a := d.findAdapter(packet.HAdapterQLinkUnion.GetAdapter())
+		if a == nil {
+			err = ErrAdapterNotFound
+			continue
+		}
+
+		d.deliver(a, toWire, packet)
+		*packetSuccess++
+	}
+
+	return err
+}
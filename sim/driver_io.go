@@ -0,0 +1,230 @@
+package sim
+
+import (
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// ReceiveFromNetwork simulates a frame arriving at the adapter from the network.
+func (d *Driver) ReceiveFromNetwork(handle A.Handle, frame []byte) error {
+	return d.Inject(Packet{Adapter: handle, DeviceFlags: A.PACKET_FLAG_ON_RECEIVE, Data: frame})
+}
+
+// SendFromStack simulates a frame sent by the protocol stack through the adapter.
+func (d *Driver) SendFromStack(handle A.Handle, frame []byte) error {
+	return d.Inject(Packet{Adapter: handle, DeviceFlags: A.PACKET_FLAG_ON_SEND, Data: frame})
+}
+
+// Inject pushes a packet into the simulated driver as if it was intercepted on the adapter.
+//
+// Packets on adapters without a tunnel or listen mode for their direction are delivered
+// unchanged. Otherwise the static filter table is consulted, the first matching filter
+// decides the action and packets matching no filter are redirected to the client.
+// In listen mode redirected packets are delivered and a copy is queued to the client.
+func (d *Driver) Inject(packet Packet) error {
+	if len(packet.Data) > A.MAX_ETHER_FRAME {
+		return ErrInvalidParameter
+	}
+	if packet.DeviceFlags != A.PACKET_FLAG_ON_SEND && packet.DeviceFlags != A.PACKET_FLAG_ON_RECEIVE {
+		return ErrInvalidParameter
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	a := d.findAdapter(packet.Adapter)
+	if a == nil {
+		return ErrAdapterNotFound
+	}
+
+	var buffer A.IntermediateBuffer
+	buffer.HAdapterQLinkUnion.SetAdapter(a.handle)
+	buffer.DeviceFlags = packet.DeviceFlags
+	buffer.M8021q = packet.M8021q
+	buffer.Length = uint32(len(packet.Data))
+	copy(buffer.Buffer[:], packet.Data)
+
+	d.process(a, &buffer)
+
+	return nil
+}
+
+// process applies the adapter mode and the static filter table to an intercepted packet. Must be called locked.
+func (d *Driver) process(a *adapter, buffer *A.IntermediateBuffer) {
+	toWire := buffer.DeviceFlags == A.PACKET_FLAG_ON_SEND
+
+	var tunnel, listen bool
+	if toWire {
+		tunnel = a.mode&A.MSTCP_FLAG_SENT_TUNNEL != 0
+		listen = a.mode&A.MSTCP_FLAG_SENT_LISTEN != 0
+	} else {
+		tunnel = a.mode&A.MSTCP_FLAG_RECV_TUNNEL != 0
+		listen = a.mode&A.MSTCP_FLAG_RECV_LISTEN != 0
+	}
+
+	if !tunnel && !listen {
+		d.deliver(a, toWire, buffer)
+		return
+	}
+
+	action := uint32(A.FILTER_PACKET_REDIRECT)
+	if index := d.matchStaticFilter(a, buffer); index >= 0 {
+		action = d.filterTable[index].FilterAction
+		buffer.FilterID = uint32(index)
+	}
+
+	if !tunnel && action == A.FILTER_PACKET_REDIRECT {
+		action = A.FILTER_PACKET_PASS_RDR
+	}
+
+	switch action {
+	case A.FILTER_PACKET_PASS:
+		d.deliver(a, toWire, buffer)
+	case A.FILTER_PACKET_DROP:
+	case A.FILTER_PACKET_REDIRECT:
+		d.enqueue(a, buffer)
+	case A.FILTER_PACKET_PASS_RDR:
+		d.deliver(a, toWire, buffer)
+		d.enqueue(a, buffer)
+	case A.FILTER_PACKET_DROP_RDR:
+		d.enqueue(a, buffer)
+	}
+}
+
+// enqueue queues a packet for the client, preferring the fast I/O sections when initialized. Must be called locked.
+func (d *Driver) enqueue(a *adapter, buffer *A.IntermediateBuffer) {
+	if !d.writeFastIO(buffer) {
+		if d.queueLimit > 0 && len(d.queue) >= d.queueLimit {
+			d.droppedOnOverflow++
+			return
+		}
+		d.queue = append(d.queue, queuedPacket{adapter: a, buffer: *buffer})
+	}
+
+	_ = A.SignalEventHandle(a.event)
+}
+
+// dequeue moves queued packets of the adapter, or of any adapter when a is nil, into the buffers. Must be called locked.
+func (d *Driver) dequeue(a *adapter, buffers []*A.IntermediateBuffer) uint32 {
+	var read uint32
+	queue := d.queue[:0]
+	for _, packet := range d.queue {
+		if int(read) < len(buffers) && (a == nil || packet.adapter == a) {
+			*buffers[read] = packet.buffer
+			read++
+			continue
+		}
+		queue = append(queue, packet)
+	}
+	d.queue = queue
+
+	return read
+}
+
+// SendPacketToMstcp indicates a packet to the protocol stack.
+func (d *Driver) SendPacketToMstcp(packet *A.EtherRequest) error {
+	return d.sendPackets(packet.AdapterHandle, []A.EthernetPacket{packet.EthernetPacket}, false)
+}
+
+// SendPacketToAdapter sends a packet to the network.
+func (d *Driver) SendPacketToAdapter(packet *A.EtherRequest) error {
+	return d.sendPackets(packet.AdapterHandle, []A.EthernetPacket{packet.EthernetPacket}, true)
+}
+
+// ReadPacket reads a queued packet of the adapter.
+// Like NdisApi.ReadPacket it reports true when the request failed, e.g. the queue was empty.
+func (d *Driver) ReadPacket(packet *A.EtherRequest) bool {
+	return d.ReadPacketErr(packet) != nil
+}
+
+// ReadPacketErr reads a queued packet of the adapter, it returns A.ErrQueueEmpty when none is queued.
+func (d *Driver) ReadPacketErr(packet *A.EtherRequest) error {
+	if packet.EthernetPacket.Buffer == nil {
+		return ErrInvalidParameter
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	a := d.findAdapter(packet.AdapterHandle)
+	if a == nil {
+		return ErrAdapterNotFound
+	}
+
+	if d.dequeue(a, []*A.IntermediateBuffer{packet.EthernetPacket.Buffer}) == 0 {
+		return A.ErrQueueEmpty
+	}
+
+	return nil
+}
+
+// SendPacketsToMstcp indicates multiple packets to the protocol stack.
+func (d *Driver) SendPacketsToMstcp(packet *A.EtherMultiRequest) error {
+	if packet.PacketsNumber > A.MaximumPacketBlock {
+		return ErrInvalidParameter
+	}
+	return d.sendPackets(packet.AdapterHandle, packet.EthernetPackets[:packet.PacketsNumber], false)
+}
+
+// SendPacketsToAdapter sends multiple packets to the network.
+func (d *Driver) SendPacketsToAdapter(packet *A.EtherMultiRequest) error {
+	if packet.PacketsNumber > A.MaximumPacketBlock {
+		return ErrInvalidParameter
+	}
+	return d.sendPackets(packet.AdapterHandle, packet.EthernetPackets[:packet.PacketsNumber], true)
+}
+
+// ReadPackets reads multiple queued packets of the adapter.
+// Like NdisApi.ReadPackets it reports true when the request failed, e.g. the queue was empty.
+func (d *Driver) ReadPackets(packet *A.EtherMultiRequest) bool {
+	return d.ReadPacketsErr(packet) != nil
+}
+
+// ReadPacketsErr reads multiple queued packets of the adapter, it returns A.ErrQueueEmpty when none are queued.
+func (d *Driver) ReadPacketsErr(packet *A.EtherMultiRequest) error {
+	if packet.PacketsNumber > A.MaximumPacketBlock {
+		return ErrInvalidParameter
+	}
+
+	buffers := make([]*A.IntermediateBuffer, 0, packet.PacketsNumber)
+	for i := uint32(0); i < packet.PacketsNumber; i++ {
+		if packet.EthernetPackets[i].Buffer == nil {
+			break
+		}
+		buffers = append(buffers, packet.EthernetPackets[i].Buffer)
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	a := d.findAdapter(packet.AdapterHandle)
+	if a == nil {
+		packet.PacketsSuccess = 0
+		return ErrAdapterNotFound
+	}
+
+	if packet.PacketsSuccess = d.dequeue(a, buffers); packet.PacketsSuccess == 0 {
+		return A.ErrQueueEmpty
+	}
+
+	return nil
+}
+
+// sendPackets delivers client packets to the network or to the protocol stack.
+func (d *Driver) sendPackets(handle A.Handle, packets []A.EthernetPacket, toWire bool) error {
+	d.Lock()
+	defer d.Unlock()
+
+	a := d.findAdapter(handle)
+	if a == nil {
+		return ErrAdapterNotFound
+	}
+
+	for _, packet := range packets {
+		if packet.Buffer == nil {
+			return ErrInvalidParameter
+		}
+		d.deliver(a, toWire, packet.Buffer)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package sim
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// defaultLinkSpeed is the link speed reported for the simulated adapters, 1 Gbps in units of 100 bps.
+const defaultLinkSpeed = 10000000
+
+// defaultOids returns the OID values of a newly plugged simulated adapter.
+func defaultOids(config AdapterConfig) map[uint32][]byte {
+	ulong := func(value uint32) []byte {
+		data := make([]byte, 4)
+		binary.LittleEndian.PutUint32(data, value)
+		return data
+	}
+
+	return map[uint32][]byte{
+		A.OID_GEN_LINK_SPEED:            ulong(defaultLinkSpeed),
+		A.OID_GEN_MEDIA_CONNECT_STATUS:  ulong(A.NdisMediaStateConnected),
+		A.OID_GEN_CURRENT_PACKET_FILTER: ulong(A.NDIS_PACKET_TYPE_DIRECTED | A.NDIS_PACKET_TYPE_MULTICAST | A.NDIS_PACKET_TYPE_BROADCAST),
+		A.OID_GEN_XMIT_OK:               make([]byte, 8),
+		A.OID_GEN_RCV_OK:                make([]byte, 8),
+		A.OID_GEN_XMIT_ERROR:            make([]byte, 8),
+		A.OID_GEN_RCV_ERROR:             make([]byte, 8),
+		A.OID_GEN_RCV_NO_BUFFER:         make([]byte, 8),
+		A.OID_802_3_PERMANENT_ADDRESS:   append([]byte(nil), config.HardwareAddr[:]...),
+		A.OID_802_3_CURRENT_ADDRESS:     append([]byte(nil), config.HardwareAddr[:]...),
+	}
+}
+
+// settableOids are the OIDs NdisSetRequest accepts, the others are read only.
+var settableOids = map[uint32]bool{
+	A.OID_GEN_CURRENT_PACKET_FILTER: true,
+}
+
+// SetOid changes the value the simulated adapter reports for the OID, including the read only ones.
+func (d *Driver) SetOid(handle A.Handle, oid uint32, data []byte) error {
+	d.Lock()
+	defer d.Unlock()
+
+	a := d.findAdapter(handle)
+	if a == nil {
+		return ErrAdapterNotFound
+	}
+	d.setOid(a, oid, data)
+
+	return nil
+}
+
+// NdisGetRequest queries the OID of the simulated adapter into data and returns the length of the OID data.
+func (d *Driver) NdisGetRequest(handle A.Handle, oid uint32, data []byte) (uint32, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	a := d.findAdapter(handle)
+	if a == nil {
+		return 0, &A.OidRequestError{Adapter: handle, Oid: oid, Err: ErrAdapterNotFound}
+	}
+	value, ok := a.oids[oid]
+	if !ok {
+		return 0, &A.OidRequestError{Adapter: handle, Oid: oid, Err: ErrNotSupported}
+	}
+	if len(data) < len(value) {
+		return 0, &A.OidRequestError{Adapter: handle, Oid: oid, Err: ErrInvalidParameter}
+	}
+
+	return uint32(copy(data, value)), nil
+}
+
+// NdisSetRequest sets the OID of the simulated adapter to data.
+func (d *Driver) NdisSetRequest(handle A.Handle, oid uint32, data []byte) error {
+	d.Lock()
+	defer d.Unlock()
+
+	a := d.findAdapter(handle)
+	if a == nil {
+		return &A.OidRequestError{Adapter: handle, Oid: oid, Set: true, Err: ErrAdapterNotFound}
+	}
+	value, ok := a.oids[oid]
+	if !ok || !settableOids[oid] {
+		return &A.OidRequestError{Adapter: handle, Oid: oid, Set: true, Err: ErrNotSupported}
+	}
+	if len(data) != len(value) {
+		return &A.OidRequestError{Adapter: handle, Oid: oid, Set: true, Err: ErrInvalidParameter}
+	}
+	d.setOid(a, oid, data)
+
+	return nil
+}
+
+// setOid stores the OID value and signals the hardware filter event when the packet filter changes.
+// Must be called locked.
+func (d *Driver) setOid(a *adapter, oid uint32, data []byte) {
+	changed := !bytes.Equal(a.oids[oid], data)
+	a.oids[oid] = append([]byte(nil), data...)
+
+	if oid == A.OID_GEN_CURRENT_PACKET_FILTER && changed {
+		_ = A.SignalEventHandle(d.hwFilterEvent)
+	}
+}
@@ -0,0 +1,378 @@
+package sim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// SetPacketFilterTable replaces the static filter table. A nil table resets it.
+func (d *Driver) SetPacketFilterTable(table *A.StaticFilterTable) error {
+	if table != nil && int(table.TableSize) > len(table.StaticFilters) {
+		return ErrInvalidParameter
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.filterTable = nil
+	if table != nil {
+		for _, filter := range table.StaticFilters[:table.TableSize] {
+			d.filterTable = append(d.filterTable, resetCounters(filter))
+		}
+	}
+
+	return nil
+}
+
+// AddStaticFilterFront adds a static filter to the front of the filter table.
+func (d *Driver) AddStaticFilterFront(filter *A.StaticFilter) error {
+	return d.InsertStaticFilter(filter, 0)
+}
+
+// AddStaticFilterBack adds a static filter to the end of the filter table.
+func (d *Driver) AddStaticFilterBack(filter *A.StaticFilter) error {
+	d.Lock()
+	size := uint32(len(d.filterTable))
+	d.Unlock()
+
+	return d.InsertStaticFilter(filter, size)
+}
+
+// InsertStaticFilter inserts a static filter at the specified position of the filter table.
+func (d *Driver) InsertStaticFilter(filter *A.StaticFilter, position uint32) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.capabilities().FilterInsertByIndex {
+		return A.ErrVersionMismatch
+	}
+	if filter == nil || int(position) > len(d.filterTable) {
+		return ErrInvalidParameter
+	}
+
+	d.filterTable = append(d.filterTable, A.StaticFilter{})
+	copy(d.filterTable[position+1:], d.filterTable[position:])
+	d.filterTable[position] = resetCounters(*filter)
+
+	return nil
+}
+
+// RemoveStaticFilter removes the static filter at the specified position of the filter table.
+func (d *Driver) RemoveStaticFilter(filterID uint32) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.capabilities().FilterInsertByIndex {
+		return A.ErrVersionMismatch
+	}
+	if int(filterID) >= len(d.filterTable) {
+		return ErrInvalidParameter
+	}
+
+	d.filterTable = append(d.filterTable[:filterID], d.filterTable[filterID+1:]...)
+
+	return nil
+}
+
+// ResetPacketFilterTable removes all static filters.
+func (d *Driver) ResetPacketFilterTable() error {
+	return d.SetPacketFilterTable(nil)
+}
+
+// GetPacketFilterTableSize retrieves the number of static filters.
+func (d *Driver) GetPacketFilterTableSize() (uint32, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	return uint32(len(d.filterTable)), nil
+}
+
+// GetPacketFilterTable retrieves a copy of the static filter table including its counters.
+func (d *Driver) GetPacketFilterTable(tableSize uint32) (*A.StaticFilterTable, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.copyFilterTable(tableSize)
+}
+
+// GetPacketFilterTableResetStats retrieves a copy of the static filter table and resets its counters.
+func (d *Driver) GetPacketFilterTableResetStats() (*A.StaticFilterTable, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	table, err := d.copyFilterTable(uint32(len(d.filterTable)))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range d.filterTable {
+		d.filterTable[i] = resetCounters(d.filterTable[i])
+	}
+
+	return table, nil
+}
+
+// SetPacketFilterCacheState sets the state of the packet filter cache.
+func (d *Driver) SetPacketFilterCacheState(state bool) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.capabilities().FilterCache {
+		return A.ErrVersionMismatch
+	}
+	d.filterCache = state
+
+	return nil
+}
+
+// SetPacketFragmentCacheState sets the state of the packet fragment cache.
+func (d *Driver) SetPacketFragmentCacheState(state bool) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.capabilities().FragmentCache {
+		return A.ErrVersionMismatch
+	}
+	d.fragmentCache = state
+
+	return nil
+}
+
+// EnablePacketFilterCache enables the packet filter cache.
+func (d *Driver) EnablePacketFilterCache() error {
+	return d.SetPacketFilterCacheState(true)
+}
+
+// DisablePacketFilterCache disables the packet filter cache.
+func (d *Driver) DisablePacketFilterCache() error {
+	return d.SetPacketFilterCacheState(false)
+}
+
+// EnablePacketFragmentCache enables the packet fragment cache.
+func (d *Driver) EnablePacketFragmentCache() error {
+	return d.SetPacketFragmentCacheState(true)
+}
+
+// DisablePacketFragmentCache disables the packet fragment cache.
+func (d *Driver) DisablePacketFragmentCache() error {
+	return d.SetPacketFragmentCacheState(false)
+}
+
+// copyFilterTable copies the static filter table. Must be called locked.
+func (d *Driver) copyFilterTable(tableSize uint32) (*A.StaticFilterTable, error) {
+	if int(tableSize) < len(d.filterTable) {
+		return nil, ErrInvalidParameter
+	}
+
+	return &A.StaticFilterTable{
+		TableSize:     uint32(len(d.filterTable)),
+		StaticFilters: append([]A.StaticFilter(nil), d.filterTable...),
+	}, nil
+}
+
+// resetCounters clears the statistics of a static filter.
+func resetCounters(filter A.StaticFilter) A.StaticFilter {
+	filter.LastReset = uint32(time.Now().Unix())
+	filter.PacketsIn = 0
+	filter.BytesIn = 0
+	filter.PacketsOut = 0
+	filter.BytesOut = 0
+	return filter
+}
+
+// matchStaticFilter returns the position of the first static filter matching the packet
+// and updates its counters, or -1 if no filter matches. Must be called locked.
+func (d *Driver) matchStaticFilter(a *adapter, buffer *A.IntermediateBuffer) int {
+	if len(d.filterTable) == 0 {
+		return -1
+	}
+
+	headers := parseHeaders(buffer.Buffer[:buffer.Length])
+
+	for i := range d.filterTable {
+		filter := &d.filterTable[i]
+
+		if filter.Adapter != (A.Handle{}) && filter.Adapter != a.handle {
+			continue
+		}
+		if filter.DirectionFlags&buffer.DeviceFlags == 0 {
+			continue
+		}
+		if filter.ValidFields&A.DATA_LINK_LAYER_VALID != 0 && !matchDataLink(&filter.DataLinkFilter, &headers) {
+			continue
+		}
+		if filter.ValidFields&A.NETWORK_LAYER_VALID != 0 && !matchNetwork(&filter.NetworkFilter, &headers) {
+			continue
+		}
+		if filter.ValidFields&A.TRANSPORT_LAYER_VALID != 0 && !matchTransport(&filter.TransportFilter, &headers) {
+			continue
+		}
+
+		if buffer.DeviceFlags == A.PACKET_FLAG_ON_RECEIVE {
+			filter.PacketsIn++
+			filter.BytesIn += uint64(buffer.Length)
+		} else {
+			filter.PacketsOut++
+			filter.BytesOut += uint64(buffer.Length)
+		}
+
+		return i
+	}
+
+	return -1
+}
+
+// matchDataLink matches the Ethernet header against the data link layer filter.
+func matchDataLink(filter *A.DataLinkLayerFilter, headers *packetHeaders) bool {
+	if filter.Selector != A.ETH_802_3 || headers.dstMAC == nil {
+		return false
+	}
+
+	eth := &filter.Eth8023Filter
+	if eth.ValidFields&A.ETH_802_3_SRC_ADDRESS != 0 && !bytes.Equal(eth.SourceAddress[:], headers.srcMAC) {
+		return false
+	}
+	if eth.ValidFields&A.ETH_802_3_DEST_ADDRESS != 0 && !bytes.Equal(eth.DestinationAddress[:], headers.dstMAC) {
+		return false
+	}
+	if eth.ValidFields&A.ETH_802_3_PROTOCOL != 0 && eth.Protocol != headers.etherType {
+		return false
+	}
+
+	return true
+}
+
+// matchNetwork matches the IP header against the network layer filter.
+func matchNetwork(filter *A.NetworkLayerFilter, headers *packetHeaders) bool {
+	switch filter.Selector {
+	case A.IPV4:
+		if headers.ipVersion != 4 {
+			return false
+		}
+
+		ipv4 := filter.GetIPv4()
+		if ipv4.ValidFields&A.IP_V4_FILTER_SRC_ADDRESS != 0 && !matchIPv4Address(&ipv4.SourceAddress, headers.srcIP) {
+			return false
+		}
+		if ipv4.ValidFields&A.IP_V4_FILTER_DEST_ADDRESS != 0 && !matchIPv4Address(&ipv4.DestinationAddress, headers.dstIP) {
+			return false
+		}
+		if ipv4.ValidFields&A.IP_V4_FILTER_PROTOCOL != 0 && ipv4.Protocol != headers.protocol {
+			return false
+		}
+
+		return true
+	case A.IPV6:
+		if headers.ipVersion != 6 {
+			return false
+		}
+
+		ipv6 := filter.GetIPv6()
+		if ipv6.ValidFields&A.IP_V6_FILTER_SRC_ADDRESS != 0 && !matchIPv6Address(&ipv6.SourceAddress, headers.srcIP) {
+			return false
+		}
+		if ipv6.ValidFields&A.IP_V6_FILTER_DEST_ADDRESS != 0 && !matchIPv6Address(&ipv6.DestinationAddress, headers.dstIP) {
+			return false
+		}
+		if ipv6.ValidFields&A.IP_V6_FILTER_PROTOCOL != 0 && ipv6.Protocol != headers.protocol {
+			return false
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// matchTransport matches the TCP, UDP or ICMP header against the transport layer filter.
+func matchTransport(filter *A.TransportLayerFilter, headers *packetHeaders) bool {
+	switch filter.Selector {
+	case A.TCPUDP:
+		if !headers.hasPorts {
+			return false
+		}
+
+		tcpudp := filter.GetTCPUDP()
+		if tcpudp.ValidFields&A.TCPUDP_SRC_PORT != 0 && !matchPortRange(tcpudp.SourcePort, headers.srcPort) {
+			return false
+		}
+		if tcpudp.ValidFields&A.TCPUDP_DEST_PORT != 0 && !matchPortRange(tcpudp.DestinationPort, headers.dstPort) {
+			return false
+		}
+		if tcpudp.ValidFields&A.TCPUDP_TCP_FLAGS != 0 && (!headers.isTCP || headers.tcpFlags&tcpudp.TCPFlags != tcpudp.TCPFlags) {
+			return false
+		}
+
+		return true
+	case A.ICMP:
+		if !headers.hasICMP {
+			return false
+		}
+
+		icmp := filter.GetICMP()
+		if icmp.ValidFields&A.ICMP_TYPE != 0 && !matchByteRange(icmp.TypeRange, headers.icmpType) {
+			return false
+		}
+		if icmp.ValidFields&A.ICMP_CODE != 0 && !matchByteRange(icmp.CodeRange, headers.icmpCode) {
+			return false
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// matchIPv4Address matches an IPv4 address against a subnet or range stored in network byte order.
+func matchIPv4Address(address *A.IPv4Address, ip []byte) bool {
+	value := binary.BigEndian.Uint32(ip)
+
+	switch address.AddressType {
+	case A.IP_SUBNET_V4_TYPE:
+		subnet := address.GetSubnet()
+		mask := networkOrder(subnet.IPMask)
+		return value&mask == networkOrder(subnet.IP)&mask
+	case A.IP_RANGE_V4_TYPE:
+		rng := address.GetRange()
+		return networkOrder(rng.StartIP) <= value && value <= networkOrder(rng.EndIP)
+	}
+
+	return false
+}
+
+// matchIPv6Address matches an IPv6 address against a subnet or range.
+func matchIPv6Address(address *A.IPv6Address, ip []byte) bool {
+	switch address.AddressType {
+	case A.IP_SUBNET_V6_TYPE:
+		subnet := address.GetSubnet()
+		for i := range ip {
+			if ip[i]&subnet.IPMask[i] != subnet.IP[i]&subnet.IPMask[i] {
+				return false
+			}
+		}
+		return true
+	case A.IP_RANGE_V6_TYPE:
+		rng := address.GetRange()
+		return bytes.Compare(rng.StartIP[:], ip) <= 0 && bytes.Compare(ip, rng.EndIP[:]) <= 0
+	}
+
+	return false
+}
+
+// networkOrder converts an address stored as raw network order bytes into a comparable value.
+func networkOrder(value uint32) uint32 {
+	var raw [4]byte
+	binary.LittleEndian.PutUint32(raw[:], value)
+	return binary.BigEndian.Uint32(raw[:])
+}
+
+func matchPortRange(rng A.PortRange, port uint16) bool {
+	return rng.StartRange <= port && port <= rng.EndRange
+}
+
+func matchByteRange(rng A.ByteRange, value uint8) bool {
+	return rng.StartRange <= value && value <= rng.EndRange
+}
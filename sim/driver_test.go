@@ -0,0 +1,434 @@
+package sim_test
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	A "github.com/wiresock/ndisapi-go"
+	"github.com/wiresock/ndisapi-go/sim"
+)
+
+var (
+	localMAC  = [6]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	remoteMAC = [6]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+// tcpFrame builds an Ethernet/IPv4/TCP frame.
+func tcpFrame(src, dst string, srcPort, dstPort uint16, flags uint8) []byte {
+	frame := make([]byte, 14+20+20)
+	copy(frame[0:6], remoteMAC[:])
+	copy(frame[6:12], localMAC[:])
+	binary.BigEndian.PutUint16(frame[12:14], 0x0800)
+
+	ip := frame[14:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], 40)
+	ip[8] = 64
+	ip[9] = 6
+	copy(ip[12:16], net.ParseIP(src).To4())
+	copy(ip[16:20], net.ParseIP(dst).To4())
+
+	tcp := ip[20:]
+	binary.BigEndian.PutUint16(tcp[0:2], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:4], dstPort)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+
+	return frame
+}
+
+// icmpv6Frame builds an Ethernet/IPv6/ICMPv6 frame preceded by a hop-by-hop options header.
+func icmpv6Frame(src, dst string, icmpType, icmpCode uint8) []byte {
+	frame := make([]byte, 14+40+8+8)
+	copy(frame[0:6], remoteMAC[:])
+	copy(frame[6:12], localMAC[:])
+	binary.BigEndian.PutUint16(frame[12:14], 0x86DD)
+
+	ip := frame[14:]
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:6], 16)
+	ip[6] = 0 // hop-by-hop options
+	ip[7] = 64
+	copy(ip[8:24], net.ParseIP(src).To16())
+	copy(ip[24:40], net.ParseIP(dst).To16())
+
+	hopByHop := ip[40:]
+	hopByHop[0] = 58 // ICMPv6
+
+	icmp := hopByHop[8:]
+	icmp[0] = icmpType
+	icmp[1] = icmpCode
+
+	return frame
+}
+
+func newDriver(t *testing.T) (*sim.Driver, A.Handle) {
+	t.Helper()
+
+	driver := sim.NewDriver()
+	handle := driver.AddAdapter(sim.AdapterConfig{
+		Name:         `\DEVICE\{00000000-0000-0000-0000-000000000001}`,
+		FriendlyName: "Ethernet",
+		HardwareAddr: localMAC,
+		MTU:          1500,
+	})
+
+	return driver, handle
+}
+
+func setMode(t *testing.T, driver *sim.Driver, handle A.Handle, flags uint32) {
+	t.Helper()
+	require.NoError(t, driver.SetAdapterMode(&A.AdapterMode{AdapterHandle: handle, Flags: flags}))
+}
+
+func newReadRequest(handle A.Handle, buffers []A.IntermediateBuffer) *A.EtherMultiRequest {
+	request := &A.EtherMultiRequest{AdapterHandle: handle, PacketsNumber: uint32(len(buffers))}
+	for i := range buffers {
+		request.EthernetPackets[i].Buffer = &buffers[i]
+	}
+	return request
+}
+
+func TestDriver_GetTcpipBoundAdaptersInfo(t *testing.T) {
+	driver, handle := newDriver(t)
+
+	list, err := driver.GetTcpipBoundAdaptersInfo()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), list.AdapterCount)
+	assert.Equal(t, handle, list.AdapterHandle[0])
+	assert.Equal(t, localMAC, list.CurrentAddress[0])
+	assert.Equal(t, uint16(1500), list.MTU[0])
+
+	name := string(list.AdapterNameList[0][:])
+	assert.Equal(t, "Ethernet", driver.ConvertWindows2000AdapterName(name))
+	assert.Equal(t, "unknown", driver.ConvertWindows2000AdapterName("unknown"))
+}
+
+func TestDriver_RemoveAdapter(t *testing.T) {
+	driver, handle := newDriver(t)
+
+	require.NoError(t, driver.RemoveAdapter(handle))
+	assert.ErrorIs(t, driver.RemoveAdapter(handle), sim.ErrAdapterNotFound)
+
+	list, err := driver.GetTcpipBoundAdaptersInfo()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), list.AdapterCount)
+}
+
+func TestDriver_PassThroughWithoutMode(t *testing.T) {
+	driver, handle := newDriver(t)
+
+	require.NoError(t, driver.ReceiveFromNetwork(handle, tcpFrame("10.0.0.2", "10.0.0.1", 80, 50000, 0x10)))
+	require.NoError(t, driver.SendFromStack(handle, tcpFrame("10.0.0.1", "10.0.0.2", 50000, 80, 0x10)))
+
+	assert.Len(t, driver.StackPackets(handle), 1)
+	assert.Len(t, driver.WirePackets(handle), 1)
+
+	var size uint32
+	require.NoError(t, driver.GetAdapterPacketQueueSize(handle, &size))
+	assert.Equal(t, uint32(0), size)
+}
+
+func TestDriver_TunnelModeQueuesPackets(t *testing.T) {
+	driver, handle := newDriver(t)
+	setMode(t, driver, handle, A.MSTCP_FLAG_SENT_TUNNEL|A.MSTCP_FLAG_RECV_TUNNEL)
+
+	frame := tcpFrame("10.0.0.2", "10.0.0.1", 80, 50000, 0x10)
+	require.NoError(t, driver.ReceiveFromNetwork(handle, frame))
+	require.NoError(t, driver.SendFromStack(handle, tcpFrame("10.0.0.1", "10.0.0.2", 50000, 80, 0x10)))
+
+	var size uint32
+	require.NoError(t, driver.GetAdapterPacketQueueSize(handle, &size))
+	assert.Equal(t, uint32(2), size)
+	assert.Empty(t, driver.StackPackets(handle))
+
+	buffers := make([]A.IntermediateBuffer, 4)
+	request := newReadRequest(handle, buffers)
+
+	// ReadPackets mirrors NdisApi and reports true on failure
+	assert.False(t, driver.ReadPackets(request))
+	assert.Equal(t, uint32(2), request.PacketsSuccess)
+	assert.Equal(t, uint32(A.PACKET_FLAG_ON_RECEIVE), buffers[0].DeviceFlags)
+	assert.Equal(t, frame, buffers[0].Buffer[:buffers[0].Length])
+	assert.Equal(t, handle, buffers[0].HAdapterQLinkUnion.GetAdapter())
+	assert.True(t, driver.ReadPackets(request))
+
+	write := &A.EtherMultiRequest{AdapterHandle: handle, PacketsNumber: 1}
+	write.EthernetPackets[0].Buffer = &buffers[0]
+	require.NoError(t, driver.SendPacketsToMstcp(write))
+
+	require.NoError(t, driver.WaitDelivered(context.Background(), 1))
+	assert.Equal(t, frame, driver.StackPackets(handle)[0].Data)
+}
+
+func TestDriver_StaticFilterActions(t *testing.T) {
+	driver, handle := newDriver(t)
+	setMode(t, driver, handle, A.MSTCP_FLAG_SENT_TUNNEL|A.MSTCP_FLAG_RECV_TUNNEL)
+
+	portFilter := func(port uint16, action uint32) *A.StaticFilter {
+		filter := &A.StaticFilter{
+			DirectionFlags: A.PACKET_FLAG_ON_SEND | A.PACKET_FLAG_ON_RECEIVE,
+			FilterAction:   action,
+			ValidFields:    A.NETWORK_LAYER_VALID | A.TRANSPORT_LAYER_VALID,
+		}
+		filter.NetworkFilter.SetIPv4(A.IPv4Filter{ValidFields: A.IP_V4_FILTER_PROTOCOL, Protocol: 6})
+		filter.TransportFilter.SetTCPUDP(A.TCPUDPFilter{
+			ValidFields:     A.TCPUDP_DEST_PORT,
+			DestinationPort: A.PortRange{StartRange: port, EndRange: port},
+		})
+		return filter
+	}
+
+	require.NoError(t, driver.AddStaticFilterBack(portFilter(445, A.FILTER_PACKET_DROP)))
+	require.NoError(t, driver.AddStaticFilterBack(portFilter(443, A.FILTER_PACKET_PASS)))
+	require.NoError(t, driver.AddStaticFilterBack(portFilter(8080, A.FILTER_PACKET_PASS_RDR)))
+	require.NoError(t, driver.AddStaticFilterBack(portFilter(8081, A.FILTER_PACKET_DROP_RDR)))
+
+	for _, port := range []uint16{445, 443, 8080, 8081, 80} {
+		require.NoError(t, driver.SendFromStack(handle, tcpFrame("10.0.0.1", "10.0.0.2", 50000, port, 0x02)))
+	}
+
+	// 443 and 8080 hit the wire, 8080, 8081 and unmatched 80 are queued
+	assert.Len(t, driver.WirePackets(handle), 2)
+
+	buffers := make([]A.IntermediateBuffer, 8)
+	request := newReadRequest(handle, buffers)
+	assert.False(t, driver.ReadPackets(request))
+	require.Equal(t, uint32(3), request.PacketsSuccess)
+	assert.Equal(t, uint32(2), buffers[0].FilterID)
+	assert.Equal(t, uint32(3), buffers[1].FilterID)
+
+	table, err := driver.GetPacketFilterTableResetStats()
+	require.NoError(t, err)
+	require.Equal(t, uint32(4), table.TableSize)
+	for _, filter := range table.StaticFilters {
+		assert.Equal(t, uint64(1), filter.PacketsOut)
+		assert.Equal(t, uint64(54), filter.BytesOut)
+		assert.Equal(t, uint64(0), filter.PacketsIn)
+	}
+
+	table, err = driver.GetPacketFilterTable(4)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), table.StaticFilters[0].PacketsOut)
+}
+
+func TestDriver_StaticFilterTableEditing(t *testing.T) {
+	driver, _ := newDriver(t)
+
+	filter := func(action uint32) *A.StaticFilter {
+		return &A.StaticFilter{DirectionFlags: A.PACKET_FLAG_ON_SEND, FilterAction: action}
+	}
+
+	require.NoError(t, driver.AddStaticFilterBack(filter(A.FILTER_PACKET_PASS)))
+	require.NoError(t, driver.AddStaticFilterFront(filter(A.FILTER_PACKET_DROP)))
+	require.NoError(t, driver.InsertStaticFilter(filter(A.FILTER_PACKET_REDIRECT), 1))
+	assert.ErrorIs(t, driver.InsertStaticFilter(filter(A.FILTER_PACKET_REDIRECT), 5), sim.ErrInvalidParameter)
+
+	table, err := driver.GetPacketFilterTable(3)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(A.FILTER_PACKET_DROP), table.StaticFilters[0].FilterAction)
+	assert.Equal(t, uint32(A.FILTER_PACKET_REDIRECT), table.StaticFilters[1].FilterAction)
+	assert.Equal(t, uint32(A.FILTER_PACKET_PASS), table.StaticFilters[2].FilterAction)
+
+	require.NoError(t, driver.RemoveStaticFilter(1))
+	size, err := driver.GetPacketFilterTableSize()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), size)
+
+	_, err = driver.GetPacketFilterTable(1)
+	assert.ErrorIs(t, err, sim.ErrInvalidParameter)
+
+	require.NoError(t, driver.ResetPacketFilterTable())
+	size, err = driver.GetPacketFilterTableSize()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), size)
+}
+
+func TestDriver_StaticFilterIPv6ICMP(t *testing.T) {
+	driver, handle := newDriver(t)
+	setMode(t, driver, handle, A.MSTCP_FLAG_RECV_TUNNEL)
+
+	_, subnet, err := net.ParseCIDR("fd00::/64")
+	require.NoError(t, err)
+
+	filter := &A.StaticFilter{
+		DirectionFlags: A.PACKET_FLAG_ON_RECEIVE,
+		FilterAction:   A.FILTER_PACKET_DROP,
+		ValidFields:    A.NETWORK_LAYER_VALID | A.TRANSPORT_LAYER_VALID,
+	}
+	filter.NetworkFilter.SetIPv6(A.IPv6Filter{
+		ValidFields:   A.IP_V6_FILTER_SRC_ADDRESS,
+		SourceAddress: *A.IPv6AddressFromIP(*subnet),
+	})
+	filter.TransportFilter.SetICMP(A.ICMPFilter{
+		ValidFields: A.ICMP_TYPE,
+		TypeRange:   A.ByteRange{StartRange: 128, EndRange: 129},
+	})
+	require.NoError(t, driver.AddStaticFilterBack(filter))
+
+	require.NoError(t, driver.ReceiveFromNetwork(handle, icmpv6Frame("fd00::2", "fd00::1", 128, 0)))
+	require.NoError(t, driver.ReceiveFromNetwork(handle, icmpv6Frame("fd01::2", "fd00::1", 128, 0)))
+	require.NoError(t, driver.ReceiveFromNetwork(handle, icmpv6Frame("fd00::2", "fd00::1", 135, 0)))
+
+	var size uint32
+	require.NoError(t, driver.GetAdapterPacketQueueSize(handle, &size))
+	assert.Equal(t, uint32(2), size)
+}
+
+func TestDriver_ListenMode(t *testing.T) {
+	driver, handle := newDriver(t)
+	setMode(t, driver, handle, A.MSTCP_FLAG_RECV_LISTEN)
+
+	require.NoError(t, driver.ReceiveFromNetwork(handle, tcpFrame("10.0.0.2", "10.0.0.1", 80, 50000, 0x10)))
+
+	assert.Len(t, driver.StackPackets(handle), 1)
+
+	var size uint32
+	require.NoError(t, driver.GetAdapterPacketQueueSize(handle, &size))
+	assert.Equal(t, uint32(1), size)
+}
+
+func TestDriver_QueueLimit(t *testing.T) {
+	driver, handle := newDriver(t)
+	setMode(t, driver, handle, A.MSTCP_FLAG_RECV_TUNNEL)
+	driver.SetQueueLimit(2)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, driver.ReceiveFromNetwork(handle, tcpFrame("10.0.0.2", "10.0.0.1", 80, 50000, 0x10)))
+	}
+
+	assert.Equal(t, uint64(3), driver.DroppedOnOverflow())
+	require.NoError(t, driver.FlushAdapterPacketQueue(handle))
+
+	var size uint32
+	require.NoError(t, driver.GetAdapterPacketQueueSize(handle, &size))
+	assert.Equal(t, uint32(0), size)
+}
+
+func TestDriver_FastIO(t *testing.T) {
+	driver, handle := newDriver(t)
+	setMode(t, driver, handle, A.MSTCP_FLAG_RECV_TUNNEL)
+
+	// A section large enough for exactly two packets
+	size := uint32(unsafe.Sizeof(A.FastIOSectionHeader{}) + 2*unsafe.Sizeof(A.IntermediateBuffer{}))
+	storage := make([]byte, size)
+	section := (*A.InitializeFastIOSection)(unsafe.Pointer(&storage[0]))
+
+	assert.False(t, driver.AddSecondaryFastIo(section, size))
+	assert.False(t, driver.InitializeFastIo(section, 8))
+	require.True(t, driver.InitializeFastIo(section, size))
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, driver.ReceiveFromNetwork(handle, tcpFrame("10.0.0.2", "10.0.0.1", 80, uint16(50000+i), 0x10)))
+	}
+
+	assert.Equal(t, uint16(2), section.FastIOHeader.FastIOWriteUnion.GetNumberOfPackets())
+	assert.Equal(t, uint16(0), section.FastIOHeader.FastIOWriteUnion.GetWriteInProgressFlag())
+	assert.Equal(t, handle, section.FastIOPackets[0].HAdapterQLinkUnion.GetAdapter())
+
+	// The third packet overflowed into the regular queue
+	buffers := make([]A.IntermediateBuffer, 4)
+	packets := []*A.IntermediateBuffer{&buffers[0], &buffers[1], &buffers[2], &buffers[3]}
+	var success uint32
+	assert.True(t, driver.ReadPacketsUnsorted(packets, uint32(len(packets)), &success))
+	require.Equal(t, uint32(1), success)
+	assert.Equal(t, uint16(50002), binary.BigEndian.Uint16(buffers[0].Buffer[14+20+2:]))
+
+	assert.True(t, driver.SendPacketsToMstcpUnsorted(packets, success, &success))
+	assert.Equal(t, uint32(1), success)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, driver.WaitDelivered(ctx, 1))
+	assert.Len(t, driver.StackPackets(handle), 1)
+}
+
+func TestDriver_GetVersion(t *testing.T) {
+	driver := sim.NewDriver()
+	driver.SetVersion(0x06000000)
+
+	version, err := driver.GetVersion()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x06000000), version)
+
+	var out uint32
+	require.NoError(t, driver.DeviceIoControl(A.IOCTL_NDISRD_GET_VERSION, nil, 0, unsafe.Pointer(&out), 4, nil, nil))
+	assert.Equal(t, uint32(0x06000000), out)
+	assert.ErrorIs(t, driver.DeviceIoControl(A.IOCTL_NDISRD_READ_PACKET, nil, 0, nil, 0, nil, nil), sim.ErrNotSupported)
+
+	assert.True(t, driver.IsDriverLoaded())
+	driver.Close()
+	assert.False(t, driver.IsDriverLoaded())
+}
+
+func TestDriver_VersionCapabilities(t *testing.T) {
+	driver, handle := newDriver(t)
+	driver.SetVersion(uint32(A.NewDriverVersion(3, 2, 0)))
+
+	// The requests the version predates fail
+	storage := make([]byte, 4096)
+	section := (*A.InitializeFastIOSection)(unsafe.Pointer(&storage[0]))
+	assert.ErrorIs(t, driver.InitializeFastIoErr(section, uint32(len(storage))), A.ErrVersionMismatch)
+	assert.ErrorIs(t, driver.AddSecondaryFastIoErr(section, uint32(len(storage))), A.ErrVersionMismatch)
+
+	var buffer A.IntermediateBuffer
+	var success uint32
+	buffer.HAdapterQLinkUnion.SetAdapter(handle)
+	packets := []*A.IntermediateBuffer{&buffer}
+	assert.ErrorIs(t, driver.ReadPacketsUnsortedErr(packets, 1, &success), A.ErrVersionMismatch)
+	assert.ErrorIs(t, driver.SendPacketsToAdaptersUnsortedErr(packets, 1, &success), A.ErrVersionMismatch)
+	assert.Zero(t, success)
+
+	assert.ErrorIs(t, driver.SetPacketFilterCacheState(true), A.ErrVersionMismatch)
+	assert.ErrorIs(t, driver.SetPacketFragmentCacheState(true), A.ErrVersionMismatch)
+	assert.ErrorIs(t, driver.AddStaticFilterBack(&A.StaticFilter{}), A.ErrVersionMismatch)
+	assert.ErrorIs(t, driver.GetIntermediateBufferPoolSize(0), A.ErrVersionMismatch)
+
+	// The whole filter table can still be set
+	require.NoError(t, driver.SetPacketFilterTable(&A.StaticFilterTable{TableSize: 1, StaticFilters: make([]A.StaticFilter, 1)}))
+
+	driver.SetVersion(A.NDISRD_VERSION)
+	assert.NoError(t, driver.InitializeFastIoErr(section, uint32(len(storage))))
+	assert.NoError(t, driver.SetPacketFilterCacheState(true))
+}
+
+func TestDriver_ErrorVariants(t *testing.T) {
+	driver, handle := newDriver(t)
+	setMode(t, driver, handle, A.MSTCP_FLAG_RECV_TUNNEL)
+
+	var buffer A.IntermediateBuffer
+	request := A.EtherMultiRequest{AdapterHandle: handle, PacketsNumber: 1}
+	request.EthernetPackets[0].Buffer = &buffer
+
+	// An empty queue is told apart from the failures
+	assert.ErrorIs(t, driver.ReadPacketsErr(&request), A.ErrQueueEmpty)
+	assert.ErrorIs(t, driver.ReadPacketErr(&A.EtherRequest{AdapterHandle: handle, EthernetPacket: A.EthernetPacket{Buffer: &buffer}}), A.ErrQueueEmpty)
+
+	request.AdapterHandle = A.Handle{0xFF}
+	assert.ErrorIs(t, driver.ReadPacketsErr(&request), A.ErrAdapterNotFound)
+	assert.ErrorIs(t, driver.ReadPacketsErr(&request), sim.ErrAdapterNotFound)
+
+	require.NoError(t, driver.ReceiveFromNetwork(handle, tcpFrame("10.0.0.2", "10.0.0.1", 80, 50000, 0x10)))
+	request.AdapterHandle = handle
+	require.NoError(t, driver.ReadPacketsErr(&request))
+	assert.Equal(t, uint32(1), request.PacketsSuccess)
+
+	var success uint32
+	packets := []*A.IntermediateBuffer{&buffer}
+	assert.ErrorIs(t, driver.ReadPacketsUnsortedErr(packets, 1, &success), A.ErrQueueEmpty)
+
+	buffer.HAdapterQLinkUnion.SetAdapter(A.Handle{0xFF})
+	assert.ErrorIs(t, driver.SendPacketsToMstcpUnsortedErr(packets, 1, &success), A.ErrAdapterNotFound)
+	assert.Zero(t, success)
+
+	var section A.InitializeFastIOSection
+	assert.ErrorIs(t, driver.InitializeFastIoErr(&section, uint32(unsafe.Sizeof(section))-1), A.ErrBufferTooSmall)
+	assert.ErrorIs(t, driver.AddSecondaryFastIoErr(nil, 0), sim.ErrInvalidParameter)
+}
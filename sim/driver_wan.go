@@ -0,0 +1,51 @@
+package sim
+
+import (
+	A "github.com/wiresock/ndisapi-go"
+)
+
+// SetRasLinks replaces the active RAS links of the simulated NDISWAN adapter and signals the WAN event.
+func (d *Driver) SetRasLinks(handle A.Handle, links []A.RASLinkInfo) error {
+	if len(links) > A.RAS_LINKS_MAX {
+		return ErrInvalidParameter
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	a := d.findAdapter(handle)
+	if a == nil {
+		return ErrAdapterNotFound
+	}
+	a.rasLinks = append([]A.RASLinkInfo(nil), links...)
+
+	_ = A.SignalEventHandle(d.wanEvent)
+
+	return nil
+}
+
+// SetWANEvent sets the event to be signaled when a RAS link goes up or down.
+func (d *Driver) SetWANEvent(win32Event A.EventHandle) error {
+	d.Lock()
+	defer d.Unlock()
+
+	d.wanEvent = win32Event
+
+	return nil
+}
+
+// GetRasLinks retrieves the active RAS links of the simulated adapter.
+func (d *Driver) GetRasLinks(handle A.Handle) (*A.RASLinks, error) {
+	d.Lock()
+	defer d.Unlock()
+
+	a := d.findAdapter(handle)
+	if a == nil {
+		return nil, ErrAdapterNotFound
+	}
+
+	links := new(A.RASLinks)
+	links.NumberOfLinks = uint32(copy(links.RASLinks[:], a.rasLinks))
+
+	return links, nil
+}
@@ -0,0 +1,143 @@
+package sim
+
+import (
+	"encoding/binary"
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86DD
+
+	protocolICMP   = 1
+	protocolTCP    = 6
+	protocolUDP    = 17
+	protocolICMPv6 = 58
+)
+
+// packetHeaders holds the header fields the static filters match on.
+type packetHeaders struct {
+	srcMAC    []byte
+	dstMAC    []byte
+	etherType uint16
+
+	ipVersion int
+	srcIP     []byte
+	dstIP     []byte
+	protocol  uint8
+
+	hasPorts bool
+	isTCP    bool
+	srcPort  uint16
+	dstPort  uint16
+	tcpFlags uint8
+
+	hasICMP  bool
+	icmpType uint8
+	icmpCode uint8
+}
+
+// parseHeaders extracts the Ethernet, IP and transport header fields of a frame.
+// Truncated headers leave the corresponding fields unset.
+func parseHeaders(frame []byte) packetHeaders {
+	var headers packetHeaders
+
+	if len(frame) < 14 {
+		return headers
+	}
+	headers.dstMAC = frame[0:6]
+	headers.srcMAC = frame[6:12]
+	headers.etherType = binary.BigEndian.Uint16(frame[12:14])
+
+	payload := frame[14:]
+	switch headers.etherType {
+	case etherTypeIPv4:
+		if len(payload) < 20 || payload[0]>>4 != 4 {
+			return headers
+		}
+		ihl := int(payload[0]&0x0F) * 4
+		if ihl < 20 || len(payload) < ihl {
+			return headers
+		}
+		headers.ipVersion = 4
+		headers.srcIP = payload[12:16]
+		headers.dstIP = payload[16:20]
+		headers.protocol = payload[9]
+		if binary.BigEndian.Uint16(payload[6:8])&0x1FFF != 0 {
+			// Non-first fragments carry no transport header
+			return headers
+		}
+		parseTransport(&headers, headers.protocol, payload[ihl:])
+	case etherTypeIPv6:
+		if len(payload) < 40 || payload[0]>>4 != 6 {
+			return headers
+		}
+		headers.ipVersion = 6
+		headers.srcIP = payload[8:24]
+		headers.dstIP = payload[24:40]
+
+		next := payload[6]
+		payload = payload[40:]
+		for {
+			var length int
+			switch next {
+			case 0, 43, 60: // hop-by-hop, routing, destination options
+				if len(payload) < 8 {
+					return headers
+				}
+				length = (int(payload[1]) + 1) * 8
+			case 44: // fragment
+				if len(payload) < 8 {
+					return headers
+				}
+				if binary.BigEndian.Uint16(payload[2:4])&0xFFF8 != 0 {
+					headers.protocol = payload[0]
+					return headers
+				}
+				length = 8
+			case 51: // authentication header
+				if len(payload) < 8 {
+					return headers
+				}
+				length = (int(payload[1]) + 2) * 4
+			default:
+				headers.protocol = next
+				parseTransport(&headers, next, payload)
+				return headers
+			}
+			if len(payload) < length {
+				return headers
+			}
+			next = payload[0]
+			payload = payload[length:]
+		}
+	}
+
+	return headers
+}
+
+// parseTransport extracts ports, TCP flags or ICMP type and code.
+func parseTransport(headers *packetHeaders, protocol uint8, payload []byte) {
+	switch protocol {
+	case protocolTCP:
+		if len(payload) < 20 {
+			return
+		}
+		headers.tcpFlags = payload[13]
+		headers.isTCP = true
+		fallthrough
+	case protocolUDP:
+		if len(payload) < 8 {
+			return
+		}
+		headers.srcPort = binary.BigEndian.Uint16(payload[0:2])
+		headers.dstPort = binary.BigEndian.Uint16(payload[2:4])
+		headers.hasPorts = true
+	case protocolICMP, protocolICMPv6:
+		if len(payload) < 4 {
+			return
+		}
+		headers.icmpType = payload[0]
+		headers.icmpCode = payload[1]
+		headers.hasICMP = true
+	}
+}